@@ -5,6 +5,7 @@ import (
 
 	smtypes "github.com/filecoin-project/boost/storagemarket/types"
 	"github.com/filecoin-project/boost/storagemarket/types/legacytypes"
+	"github.com/filecoin-project/go-address"
 	"github.com/google/uuid"
 	"github.com/ipfs/go-cid"
 	"github.com/multiformats/go-multihash"
@@ -39,6 +40,8 @@ type Boost interface {
 	BoostIndexerAnnounceDeal(ctx context.Context, deal *smtypes.ProviderDealState) (cid.Cid, error)                                             //perm:admin
 	BoostIndexerAnnounceLegacyDeal(ctx context.Context, proposalCid cid.Cid) (cid.Cid, error)                                                   //perm:admin
 	BoostDirectDeal(ctx context.Context, params smtypes.DirectDealParams) (*ProviderDealRejectionInfo, error)                                   //perm:admin
+	BoostCommpWorkerRegister(ctx context.Context, url string, maxJobs int) error                                                                //perm:admin
+	BoostSubPieceDeals(ctx context.Context, subPieceCid cid.Cid) ([]smtypes.DealSubPiece, error)                                                //perm:read
 	MarketGetAsk(ctx context.Context) (*legacytypes.SignedStorageAsk, error)                                                                    //perm:read
 
 	// MethodGroup: Blockstore
@@ -47,9 +50,10 @@ type Boost interface {
 	BlockstoreGetSize(ctx context.Context, c cid.Cid) (int, error) //perm:read
 
 	// MethodGroup: PieceDirectory
-	PdBuildIndexForPieceCid(ctx context.Context, piececid cid.Cid) error             //perm:admin
-	PdRemoveDealForPiece(ctx context.Context, piececid cid.Cid, dealID string) error //perm:admin
-	PdCleanup(ctx context.Context) error                                             //perm:admin
+	PdBuildIndexForPieceCid(ctx context.Context, piececid cid.Cid) error                                       //perm:admin
+	PdRemoveDealForPiece(ctx context.Context, piececid cid.Cid, dealID string) error                           //perm:admin
+	PdCleanup(ctx context.Context) error                                                                       //perm:admin
+	PdRemediateFlaggedPiece(ctx context.Context, piececid cid.Cid, maddr address.Address, action string) error //perm:admin
 
 	// MethodGroup: Misc
 	OnlineBackup(context.Context, string) error //perm:admin
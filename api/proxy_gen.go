@@ -38,6 +38,8 @@ type BoostStruct struct {
 
 		BlockstoreHas func(p0 context.Context, p1 cid.Cid) (bool, error) `perm:"read"`
 
+		BoostCommpWorkerRegister func(p0 context.Context, p1 string, p2 int) error `perm:"admin"`
+
 		BoostDeal func(p0 context.Context, p1 uuid.UUID) (*smtypes.ProviderDealState, error) `perm:"admin"`
 
 		BoostDealBySignedProposalCid func(p0 context.Context, p1 cid.Cid) (*smtypes.ProviderDealState, error) `perm:"admin"`
@@ -64,6 +66,8 @@ type BoostStruct struct {
 
 		BoostOfflineDealWithData func(p0 context.Context, p1 uuid.UUID, p2 string, p3 bool) (*ProviderDealRejectionInfo, error) `perm:"admin"`
 
+		BoostSubPieceDeals func(p0 context.Context, p1 cid.Cid) ([]smtypes.DealSubPiece, error) `perm:"read"`
+
 		MarketGetAsk func(p0 context.Context) (*legacytypes.SignedStorageAsk, error) `perm:"read"`
 
 		OnlineBackup func(p0 context.Context, p1 string) error `perm:"admin"`
@@ -72,6 +76,8 @@ type BoostStruct struct {
 
 		PdCleanup func(p0 context.Context) error `perm:"admin"`
 
+		PdRemediateFlaggedPiece func(p0 context.Context, p1 cid.Cid, p2 address.Address, p3 string) error `perm:"admin"`
+
 		PdRemoveDealForPiece func(p0 context.Context, p1 cid.Cid, p2 string) error `perm:"admin"`
 	}
 }
@@ -234,6 +240,17 @@ func (s *BoostStub) BlockstoreHas(p0 context.Context, p1 cid.Cid) (bool, error)
 	return false, ErrNotSupported
 }
 
+func (s *BoostStruct) BoostCommpWorkerRegister(p0 context.Context, p1 string, p2 int) error {
+	if s.Internal.BoostCommpWorkerRegister == nil {
+		return ErrNotSupported
+	}
+	return s.Internal.BoostCommpWorkerRegister(p0, p1, p2)
+}
+
+func (s *BoostStub) BoostCommpWorkerRegister(p0 context.Context, p1 string, p2 int) error {
+	return ErrNotSupported
+}
+
 func (s *BoostStruct) BoostDeal(p0 context.Context, p1 uuid.UUID) (*smtypes.ProviderDealState, error) {
 	if s.Internal.BoostDeal == nil {
 		return nil, ErrNotSupported
@@ -377,6 +394,17 @@ func (s *BoostStub) BoostOfflineDealWithData(p0 context.Context, p1 uuid.UUID, p
 	return nil, ErrNotSupported
 }
 
+func (s *BoostStruct) BoostSubPieceDeals(p0 context.Context, p1 cid.Cid) ([]smtypes.DealSubPiece, error) {
+	if s.Internal.BoostSubPieceDeals == nil {
+		return *new([]smtypes.DealSubPiece), ErrNotSupported
+	}
+	return s.Internal.BoostSubPieceDeals(p0, p1)
+}
+
+func (s *BoostStub) BoostSubPieceDeals(p0 context.Context, p1 cid.Cid) ([]smtypes.DealSubPiece, error) {
+	return *new([]smtypes.DealSubPiece), ErrNotSupported
+}
+
 func (s *BoostStruct) MarketGetAsk(p0 context.Context) (*legacytypes.SignedStorageAsk, error) {
 	if s.Internal.MarketGetAsk == nil {
 		return nil, ErrNotSupported
@@ -421,6 +449,17 @@ func (s *BoostStub) PdCleanup(p0 context.Context) error {
 	return ErrNotSupported
 }
 
+func (s *BoostStruct) PdRemediateFlaggedPiece(p0 context.Context, p1 cid.Cid, p2 address.Address, p3 string) error {
+	if s.Internal.PdRemediateFlaggedPiece == nil {
+		return ErrNotSupported
+	}
+	return s.Internal.PdRemediateFlaggedPiece(p0, p1, p2, p3)
+}
+
+func (s *BoostStub) PdRemediateFlaggedPiece(p0 context.Context, p1 cid.Cid, p2 address.Address, p3 string) error {
+	return ErrNotSupported
+}
+
 func (s *BoostStruct) PdRemoveDealForPiece(p0 context.Context, p1 cid.Cid, p2 string) error {
 	if s.Internal.PdRemoveDealForPiece == nil {
 		return ErrNotSupported
@@ -21,6 +21,9 @@ import (
 type ProviderDealRejectionInfo struct {
 	Accepted bool
 	Reason   string // The rejection reason, if the deal is rejected
+	// The number of seconds the client should wait before retrying, if the
+	// provider is temporarily unable to accept deals
+	RetryAfter uint64
 }
 
 type MultiaddrSlice []ma.Multiaddr
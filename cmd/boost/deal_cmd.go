@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	bcli "github.com/filecoin-project/boost/cli"
 	clinode "github.com/filecoin-project/boost/cli/node"
@@ -290,6 +291,9 @@ func dealCmdAction(cctx *cli.Context, isOnline bool) error {
 	}
 
 	if !resp.Accepted {
+		if resp.RetryAfter > 0 {
+			return fmt.Errorf("deal proposal rejected: %s (provider asked to retry in %s)", resp.Message, time.Duration(resp.RetryAfter)*time.Second)
+		}
 		return fmt.Errorf("deal proposal rejected: %s", resp.Message)
 	}
 
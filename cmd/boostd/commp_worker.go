@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	bcli "github.com/filecoin-project/boost/cli"
+	cliutil "github.com/filecoin-project/boost/cli/util"
+	"github.com/filecoin-project/boost/node/repo"
+	"github.com/filecoin-project/boost/storagemarket/commppool"
+	"github.com/filecoin-project/go-jsonrpc"
+	"github.com/gorilla/mux"
+	"github.com/urfave/cli/v2"
+)
+
+// How often the worker re-registers with boost, so that boost picks up the
+// worker again after a restart
+const commpWorkerRegisterInterval = 30 * time.Second
+
+var commpWorkerCmd = &cli.Command{
+	Name:  "commp-worker",
+	Usage: "Run a process that computes commp for deals on behalf of boost",
+	Description: `The commp worker registers with boost, which sends it commp jobs when
+Dealmaking.CommpWorkerPool is enabled in the boost config. If the worker can
+access the staged deal files at the same path as boost (eg over a shared
+filesystem) it reads them directly, otherwise it streams them from boost.`,
+	Before: before,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "listen",
+			Usage: "the address to listen on for commp jobs from boost",
+			Value: "0.0.0.0:8044",
+		},
+		&cli.StringFlag{
+			Name:     "url",
+			Usage:    "the RPC URL at which boost can reach this worker, eg ws://<host>:8044/rpc/v0",
+			Required: true,
+		},
+		&cli.IntFlag{
+			Name:  "max-jobs",
+			Usage: "the maximum number of commp jobs to run in parallel",
+			Value: 1,
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := bcli.DaemonContext(cctx)
+
+		// Get the URL at which to fetch job data from boost
+		addr, headers, err := cliutil.GetRawAPI(cctx, repo.Boost, "v0")
+		if err != nil {
+			return fmt.Errorf("getting boost api info: %w", err)
+		}
+		fetchURL, err := commpJobDataURL(addr)
+		if err != nil {
+			return err
+		}
+
+		maxJobs := cctx.Int("max-jobs")
+		worker := commppool.NewWorker(fetchURL, headers, maxJobs)
+
+		server := jsonrpc.NewServer()
+		server.Register(commppool.WorkerNamespace, worker)
+		router := mux.NewRouter()
+		router.Handle("/rpc/v0", server)
+
+		ln, err := net.Listen("tcp", cctx.String("listen"))
+		if err != nil {
+			return fmt.Errorf("setting up listener for commp worker: %w", err)
+		}
+
+		srv := &http.Server{Handler: router}
+		go func() {
+			if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+				log.Errorf("exiting commp worker server: %s", err)
+			}
+		}()
+		log.Infow("commp worker is listening", "addr", ln.Addr(), "max-jobs", maxJobs)
+
+		go registerCommpWorker(ctx, cctx, cctx.String("url"), maxJobs)
+
+		<-ctx.Done()
+		log.Info("shutting down commp worker")
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return srv.Shutdown(shutdownCtx)
+	},
+}
+
+// commpJobDataURL converts the boost API address (eg ws://host:port/rpc/v0)
+// into the URL at which boost serves commp job data
+func commpJobDataURL(addr string) (string, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return "", fmt.Errorf("parsing boost API URL: %w", err)
+	}
+
+	switch u.Scheme {
+	case "ws":
+		u.Scheme = "http"
+	case "wss":
+		u.Scheme = "https"
+	}
+	u.Path = commppool.JobDataPath
+
+	return u.String(), nil
+}
+
+// registerCommpWorker registers the worker with boost periodically until the
+// context is cancelled
+func registerCommpWorker(ctx context.Context, cctx *cli.Context, workerURL string, maxJobs int) {
+	register := func() {
+		boostApi, closer, err := bcli.GetBoostAPI(cctx)
+		if err != nil {
+			log.Warnw("getting boost api", "err", err)
+			return
+		}
+		defer closer()
+
+		if err := boostApi.BoostCommpWorkerRegister(ctx, workerURL, maxJobs); err != nil {
+			log.Warnw("registering commp worker with boost", "url", workerURL, "err", err)
+			return
+		}
+		log.Debugw("registered commp worker with boost", "url", workerURL)
+	}
+
+	register()
+
+	ticker := time.NewTicker(commpWorkerRegisterInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			register()
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/filecoin-project/boost/extern/boostd-data/archive"
+	bdclient "github.com/filecoin-project/boost/extern/boostd-data/client"
+	lcli "github.com/filecoin-project/lotus/cli"
+	"github.com/ipfs/go-cid"
+	"github.com/mitchellh/go-homedir"
+	"github.com/urfave/cli/v2"
+)
+
+var lidAPIFlag = &cli.StringSliceFlag{
+	Name:     "api-lid",
+	Usage:    "the endpoint for the LID API (eg ws://localhost:8042). Set the flag once for each service, with the same connect strings as the LocalIndexDirectory.ServiceApiInfoShards config, to connect to a sharded local index directory",
+	Required: true,
+}
+
+var lidExportCmd = &cli.Command{
+	Name:      "export",
+	Usage:     "Export piece metadata, deals and indexes from the Local Index Directory to an archive file",
+	ArgsUsage: "[piece CID...]",
+	Description: "Exports the piece metadata, deals and index records of the given pieces to an archive file. " +
+		"If no piece CIDs are given, all pieces in the Local Index Directory are exported. " +
+		"The archive can be imported into a Local Index Directory with any backend using 'boostd lid import'.",
+	Flags: []cli.Flag{
+		lidAPIFlag,
+		&cli.StringFlag{
+			Name:     "output",
+			Aliases:  []string{"o"},
+			Usage:    "the path of the archive file to write",
+			Required: true,
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := lcli.ReqContext(cctx)
+
+		outPath, err := homedir.Expand(cctx.String("output"))
+		if err != nil {
+			return err
+		}
+
+		var pieceCids []cid.Cid
+		for _, arg := range cctx.Args().Slice() {
+			pieceCid, err := cid.Decode(arg)
+			if err != nil {
+				return fmt.Errorf("parsing piece CID %s: %w", arg, err)
+			}
+			pieceCids = append(pieceCids, pieceCid)
+		}
+
+		cl, err := dialLID(ctx, cctx.StringSlice("api-lid"))
+		if err != nil {
+			return err
+		}
+		defer cl.Close(ctx)
+
+		if len(pieceCids) == 0 {
+			pieceCids, err = cl.ListPieces(ctx)
+			if err != nil {
+				return fmt.Errorf("listing pieces: %w", err)
+			}
+		}
+
+		// Write to a temporary file and rename it once the export is
+		// complete, so that a partial export is never mistaken for an archive
+		tmpPath := outPath + ".tmp"
+		f, err := os.Create(tmpPath)
+		if err != nil {
+			return fmt.Errorf("creating archive file: %w", err)
+		}
+		defer f.Close() //nolint:errcheck
+
+		w, err := archive.NewWriter(f, len(pieceCids))
+		if err != nil {
+			return err
+		}
+
+		start := time.Now()
+		var records int
+		for i, pieceCid := range pieceCids {
+			p, err := exportPiece(ctx, cl, pieceCid)
+			if err != nil {
+				return fmt.Errorf("exporting piece %s: %w", pieceCid, err)
+			}
+			if err := w.WritePiece(*p); err != nil {
+				return err
+			}
+			records += len(p.Records)
+			fmt.Printf("%d / %d: exported piece %s with %d deals and %d index records\n",
+				i+1, len(pieceCids), pieceCid, len(p.Metadata.Deals), len(p.Records))
+		}
+
+		if err := w.Close(); err != nil {
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("closing archive file: %w", err)
+		}
+		if err := os.Rename(tmpPath, outPath); err != nil {
+			return fmt.Errorf("renaming archive file: %w", err)
+		}
+
+		fmt.Printf("Exported %d pieces with %d index records to %s in %s\n",
+			len(pieceCids), records, outPath, time.Since(start).String())
+		return nil
+	},
+}
+
+func exportPiece(ctx context.Context, cl *bdclient.Store, pieceCid cid.Cid) (*archive.Piece, error) {
+	md, err := cl.GetPieceMetadata(ctx, pieceCid)
+	if err != nil {
+		return nil, fmt.Errorf("getting piece metadata: %w", err)
+	}
+
+	p := &archive.Piece{PieceCid: pieceCid, Metadata: md}
+	if md.IndexedAt.IsZero() {
+		return p, nil
+	}
+
+	p.Records, err = cl.GetRecords(ctx, pieceCid)
+	if err != nil {
+		return nil, fmt.Errorf("getting index records: %w", err)
+	}
+	return p, nil
+}
+
+var lidImportCmd = &cli.Command{
+	Name:      "import",
+	Usage:     "Import piece metadata, deals and indexes from an archive file into the Local Index Directory",
+	ArgsUsage: "<archive file>",
+	Description: "Imports an archive created with 'boostd lid export' into the Local Index Directory. " +
+		"The checksum of each piece is verified before the piece is imported. " +
+		"The pieces that have been imported are recorded in a progress file, so it is safe to stop " +
+		"and restart the import: pieces that have already been imported are skipped.",
+	Flags: []cli.Flag{
+		lidAPIFlag,
+		&cli.StringFlag{
+			Name:  "progress-file",
+			Usage: "the file used to record import progress (defaults to <archive file>.progress)",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := lcli.ReqContext(cctx)
+
+		if cctx.Args().Len() != 1 {
+			return fmt.Errorf("must specify archive file")
+		}
+
+		archivePath, err := homedir.Expand(cctx.Args().Get(0))
+		if err != nil {
+			return err
+		}
+		progressPath := archivePath + ".progress"
+		if cctx.IsSet("progress-file") {
+			progressPath, err = homedir.Expand(cctx.String("progress-file"))
+			if err != nil {
+				return err
+			}
+		}
+
+		f, err := os.Open(archivePath)
+		if err != nil {
+			return fmt.Errorf("opening archive file: %w", err)
+		}
+		defer f.Close() //nolint:errcheck
+
+		r, err := archive.NewReader(f)
+		if err != nil {
+			return err
+		}
+
+		imported, err := readImportProgress(progressPath)
+		if err != nil {
+			return err
+		}
+		progress, err := os.OpenFile(progressPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return fmt.Errorf("opening progress file: %w", err)
+		}
+		defer progress.Close() //nolint:errcheck
+
+		cl, err := dialLID(ctx, cctx.StringSlice("api-lid"))
+		if err != nil {
+			return err
+		}
+		defer cl.Close(ctx)
+
+		total := r.Header().Pieces
+		fmt.Printf("Importing %d pieces from archive created at %s\n", total, r.Header().CreatedAt.Format(time.RFC3339))
+		if len(imported) > 0 {
+			fmt.Printf("Resuming import: %d pieces have already been imported\n", len(imported))
+		}
+
+		start := time.Now()
+		var count, skipped int
+		for {
+			p, err := r.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("reading archive: %w", err)
+			}
+			count++
+
+			if _, ok := imported[p.PieceCid]; ok {
+				skipped++
+				continue
+			}
+
+			if err := importPiece(ctx, cl, p); err != nil {
+				return fmt.Errorf("importing piece %s: %w", p.PieceCid, err)
+			}
+			if _, err := fmt.Fprintln(progress, p.PieceCid.String()); err != nil {
+				return fmt.Errorf("writing progress file: %w", err)
+			}
+
+			fmt.Printf("%d / %d: imported piece %s with %d deals and %d index records\n",
+				count, total, p.PieceCid, len(p.Metadata.Deals), len(p.Records))
+		}
+
+		fmt.Printf("Imported %d pieces (%d already imported) in %s\n", count-skipped, skipped, time.Since(start).String())
+		return nil
+	},
+}
+
+func importPiece(ctx context.Context, cl *bdclient.Store, p *archive.Piece) error {
+	if !p.Metadata.IndexedAt.IsZero() {
+		err := cl.AddIndex(ctx, p.PieceCid, p.Records, p.Metadata.CompleteIndex)
+		if err != nil {
+			return fmt.Errorf("adding index: %w", err)
+		}
+	}
+
+	for _, dl := range p.Metadata.Deals {
+		err := cl.AddDealForPiece(ctx, p.PieceCid, dl)
+		if err != nil {
+			return fmt.Errorf("adding deal %s: %w", dl.DealUuid, err)
+		}
+	}
+
+	return nil
+}
+
+// readImportProgress reads the set of piece CIDs that have already been
+// imported from the progress file
+func readImportProgress(path string) (map[cid.Cid]struct{}, error) {
+	imported := make(map[cid.Cid]struct{})
+
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return imported, nil
+		}
+		return nil, fmt.Errorf("opening progress file: %w", err)
+	}
+	defer f.Close() //nolint:errcheck
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		// Ignore a partially written line at the end of the file, in case
+		// the process was stopped while writing it
+		pieceCid, err := cid.Decode(line)
+		if err != nil {
+			continue
+		}
+		imported[pieceCid] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading progress file: %w", err)
+	}
+
+	return imported, nil
+}
+
+func dialLID(ctx context.Context, addrs []string) (*bdclient.Store, error) {
+	cl := bdclient.NewStore()
+	err := cl.DialShards(ctx, addrs)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to local index directory service: %w", err)
+	}
+	return cl, nil
+}
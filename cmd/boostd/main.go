@@ -69,6 +69,7 @@ func before(cctx *cli.Context) error {
 	_ = logging.SetLogLevel("piecedirectory", "INFO")
 	_ = logging.SetLogLevel("sectorstatemgr", "INFO")
 	_ = logging.SetLogLevel("migrations", "INFO")
+	_ = logging.SetLogLevel("commppool", "INFO")
 
 	if cliutil.IsVeryVerbose {
 		_ = logging.SetLogLevel("boostd", "DEBUG")
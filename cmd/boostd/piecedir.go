@@ -6,6 +6,7 @@ import (
 	"time"
 
 	bcli "github.com/filecoin-project/boost/cli"
+	"github.com/filecoin-project/go-address"
 	lcli "github.com/filecoin-project/lotus/cli"
 	"github.com/google/uuid"
 	"github.com/ipfs/go-cid"
@@ -20,6 +21,9 @@ var pieceDirCmd = &cli.Command{
 		recoverCmd,
 		removeDealCmd,
 		lidCleanupCmd,
+		lidExportCmd,
+		lidImportCmd,
+		remediateCmd,
 	},
 }
 
@@ -131,3 +135,43 @@ var lidCleanupCmd = &cli.Command{
 		return nil
 	},
 }
+
+var remediateCmd = &cli.Command{
+	Name:      "remediate",
+	Usage:     "Take an action to fix a piece that has been flagged by the piece doctor",
+	ArgsUsage: "<piece CID> <miner address> <rebuild-index|unseal|untrack>",
+	Description: "Actions:\n" +
+		"   rebuild-index: remove the existing index for the piece and build a new index from an unsealed copy\n" +
+		"   unseal:        request the sealer to unseal a sector containing the piece\n" +
+		"   untrack:       stop checking the piece with the piece doctor",
+	Action: func(cctx *cli.Context) error {
+		ctx := lcli.ReqContext(cctx)
+
+		if cctx.Args().Len() != 3 {
+			return fmt.Errorf("must specify piece CID, miner address and action")
+		}
+
+		piececid, err := cid.Decode(cctx.Args().Get(0))
+		if err != nil {
+			return fmt.Errorf("parsing piece CID: %w", err)
+		}
+		maddr, err := address.NewFromString(cctx.Args().Get(1))
+		if err != nil {
+			return fmt.Errorf("parsing miner address: %w", err)
+		}
+		action := cctx.Args().Get(2)
+
+		napi, closer, err := bcli.GetBoostAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		err = napi.PdRemediateFlaggedPiece(ctx, piececid, maddr, action)
+		if err != nil {
+			return fmt.Errorf("remediating piece %s: %w", piececid, err)
+		}
+		fmt.Printf("Applied remediation action %s to piece %s\n", action, piececid)
+		return nil
+	},
+}
@@ -80,9 +80,9 @@ var lidCmd = &cli.Command{
 			Usage:    "the endpoint for the storage node API",
 			Required: true,
 		},
-		&cli.StringFlag{
+		&cli.StringSliceFlag{
 			Name:  "api-lid",
-			Usage: "the endpoint for the LID API",
+			Usage: "the endpoint for the LID API. Set the flag once for each service, with the same connect strings as the LocalIndexDirectory.ServiceApiInfoShards config, to connect to a sharded local index directory",
 			//Required: true,
 		},
 		&cli.StringFlag{
@@ -204,7 +204,7 @@ func action(cctx *cli.Context) error {
 	} else {
 		cl := bdclient.NewStore()
 		defer cl.Close(ctx)
-		err = cl.Dial(ctx, cctx.String("api-lid"))
+		err = cl.DialShards(ctx, cctx.StringSlice("api-lid"))
 		if err != nil {
 			return fmt.Errorf("connecting to local index directory service: %w", err)
 		}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/filecoin-project/boost/cmd/lib/filters"
+	"github.com/filecoin-project/boost/metrics"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+)
+
+// geoFilter applies the geo policy to requests that pass the other filters.
+// The client IP is the remote address of the connection to the peer. When
+// requests are received through a relay the client IP is not known, and the
+// policy's UnknownLocation setting applies.
+// When booster-bitswap runs behind the boostd libp2p proxy, every request is
+// forwarded by the proxy, which doesn't forward the client's address. In
+// that case the rules that depend on the client's location are skipped,
+// rather than applying the UnknownLocation setting to every request.
+type geoFilter struct {
+	ctx       context.Context
+	next      Filter
+	geo       *filters.GeoFilter
+	host      host.Host
+	blockSize func(ctx context.Context, c cid.Cid) (int, error)
+	proxied   bool
+}
+
+func newGeoFilter(ctx context.Context, next Filter, geo *filters.GeoFilter, h host.Host, blockSize func(ctx context.Context, c cid.Cid) (int, error), proxied bool) *geoFilter {
+	return &geoFilter{ctx: ctx, next: next, geo: geo, host: h, blockSize: blockSize, proxied: proxied}
+}
+
+// peerIP returns the IP address of a direct connection to the peer, or nil
+// if there is no direct connection with an IP address
+func (f *geoFilter) peerIP(p peer.ID) net.IP {
+	for _, conn := range f.host.Network().ConnsToPeer(p) {
+		// The IP address of a relayed connection is the relay's address
+		if _, err := conn.RemoteMultiaddr().ValueForProtocol(ma.P_CIRCUIT); err == nil {
+			continue
+		}
+		if ip, err := manet.ToIP(conn.RemoteMultiaddr()); err == nil {
+			return ip
+		}
+	}
+	return nil
+}
+
+func (f *geoFilter) FulfillRequest(p peer.ID, c cid.Cid) (bool, error) {
+	fulfill, err := f.next.FulfillRequest(p, c)
+	if !fulfill || err != nil {
+		return fulfill, err
+	}
+
+	var d filters.GeoDecision
+	if f.proxied {
+		d, err = f.geo.DecideWithoutLocation(f.ctx, c)
+	} else {
+		d, err = f.geo.Decide(f.ctx, f.peerIP(p), c)
+	}
+	if err != nil {
+		return false, err
+	}
+	if !d.Allow {
+		ctx, _ := tag.New(f.ctx, tag.Upsert(metrics.GeoRule, d.Rule))
+		stats.Record(ctx, metrics.BitswapGeoDeniedCount.M(1))
+		return false, nil
+	}
+	if d.Bandwidth == nil {
+		return true, nil
+	}
+
+	// Bitswap responses can't be throttled, so requests for blocks are
+	// refused while the rule's bandwidth cap is exceeded
+	size, err := f.blockSize(f.ctx, c)
+	if err != nil {
+		if ipld.IsNotFound(err) {
+			// Let bitswap respond that it doesn't have the block
+			return true, nil
+		}
+		return false, err
+	}
+	if !d.Bandwidth.AllowN(time.Now(), size) {
+		log.Debugw("geo policy bandwidth cap exceeded", "rule", d.Rule, "peer", p, "cid", c, "size", size)
+		return false, nil
+	}
+	return true, nil
+}
@@ -58,9 +58,9 @@ var runCmd = &cli.Command{
 			Usage:    "the endpoint for the storage node API",
 			Required: true,
 		},
-		&cli.StringFlag{
+		&cli.StringSliceFlag{
 			Name:     "api-lid",
-			Usage:    "the endpoint for the local index directory API, eg 'http://localhost:8042'",
+			Usage:    "the endpoint for the local index directory API, eg 'http://localhost:8042'. Set the flag once for each service, with the same connect strings as the LocalIndexDirectory.ServiceApiInfoShards config, to connect to a sharded local index directory",
 			Required: true,
 		},
 		&cli.IntFlag{
@@ -100,6 +100,12 @@ var runCmd = &cli.Command{
 			Usage: "the endpoints for fetching one or more custom BadBits list instead of the default one at https://badbits.dwebops.pub/denylist.json",
 			Value: cli.NewStringSlice("https://badbits.dwebops.pub/denylist.json"),
 		},
+		&cli.StringFlag{
+			Name: "geo-policy",
+			Usage: "path to a JSON file with rules that allow, deny or cap the bandwidth of retrievals by client IP range, country and ASN, " +
+				"and the MaxMind-format databases used to look up client locations; the file and databases are reloaded when they change. " +
+				"When running behind a proxy (--proxy) the client IP is not known, so only rules that match all clients apply",
+		},
 		&cli.IntFlag{
 			Name:  "engine-blockstore-worker-count",
 			Usage: "number of threads for blockstore operations. Used to throttle the number of concurrent requests to the block store",
@@ -204,7 +210,7 @@ var runCmd = &cli.Command{
 		// Connect to the local index directory service
 		cl := bdclient.NewStore()
 		defer cl.Close(ctx)
-		err = cl.Dial(ctx, cctx.String("api-lid"))
+		err = cl.DialShards(ctx, cctx.StringSlice("api-lid"))
 		if err != nil {
 			return fmt.Errorf("connecting to local index directory service: %w", err)
 		}
@@ -243,7 +249,6 @@ var runCmd = &cli.Command{
 		pd := piecedirectory.NewPieceDirectory(cl, sa, cctx.Int("add-index-throttle"),
 			piecedirectory.WithAddIndexConcurrency(cctx.Int("add-index-concurrency")))
 		remoteStore := remoteblockstore.NewRemoteBlockstore(pd, &bitswapBlockMetrics)
-		server := NewBitswapServer(remoteStore, host, multiFilter)
 
 		var proxyAddrInfo *peer.AddrInfo
 		if cctx.IsSet("proxy") {
@@ -254,6 +259,26 @@ var runCmd = &cli.Command{
 			}
 		}
 
+		var filter Filter = multiFilter
+		if geoPolicyPath := cctx.String("geo-policy"); geoPolicyPath != "" {
+			// Rules that apply to particular pieces are applied to requests
+			// for blocks using the pieces that contain the block
+			geo, err := filters.NewGeoFilter(geoPolicyPath, pd.PiecesContainingMultihash, nil)
+			if err != nil {
+				return fmt.Errorf("creating geo filter: %w", err)
+			}
+			defer geo.Close()
+			geo.Start(ctx)
+			proxied := proxyAddrInfo != nil
+			filter = newGeoFilter(ctx, multiFilter, geo, host, remoteStore.GetSize, proxied)
+			if proxied {
+				log.Warnw("geo policy rules that depend on the client's location are not applied to requests received through the proxy",
+					"config", geoPolicyPath)
+			}
+			log.Infow("geo policy enabled", "config", geoPolicyPath)
+		}
+		server := NewBitswapServer(remoteStore, host, filter)
+
 		// Start the local index directory
 		pd.Start(ctx)
 
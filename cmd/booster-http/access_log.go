@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/filecoin-project/boost/cmd/booster-http/accesslog"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+type accessLogEntryKey struct{}
+
+// accessLogHandler writes an entry to the access log for each request.
+// Handlers can add details to the entry with the setAccessLog functions.
+func (s *HttpServer) accessLogHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		e := &accesslog.Entry{
+			Time:      start,
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			UserAgent: r.UserAgent(),
+		}
+		if ip := clientIP(r, true); ip != nil {
+			e.ClientIP = ip.String()
+		} else if pid, err := peer.Decode(r.RemoteAddr); err == nil {
+			// Requests over libp2p have the client's peer ID as the remote address
+			e.PeerID = pid.String()
+		}
+		s.setAccessLogCids(e, r)
+
+		sw := &statusResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), accessLogEntryKey{}, e)))
+
+		e.Status = sw.status
+		e.Bytes = sw.written
+		e.DurationMs = time.Since(start).Milliseconds()
+		if err := s.opts.AccessLog.Write(e); err != nil {
+			log.Warnw("writing to access log", "err", err)
+		}
+	})
+}
+
+// setAccessLogCids sets the piece CID and payload CID in the entry from the
+// request path
+func (s *HttpServer) setAccessLogCids(e *accesslog.Entry, r *http.Request) {
+	switch {
+	case strings.HasPrefix(r.URL.Path, s.pieceBasePath()):
+		e.PieceCid, _, _ = strings.Cut(strings.TrimPrefix(r.URL.Path, s.pieceBasePath()), "/")
+		e.Cid = r.URL.Query().Get("root")
+	case strings.HasPrefix(r.URL.Path, s.ipfsBasePath()):
+		e.Cid, _, _ = strings.Cut(strings.TrimPrefix(r.URL.Path, s.ipfsBasePath()), "/")
+	}
+}
+
+func accessLogEntryFromContext(ctx context.Context) *accesslog.Entry {
+	e, _ := ctx.Value(accessLogEntryKey{}).(*accesslog.Entry)
+	return e
+}
+
+// setAccessLogKey records the API key of the request in the access log
+func setAccessLogKey(ctx context.Context, key string) {
+	if e := accessLogEntryFromContext(ctx); e != nil {
+		e.Key = key
+	}
+}
+
+// setAccessLogCacheHit records whether the request was served from the cache
+func setAccessLogCacheHit(ctx context.Context, hit bool) {
+	if e := accessLogEntryFromContext(ctx); e != nil {
+		e.CacheHit = hit
+	}
+}
+
+// statusResponseWriter records the status code and the number of bytes
+// written to the response
+type statusResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	written     int64
+	wroteHeader bool
+}
+
+func (w *statusResponseWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusResponseWriter) Write(bz []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(bz)
+	w.written += int64(n)
+	return n, err
+}
+
+func (w *statusResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
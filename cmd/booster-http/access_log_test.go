@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/filecoin-project/boost/cmd/booster-http/accesslog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccessLogHandler(t *testing.T) {
+	dir := t.TempDir()
+	w, err := accesslog.NewWriter(dir, 0, 0, 0)
+	require.NoError(t, err)
+
+	s := NewHttpServer("", "0.0.0.0", 0, nil, &HttpServerOptions{ServePieces: true, AccessLog: w})
+	h := s.accessLogHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setAccessLogKey(r.Context(), "alice")
+		setAccessLogCacheHit(r.Context(), true)
+		w.WriteHeader(http.StatusPartialContent)
+		_, _ = w.Write([]byte("hello"))
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/piece/bafypiece?root=bafyroot", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusPartialContent, rec.Code)
+
+	// Requests through a reverse proxy should be logged with the client's IP
+	req = httptest.NewRequest(http.MethodGet, "/ipfs/bafyroot/file.txt", nil)
+	req.Header.Set("X-Forwarded-For", "10.0.0.2, 10.0.0.3")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	require.NoError(t, w.Close())
+
+	files, err := accesslog.Files(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	data, err := os.ReadFile(files[0])
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2)
+
+	var e accesslog.Entry
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &e))
+	require.Equal(t, "10.0.0.1", e.ClientIP)
+	require.Equal(t, "alice", e.Key)
+	require.Equal(t, "bafypiece", e.PieceCid)
+	require.Equal(t, "bafyroot", e.Cid)
+	require.Equal(t, http.StatusPartialContent, e.Status)
+	require.Equal(t, int64(5), e.Bytes)
+	require.True(t, e.CacheHit)
+
+	e = accesslog.Entry{}
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &e))
+	require.Equal(t, "10.0.0.2", e.ClientIP)
+	require.Equal(t, "/ipfs/bafyroot/file.txt", e.Path)
+	require.Equal(t, "bafyroot", e.Cid)
+	require.Empty(t, e.PieceCid)
+}
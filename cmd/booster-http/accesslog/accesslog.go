@@ -0,0 +1,211 @@
+package accesslog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// The name of the access log file that is currently being written to
+	currentFileName = "access.log"
+	// Rotated access log files are named access-<timestamp>.log
+	rotatedFilePrefix = "access-"
+	rotatedFileSuffix = ".log"
+	// The timestamp format used in rotated file names, which sorts by time
+	rotatedTimeFormat = "20060102T150405.000000000"
+)
+
+// Entry is a single request in the booster-http access log
+type Entry struct {
+	Time time.Time `json:"time"`
+	// The IP address of the client (from X-Forwarded-For if set)
+	ClientIP string `json:"clientIp,omitempty"`
+	// The peer ID of the client, for requests made over libp2p
+	PeerID string `json:"peerId,omitempty"`
+	// The name of the API key (or token issuer / subject) of the request
+	Key        string `json:"key,omitempty"`
+	Method     string `json:"method"`
+	Path       string `json:"path"`
+	Cid        string `json:"cid,omitempty"`
+	PieceCid   string `json:"pieceCid,omitempty"`
+	Status     int    `json:"status"`
+	Bytes      int64  `json:"bytes"`
+	DurationMs int64  `json:"durationMs"`
+	CacheHit   bool   `json:"cacheHit"`
+	UserAgent  string `json:"userAgent,omitempty"`
+}
+
+// Client identifies the client that made the request: the API key if the
+// request was authenticated, otherwise the peer ID or IP address
+func (e *Entry) Client() string {
+	switch {
+	case e.Key != "":
+		return "key:" + e.Key
+	case e.PeerID != "":
+		return e.PeerID
+	}
+	return e.ClientIP
+}
+
+// Writer writes access log entries as JSON lines to a file in a directory.
+// When the file reaches the maximum size it is rotated, and old files are
+// removed once there are more than the maximum number of files or they are
+// older than the maximum age.
+type Writer struct {
+	dir      string
+	maxSize  int64
+	maxFiles int
+	maxAge   time.Duration
+
+	lk   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+func NewWriter(dir string, maxSize int64, maxFiles int, maxAge time.Duration) (*Writer, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating access log directory %s: %w", dir, err)
+	}
+	w := &Writer{dir: dir, maxSize: maxSize, maxFiles: maxFiles, maxAge: maxAge}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	w.removeOld()
+	return w, nil
+}
+
+func (w *Writer) open() error {
+	path := filepath.Join(w.dir, currentFileName)
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("opening access log %s: %w", path, err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("getting size of access log %s: %w", path, err)
+	}
+	w.f = f
+	w.size = fi.Size()
+	return nil
+}
+
+// Write appends the entry to the access log
+func (w *Writer) Write(e *Entry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	w.lk.Lock()
+	defer w.lk.Unlock()
+
+	if w.f == nil {
+		return errors.New("access log is closed")
+	}
+	if w.maxSize > 0 && w.size > 0 && w.size+int64(len(line)) > w.maxSize {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := w.f.Write(line)
+	w.size += int64(n)
+	return err
+}
+
+// rotate renames the current file and opens a new one.
+// Must be called with the lock held.
+func (w *Writer) rotate() error {
+	if err := w.f.Close(); err != nil {
+		return fmt.Errorf("closing access log: %w", err)
+	}
+	w.f = nil
+
+	rotated := rotatedFilePrefix + time.Now().UTC().Format(rotatedTimeFormat) + rotatedFileSuffix
+	if err := os.Rename(filepath.Join(w.dir, currentFileName), filepath.Join(w.dir, rotated)); err != nil {
+		return fmt.Errorf("rotating access log: %w", err)
+	}
+	if err := w.open(); err != nil {
+		return err
+	}
+	w.removeOld()
+	return nil
+}
+
+// removeOld removes rotated files beyond the maximum number of files, and
+// files older than the maximum age
+func (w *Writer) removeOld() {
+	rotated, err := rotatedFiles(w.dir)
+	if err != nil {
+		return
+	}
+
+	// The current file counts towards the maximum number of files
+	excess := 0
+	if w.maxFiles > 0 {
+		excess = len(rotated) + 1 - w.maxFiles
+	}
+	for i, path := range rotated {
+		remove := i < excess
+		if !remove && w.maxAge > 0 {
+			if fi, err := os.Stat(path); err == nil && time.Since(fi.ModTime()) > w.maxAge {
+				remove = true
+			}
+		}
+		if remove {
+			_ = os.Remove(path)
+		}
+	}
+}
+
+func (w *Writer) Close() error {
+	w.lk.Lock()
+	defer w.lk.Unlock()
+
+	if w.f == nil {
+		return nil
+	}
+	err := w.f.Close()
+	w.f = nil
+	return err
+}
+
+// rotatedFiles returns the paths of the rotated files in the directory,
+// oldest first
+func rotatedFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, e := range entries {
+		name := e.Name()
+		if !e.IsDir() && strings.HasPrefix(name, rotatedFilePrefix) && strings.HasSuffix(name, rotatedFileSuffix) {
+			paths = append(paths, filepath.Join(dir, name))
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// Files returns the paths of the access log files in the directory, oldest
+// first
+func Files(dir string) ([]string, error) {
+	paths, err := rotatedFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	current := filepath.Join(dir, currentFileName)
+	if _, err := os.Stat(current); err == nil {
+		paths = append(paths, current)
+	}
+	return paths, nil
+}
@@ -0,0 +1,99 @@
+package accesslog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriterRotation(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriter(dir, 1024, 3, 0)
+	require.NoError(t, err)
+
+	// Write enough entries to rotate the file several times
+	for i := 0; i < 50; i++ {
+		err := w.Write(&Entry{Time: time.Now(), Method: "GET", Path: fmt.Sprintf("/piece/%d", i), Status: 200})
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+
+	// Only the maximum number of files should be kept
+	files, err := Files(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 3)
+	require.Equal(t, filepath.Join(dir, currentFileName), files[len(files)-1])
+	for _, f := range files {
+		fi, err := os.Stat(f)
+		require.NoError(t, err)
+		require.LessOrEqual(t, fi.Size(), int64(1024))
+	}
+
+	// The most recent entry should be in the current file
+	var last *Entry
+	require.NoError(t, readEntries(files[len(files)-1], func(e *Entry) { last = e }))
+	require.NotNil(t, last)
+	require.Equal(t, "/piece/49", last.Path)
+
+	// Re-opening the writer should append to the current file
+	w, err = NewWriter(dir, 1024, 3, 0)
+	require.NoError(t, err)
+	require.NoError(t, w.Write(&Entry{Time: time.Now(), Path: "/piece/50", Status: 200}))
+	require.NoError(t, w.Close())
+	require.NoError(t, readEntries(filepath.Join(dir, currentFileName), func(e *Entry) { last = e }))
+	require.Equal(t, "/piece/50", last.Path)
+}
+
+func TestSummarise(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriter(dir, 0, 0, 0)
+	require.NoError(t, err)
+
+	start := time.Now().Add(-time.Hour)
+	write := func(offset time.Duration, e Entry) {
+		e.Time = start.Add(offset)
+		require.NoError(t, w.Write(&e))
+	}
+
+	// An old entry that should be excluded from the summary
+	write(-time.Hour, Entry{ClientIP: "10.0.0.9", PieceCid: "piece3", Status: 200, Bytes: 1 << 20, DurationMs: 1000})
+
+	for i := 0; i < 98; i++ {
+		write(time.Duration(i)*time.Second, Entry{ClientIP: "10.0.0.1", PieceCid: "piece1", Status: 200, Bytes: 100, DurationMs: int64(i + 1), CacheHit: i%2 == 0})
+	}
+	write(100*time.Second, Entry{Key: "alice", PieceCid: "piece2", Status: 200, Bytes: 50000, DurationMs: 500})
+	write(101*time.Second, Entry{PeerID: "12D3KooW", Cid: "bafy", Status: 500, DurationMs: 1000})
+	require.NoError(t, w.Close())
+
+	s, err := Summarise(dir, start, 10)
+	require.NoError(t, err)
+	require.Equal(t, int64(100), s.Requests)
+	require.Equal(t, int64(98*100+50000), s.Bytes)
+	require.Equal(t, int64(1), s.ServerErrors)
+	require.Equal(t, int64(0), s.ClientErrors)
+	require.Equal(t, int64(49), s.CacheHits)
+	require.InDelta(t, 0.01, s.ErrorRate(), 0.0001)
+	require.Equal(t, 50*time.Millisecond, s.LatencyP50)
+	require.Equal(t, 500*time.Millisecond, s.LatencyP99)
+	require.True(t, s.From.Equal(start))
+	require.True(t, s.To.Equal(start.Add(101*time.Second)))
+
+	require.Len(t, s.TopPieces, 2)
+	require.Equal(t, Count{Name: "piece2", Requests: 1, Bytes: 50000}, s.TopPieces[0])
+	require.Equal(t, Count{Name: "piece1", Requests: 98, Bytes: 9800}, s.TopPieces[1])
+
+	require.Len(t, s.TopClients, 3)
+	require.Equal(t, "key:alice", s.TopClients[0].Name)
+	require.Equal(t, "10.0.0.1", s.TopClients[1].Name)
+	require.Equal(t, Count{Name: "12D3KooW", Requests: 1, Errors: 1}, s.TopClients[2])
+
+	// Only the top n should be returned
+	s, err = Summarise(dir, start, 1)
+	require.NoError(t, err)
+	require.Len(t, s.TopPieces, 1)
+	require.Len(t, s.TopClients, 1)
+}
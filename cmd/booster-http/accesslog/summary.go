@@ -0,0 +1,181 @@
+package accesslog
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// Count is the number of requests and bytes served for a piece or client
+type Count struct {
+	Name     string
+	Requests int64
+	Bytes    int64
+	Errors   int64
+}
+
+// Summary summarises the requests in the access log
+type Summary struct {
+	// The time of the first and last requests in the summary
+	From time.Time
+	To   time.Time
+
+	Requests     int64
+	Bytes        int64
+	ClientErrors int64 // 4xx responses
+	ServerErrors int64 // 5xx responses
+	CacheHits    int64
+
+	LatencyP50 time.Duration
+	LatencyP99 time.Duration
+
+	// The pieces and clients with the most bytes served
+	TopPieces  []Count
+	TopClients []Count
+}
+
+// ErrorRate is the fraction of requests that failed with a server error
+func (s *Summary) ErrorRate() float64 {
+	if s.Requests == 0 {
+		return 0
+	}
+	return float64(s.ServerErrors) / float64(s.Requests)
+}
+
+// Summarise reads the access logs in the directory and summarises the
+// requests made since the given time, including the top n pieces and clients
+func Summarise(dir string, since time.Time, n int) (*Summary, error) {
+	paths, err := Files(dir)
+	if err != nil {
+		return nil, fmt.Errorf("listing access logs in %s: %w", dir, err)
+	}
+
+	s := &Summary{}
+	pieces := make(map[string]*Count)
+	clients := make(map[string]*Count)
+	var durations []int64
+	for _, path := range paths {
+		err := readEntries(path, func(e *Entry) {
+			if e.Time.Before(since) {
+				return
+			}
+
+			if s.From.IsZero() || e.Time.Before(s.From) {
+				s.From = e.Time
+			}
+			if e.Time.After(s.To) {
+				s.To = e.Time
+			}
+			s.Requests++
+			s.Bytes += e.Bytes
+			isErr := false
+			switch {
+			case e.Status >= 500:
+				s.ServerErrors++
+				isErr = true
+			case e.Status >= 400:
+				s.ClientErrors++
+				isErr = true
+			}
+			if e.CacheHit {
+				s.CacheHits++
+			}
+			durations = append(durations, e.DurationMs)
+
+			if e.PieceCid != "" {
+				addCount(pieces, e.PieceCid, e, isErr)
+			}
+			if client := e.Client(); client != "" {
+				addCount(clients, client, e, isErr)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	s.LatencyP50 = percentile(durations, 50)
+	s.LatencyP99 = percentile(durations, 99)
+	s.TopPieces = topCounts(pieces, n)
+	s.TopClients = topCounts(clients, n)
+	return s, nil
+}
+
+// readEntries calls cb for each entry in the access log file.
+// Lines that can't be parsed are skipped.
+func readEntries(path string, cb func(e *Entry)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// The file may have been removed by rotation
+			return nil
+		}
+		return fmt.Errorf("opening access log %s: %w", path, err)
+	}
+	defer f.Close() //nolint:errcheck
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		cb(&e)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading access log %s: %w", path, err)
+	}
+	return nil
+}
+
+func addCount(counts map[string]*Count, name string, e *Entry, isErr bool) {
+	c, ok := counts[name]
+	if !ok {
+		c = &Count{Name: name}
+		counts[name] = c
+	}
+	c.Requests++
+	c.Bytes += e.Bytes
+	if isErr {
+		c.Errors++
+	}
+}
+
+// topCounts returns the n counts with the most bytes
+func topCounts(counts map[string]*Count, n int) []Count {
+	all := make([]Count, 0, len(counts))
+	for _, c := range counts {
+		all = append(all, *c)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Bytes != all[j].Bytes {
+			return all[i].Bytes > all[j].Bytes
+		}
+		if all[i].Requests != all[j].Requests {
+			return all[i].Requests > all[j].Requests
+		}
+		return all[i].Name < all[j].Name
+	})
+	if n >= 0 && len(all) > n {
+		all = all[:n]
+	}
+	return all
+}
+
+// percentile returns the p-th percentile of the sorted durations in
+// milliseconds
+func percentile(sorted []int64, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := (len(sorted)*p+99)/100 - 1
+	if idx < 0 {
+		idx = 0
+	}
+	return time.Duration(sorted[idx]) * time.Millisecond
+}
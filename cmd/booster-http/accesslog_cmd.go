@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/filecoin-project/boost/cmd/booster-http/accesslog"
+	"github.com/urfave/cli/v2"
+)
+
+var accessLogStatsCmd = &cli.Command{
+	Name:      "access-log-stats",
+	Usage:     "Summarise the booster-http access log",
+	ArgsUsage: "<access log dir>",
+	Before:    before,
+	Flags: []cli.Flag{
+		&cli.DurationFlag{
+			Name:  "since",
+			Usage: "only summarise requests made in this time period",
+			Value: 24 * time.Hour,
+		},
+		&cli.IntFlag{
+			Name:  "top",
+			Usage: "the number of top pieces and clients to show",
+			Value: 10,
+		},
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "output the summary as JSON",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		if cctx.Args().Len() != 1 {
+			return fmt.Errorf("usage: access-log-stats <access log dir>")
+		}
+
+		since := time.Now().Add(-cctx.Duration("since"))
+		s, err := accesslog.Summarise(cctx.Args().First(), since, cctx.Int("top"))
+		if err != nil {
+			return err
+		}
+
+		w := cctx.App.Writer
+		if cctx.Bool("json") {
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			return enc.Encode(s)
+		}
+
+		if s.Requests == 0 {
+			fmt.Fprintf(w, "No requests since %s\n", since.Format(time.RFC3339))
+			return nil
+		}
+
+		fmt.Fprintf(w, "Requests from %s to %s\n", s.From.Format(time.RFC3339), s.To.Format(time.RFC3339))
+		tw := tabwriter.NewWriter(w, 4, 4, 2, ' ', 0)
+		fmt.Fprintf(tw, "Requests:\t%d\n", s.Requests)
+		fmt.Fprintf(tw, "Bytes sent:\t%s\n", humanize.IBytes(uint64(s.Bytes)))
+		fmt.Fprintf(tw, "Client errors (4xx):\t%d\n", s.ClientErrors)
+		fmt.Fprintf(tw, "Server errors (5xx):\t%d (%.2f%%)\n", s.ServerErrors, 100*s.ErrorRate())
+		fmt.Fprintf(tw, "Piece cache hits:\t%d\n", s.CacheHits)
+		fmt.Fprintf(tw, "Latency p50:\t%s\n", s.LatencyP50)
+		fmt.Fprintf(tw, "Latency p99:\t%s\n", s.LatencyP99)
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+
+		printCounts := func(title string, counts []accesslog.Count) error {
+			fmt.Fprintf(w, "\n%s\n", title)
+			tw := tabwriter.NewWriter(w, 4, 4, 2, ' ', 0)
+			fmt.Fprintf(tw, "Name\tRequests\tBytes\tErrors\n")
+			for _, c := range counts {
+				fmt.Fprintf(tw, "%s\t%d\t%s\t%d\n", c.Name, c.Requests, humanize.IBytes(uint64(c.Bytes)), c.Errors)
+			}
+			return tw.Flush()
+		}
+		if err := printCounts("Top pieces", s.TopPieces); err != nil {
+			return err
+		}
+		return printCounts("Top clients", s.TopClients)
+	},
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/filecoin-project/boost/metrics"
+	"github.com/gbrlsnchs/jwt/v3"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+)
+
+var errUnauthorized = errors.New("unauthorized")
+
+// AuthConfig is the format of the file passed to booster-http with
+// --auth-config. It lists the API keys and the token issuers that are allowed
+// to retrieve data, and the quota for each.
+type AuthConfig struct {
+	// The period over which request and byte quotas apply, eg "24h".
+	// If empty, usage accumulates from when booster-http starts.
+	// Usage is kept in memory, so quotas apply to each booster-http process
+	// separately and are reset when it restarts.
+	QuotaPeriod string
+	// API keys that may be passed in the Authorization header as a bearer
+	// token
+	Keys []APIKeyConfig
+	// Issuers of signed bearer tokens (JWTs)
+	Issuers []TokenIssuerConfig
+}
+
+// Quota limits the usage of a single API key or token subject.
+// A zero value means no limit.
+type Quota struct {
+	// The maximum number of requests per quota period
+	Requests int64
+	// The maximum number of bytes served per quota period
+	Bytes int64
+	// The maximum number of requests that may be served concurrently
+	ConcurrentStreams int64
+}
+
+type APIKeyConfig struct {
+	// The name of the key, used in the usage ledger and in metrics
+	Name string
+	// The secret value of the key
+	Key   string
+	Quota Quota
+}
+
+type TokenIssuerConfig struct {
+	// Tokens are matched to an issuer by their iss claim
+	Issuer string
+	// Tokens must include this value in their aud claim, so that tokens
+	// issued for other services are not accepted
+	Audience string
+	// The algorithm used to sign tokens: HS256 or EdDSA
+	Algorithm string
+	// For HS256, the shared secret
+	Secret string
+	// For EdDSA, the base64 encoded ed25519 public key
+	PublicKey string
+	// The quota for each subject (the sub claim) of a token from the issuer
+	Quota Quota
+}
+
+func loadAuthConfig(path string) (*AuthConfig, error) {
+	bz, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading auth config file: %w", err)
+	}
+
+	var cfg AuthConfig
+	if err := json.Unmarshal(bz, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing auth config file %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// authIdentity is the identity of an authenticated request
+type authIdentity struct {
+	// The key in the usage ledger
+	ID string
+	// The name of the API key or the token issuer, used to tag metrics
+	// (token subjects are not used so as to bound the number of metrics)
+	Group string
+	Quota Quota
+}
+
+type tokenIssuer struct {
+	cfg TokenIssuerConfig
+	alg jwt.Algorithm
+}
+
+// Authenticator checks that requests have a valid API key or bearer token,
+// and enforces the quota for the key
+type Authenticator struct {
+	keys    map[string]APIKeyConfig
+	issuers []tokenIssuer
+	ledger  *usageLedger
+}
+
+func NewAuthenticator(cfg AuthConfig) (*Authenticator, error) {
+	var period time.Duration
+	if cfg.QuotaPeriod != "" {
+		var err error
+		period, err = time.ParseDuration(cfg.QuotaPeriod)
+		if err != nil {
+			return nil, fmt.Errorf("parsing quota period '%s': %w", cfg.QuotaPeriod, err)
+		}
+	}
+
+	a := &Authenticator{
+		keys:   make(map[string]APIKeyConfig, len(cfg.Keys)),
+		ledger: newUsageLedger(period),
+	}
+	for _, k := range cfg.Keys {
+		if k.Name == "" || k.Key == "" {
+			return nil, errors.New("API keys must have a name and a key")
+		}
+		a.keys[k.Key] = k
+	}
+
+	for _, iss := range cfg.Issuers {
+		if iss.Audience == "" {
+			return nil, fmt.Errorf("token issuer %s: an audience is required", iss.Issuer)
+		}
+
+		var alg jwt.Algorithm
+		switch iss.Algorithm {
+		case "HS256":
+			if iss.Secret == "" {
+				return nil, fmt.Errorf("token issuer %s: HS256 requires a secret", iss.Issuer)
+			}
+			alg = jwt.NewHS256([]byte(iss.Secret))
+		case "EdDSA":
+			pub, err := base64.StdEncoding.DecodeString(iss.PublicKey)
+			if err != nil || len(pub) != ed25519.PublicKeySize {
+				return nil, fmt.Errorf("token issuer %s: EdDSA requires a base64 encoded ed25519 public key", iss.Issuer)
+			}
+			alg = jwt.NewEd25519(jwt.Ed25519PublicKey(pub))
+		default:
+			return nil, fmt.Errorf("token issuer %s: unsupported algorithm '%s'", iss.Issuer, iss.Algorithm)
+		}
+		a.issuers = append(a.issuers, tokenIssuer{cfg: iss, alg: alg})
+	}
+
+	return a, nil
+}
+
+// identify returns the identity for the bearer token in the request
+func (a *Authenticator) identify(r *http.Request) (*authIdentity, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return nil, fmt.Errorf("missing bearer token: %w", errUnauthorized)
+	}
+
+	if k, ok := a.keys[token]; ok {
+		return &authIdentity{ID: k.Name, Group: k.Name, Quota: k.Quota}, nil
+	}
+
+	// Check if the token is a JWT signed by one of the configured issuers
+	if strings.Count(token, ".") == 2 {
+		now := time.Now()
+		for _, iss := range a.issuers {
+			var pl jwt.Payload
+			_, err := jwt.Verify([]byte(token), iss.alg, &pl, jwt.ValidatePayload(&pl,
+				jwt.IssuerValidator(iss.cfg.Issuer),
+				jwt.AudienceValidator(jwt.Audience{iss.cfg.Audience}),
+				jwt.ExpirationTimeValidator(now),
+				jwt.NotBeforeValidator(now)))
+			if err != nil || pl.Subject == "" {
+				continue
+			}
+			return &authIdentity{
+				ID:    iss.cfg.Issuer + "/" + pl.Subject,
+				Group: iss.cfg.Issuer,
+				Quota: iss.cfg.Quota,
+			}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("invalid bearer token: %w", errUnauthorized)
+}
+
+// handler wraps the given handler so that requests must be authenticated,
+// and are counted against the quota for their API key
+func (a *Authenticator) handler(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := a.identify(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="booster-http"`)
+			writeError(w, r, http.StatusUnauthorized, err)
+			return
+		}
+
+		setAccessLogKey(r.Context(), id.ID)
+		ctx, _ := tag.New(r.Context(), tag.Upsert(metrics.APIKey, id.Group))
+		stream, err := a.ledger.begin(id)
+		if err != nil {
+			stats.Record(ctx, metrics.HttpAuthRejectedCount.M(1))
+			writeError(w, r, http.StatusTooManyRequests, err)
+			return
+		}
+		stats.Record(ctx, metrics.HttpAuthRequestCount.M(1))
+
+		mw := &meteredResponseWriter{ResponseWriter: w, stream: stream}
+		defer func() {
+			stream.end()
+			stats.Record(ctx, metrics.HttpAuthBytesSentCount.M(mw.written))
+		}()
+
+		next.ServeHTTP(mw, r.WithContext(context.WithValue(r.Context(), authIdentityKey{}, id)))
+	}
+}
+
+// usage returns the usage for the credentials in the request
+func (a *Authenticator) usage(r *http.Request) (*KeyUsage, error) {
+	id, err := a.identify(r)
+	if err != nil {
+		return nil, err
+	}
+	u := a.ledger.usage(id.ID)
+	return &u, nil
+}
+
+type authIdentityKey struct{}
+
+// authIdentityFromContext returns the identity of an authenticated request,
+// or nil if the request was not authenticated
+func authIdentityFromContext(ctx context.Context) *authIdentity {
+	id, _ := ctx.Value(authIdentityKey{}).(*authIdentity)
+	return id
+}
+
+// meteredResponseWriter counts the number of bytes written to the response
+// against the key's byte quota, and stops writing when the quota is
+// exhausted
+type meteredResponseWriter struct {
+	http.ResponseWriter
+	stream  *usageStream
+	written int64
+}
+
+func (w *meteredResponseWriter) Write(bz []byte) (int, error) {
+	n := w.stream.reserve(len(bz))
+	written, err := w.ResponseWriter.Write(bz[:n])
+	w.written += int64(written)
+	w.stream.refund(n - written)
+	if err != nil {
+		return written, err
+	}
+	if n < len(bz) {
+		return written, fmt.Errorf("byte quota of %d bytes exceeded", w.stream.quota.Bytes)
+	}
+	return written, nil
+}
+
+func (w *meteredResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
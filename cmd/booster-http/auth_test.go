@@ -0,0 +1,246 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gbrlsnchs/jwt/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthenticator(t *testing.T) {
+	auth, err := NewAuthenticator(AuthConfig{
+		Keys: []APIKeyConfig{{
+			Name:  "partner",
+			Key:   "secret-key",
+			Quota: Quota{Requests: 2, Bytes: 1000},
+		}},
+		Issuers: []TokenIssuerConfig{{
+			Issuer:    "issuer",
+			Audience:  "booster-http",
+			Algorithm: "HS256",
+			Secret:    "issuer-secret",
+			Quota:     Quota{ConcurrentStreams: 1},
+		}},
+	})
+	require.NoError(t, err)
+
+	block := make(chan struct{})
+	handler := auth.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/block" {
+			<-block
+		}
+		_, _ = w.Write([]byte(strings.Repeat("a", 100)))
+	}))
+
+	get := func(path string, token string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec.Code
+	}
+
+	// Requests without a valid token should be rejected
+	require.Equal(t, http.StatusUnauthorized, get("/", ""))
+	require.Equal(t, http.StatusUnauthorized, get("/", "wrong-key"))
+
+	// Requests with the API key should succeed until the request quota is
+	// exceeded
+	require.Equal(t, http.StatusOK, get("/", "secret-key"))
+	require.Equal(t, http.StatusOK, get("/", "secret-key"))
+	require.Equal(t, http.StatusTooManyRequests, get("/", "secret-key"))
+
+	req := httptest.NewRequest(http.MethodGet, "/info", nil)
+	req.Header.Set("Authorization", "Bearer secret-key")
+	usage, err := auth.usage(req)
+	require.NoError(t, err)
+	require.Equal(t, "partner", usage.Key)
+	require.EqualValues(t, 2, usage.Requests)
+	require.EqualValues(t, 200, usage.Bytes)
+	require.EqualValues(t, 1, usage.Rejected)
+
+	// A token signed by the issuer should be accepted
+	signFor := func(aud jwt.Audience, iss string, secret string, exp time.Time) string {
+		pl := jwt.Payload{
+			Issuer:         iss,
+			Subject:        "client",
+			Audience:       aud,
+			ExpirationTime: jwt.NumericDate(exp),
+		}
+		tok, err := jwt.Sign(&pl, jwt.NewHS256([]byte(secret)))
+		require.NoError(t, err)
+		return string(tok)
+	}
+	sign := func(iss string, secret string, exp time.Time) string {
+		return signFor(jwt.Audience{"booster-http"}, iss, secret, exp)
+	}
+	token := sign("issuer", "issuer-secret", time.Now().Add(time.Hour))
+	require.Equal(t, http.StatusOK, get("/", token))
+
+	// Tokens with the wrong signature, or that have expired, should be
+	// rejected
+	require.Equal(t, http.StatusUnauthorized, get("/", sign("issuer", "wrong-secret", time.Now().Add(time.Hour))))
+	require.Equal(t, http.StatusUnauthorized, get("/", sign("issuer", "issuer-secret", time.Now().Add(-time.Hour))))
+
+	// Tokens for another audience, or without an audience, should be rejected
+	require.Equal(t, http.StatusUnauthorized, get("/", signFor(jwt.Audience{"other-service"}, "issuer", "issuer-secret", time.Now().Add(time.Hour))))
+	require.Equal(t, http.StatusUnauthorized, get("/", signFor(nil, "issuer", "issuer-secret", time.Now().Add(time.Hour))))
+
+	// Only one request at a time should be allowed for the token subject
+	done := make(chan int)
+	go func() {
+		done <- get("/block", token)
+	}()
+	require.Eventually(t, func() bool {
+		return auth.ledger.usage("issuer/client").ActiveStreams == 1
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, http.StatusTooManyRequests, get("/", token))
+	close(block)
+	require.Equal(t, http.StatusOK, <-done)
+	require.Equal(t, http.StatusOK, get("/", token))
+
+	// Token issuers must be configured with an audience
+	_, err = NewAuthenticator(AuthConfig{
+		Issuers: []TokenIssuerConfig{{Issuer: "issuer", Algorithm: "HS256", Secret: "issuer-secret"}},
+	})
+	require.Error(t, err)
+}
+
+func TestUsageLedgerPeriod(t *testing.T) {
+	now := time.Now()
+	l := newUsageLedger(time.Hour)
+	l.now = func() time.Time { return now }
+
+	id := &authIdentity{ID: "key", Quota: Quota{Requests: 1}}
+	stream, err := l.begin(id)
+	require.NoError(t, err)
+	require.Equal(t, 10, stream.reserve(10))
+	stream.end()
+
+	_, err = l.begin(id)
+	require.Error(t, err)
+
+	// The quota should be reset at the start of the next period
+	now = now.Add(time.Hour)
+	stream, err = l.begin(id)
+	require.NoError(t, err)
+	require.Equal(t, 5, stream.reserve(5))
+	stream.end()
+
+	u := l.usage("key")
+	require.EqualValues(t, 1, u.Requests)
+	require.EqualValues(t, 5, u.Bytes)
+
+	// Idle entries should be evicted once their period has ended
+	other := &authIdentity{ID: "other"}
+	now = now.Add(2 * time.Hour)
+	stream, err = l.begin(other)
+	require.NoError(t, err)
+	require.NotContains(t, l.keys, "key")
+	require.Contains(t, l.keys, "other")
+
+	// Entries with active streams should not be evicted
+	now = now.Add(2 * time.Hour)
+	_, err = l.begin(id)
+	require.NoError(t, err)
+	require.Contains(t, l.keys, "other")
+	stream.end()
+}
+
+func TestUsageLedgerIdle(t *testing.T) {
+	now := time.Now()
+	l := newUsageLedger(0)
+	l.now = func() time.Time { return now }
+
+	// Without a quota period, usage is only evicted for keys without a
+	// request or byte quota
+	limited := &authIdentity{ID: "limited", Quota: Quota{Bytes: 100}}
+	unlimited := &authIdentity{ID: "unlimited"}
+	for _, id := range []*authIdentity{limited, unlimited} {
+		stream, err := l.begin(id)
+		require.NoError(t, err)
+		stream.end()
+	}
+
+	now = now.Add(ledgerIdleTimeout)
+	_, err := l.begin(&authIdentity{ID: "new"})
+	require.NoError(t, err)
+	require.Contains(t, l.keys, "limited")
+	require.NotContains(t, l.keys, "unlimited")
+}
+
+func TestUsageLedgerMaxKeys(t *testing.T) {
+	now := time.Now()
+	l := newUsageLedger(0)
+	l.maxKeys = 2
+	l.now = func() time.Time { return now }
+
+	begin := func(key string) *usageStream {
+		now = now.Add(time.Second)
+		stream, err := l.begin(&authIdentity{ID: key, Quota: Quota{Requests: 10}})
+		require.NoError(t, err)
+		return stream
+	}
+
+	// When the ledger is full, the least recently used key without active
+	// streams should be evicted
+	active := begin("active")
+	begin("a").end()
+	begin("b").end()
+	require.Len(t, l.keys, 2)
+	require.Contains(t, l.keys, "active")
+	require.Contains(t, l.keys, "b")
+
+	active.end()
+	begin("b").end()
+	begin("c").end()
+	require.Len(t, l.keys, 2)
+	require.NotContains(t, l.keys, "active")
+	require.Contains(t, l.keys, "b")
+	require.Contains(t, l.keys, "c")
+}
+
+func TestAuthenticatorByteQuota(t *testing.T) {
+	auth, err := NewAuthenticator(AuthConfig{
+		Keys: []APIKeyConfig{{
+			Name:  "partner",
+			Key:   "secret-key",
+			Quota: Quota{Bytes: 150},
+		}},
+	})
+	require.NoError(t, err)
+
+	var writeErr error
+	handler := auth.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, writeErr = w.Write([]byte(strings.Repeat("a", 100)))
+	}))
+	get := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer secret-key")
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	// The second response should be truncated when the byte quota is
+	// exhausted
+	rec := get()
+	require.NoError(t, writeErr)
+	require.Equal(t, 100, rec.Body.Len())
+	rec = get()
+	require.Error(t, writeErr)
+	require.Equal(t, 50, rec.Body.Len())
+	require.Equal(t, http.StatusTooManyRequests, get().Code)
+
+	req := httptest.NewRequest(http.MethodGet, "/info", nil)
+	req.Header.Set("Authorization", "Bearer secret-key")
+	usage, err := auth.usage(req)
+	require.NoError(t, err)
+	require.EqualValues(t, 150, usage.Bytes)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/filecoin-project/boost/metrics"
+	"github.com/ipfs/boxo/blockstore"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+	"go.opencensus.io/stats"
+)
+
+// The maximum number of blocks for which request counts are kept when
+// deciding which blocks to admit to the cache
+const blockCacheMaxTracked = 1 << 20
+
+// PieceLookup returns the pieces that contain the multihash
+type PieceLookup func(ctx context.Context, m multihash.Multihash) ([]cid.Cid, error)
+
+// CachingBlockstore keeps small blocks that are requested frequently in
+// memory, in front of a (remote) blockstore.
+// The pieces that contain each cached block are recorded, so that the block
+// can be removed from the cache when the deals for those pieces are removed.
+type CachingBlockstore struct {
+	blockstore.Blockstore
+	maxBlockSize int
+	pieces       PieceLookup
+
+	lk     sync.Mutex
+	blocks *sizedLRU[cid.Cid, blocks.Block]
+	admit  *admissionFilter[cid.Cid]
+	// the pieces that contain each cached block
+	owners map[cid.Cid][]cid.Cid
+}
+
+func NewCachingBlockstore(bs blockstore.Blockstore, pieces PieceLookup, maxBytes int64, maxBlockSize int, admitAfter int) *CachingBlockstore {
+	cb := &CachingBlockstore{
+		Blockstore:   bs,
+		maxBlockSize: maxBlockSize,
+		pieces:       pieces,
+		admit:        newAdmissionFilter[cid.Cid](admitAfter, blockCacheMaxTracked),
+		owners:       make(map[cid.Cid][]cid.Cid),
+	}
+	cb.blocks = newSizedLRU[cid.Cid, blocks.Block](maxBytes, func(c cid.Cid, _ blocks.Block) {
+		delete(cb.owners, c)
+	})
+	return cb
+}
+
+func (cb *CachingBlockstore) cached(c cid.Cid) (blocks.Block, bool) {
+	cb.lk.Lock()
+	defer cb.lk.Unlock()
+	return cb.blocks.get(c)
+}
+
+func (cb *CachingBlockstore) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	if blk, ok := cb.cached(c); ok {
+		stats.Record(ctx, metrics.HttpBlockCacheHitCount.M(1))
+		return blk, nil
+	}
+	stats.Record(ctx, metrics.HttpBlockCacheMissCount.M(1))
+
+	blk, err := cb.Blockstore.Get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(blk.RawData()) <= cb.maxBlockSize {
+		cb.lk.Lock()
+		admit := cb.admit.admit(c)
+		cb.lk.Unlock()
+		if admit {
+			cb.add(ctx, blk)
+		}
+	}
+	return blk, nil
+}
+
+// add adds the block to the cache, if the pieces that contain it can be
+// found. A block whose pieces aren't known is not cached, because it could
+// not be removed when the deals for those pieces are removed.
+func (cb *CachingBlockstore) add(ctx context.Context, blk blocks.Block) {
+	pieces, err := cb.pieces(ctx, blk.Cid().Hash())
+	if err != nil || len(pieces) == 0 {
+		log.Debugw("not caching block: looking up pieces containing block", "cid", blk.Cid(), "err", err)
+		return
+	}
+
+	cb.lk.Lock()
+	defer cb.lk.Unlock()
+	cb.blocks.add(blk.Cid(), blk, int64(len(blk.RawData())))
+	cb.owners[blk.Cid()] = pieces
+}
+
+func (cb *CachingBlockstore) GetSize(ctx context.Context, c cid.Cid) (int, error) {
+	if blk, ok := cb.cached(c); ok {
+		return len(blk.RawData()), nil
+	}
+	return cb.Blockstore.GetSize(ctx, c)
+}
+
+func (cb *CachingBlockstore) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	if _, ok := cb.cached(c); ok {
+		return true, nil
+	}
+	return cb.Blockstore.Has(ctx, c)
+}
+
+// cachedPieces returns the pieces that contain cached blocks
+func (cb *CachingBlockstore) cachedPieces() []cid.Cid {
+	cb.lk.Lock()
+	defer cb.lk.Unlock()
+
+	set := make(map[cid.Cid]struct{})
+	for _, pieces := range cb.owners {
+		for _, pieceCid := range pieces {
+			set[pieceCid] = struct{}{}
+		}
+	}
+	pcids := make([]cid.Cid, 0, len(set))
+	for pieceCid := range set {
+		pcids = append(pcids, pieceCid)
+	}
+	return pcids
+}
+
+// invalidate removes the piece from the pieces that contain each cached
+// block, and removes the blocks that are no longer in any piece
+func (cb *CachingBlockstore) invalidate(pieceCid cid.Cid) {
+	cb.lk.Lock()
+	defer cb.lk.Unlock()
+
+	var remove []cid.Cid
+	for c, pieces := range cb.owners {
+		remaining := make([]cid.Cid, 0, len(pieces))
+		for _, p := range pieces {
+			if p != pieceCid {
+				remaining = append(remaining, p)
+			}
+		}
+		if len(remaining) == 0 {
+			remove = append(remove, c)
+		} else {
+			cb.owners[c] = remaining
+		}
+	}
+	for _, c := range remove {
+		cb.blocks.remove(c)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/filecoin-project/boost/testutil"
+	bstore "github.com/ipfs/boxo/blockstore"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCachingBlockstore(t *testing.T) {
+	ctx := context.Background()
+	bs := bstore.NewBlockstore(dss.MutexWrap(datastore.NewMapDatastore()))
+	small := testutil.GenerateBlocksOfSize(1, 100)[0]
+	large := testutil.GenerateBlocksOfSize(1, 1000)[0]
+	require.NoError(t, bs.PutMany(ctx, []blocks.Block{small, large}))
+
+	// The small block is in two pieces
+	pieceA := testutil.GenerateCid()
+	pieceB := testutil.GenerateCid()
+	pieces := func(ctx context.Context, m multihash.Multihash) ([]cid.Cid, error) {
+		return []cid.Cid{pieceA, pieceB}, nil
+	}
+
+	cb := NewCachingBlockstore(bs, pieces, 10_000, 500, 2)
+
+	// The small block should be cached after it has been requested twice
+	for i := 0; i < 2; i++ {
+		blk, err := cb.Get(ctx, small.Cid())
+		require.NoError(t, err)
+		require.Equal(t, small.RawData(), blk.RawData())
+	}
+	require.True(t, cb.blocks.contains(small.Cid()))
+
+	// The large block should never be cached
+	for i := 0; i < 3; i++ {
+		blk, err := cb.Get(ctx, large.Cid())
+		require.NoError(t, err)
+		require.Equal(t, large.RawData(), blk.RawData())
+	}
+	require.False(t, cb.blocks.contains(large.Cid()))
+
+	// The cached block should be served from the cache
+	require.NoError(t, bs.DeleteBlock(ctx, small.Cid()))
+	blk, err := cb.Get(ctx, small.Cid())
+	require.NoError(t, err)
+	require.Equal(t, small.RawData(), blk.RawData())
+	has, err := cb.Has(ctx, small.Cid())
+	require.NoError(t, err)
+	require.True(t, has)
+	size, err := cb.GetSize(ctx, small.Cid())
+	require.NoError(t, err)
+	require.Equal(t, len(small.RawData()), size)
+
+	require.ElementsMatch(t, []cid.Cid{pieceA, pieceB}, cb.cachedPieces())
+
+	// The block should stay in the cache while one of its pieces remains
+	cb.invalidate(pieceA)
+	_, err = cb.Get(ctx, small.Cid())
+	require.NoError(t, err)
+	require.Equal(t, []cid.Cid{pieceB}, cb.cachedPieces())
+
+	// After both pieces are removed the block should no longer be found
+	cb.invalidate(pieceB)
+	_, err = cb.Get(ctx, small.Cid())
+	require.Error(t, err)
+	require.Empty(t, cb.cachedPieces())
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/filecoin-project/boost/extern/boostd-data/shared/tracing"
+	"github.com/ipfs/boxo/blockservice"
+	"github.com/ipfs/boxo/blockstore"
+	offline "github.com/ipfs/boxo/exchange/offline"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	unixfsio "github.com/ipfs/boxo/ipld/unixfs/io"
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+)
+
+// isTrustlessRequest returns true if the request is for a CAR file or a raw
+// block, as opposed to a deserialized file
+func isTrustlessRequest(r *http.Request) bool {
+	switch r.URL.Query().Get("format") {
+	case "car", "raw":
+		return true
+	}
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "application/vnd.ipld.car") || strings.Contains(accept, "application/vnd.ipld.raw")
+}
+
+// ipfsHandler routes requests for CAR files and raw blocks to the trustless
+// gateway handler, and other requests to the files handler.
+// Either handler may be nil if it's not enabled.
+func ipfsHandler(trustless http.Handler, files http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if isTrustlessRequest(r) {
+			if trustless == nil {
+				writeError(w, r, http.StatusNotAcceptable, errors.New("serving CAR files and raw blocks is disabled"))
+				return
+			}
+			trustless.ServeHTTP(w, r)
+			return
+		}
+
+		if files == nil {
+			// Let the trustless gateway respond to the request, so that the
+			// client gets the same response as when serving files is disabled
+			trustless.ServeHTTP(w, r)
+			return
+		}
+		files.ServeHTTP(w, r)
+	}
+}
+
+// fileHandler serves UnixFS files and directory listings by path, eg
+// /ipfs/<root cid>/path/to/file.jpg
+type fileHandler struct {
+	basePath string
+	dserv    format.DAGService
+}
+
+func newFileHandler(basePath string, bs blockstore.Blockstore) *fileHandler {
+	return &fileHandler{
+		basePath: basePath,
+		dserv:    merkledag.NewDAGService(blockservice.New(bs, offline.Exchange(bs))),
+	}
+}
+
+func (h *fileHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.Tracer.Start(r.Context(), "http.file")
+	defer span.End()
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeError(w, r, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	// Remove the path up to the root cid
+	rootCidStr, filePath, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, h.basePath), "/")
+	if rootCidStr == "" {
+		writeError(w, r, http.StatusBadRequest, fmt.Errorf("path '%s' is missing root CID", r.URL.Path))
+		return
+	}
+	rootCid, err := cid.Parse(rootCidStr)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, fmt.Errorf("parsing root CID '%s': %s", rootCidStr, err.Error()))
+		return
+	}
+
+	nd, err := h.resolve(ctx, rootCid, filePath)
+	if err != nil {
+		if isNotFoundError(err) {
+			writeError(w, r, http.StatusNotFound, err)
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, fmt.Errorf("server error resolving path %s: %s", r.URL.Path, err.Error()))
+		return
+	}
+
+	name := path.Base(filePath)
+	dir, err := unixfsio.NewDirectoryFromNode(h.dserv, nd)
+	switch {
+	case err == nil:
+		// Directory paths must end with a slash so that relative links in
+		// the directory listing (or index.html) resolve correctly
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			redirect := r.URL.Path + "/"
+			if r.URL.RawQuery != "" {
+				redirect += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, redirect, http.StatusMovedPermanently)
+			return
+		}
+
+		// If the directory has an index.html file, serve it instead of the
+		// directory listing
+		idx, err := dir.Find(ctx, "index.html")
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				writeError(w, r, http.StatusInternalServerError, fmt.Errorf("server error reading directory %s: %s", r.URL.Path, err.Error()))
+				return
+			}
+			h.serveDirectory(ctx, w, r, nd.Cid(), dir, strings.Trim(filePath, "/") == "")
+			return
+		}
+		nd, name = idx, "index.html"
+	case !errors.Is(err, unixfsio.ErrNotADir):
+		writeError(w, r, http.StatusInternalServerError, fmt.Errorf("server error reading directory %s: %s", r.URL.Path, err.Error()))
+		return
+	}
+
+	h.serveFile(ctx, w, r, nd, name)
+}
+
+// resolve follows the path from the root node through UnixFS directories
+func (h *fileHandler) resolve(ctx context.Context, rootCid cid.Cid, filePath string) (format.Node, error) {
+	nd, err := h.dserv.Get(ctx, rootCid)
+	if err != nil {
+		return nil, fmt.Errorf("getting root %s: %w", rootCid, err)
+	}
+
+	resolved := rootCid.String()
+	for _, name := range strings.Split(filePath, "/") {
+		if name == "" {
+			continue
+		}
+
+		dir, err := unixfsio.NewDirectoryFromNode(h.dserv, nd)
+		if err != nil {
+			if errors.Is(err, unixfsio.ErrNotADir) {
+				return nil, fmt.Errorf("%s is not a directory: %w", resolved, ErrNotFound)
+			}
+			return nil, fmt.Errorf("reading directory %s: %w", resolved, err)
+		}
+
+		nd, err = dir.Find(ctx, name)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil, fmt.Errorf("%s not found in %s: %w", name, resolved, ErrNotFound)
+			}
+			return nil, fmt.Errorf("finding %s in %s: %w", name, resolved, err)
+		}
+		resolved += "/" + name
+	}
+	return nd, nil
+}
+
+func (h *fileHandler) serveFile(ctx context.Context, w http.ResponseWriter, r *http.Request, nd format.Node, name string) {
+	content, err := unixfsio.NewDagReader(ctx, nd, h.dserv)
+	if err != nil {
+		if errors.Is(err, unixfsio.ErrCantReadSymlinks) {
+			writeError(w, r, http.StatusNotImplemented, fmt.Errorf("%s is a symlink: serving symlinks is not supported", r.URL.Path))
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, fmt.Errorf("server error reading file %s: %s", r.URL.Path, err.Error()))
+		return
+	}
+	defer content.Close() //nolint:errcheck
+
+	w.Header().Set("Etag", `"`+nd.Cid().String()+`"`)
+	w.Header().Set("X-Ipfs-Path", r.URL.Path)
+	w.Header().Set("Cache-Control", "public, max-age=29030400, immutable")
+
+	// http.ServeContent sets the content type from the file name extension,
+	// or by sniffing the content, and handles range requests
+	res := newPieceAccountingWriter(w, toLoggingResponseWriter(w))
+	http.ServeContent(res, r, name, lastModified, content)
+}
+
+func (h *fileHandler) serveDirectory(ctx context.Context, w http.ResponseWriter, r *http.Request, dirCid cid.Cid, dir unixfsio.Directory, isRoot bool) {
+	links, err := dir.Links(ctx)
+	if err != nil {
+		if isNotFoundError(err) {
+			writeError(w, r, http.StatusNotFound, err)
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, fmt.Errorf("server error listing directory %s: %s", r.URL.Path, err.Error()))
+		return
+	}
+
+	listing, err := parseDirListing(r.URL.Path, isRoot, links)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, fmt.Errorf("server error listing directory %s: %s", r.URL.Path, err.Error()))
+		return
+	}
+
+	w.Header().Set("Etag", `"DirIndex-`+dirCid.String()+`"`)
+	w.Header().Set("X-Ipfs-Path", r.URL.Path)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	http.ServeContent(w, r, "", time.Time{}, strings.NewReader(listing))
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	bstore "github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/boxo/ipld/unixfs"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileHandler(t *testing.T) {
+	ctx := context.Background()
+	bs := bstore.NewBlockstore(dss.MutexWrap(datastore.NewMapDatastore()))
+	h := newFileHandler("/ipfs/", bs)
+
+	// Create a directory with a file and a sub-directory with an index.html
+	hello := merkledag.NewRawNode([]byte("hello world"))
+	index := merkledag.NewRawNode([]byte("<html>index</html>"))
+	site := unixfs.EmptyDirNode()
+	require.NoError(t, site.AddNodeLink("index.html", index))
+	root := unixfs.EmptyDirNode()
+	require.NoError(t, root.AddNodeLink("hello.txt", hello))
+	require.NoError(t, root.AddNodeLink("site", site))
+	require.NoError(t, h.dserv.AddMany(ctx, []format.Node{hello, index, site, root}))
+
+	get := func(path string, hdrs ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for i := 0; i+1 < len(hdrs); i += 2 {
+			req.Header.Set(hdrs[i], hdrs[i+1])
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	rootPath := "/ipfs/" + root.Cid().String()
+
+	// Get a file
+	rec := get(rootPath + "/hello.txt")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "hello world", rec.Body.String())
+	require.Equal(t, "text/plain; charset=utf-8", rec.Header().Get("Content-Type"))
+	require.Equal(t, `"`+hello.Cid().String()+`"`, rec.Header().Get("Etag"))
+
+	// Get a range of a file
+	rec = get(rootPath+"/hello.txt", "Range", "bytes=6-")
+	require.Equal(t, http.StatusPartialContent, rec.Code)
+	require.Equal(t, "world", rec.Body.String())
+
+	// A directory path without a trailing slash should be redirected
+	rec = get(rootPath)
+	require.Equal(t, http.StatusMovedPermanently, rec.Code)
+	require.Equal(t, rootPath+"/", rec.Header().Get("Location"))
+
+	// Get a directory listing
+	rec = get(rootPath + "/")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+	require.Contains(t, rec.Body.String(), `href="./hello.txt"`)
+	require.Contains(t, rec.Body.String(), `href="./site"`)
+	require.NotContains(t, rec.Body.String(), `href="../"`)
+
+	// A directory with an index.html should serve the index.html
+	rec = get(rootPath + "/site/")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "<html>index</html>", rec.Body.String())
+	require.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+
+	// Paths that don't exist should return not found
+	require.Equal(t, http.StatusNotFound, get(rootPath+"/missing.txt").Code)
+	require.Equal(t, http.StatusNotFound, get(rootPath+"/hello.txt/more").Code)
+	require.Equal(t, http.StatusBadRequest, get("/ipfs/notacid/hello.txt").Code)
+}
+
+func TestIpfsHandlerRouting(t *testing.T) {
+	handlerFor := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(name))
+		})
+	}
+
+	get := func(h http.HandlerFunc, path string, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		return rec
+	}
+
+	both := ipfsHandler(handlerFor("trustless"), handlerFor("files"))
+	require.Equal(t, "trustless", get(both, "/ipfs/bafy?format=car", "").Body.String())
+	require.Equal(t, "trustless", get(both, "/ipfs/bafy", "application/vnd.ipld.raw").Body.String())
+	require.Equal(t, "files", get(both, "/ipfs/bafy/file.jpg", "image/*").Body.String())
+
+	filesOnly := ipfsHandler(nil, handlerFor("files"))
+	require.Equal(t, http.StatusNotAcceptable, get(filesOnly, "/ipfs/bafy?format=car", "").Code)
+	require.Equal(t, "files", get(filesOnly, "/ipfs/bafy", "").Body.String())
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/filecoin-project/boost/metrics"
+	"github.com/ipfs/go-cid"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+	"golang.org/x/time/rate"
+)
+
+// requestCid returns the piece CID or the root CID in the request path, or
+// cid.Undef if there isn't one
+func (s *HttpServer) requestCid(r *http.Request) cid.Cid {
+	var cidStr string
+	switch {
+	case strings.HasPrefix(r.URL.Path, s.pieceBasePath()):
+		cidStr = strings.TrimPrefix(r.URL.Path, s.pieceBasePath())
+	case strings.HasPrefix(r.URL.Path, s.ipfsBasePath()):
+		cidStr, _, _ = strings.Cut(strings.TrimPrefix(r.URL.Path, s.ipfsBasePath()), "/")
+	}
+	c, err := cid.Parse(cidStr)
+	if err != nil {
+		return cid.Undef
+	}
+	return c
+}
+
+// geoClientIP returns the IP of the client that made the request, or nil if
+// it is not known. Behind a trusted reverse proxy the remote address is the
+// address of the proxy, so the client IP is only known if the proxy
+// forwarded it.
+func geoClientIP(r *http.Request, trustForwardedFor bool) net.IP {
+	if !trustForwardedFor {
+		return clientIP(r, false)
+	}
+	first, _, _ := strings.Cut(r.Header.Get("X-Forwarded-For"), ",")
+	return net.ParseIP(strings.TrimSpace(first))
+}
+
+// geoHandler wraps the given handler so that requests are rejected with
+// 403 Forbidden when the geo policy denies them, and the response is
+// throttled to the bandwidth cap of the rule that matched
+func (s *HttpServer) geoHandler(next http.Handler) http.HandlerFunc {
+	gf := s.opts.GeoFilter
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Requests over libp2p don't have a client IP, so they are treated
+		// as coming from an unknown location
+		ip := geoClientIP(r, gf.TrustForwardedFor())
+		d, err := gf.Decide(r.Context(), ip, s.requestCid(r))
+		if err != nil {
+			if isNotFoundError(err) {
+				// There are no pieces containing the requested CID
+				writeError(w, r, http.StatusNotFound, err)
+				return
+			}
+			writeError(w, r, http.StatusInternalServerError, fmt.Errorf("applying geo policy: %w", err))
+			return
+		}
+		if !d.Allow {
+			ctx, _ := tag.New(r.Context(), tag.Upsert(metrics.GeoRule, d.Rule))
+			stats.Record(ctx, metrics.HttpGeoDeniedCount.M(1))
+			writeError(w, r, http.StatusForbidden, fmt.Errorf("retrieval from this location is not permitted by rule %s", d.Rule))
+			return
+		}
+
+		if d.Bandwidth != nil {
+			w = &throttledResponseWriter{ResponseWriter: w, ctx: r.Context(), limiters: []*rate.Limiter{d.Bandwidth}}
+		}
+		next.ServeHTTP(w, r)
+	}
+}
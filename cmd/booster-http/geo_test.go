@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/filecoin-project/boost/cmd/lib/filters"
+	"github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeoHandler(t *testing.T) {
+	pieceCid := "baga6ea4seaqjtovkwk4myyzj56eztkh5pzsk5upksan6f5outesy62bsvl4dsha"
+	cfgPath := filepath.Join(t.TempDir(), "geo-policy.json")
+	bz, err := json.Marshal(filters.GeoPolicyConfig{
+		TrustForwardedFor: true,
+		Rules: []filters.GeoRule{{
+			Name:   "residency",
+			CIDRs:  []string{"10.0.0.0/8"},
+			Pieces: []string{pieceCid},
+		}, {
+			Name:   "residency-deny",
+			Pieces: []string{pieceCid},
+			Action: filters.GeoDeny,
+		}, {
+			Name:           "capped",
+			CIDRs:          []string{"172.16.0.0/12"},
+			BytesPerSecond: 1024,
+		}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(cfgPath, bz, 0o644))
+
+	// None of the pieces contain any blocks
+	pieceLookup := func(ctx context.Context, m mh.Multihash) ([]cid.Cid, error) {
+		return nil, fmt.Errorf("getting pieces for multihash %s: %w", m, ErrNotFound)
+	}
+	gf, err := filters.NewGeoFilter(cfgPath, pieceLookup, nil)
+	require.NoError(t, err)
+	defer gf.Close()
+
+	s := NewHttpServer("", "0.0.0.0", 0, nil, &HttpServerOptions{ServePieces: true, GeoFilter: gf})
+	var throttled bool
+	handler := s.geoHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, throttled = w.(*throttledResponseWriter)
+		_, _ = w.Write([]byte("data"))
+	}))
+
+	get := func(path string, forwardedFor string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	// The piece may only be retrieved from 10.0.0.0/8
+	require.Equal(t, http.StatusOK, get("/piece/"+pieceCid, "10.0.0.1").Code)
+	require.False(t, throttled)
+	require.Equal(t, http.StatusForbidden, get("/piece/"+pieceCid, "8.8.8.8").Code)
+
+	// Other pieces may be retrieved from anywhere, with the bandwidth capped
+	// for 172.16.0.0/12
+	otherPiece := "/piece/baga6ea4seaqhmks7eb3itzstkumzzb5d6v33wbnwk5ihgbwlk4xtonmnd3upqpa"
+	require.Equal(t, http.StatusOK, get(otherPiece, "8.8.8.8").Code)
+	require.False(t, throttled)
+	rec := get(otherPiece, "172.16.0.1")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "data", rec.Body.String())
+	require.True(t, throttled)
+
+	// A block that is not in any piece is not found
+	blockPath := "/ipfs/QmWATWQ7fVPP2EFGu71UkfnqhYXDYH566qy47CnJDgvs8u"
+	require.Equal(t, http.StatusNotFound, get(blockPath, "10.0.0.1").Code)
+
+	// Behind a proxy, requests without a forwarded client address are from
+	// an unknown location, so the rules that depend on location deny them
+	require.Equal(t, http.StatusForbidden, get("/piece/"+pieceCid, "").Code)
+	require.Equal(t, http.StatusForbidden, get(otherPiece, "").Code)
+}
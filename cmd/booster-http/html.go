@@ -3,6 +3,10 @@ package main
 import (
 	"bytes"
 	"html/template"
+	"net/url"
+
+	"github.com/dustin/go-humanize"
+	format "github.com/ipfs/go-ipld-format"
 )
 
 const idxTemplate = `
@@ -40,15 +44,33 @@ func parseTemplate(opts HttpServerOptions) string {
 			Description: "Download a raw piece by its piece CID",
 			Value:       `<a href="/piece/bafySomePieceCid">/piece/&lt;piece cid&gt;</a>`,
 		})
+		endpoints = append(endpoints, templateRow{
+			Description: "Download the CAR file with a payload root from an aggregate piece",
+			Value:       `<a href="/piece/bafySomePieceCid?root=bafySomePayloadCid">/piece/&lt;piece cid&gt;?root=&lt;payload cid&gt;</a>`,
+		})
 	}
 
-	if opts.Blockstore != nil {
+	if opts.Blockstore != nil && opts.ServeTrustless {
 		endpoints = append(endpoints, templateRow{
 			Description: "Download raw blocks or CAR files",
 			Value:       `<a href="/ipfs/bafySomeBlockCid">/ipfs/&lt;block cid&gt;</a>`,
 		})
 	}
 
+	if opts.Blockstore != nil && opts.ServeFiles {
+		endpoints = append(endpoints, templateRow{
+			Description: "Download files or list directories",
+			Value:       `<a href="/ipfs/bafySomeRootCid/path/to/file">/ipfs/&lt;root cid&gt;/&lt;path&gt;</a>`,
+		})
+	}
+
+	if opts.ServePieces && opts.Pricer != nil {
+		endpoints = append(endpoints, templateRow{
+			Description: "Get the price of retrieving a piece",
+			Value:       `<a href="/quote/bafySomePieceCid">/quote/&lt;piece cid&gt;</a>`,
+		})
+	}
+
 	t := template.Must(template.New("index.html").Parse(idxTemplate))
 	var buff bytes.Buffer
 	err := t.Execute(&buff, endpoints)
@@ -57,3 +79,68 @@ func parseTemplate(opts HttpServerOptions) string {
 	}
 	return buff.String()
 }
+
+const dirListingTemplate = `
+<html>
+  <head>
+    <meta charset="utf-8">
+    <title>{{ .Path }}</title>
+  </head>
+  <body>
+    <h4>Index of {{ .Path }}</h4>
+    <table>
+      <tbody>
+      {{ if .Parent }}
+        <tr>
+          <td><a href="{{ .Parent }}">..</a></td>
+          <td></td>
+          <td></td>
+        </tr>
+      {{ end }}
+      {{ range .Entries }}
+        <tr>
+          <td><a href="{{ .Href }}">{{ .Name }}</a></td>
+          <td>{{ .Cid }}</td>
+          <td>{{ .Size }}</td>
+        </tr>
+      {{ end }}
+      </tbody>
+    </table>
+  </body>
+</html>
+`
+
+var dirListingTmpl = template.Must(template.New("dir.html").Parse(dirListingTemplate))
+
+// parseDirListing renders an HTML listing of the links in a UnixFS directory
+func parseDirListing(dirPath string, isRoot bool, links []*format.Link) (string, error) {
+	type dirEntry struct {
+		Name string
+		Href string
+		Cid  string
+		Size string
+	}
+	data := struct {
+		Path    string
+		Parent  string
+		Entries []dirEntry
+	}{Path: dirPath}
+	if !isRoot {
+		data.Parent = "../"
+	}
+
+	for _, l := range links {
+		data.Entries = append(data.Entries, dirEntry{
+			Name: l.Name,
+			Href: "./" + url.PathEscape(l.Name),
+			Cid:  l.Cid.String(),
+			Size: humanize.Bytes(l.Size),
+		})
+	}
+
+	var buff bytes.Buffer
+	if err := dirListingTmpl.Execute(&buff, data); err != nil {
+		return "", err
+	}
+	return buff.String(), nil
+}
@@ -18,6 +18,7 @@ import (
 	mocks_booster_http "github.com/filecoin-project/boost/cmd/booster-http/mocks"
 	"github.com/filecoin-project/boost/extern/boostd-data/model"
 	"github.com/filecoin-project/boost/testutil"
+	"github.com/filecoin-project/boost/transport/types"
 	"github.com/golang/mock/gomock"
 	"github.com/ipfs/go-cid"
 	unixfstestutil "github.com/ipfs/go-unixfsnode/testutil"
@@ -26,6 +27,9 @@ import (
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
 	"github.com/ipld/go-ipld-prime/storage/memstore"
 	trustlesstestutil "github.com/ipld/go-trustless-utils/testutil"
+	"github.com/libp2p/go-libp2p"
+	p2phttp "github.com/libp2p/go-libp2p-http"
+	"github.com/libp2p/go-libp2p/core/peerstore"
 	"github.com/stretchr/testify/require"
 )
 
@@ -376,6 +380,45 @@ func TestHttpInfo(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestHttpInfoOverLibp2p(t *testing.T) {
+	var v apiVersion
+
+	port, err := testutil.FreePort()
+	require.NoError(t, err)
+	srvHost, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	defer srvHost.Close() //nolint:errcheck
+
+	// Create a new mock Http server that also serves over libp2p
+	ctrl := gomock.NewController(t)
+	opts := &HttpServerOptions{ServePieces: true, Libp2pHost: srvHost}
+	httpServer := NewHttpServer("", "0.0.0.0", port, mocks_booster_http.NewMockHttpServerApi(ctrl), opts)
+	err = httpServer.Start(context.Background())
+	require.NoError(t, err)
+	waitServerUp(t, port)
+
+	// Make a request to the server over libp2p
+	clientHost, err := libp2p.New(libp2p.NoListenAddrs)
+	require.NoError(t, err)
+	defer clientHost.Close() //nolint:errcheck
+	clientHost.Peerstore().AddAddrs(srvHost.ID(), srvHost.Addrs(), peerstore.PermanentAddrTTL)
+
+	tr := &http.Transport{}
+	tr.RegisterProtocol("libp2p", p2phttp.NewTransport(clientHost, p2phttp.ProtocolOption(types.Libp2pHttpRetrievalProtocol)))
+	client := &http.Client{Transport: tr}
+	response, err := client.Get(fmt.Sprintf("libp2p://%s/info", srvHost.ID()))
+	require.NoError(t, err)
+	defer response.Body.Close()
+	require.Equal(t, http.StatusOK, response.StatusCode)
+
+	json.NewDecoder(response.Body).Decode(&v) //nolint:errcheck
+	require.Equal(t, "0.3.0", v.Version)
+
+	// Stop the server
+	err = httpServer.Stop()
+	require.NoError(t, err)
+}
+
 func waitServerUp(t *testing.T, port int) {
 	require.Eventually(t, func() bool {
 		_, err := http.Get(fmt.Sprintf("http://localhost:%d", port))
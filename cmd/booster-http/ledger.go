@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// KeyUsage is the usage recorded in the ledger for an API key or token
+// subject during the current quota period
+type KeyUsage struct {
+	Key           string
+	PeriodStart   time.Time
+	Requests      int64
+	Bytes         int64
+	ActiveStreams int64
+	// The number of requests that were refused because the quota was exceeded
+	Rejected int64
+	Quota    Quota
+}
+
+// The interval at which idle entries are evicted from the usage ledger
+const ledgerSweepInterval = time.Minute
+
+// Entries for keys without a request or byte quota are evicted after they
+// have been idle for this long
+const ledgerIdleTimeout = time.Hour
+
+// The maximum number of keys in the usage ledger. Token subjects are not
+// known in advance, so the number of keys is bounded to bound the memory
+// used by the ledger.
+const ledgerMaxKeys = 100_000
+
+// usageLedger keeps track of the usage of each API key, and enforces the
+// quota for the key.
+// The ledger is kept in memory only: usage is per booster-http process, and
+// is reset when the process restarts.
+type usageLedger struct {
+	period  time.Duration
+	maxKeys int
+	now     func() time.Time
+
+	lk        sync.Mutex
+	keys      map[string]*KeyUsage
+	lastUsed  map[string]time.Time
+	lastSweep time.Time
+}
+
+func newUsageLedger(period time.Duration) *usageLedger {
+	return &usageLedger{
+		period:   period,
+		maxKeys:  ledgerMaxKeys,
+		now:      time.Now,
+		keys:     make(map[string]*KeyUsage),
+		lastUsed: make(map[string]time.Time),
+	}
+}
+
+// usageStream is a request that is counted against the quota of a key
+type usageStream struct {
+	l     *usageLedger
+	key   string
+	quota Quota
+}
+
+// begin records the start of a request. If the request would exceed the
+// quota, it returns an error. Otherwise it returns a stream that must be
+// used to reserve the bytes served, and ended when the request completes.
+func (l *usageLedger) begin(id *authIdentity) (*usageStream, error) {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	l.evictLocked()
+
+	u := l.get(id.ID)
+	u.Quota = id.Quota
+
+	var err error
+	switch {
+	case id.Quota.Requests > 0 && u.Requests >= id.Quota.Requests:
+		err = fmt.Errorf("request quota of %d requests exceeded", id.Quota.Requests)
+	case id.Quota.Bytes > 0 && u.Bytes >= id.Quota.Bytes:
+		err = fmt.Errorf("byte quota of %d bytes exceeded", id.Quota.Bytes)
+	case id.Quota.ConcurrentStreams > 0 && u.ActiveStreams >= id.Quota.ConcurrentStreams:
+		err = fmt.Errorf("limit of %d concurrent streams reached", id.Quota.ConcurrentStreams)
+	}
+	if err != nil {
+		u.Rejected++
+		return nil, err
+	}
+
+	u.Requests++
+	u.ActiveStreams++
+	return &usageStream{l: l, key: id.ID, quota: id.Quota}, nil
+}
+
+// reserve counts up to n bytes against the key's byte quota, and returns
+// the number of bytes that the remaining allowance covers
+func (s *usageStream) reserve(n int) int {
+	s.l.lk.Lock()
+	defer s.l.lk.Unlock()
+
+	u := s.l.get(s.key)
+	if s.quota.Bytes > 0 {
+		if remaining := s.quota.Bytes - u.Bytes; remaining < int64(n) {
+			n = int(max(remaining, 0))
+		}
+	}
+	u.Bytes += int64(n)
+	return n
+}
+
+// refund returns reserved bytes that were not served
+func (s *usageStream) refund(n int) {
+	if n <= 0 {
+		return
+	}
+
+	s.l.lk.Lock()
+	defer s.l.lk.Unlock()
+
+	u := s.l.get(s.key)
+	u.Bytes = max(u.Bytes-int64(n), 0)
+}
+
+// end records the end of the request
+func (s *usageStream) end() {
+	s.l.lk.Lock()
+	defer s.l.lk.Unlock()
+
+	s.l.get(s.key).ActiveStreams--
+}
+
+// usage returns the usage for the given key
+func (l *usageLedger) usage(key string) KeyUsage {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	return *l.get(key)
+}
+
+// get returns the usage for the key in the current quota period.
+// Must be called with the lock held.
+func (l *usageLedger) get(key string) *KeyUsage {
+	now := l.now()
+	l.lastUsed[key] = now
+	u, ok := l.keys[key]
+	if !ok {
+		if len(l.keys) >= l.maxKeys {
+			l.evictOldestLocked()
+		}
+		u = &KeyUsage{Key: key, PeriodStart: now}
+		l.keys[key] = u
+		return u
+	}
+
+	// Start a new period if the current one has ended
+	if l.period > 0 && now.Sub(u.PeriodStart) >= l.period {
+		u.PeriodStart = now
+		u.Requests = 0
+		u.Bytes = 0
+		u.Rejected = 0
+	}
+	return u
+}
+
+// evictLocked removes the entries for keys that have no active streams, and
+// either whose quota period has ended, or that have no request or byte
+// quota and have been idle for ledgerIdleTimeout. The usage of these keys
+// would be reset or is not enforced, so evicting them does not change how
+// the quota is applied.
+// Must be called with the lock held.
+func (l *usageLedger) evictLocked() {
+	now := l.now()
+	if now.Sub(l.lastSweep) < ledgerSweepInterval {
+		return
+	}
+	l.lastSweep = now
+
+	for key, u := range l.keys {
+		if u.ActiveStreams > 0 {
+			continue
+		}
+		periodEnded := l.period > 0 && now.Sub(u.PeriodStart) >= l.period
+		idle := u.Quota.Requests == 0 && u.Quota.Bytes == 0 && now.Sub(l.lastUsed[key]) >= ledgerIdleTimeout
+		if periodEnded || idle {
+			delete(l.keys, key)
+			delete(l.lastUsed, key)
+		}
+	}
+}
+
+// evictOldestLocked makes room for a new key when the ledger is full, by
+// evicting the least recently used key that has no active streams.
+// When there is no quota period, usage accumulates until the entry is
+// evicted, so evicting an entry with a request or byte quota resets its
+// usage. Only the least recently used entry is evicted, so this only
+// happens when more than maxKeys keys are in use.
+// Must be called with the lock held.
+func (l *usageLedger) evictOldestLocked() {
+	var oldest string
+	var oldestUsed time.Time
+	found := false
+	for key, u := range l.keys {
+		if u.ActiveStreams > 0 {
+			continue
+		}
+		if used := l.lastUsed[key]; !found || used.Before(oldestUsed) {
+			oldest, oldestUsed, found = key, used, true
+		}
+	}
+	if !found {
+		// All keys have active streams
+		return
+	}
+	log.Debugw("usage ledger is full: evicting least recently used key", "key", oldest)
+	delete(l.keys, oldest)
+	delete(l.lastUsed, oldest)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/filecoin-project/boost/protocolproxy"
+	"github.com/filecoin-project/boost/transport/types"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/muxer/yamux"
+	"github.com/libp2p/go-libp2p/p2p/net/gostream"
+	quic "github.com/libp2p/go-libp2p/p2p/transport/quic"
+	"github.com/libp2p/go-libp2p/p2p/transport/tcp"
+)
+
+const (
+	// The tag used to protect the connection to the proxy from being pruned
+	protectTag = "booster-http-to-proxy"
+	// How often to check that the connection to the proxy is alive
+	proxyKeepAliveInterval = 5 * time.Second
+)
+
+// setupHost creates a libp2p host with the peer key in the repo directory,
+// creating the key if it doesn't exist yet
+func setupHost(repoDir string, port int) (host.Host, error) {
+	peerKey, err := loadPeerKey(repoDir)
+	if err != nil {
+		return nil, err
+	}
+	return libp2p.New(
+		libp2p.ListenAddrStrings(
+			fmt.Sprintf("/ip4/0.0.0.0/tcp/%d", port),
+			fmt.Sprintf("/ip4/0.0.0.0/udp/%d/quic", port),
+		),
+		libp2p.Transport(tcp.NewTCPTransport),
+		libp2p.Transport(quic.NewTransport),
+		libp2p.Muxer("/yamux/1.0.0", yamux.DefaultTransport),
+		libp2p.Identity(peerKey),
+		libp2p.ResourceManager(&network.NullResourceManager{}),
+	)
+}
+
+func loadPeerKey(repoDir string) (crypto.PrivKey, error) {
+	keyPath := filepath.Join(repoDir, "libp2p.key")
+	keyFile, err := os.ReadFile(keyPath)
+	if err == nil {
+		return crypto.UnmarshalPrivateKey(keyFile)
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	log.Infof("Generating new libp2p peer key at %s", keyPath)
+	key, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	data, err := crypto.MarshalPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(keyPath, data, 0o600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// startLibp2p serves the handler over libp2p HTTP on the libp2p host.
+// If a proxy is configured, the host connects to the proxy and registers the
+// protocol with it, so that clients can reach booster-http through the proxy.
+func (s *HttpServer) startLibp2p(handler http.Handler) error {
+	h := s.opts.Libp2pHost
+	proxy := s.opts.Libp2pProxy
+	if proxy != nil {
+		log.Infow("connecting to libp2p proxy", "proxy", proxy)
+		if err := h.Connect(s.ctx, *proxy); err != nil {
+			return fmt.Errorf("connecting to proxy %s: %w", proxy, err)
+		}
+		h.ConnManager().Protect(proxy.ID, protectTag)
+
+		// Create a forwarding host that registers routes with the proxy
+		h = protocolproxy.NewForwardingHost(h, *proxy)
+		go s.keepProxyConnectionAlive(s.ctx, *proxy)
+	}
+
+	listener, err := gostream.Listen(h, types.Libp2pHttpRetrievalProtocol)
+	if err != nil {
+		return fmt.Errorf("starting gostream listener: %w", err)
+	}
+	s.libp2pListener = listener
+
+	s.libp2pServer = &http.Server{
+		Handler: handler,
+		// This context will be the parent of the context associated with all
+		// incoming requests
+		BaseContext: func(listener net.Listener) context.Context {
+			return s.ctx
+		},
+	}
+	go func() {
+		if err := s.libp2pServer.Serve(listener); err != nil && err != http.ErrServerClosed && s.ctx.Err() == nil {
+			log.Errorf("serving http over libp2p: %v", err)
+		}
+	}()
+	return nil
+}
+
+func (s *HttpServer) stopLibp2p() {
+	if proxy := s.opts.Libp2pProxy; proxy != nil {
+		s.opts.Libp2pHost.ConnManager().Unprotect(proxy.ID, protectTag)
+	}
+	if err := s.libp2pServer.Close(); err != nil {
+		log.Warnw("closing libp2p http server", "err", err)
+	}
+	if err := s.libp2pListener.Close(); err != nil {
+		log.Debugw("closing libp2p http listener", "err", err)
+	}
+}
+
+func (s *HttpServer) keepProxyConnectionAlive(ctx context.Context, proxy peer.AddrInfo) {
+	// Periodically ensure that the connection over libp2p to the proxy is alive
+	ticker := time.NewTicker(proxyKeepAliveInterval)
+	defer ticker.Stop()
+
+	connected := true
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := s.opts.Libp2pHost.Connect(ctx, proxy)
+			if err != nil {
+				connected = false
+				log.Warnw("failed to connect to proxy", "address", proxy)
+			} else if !connected {
+				log.Infow("reconnected to proxy", "address", proxy)
+				connected = true
+			}
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import "container/list"
+
+// sizedLRU is a least-recently-used cache that is bounded by the total size
+// of its values. It is not safe for concurrent use.
+type sizedLRU[K comparable, V any] struct {
+	maxSize int64
+	size    int64
+	ll      *list.List
+	items   map[K]*list.Element
+	onEvict func(K, V)
+}
+
+type lruEntry[K comparable, V any] struct {
+	key   K
+	value V
+	size  int64
+}
+
+func newSizedLRU[K comparable, V any](maxSize int64, onEvict func(K, V)) *sizedLRU[K, V] {
+	return &sizedLRU[K, V]{
+		maxSize: maxSize,
+		ll:      list.New(),
+		items:   make(map[K]*list.Element),
+		onEvict: onEvict,
+	}
+}
+
+func (c *sizedLRU[K, V]) get(k K) (V, bool) {
+	el, ok := c.items[k]
+	if !ok {
+		var v V
+		return v, false
+	}
+	c.ll.MoveToFront(el)
+	return el.Value.(*lruEntry[K, V]).value, true
+}
+
+func (c *sizedLRU[K, V]) contains(k K) bool {
+	_, ok := c.items[k]
+	return ok
+}
+
+// add adds the value to the cache, evicting the least recently used values
+// until the cache is within its maximum size
+func (c *sizedLRU[K, V]) add(k K, v V, size int64) {
+	if el, ok := c.items[k]; ok {
+		c.removeElement(el)
+	}
+
+	c.items[k] = c.ll.PushFront(&lruEntry[K, V]{key: k, value: v, size: size})
+	c.size += size
+	for c.size > c.maxSize {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+func (c *sizedLRU[K, V]) remove(k K) {
+	if el, ok := c.items[k]; ok {
+		c.removeElement(el)
+	}
+}
+
+// removeIf removes all values whose key matches the predicate
+func (c *sizedLRU[K, V]) removeIf(match func(K) bool) {
+	for k, el := range c.items {
+		if match(k) {
+			c.removeElement(el)
+		}
+	}
+}
+
+func (c *sizedLRU[K, V]) removeElement(el *list.Element) {
+	e := el.Value.(*lruEntry[K, V])
+	c.ll.Remove(el)
+	delete(c.items, e.key)
+	c.size -= e.size
+	if c.onEvict != nil {
+		c.onEvict(e.key, e.value)
+	}
+}
+
+// admissionFilter counts the number of times each key is requested, so that
+// only keys that are requested frequently are admitted to a cache.
+// The counts are reset when the number of keys being tracked reaches the
+// limit, which bounds the memory used and forgets old requests.
+type admissionFilter[K comparable] struct {
+	admitAfter int
+	maxKeys    int
+	counts     map[K]int
+}
+
+func newAdmissionFilter[K comparable](admitAfter int, maxKeys int) *admissionFilter[K] {
+	return &admissionFilter[K]{
+		admitAfter: admitAfter,
+		maxKeys:    maxKeys,
+		counts:     make(map[K]int),
+	}
+}
+
+// admit records a request for the key, and returns true if the key has now
+// been requested enough times to be admitted
+func (f *admissionFilter[K]) admit(k K) bool {
+	if f.admitAfter <= 1 {
+		return true
+	}
+
+	if _, ok := f.counts[k]; !ok && len(f.counts) >= f.maxKeys {
+		f.counts = make(map[K]int)
+	}
+	f.counts[k]++
+	if f.counts[k] < f.admitAfter {
+		return false
+	}
+	delete(f.counts, k)
+	return true
+}
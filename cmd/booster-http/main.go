@@ -29,6 +29,7 @@ var app = &cli.App{
 	},
 	Commands: []*cli.Command{
 		runCmd,
+		accessLogStatsCmd,
 	},
 }
 
@@ -9,6 +9,7 @@ import (
 	reflect "reflect"
 
 	model "github.com/filecoin-project/boost/extern/boostd-data/model"
+	types "github.com/filecoin-project/boost/storagemarket/types"
 	mount "github.com/filecoin-project/dagstore/mount"
 	address "github.com/filecoin-project/go-address"
 	abi "github.com/filecoin-project/go-state-types/abi"
@@ -54,6 +55,21 @@ func (mr *MockHttpServerApiMockRecorder) GetPieceDeals(ctx, pieceCID interface{}
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPieceDeals", reflect.TypeOf((*MockHttpServerApi)(nil).GetPieceDeals), ctx, pieceCID)
 }
 
+// GetSubPieceDeals mocks base method.
+func (m *MockHttpServerApi) GetSubPieceDeals(ctx context.Context, subPieceCid cid.Cid) ([]types.DealSubPiece, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetSubPieceDeals", ctx, subPieceCid)
+	ret0, _ := ret[0].([]types.DealSubPiece)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetSubPieceDeals indicates an expected call of GetSubPieceDeals.
+func (mr *MockHttpServerApiMockRecorder) GetSubPieceDeals(ctx, subPieceCid interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSubPieceDeals", reflect.TypeOf((*MockHttpServerApi)(nil).GetSubPieceDeals), ctx, subPieceCid)
+}
+
 // IsUnsealed mocks base method.
 func (m *MockHttpServerApi) IsUnsealed(ctx context.Context, minerAddr address.Address, sectorID abi.SectorNumber, offset, length abi.UnpaddedPieceSize) (bool, error) {
 	m.ctrl.T.Helper()
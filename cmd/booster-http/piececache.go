@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/boost/metrics"
+	"github.com/ipfs/go-cid"
+	"go.opencensus.io/stats"
+)
+
+const (
+	// Pieces are cached in chunks, so that the parts of a large piece that
+	// are requested frequently can be cached without caching the whole piece
+	pieceCacheChunkSize = 8 << 20
+	// The maximum number of chunks for which request counts are kept when
+	// deciding which chunks to admit to the cache
+	pieceCacheMaxTracked = 1 << 16
+	// How often to check if the deals for cached pieces have been removed
+	cacheInvalidateInterval = time.Minute
+	// The subdirectory of the piece cache directory that chunks are written
+	// to. It is owned by the piece cache and cleared on startup.
+	pieceCacheChunksDir = "chunks"
+)
+
+type chunkKey struct {
+	piece cid.Cid
+	index int64
+}
+
+// PieceCache keeps chunks of piece data that are requested frequently on
+// disk, so that they don't need to be read from an unsealed sector each time
+type PieceCache struct {
+	dir string
+
+	lk sync.Mutex
+	// the size of each piece that has been requested
+	sizes map[cid.Cid]int64
+	// the path of the file for each cached chunk
+	chunks *sizedLRU[chunkKey, string]
+	admit  *admissionFilter[chunkKey]
+	// the files of chunks that have been evicted, which are removed after the
+	// lock is released
+	evicted []string
+}
+
+func NewPieceCache(dir string, maxBytes int64, admitAfter int) (*PieceCache, error) {
+	// The index of cached chunks is kept in memory, so clear out any chunks
+	// left by a previous run. Only the chunks subdirectory is cleared, so
+	// that nothing else in the directory is removed.
+	dir = filepath.Join(dir, pieceCacheChunksDir)
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, fmt.Errorf("clearing piece cache directory %s: %w", dir, err)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating piece cache directory %s: %w", dir, err)
+	}
+
+	pc := &PieceCache{
+		dir:   dir,
+		sizes: make(map[cid.Cid]int64),
+		admit: newAdmissionFilter[chunkKey](admitAfter, pieceCacheMaxTracked),
+	}
+	pc.chunks = newSizedLRU[chunkKey, string](maxBytes, func(_ chunkKey, path string) {
+		pc.evicted = append(pc.evicted, path)
+	})
+	return pc, nil
+}
+
+func (pc *PieceCache) pieceDir(pieceCid cid.Cid) string {
+	return filepath.Join(pc.dir, pieceCid.String())
+}
+
+// unlock releases the lock and then removes the files of evicted chunks, so
+// that requests aren't blocked on the file system
+func (pc *PieceCache) unlock() {
+	evicted := pc.evicted
+	pc.evicted = nil
+	pc.lk.Unlock()
+
+	for _, path := range evicted {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Warnw("removing piece cache chunk", "path", path, "err", err)
+		}
+	}
+}
+
+// reader returns a reader over the piece that reads cached chunks from disk.
+// Other chunks are read from the reader returned by open, which is only
+// called if needed.
+func (pc *PieceCache) reader(ctx context.Context, pieceCid cid.Cid, open func() (io.ReadSeeker, error)) (io.ReadSeeker, error) {
+	r := &cachedPieceReader{ctx: ctx, pc: pc, pieceCid: pieceCid, open: open, chunkIdx: -1}
+
+	pc.lk.Lock()
+	size, ok := pc.sizes[pieceCid]
+	pc.lk.Unlock()
+	if ok {
+		r.size = size
+		return r, nil
+	}
+
+	// The size of the piece is not known yet, so open the underlying reader
+	// to get it
+	u, err := open()
+	if err != nil {
+		return nil, err
+	}
+	size, err = u.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, fmt.Errorf("getting size of piece %s: %w", pieceCid, err)
+	}
+	r.underlying = u
+	r.size = size
+
+	pc.lk.Lock()
+	pc.sizes[pieceCid] = size
+	pc.lk.Unlock()
+
+	return r, nil
+}
+
+// access records a request for a chunk. It returns the path to the chunk if
+// it is cached, and whether the chunk should be admitted to the cache if not.
+func (pc *PieceCache) access(k chunkKey) (string, bool) {
+	pc.lk.Lock()
+	defer pc.lk.Unlock()
+
+	if path, ok := pc.chunks.get(k); ok {
+		return path, false
+	}
+	return "", pc.admit.admit(k)
+}
+
+// put writes a chunk to the cache
+func (pc *PieceCache) put(k chunkKey, data []byte) error {
+	dir := pc.pieceDir(k.piece)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	// Each write of a chunk goes to a new file, which is only read once it
+	// has been added to the cache, so that a partially written chunk is never
+	// read, and removing an evicted chunk never removes a newer copy
+	f, err := os.CreateTemp(dir, strconv.FormatInt(k.index, 10)+"-*")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+
+	pc.lk.Lock()
+	defer pc.unlock()
+	pc.chunks.add(k, f.Name(), int64(len(data)))
+	return nil
+}
+
+// pieces returns the pieces that have been requested
+func (pc *PieceCache) pieces() []cid.Cid {
+	pc.lk.Lock()
+	defer pc.lk.Unlock()
+
+	pcids := make([]cid.Cid, 0, len(pc.sizes))
+	for pieceCid := range pc.sizes {
+		pcids = append(pcids, pieceCid)
+	}
+	return pcids
+}
+
+// invalidate removes all cached chunks for the piece
+func (pc *PieceCache) invalidate(pieceCid cid.Cid) {
+	pc.lk.Lock()
+	delete(pc.sizes, pieceCid)
+	pc.chunks.removeIf(func(k chunkKey) bool { return k.piece == pieceCid })
+	pc.unlock()
+
+	// Remove the piece directory if it's empty
+	_ = os.Remove(pc.pieceDir(pieceCid))
+}
+
+// cachedPieceReader reads a piece a chunk at a time, from the cache if the
+// chunk is cached, or from the underlying reader if not
+type cachedPieceReader struct {
+	ctx      context.Context
+	pc       *PieceCache
+	pieceCid cid.Cid
+	size     int64
+	offset   int64
+
+	open       func() (io.ReadSeeker, error)
+	underlying io.ReadSeeker
+
+	// the chunk that was last read from, and the cached file or data for the
+	// chunk if it is cached
+	chunkIdx  int64
+	chunkFile *os.File
+	chunkData []byte
+
+	// the number of chunks read from the cache and from the underlying reader
+	hits   int
+	misses int
+}
+
+func (r *cachedPieceReader) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = r.offset + offset
+	case io.SeekEnd:
+		abs = r.size + offset
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if abs < 0 {
+		return 0, errors.New("negative position")
+	}
+	r.offset = abs
+	return abs, nil
+}
+
+func (r *cachedPieceReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+
+	idx := r.offset / pieceCacheChunkSize
+	chunkStart := idx * pieceCacheChunkSize
+	chunkEnd := chunkStart + pieceCacheChunkSize
+	if chunkEnd > r.size {
+		chunkEnd = r.size
+	}
+	if int64(len(p)) > chunkEnd-r.offset {
+		p = p[:chunkEnd-r.offset]
+	}
+
+	if idx != r.chunkIdx {
+		if err := r.enterChunk(idx, chunkStart, chunkEnd); err != nil {
+			return 0, err
+		}
+	}
+
+	var n int
+	var err error
+	switch {
+	case r.chunkFile != nil:
+		n, err = r.chunkFile.ReadAt(p, r.offset-chunkStart)
+		if errors.Is(err, io.EOF) && n == len(p) {
+			err = nil
+		}
+	case r.chunkData != nil:
+		n = copy(p, r.chunkData[r.offset-chunkStart:])
+	default:
+		if _, err = r.underlying.Seek(r.offset, io.SeekStart); err != nil {
+			return 0, err
+		}
+		n, err = r.underlying.Read(p)
+	}
+	r.offset += int64(n)
+	return n, err
+}
+
+// enterChunk is called when the reader moves to a new chunk. If the chunk is
+// cached it opens the cached file. If the chunk should be admitted to the
+// cache, it reads the whole chunk and writes it to the cache.
+func (r *cachedPieceReader) enterChunk(idx int64, chunkStart int64, chunkEnd int64) error {
+	r.closeChunk()
+	r.chunkIdx = idx
+
+	k := chunkKey{piece: r.pieceCid, index: idx}
+	path, admit := r.pc.access(k)
+	if path != "" {
+		f, err := os.Open(path)
+		if err == nil {
+			stats.Record(r.ctx, metrics.HttpPieceCacheHitCount.M(1))
+			r.hits++
+			r.chunkFile = f
+			return nil
+		}
+		// The chunk may have just been evicted
+		log.Debugw("opening cached piece chunk", "piece", r.pieceCid, "chunk", idx, "err", err)
+	}
+	stats.Record(r.ctx, metrics.HttpPieceCacheMissCount.M(1))
+	r.misses++
+
+	if r.underlying == nil {
+		u, err := r.open()
+		if err != nil {
+			return err
+		}
+		r.underlying = u
+	}
+	if !admit {
+		return nil
+	}
+
+	data := make([]byte, chunkEnd-chunkStart)
+	if _, err := r.underlying.Seek(chunkStart, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(r.underlying, data); err != nil {
+		return fmt.Errorf("reading chunk %d of piece %s: %w", idx, r.pieceCid, err)
+	}
+	if err := r.pc.put(k, data); err != nil {
+		log.Warnw("writing piece chunk to cache", "piece", r.pieceCid, "chunk", idx, "err", err)
+	}
+	r.chunkData = data
+	return nil
+}
+
+func (r *cachedPieceReader) closeChunk() {
+	if r.chunkFile != nil {
+		_ = r.chunkFile.Close()
+		r.chunkFile = nil
+	}
+	r.chunkData = nil
+}
+
+func (r *cachedPieceReader) Close() error {
+	r.closeChunk()
+	if c, ok := r.underlying.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
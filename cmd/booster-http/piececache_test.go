@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/filecoin-project/boost/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSizedLRU(t *testing.T) {
+	var evicted []string
+	lru := newSizedLRU[string, int](10, func(k string, _ int) {
+		evicted = append(evicted, k)
+	})
+
+	lru.add("a", 1, 4)
+	lru.add("b", 2, 4)
+	_, ok := lru.get("a")
+	require.True(t, ok)
+
+	// Adding c should evict b, because a was used more recently
+	lru.add("c", 3, 4)
+	require.Equal(t, []string{"b"}, evicted)
+	require.True(t, lru.contains("a"))
+	require.False(t, lru.contains("b"))
+	require.True(t, lru.contains("c"))
+
+	lru.removeIf(func(k string) bool { return k == "a" })
+	require.Equal(t, []string{"b", "a"}, evicted)
+	require.EqualValues(t, 4, lru.size)
+}
+
+func TestAdmissionFilter(t *testing.T) {
+	f := newAdmissionFilter[string](3, 2)
+	require.False(t, f.admit("a"))
+	require.False(t, f.admit("a"))
+	require.True(t, f.admit("a"))
+
+	// When the number of keys tracked reaches the limit the counts are reset
+	require.False(t, f.admit("b"))
+	require.False(t, f.admit("c"))
+	require.False(t, f.admit("d"))
+	require.False(t, f.admit("b"))
+	require.False(t, f.admit("b"))
+	require.True(t, f.admit("b"))
+
+	require.True(t, newAdmissionFilter[string](1, 2).admit("a"))
+}
+
+func TestPieceCache(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	pieceCid := testutil.GenerateCid()
+
+	// A piece of two and a half chunks
+	data := make([]byte, 2*pieceCacheChunkSize+pieceCacheChunkSize/2)
+	rand.New(rand.NewSource(1)).Read(data)
+	opens := 0
+	open := func() (io.ReadSeeker, error) {
+		opens++
+		return bytes.NewReader(data), nil
+	}
+
+	// Files in the piece cache directory that were not written by the piece
+	// cache should not be removed
+	other := filepath.Join(dir, "other")
+	require.NoError(t, os.WriteFile(other, []byte("other"), 0o644))
+
+	pc, err := NewPieceCache(dir, 2*pieceCacheChunkSize, 2)
+	require.NoError(t, err)
+	_, err = os.Stat(other)
+	require.NoError(t, err)
+
+	readRange := func(offset int64, length int) []byte {
+		r, err := pc.reader(ctx, pieceCid, open)
+		require.NoError(t, err)
+		defer r.(*cachedPieceReader).Close() //nolint:errcheck
+
+		_, err = r.Seek(offset, io.SeekStart)
+		require.NoError(t, err)
+		buf := make([]byte, length)
+		_, err = io.ReadFull(r, buf)
+		require.NoError(t, err)
+		return buf
+	}
+
+	// Read a range across the first and second chunk
+	offset := int64(pieceCacheChunkSize - 100)
+	require.Equal(t, data[offset:offset+200], readRange(offset, 200))
+	require.Equal(t, 1, opens)
+	require.Equal(t, 0, pc.chunks.ll.Len())
+
+	// The second time the range is read the chunks should be admitted to the
+	// cache
+	require.Equal(t, data[offset:offset+200], readRange(offset, 200))
+	require.Equal(t, 2, pc.chunks.ll.Len())
+	files, err := filepath.Glob(filepath.Join(pc.pieceDir(pieceCid), "0-*"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	// Reading the range again should not open the underlying reader
+	opens = 0
+	require.Equal(t, data[offset:offset+200], readRange(offset, 200))
+	require.Equal(t, 0, opens)
+
+	// Reading the whole piece should return the correct data, and the cache
+	// should be kept within its maximum size
+	require.Equal(t, data, readRange(0, len(data)))
+	require.Equal(t, data, readRange(0, len(data)))
+	require.LessOrEqual(t, pc.chunks.size, int64(2*pieceCacheChunkSize))
+
+	// The files of evicted chunks should have been removed
+	entries, err := os.ReadDir(pc.pieceDir(pieceCid))
+	require.NoError(t, err)
+	require.Len(t, entries, pc.chunks.ll.Len())
+
+	// Invalidating the piece should remove its chunks
+	pc.invalidate(pieceCid)
+	require.Equal(t, 0, pc.chunks.ll.Len())
+	require.Empty(t, pc.pieces())
+	_, err = os.Stat(pc.pieceDir(pieceCid))
+	require.True(t, os.IsNotExist(err))
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/filecoin-project/boost/extern/boostd-data/model"
 	"github.com/filecoin-project/boost/extern/boostd-data/shared/tracing"
 	"github.com/filecoin-project/boost/metrics"
+	"github.com/filecoin-project/boost/piecedirectory"
 	"github.com/filecoin-project/boost/retrievalmarket/types/legacyretrievaltypes"
 	"github.com/hashicorp/go-multierror"
 	"github.com/ipfs/go-cid"
@@ -54,8 +55,35 @@ func (s *HttpServer) handleByPieceCid(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get a reader over the piece
-	content, err := s.getPieceContent(ctx, pieceCid)
+	// If a payload root is specified, only the CAR file with that root is
+	// served from the (aggregate) piece
+	var root cid.Cid
+	if rootStr := r.URL.Query().Get("root"); rootStr != "" {
+		root, err = cid.Parse(rootStr)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, fmt.Errorf("parsing root CID '%s': %s", rootStr, err.Error()))
+			stats.Record(ctx, metrics.HttpPieceByCid400ResponseCount.M(1))
+			return
+		}
+	}
+
+	var content io.ReadSeeker
+	if root.Defined() {
+		// Get a reader over the CAR file with the payload root
+		content, err = s.getPieceRootContent(ctx, pieceCid, root)
+	} else {
+		// Get a reader over the piece
+		content, err = s.getCachedPieceContent(ctx, pieceCid)
+		if err != nil && isNotFoundError(err) {
+			// The piece CID may be the CID of a sub-piece of an aggregate piece
+			subPieceContent, subPieceErr := s.getSubPieceContent(ctx, pieceCid)
+			if subPieceErr == nil {
+				content, err = subPieceContent, nil
+			} else if !isNotFoundError(subPieceErr) {
+				err = subPieceErr
+			}
+		}
+	}
 	if err != nil {
 		if isNotFoundError(err) {
 			writeError(w, r, http.StatusNotFound, err)
@@ -67,7 +95,22 @@ func (s *HttpServer) handleByPieceCid(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	setHeaders(w, pieceCid)
+	if cr, ok := content.(*cachedPieceReader); ok {
+		defer func() {
+			// The request was served from the cache if no data had to be
+			// read from the underlying piece
+			setAccessLogCacheHit(r.Context(), cr.hits > 0 && cr.misses == 0)
+			_ = cr.Close()
+		}()
+	} else if c, ok := content.(io.Closer); ok {
+		defer c.Close() //nolint:errcheck
+	}
+
+	if root.Defined() {
+		setHeaders(w, pieceCid.String()+"."+root.String(), "application/vnd.ipld.car; version=1")
+	} else {
+		setHeaders(w, pieceCid.String(), "application/piece")
+	}
 	serveContent(w, r, content)
 
 	stats.Record(ctx, metrics.HttpPieceByCid200ResponseCount.M(1))
@@ -96,6 +139,159 @@ func (s *HttpServer) getPieceContent(ctx context.Context, pieceCid cid.Cid) (io.
 	return pieceReader, nil
 }
 
+// getCachedPieceContent gets a reader over the piece that reads from the
+// piece cache, if it's enabled
+func (s *HttpServer) getCachedPieceContent(ctx context.Context, pieceCid cid.Cid) (io.ReadSeeker, error) {
+	if s.opts.PieceCache == nil {
+		return s.getPieceContent(ctx, pieceCid)
+	}
+	return s.opts.PieceCache.reader(ctx, pieceCid, func() (io.ReadSeeker, error) {
+		return s.getPieceContent(ctx, pieceCid)
+	})
+}
+
+// invalidateCachesLoop periodically checks that the pieces in the piece
+// cache and the pieces containing blocks in the block cache still have
+// deals, and removes them from the caches if not.
+// Deals are removed by boostd, so booster-http finds out about removed deals
+// by checking the local index directory.
+func (s *HttpServer) invalidateCachesLoop(ctx context.Context) {
+	ticker := time.NewTicker(cacheInvalidateInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		s.invalidateCaches(ctx)
+	}
+}
+
+func (s *HttpServer) invalidateCaches(ctx context.Context) {
+	pieces := make(map[cid.Cid]struct{})
+	if s.opts.PieceCache != nil {
+		for _, pieceCid := range s.opts.PieceCache.pieces() {
+			pieces[pieceCid] = struct{}{}
+		}
+	}
+	if s.opts.BlockCache != nil {
+		for _, pieceCid := range s.opts.BlockCache.cachedPieces() {
+			pieces[pieceCid] = struct{}{}
+		}
+	}
+
+	for pieceCid := range pieces {
+		pieceDeals, err := s.api.GetPieceDeals(ctx, pieceCid)
+		if err != nil && !isNotFoundError(err) {
+			log.Warnw("checking deals for cached piece", "piece", pieceCid, "err", err)
+			continue
+		}
+		if len(pieceDeals) > 0 {
+			continue
+		}
+
+		log.Infow("removing piece with no deals from caches", "piece", pieceCid)
+		if s.opts.PieceCache != nil {
+			s.opts.PieceCache.invalidate(pieceCid)
+		}
+		if s.opts.BlockCache != nil {
+			s.opts.BlockCache.invalidate(pieceCid)
+		}
+	}
+}
+
+// getPieceRootContent gets a reader over the CAR file with the given payload
+// root, by looking up the data segment that contains it in the data segment
+// index of the piece
+func (s *HttpServer) getPieceRootContent(ctx context.Context, pieceCid cid.Cid, root cid.Cid) (io.ReadSeeker, error) {
+	pieceDeals, err := s.api.GetPieceDeals(ctx, pieceCid)
+	if err != nil {
+		return nil, fmt.Errorf("getting sector info for piece %s: %w", pieceCid, err)
+	}
+
+	di, err := s.unsealedDeal(ctx, pieceCid, pieceDeals)
+	if err != nil {
+		return nil, fmt.Errorf("getting unsealed CAR file: %w", err)
+	}
+
+	pieceReader, err := s.api.UnsealSectorAt(ctx, di.MinerAddr, di.SectorID, di.PieceOffset.Unpadded(), di.PieceLength.Unpadded())
+	if err != nil {
+		return nil, fmt.Errorf("getting raw data from sector %d: %w", di.SectorID, err)
+	}
+
+	// Reading the data segment index is expensive, so the location of the
+	// segment is cached
+	key := segmentKey{piece: pieceCid, root: root}
+	s.segmentsLk.Lock()
+	loc, ok := s.segments.get(key)
+	s.segmentsLk.Unlock()
+	if !ok {
+		loc.offset, loc.length, err = piecedirectory.FindDataSegmentByRoot(pieceCid, int64(di.PieceLength.Unpadded()), pieceReader, root)
+		if err != nil {
+			_ = pieceReader.Close()
+			return nil, fmt.Errorf("finding CAR file with root %s in piece %s: %s: %w", root, pieceCid, err, ErrNotFound)
+		}
+		s.segmentsLk.Lock()
+		s.segments.add(key, loc, 1)
+		s.segmentsLk.Unlock()
+	}
+
+	return &sectionReadCloser{
+		SectionReader: io.NewSectionReader(pieceReader, int64(loc.offset), int64(loc.length)),
+		Closer:        pieceReader,
+	}, nil
+}
+
+// sectionReadCloser reads a section of a reader, and closes the underlying
+// reader when it is closed
+type sectionReadCloser struct {
+	*io.SectionReader
+	io.Closer
+}
+
+// getSubPieceContent gets a reader over the data of a sub-piece of an
+// aggregate piece
+func (s *HttpServer) getSubPieceContent(ctx context.Context, subPieceCid cid.Cid) (io.ReadSeeker, error) {
+	// Get the aggregate pieces that contain the sub-piece
+	subPieces, err := s.api.GetSubPieceDeals(ctx, subPieceCid)
+	if err != nil {
+		return nil, fmt.Errorf("getting aggregate pieces for sub-piece %s: %w", subPieceCid, err)
+	}
+	if len(subPieces) == 0 {
+		return nil, fmt.Errorf("there are no aggregate pieces containing sub-piece %s: %w", subPieceCid, ErrNotFound)
+	}
+
+	var allErr error
+	for _, sp := range subPieces {
+		pieceDeals, err := s.api.GetPieceDeals(ctx, sp.PieceCID)
+		if err != nil {
+			allErr = multierror.Append(allErr, fmt.Errorf("getting sector info for aggregate piece %s: %w", sp.PieceCID, err))
+			continue
+		}
+
+		di, err := s.unsealedDeal(ctx, sp.PieceCID, pieceDeals)
+		if err != nil {
+			allErr = multierror.Append(allErr, err)
+			continue
+		}
+
+		// Read the sub-piece data directly from its offset within the
+		// aggregate piece in the sector
+		offset := di.PieceOffset.Unpadded() + sp.Offset.Unpadded()
+		pieceReader, err := s.api.UnsealSectorAt(ctx, di.MinerAddr, di.SectorID, offset, sp.Size.Unpadded())
+		if err != nil {
+			allErr = multierror.Append(allErr, fmt.Errorf("getting raw data from sector %d: %w", di.SectorID, err))
+			continue
+		}
+		return pieceReader, nil
+	}
+
+	return nil, fmt.Errorf("getting unsealed data for sub-piece %s: %w", subPieceCid, allErr)
+}
+
 func isGzipped(res http.ResponseWriter) bool {
 	switch res.(type) {
 	case *gziphandler.GzipResponseWriter, gziphandler.GzipResponseWriterWithCloseNotify:
@@ -107,14 +303,14 @@ func isGzipped(res http.ResponseWriter) bool {
 	return false
 }
 
-func setHeaders(w http.ResponseWriter, pieceCid cid.Cid) {
+func setHeaders(w http.ResponseWriter, id string, contentType string) {
 	w.Header().Set("Vary", "Accept-Encoding")
-	etag := `"` + pieceCid.String() + `"` // must be quoted
+	etag := `"` + id + `"` // must be quoted
 	if isGzipped(w) {
 		etag = etag[:len(etag)-1] + ".gz\""
 	}
 	w.Header().Set("Etag", etag)
-	w.Header().Set("Content-Type", "application/piece")
+	w.Header().Set("Content-Type", contentType)
 	w.Header().Set("Cache-Control", "public, max-age=29030400, immutable")
 }
 
@@ -223,9 +419,13 @@ func toLoggingResponseWriter(res http.ResponseWriter) *frisbii.LoggingResponseWr
 	case *frisbii.LoggingResponseWriter:
 		return lrw
 	case *gziphandler.GzipResponseWriter:
-		if lrw, ok := lrw.ResponseWriter.(*frisbii.LoggingResponseWriter); ok {
-			return lrw
-		}
+		return toLoggingResponseWriter(lrw.ResponseWriter)
+	case *meteredResponseWriter:
+		return toLoggingResponseWriter(lrw.ResponseWriter)
+	case *throttledResponseWriter:
+		return toLoggingResponseWriter(lrw.ResponseWriter)
+	case *paidResponseWriter:
+		return toLoggingResponseWriter(lrw.ResponseWriter)
 	}
 	return nil
 }
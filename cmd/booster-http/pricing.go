@@ -0,0 +1,712 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/boost/cmd/lib/filters"
+	"github.com/filecoin-project/boost/metrics"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/builtin/v8/paych"
+	"github.com/ipfs/go-cid"
+	"go.opencensus.io/stats"
+)
+
+const (
+	// How often to check if the pricing config file has changed, and to save
+	// the payment ledger if it has changed
+	pricingReloadInterval = 10 * time.Second
+	// The header in which clients send a payment voucher
+	paymentVoucherHeader = "X-Payment-Voucher"
+)
+
+var errPaymentRequired = errors.New("payment required")
+
+// PricingConfig is the format of the file passed to booster-http with
+// --pricing-config. The price of a request is the price for the piece if
+// there is one, otherwise the price for the client's class if there is one,
+// otherwise the default price. For requests for a payload CID, the piece
+// price is the highest price of the pieces that contain the CID. For
+// requests for a sub-piece of an aggregate piece without a price of its own,
+// the piece price is the highest price of the aggregate pieces that contain
+// the sub-piece.
+// Amounts are in attoFIL.
+type PricingConfig struct {
+	// The default price
+	Price
+	// Prices for client classes, by API key name or token issuer
+	Classes []ClassPrice
+	// Prices for particular pieces
+	Pieces []PiecePrice
+	// The prepaid credit for each API key (by key name) or token subject
+	// (<issuer>/<subject>). Increase the credit to top up a client's balance.
+	Credits map[string]string
+}
+
+type Price struct {
+	// The price of each byte served
+	PricePerByte string
+	// A fixed price for each request
+	PricePerRequest string
+}
+
+type ClassPrice struct {
+	Class string
+	Price
+}
+
+type PiecePrice struct {
+	PieceCid string
+	Price
+}
+
+type price struct {
+	perByte    abi.TokenAmount
+	perRequest abi.TokenAmount
+}
+
+func (p price) isFree() bool {
+	return p.perByte.IsZero() && p.perRequest.IsZero()
+}
+
+func parsePrice(p Price) (price, error) {
+	parse := func(s string) (abi.TokenAmount, error) {
+		if s == "" {
+			return big.Zero(), nil
+		}
+		amt, err := big.FromString(s)
+		if err != nil {
+			return big.Zero(), fmt.Errorf("parsing amount '%s': %w", s, err)
+		}
+		if amt.LessThan(big.Zero()) {
+			return big.Zero(), fmt.Errorf("amount '%s' is negative", s)
+		}
+		return amt, nil
+	}
+
+	perByte, err := parse(p.PricePerByte)
+	if err != nil {
+		return price{}, err
+	}
+	perRequest, err := parse(p.PricePerRequest)
+	if err != nil {
+		return price{}, err
+	}
+	return price{perByte: perByte, perRequest: perRequest}, nil
+}
+
+type parsedPricingConfig struct {
+	dflt    price
+	classes map[string]price
+	pieces  map[string]price
+	credits map[string]abi.TokenAmount
+}
+
+func loadPricingConfig(path string) (*parsedPricingConfig, error) {
+	bz, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading pricing config file: %w", err)
+	}
+
+	var cfg PricingConfig
+	if err := json.Unmarshal(bz, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing pricing config file %s: %w", path, err)
+	}
+
+	parsed := &parsedPricingConfig{
+		classes: make(map[string]price, len(cfg.Classes)),
+		pieces:  make(map[string]price, len(cfg.Pieces)),
+		credits: make(map[string]abi.TokenAmount, len(cfg.Credits)),
+	}
+	parsed.dflt, err = parsePrice(cfg.Price)
+	if err != nil {
+		return nil, fmt.Errorf("default price: %w", err)
+	}
+	for _, c := range cfg.Classes {
+		parsed.classes[c.Class], err = parsePrice(c.Price)
+		if err != nil {
+			return nil, fmt.Errorf("price for class %s: %w", c.Class, err)
+		}
+	}
+	for _, pp := range cfg.Pieces {
+		pieceCid, err := cid.Parse(pp.PieceCid)
+		if err != nil {
+			return nil, fmt.Errorf("parsing piece CID '%s': %w", pp.PieceCid, err)
+		}
+		parsed.pieces[pieceCid.String()], err = parsePrice(pp.Price)
+		if err != nil {
+			return nil, fmt.Errorf("price for piece %s: %w", pp.PieceCid, err)
+		}
+	}
+	for key, amt := range cfg.Credits {
+		credit, err := big.FromString(amt)
+		if err != nil {
+			return nil, fmt.Errorf("parsing credit '%s' for %s: %w", amt, key, err)
+		}
+		parsed.credits[key] = credit
+	}
+	return parsed, nil
+}
+
+// PaychAPI is the full node API used to redeem payment vouchers
+type PaychAPI interface {
+	PaychVoucherAdd(ctx context.Context, ch address.Address, sv *paych.SignedVoucher, proof []byte, minDelta big.Int) (big.Int, error)
+}
+
+// paymentAccount is the record of payments for an API key or token subject
+type paymentAccount struct {
+	// The amount paid with payment vouchers
+	Paid abi.TokenAmount
+	// The amount spent on retrievals
+	Spent abi.TokenAmount
+	// The payment channels that the client has paid with. A payment channel
+	// can only be used to pay for one client.
+	Channels []address.Address `json:",omitempty"`
+}
+
+// Pricer charges authenticated clients for retrievals. Each client has a
+// balance, which is the credit in the pricing config plus the amount paid
+// with payment vouchers, minus the amount spent. Requests are refused with
+// 402 Payment Required when the balance is exhausted.
+// The pricing config is reloaded when the file changes, and the payment
+// ledger is saved to disk so that balances survive a restart. The ledger is
+// saved as soon as a payment voucher is redeemed, and periodically for the
+// amounts spent.
+type Pricer struct {
+	path       string
+	ledgerPath string
+	paych      PaychAPI
+	pieces     filters.PieceLookup
+	subPieces  filters.SubPieceLookup
+
+	// saveLk serializes writes of the ledger file
+	saveLk sync.Mutex
+
+	lk       sync.Mutex
+	cfg      *parsedPricingConfig
+	modTime  time.Time
+	accounts map[string]*paymentAccount
+	// The key of the client that each payment channel is bound to
+	channels map[address.Address]string
+	dirty    bool
+}
+
+// NewPricer loads the pricing config and the payment ledger. The piece lookup
+// is used to find the pieces that contain the payload CID of a request, and
+// the sub-piece lookup is used to find the aggregate pieces that contain a
+// sub-piece, so that the piece prices can be applied to them.
+func NewPricer(path string, ledgerPath string, paych PaychAPI, pieces filters.PieceLookup, subPieces filters.SubPieceLookup) (*Pricer, error) {
+	p := &Pricer{
+		path:       path,
+		ledgerPath: ledgerPath,
+		paych:      paych,
+		pieces:     pieces,
+		subPieces:  subPieces,
+		accounts:   make(map[string]*paymentAccount),
+		channels:   make(map[address.Address]string),
+	}
+	if err := p.reload(); err != nil {
+		return nil, err
+	}
+
+	bz, err := os.ReadFile(ledgerPath)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(bz, &p.accounts); err != nil {
+			return nil, fmt.Errorf("parsing payment ledger %s: %w", ledgerPath, err)
+		}
+		for key, a := range p.accounts {
+			if a.Paid.Int == nil {
+				a.Paid = big.Zero()
+			}
+			if a.Spent.Int == nil {
+				a.Spent = big.Zero()
+			}
+			for _, ch := range a.Channels {
+				p.channels[ch] = key
+			}
+		}
+	case !os.IsNotExist(err):
+		return nil, fmt.Errorf("reading payment ledger: %w", err)
+	}
+	return p, nil
+}
+
+func (p *Pricer) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(pricingReloadInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			fi, err := os.Stat(p.path)
+			if err != nil {
+				log.Warnw("checking pricing config file", "path", p.path, "err", err)
+			} else if !fi.ModTime().Equal(p.getModTime()) {
+				if err := p.reload(); err != nil {
+					log.Errorw("reloading pricing config, keeping existing prices", "path", p.path, "err", err)
+				} else {
+					log.Infow("reloaded pricing config", "path", p.path)
+				}
+			}
+
+			if err := p.save(); err != nil {
+				log.Errorw("saving payment ledger", "path", p.ledgerPath, "err", err)
+			}
+		}
+	}()
+}
+
+// Close saves the payment ledger
+func (p *Pricer) Close() error {
+	return p.save()
+}
+
+func (p *Pricer) getModTime() time.Time {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	return p.modTime
+}
+
+func (p *Pricer) reload() error {
+	fi, err := os.Stat(p.path)
+	if err != nil {
+		return fmt.Errorf("reading pricing config file: %w", err)
+	}
+	cfg, err := loadPricingConfig(p.path)
+	if err != nil {
+		return err
+	}
+
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	p.cfg = cfg
+	p.modTime = fi.ModTime()
+	return nil
+}
+
+// save writes the payment ledger to disk if it has changed
+func (p *Pricer) save() error {
+	p.saveLk.Lock()
+	defer p.saveLk.Unlock()
+
+	p.lk.Lock()
+	if !p.dirty {
+		p.lk.Unlock()
+		return nil
+	}
+	bz, err := json.Marshal(p.accounts)
+	p.dirty = false
+	p.lk.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file and then rename it, so that the ledger is
+	// never partially written
+	tmp := p.ledgerPath + ".tmp"
+	err = os.WriteFile(tmp, bz, 0o600)
+	if err == nil {
+		err = os.Rename(tmp, p.ledgerPath)
+	}
+	if err != nil {
+		// Try again next time
+		p.lk.Lock()
+		p.dirty = true
+		p.lk.Unlock()
+	}
+	return err
+}
+
+// account returns the payment account for the key.
+// Must be called with the lock held.
+func (p *Pricer) account(key string) *paymentAccount {
+	a, ok := p.accounts[key]
+	if !ok {
+		a = &paymentAccount{Paid: big.Zero(), Spent: big.Zero()}
+		p.accounts[key] = a
+	}
+	return a
+}
+
+// balanceLocked returns the balance for the key.
+// Must be called with the lock held.
+func (p *Pricer) balanceLocked(key string) abi.TokenAmount {
+	a := p.account(key)
+	credit, ok := p.cfg.credits[key]
+	if !ok {
+		credit = big.Zero()
+	}
+	return big.Sub(big.Add(credit, a.Paid), a.Spent)
+}
+
+func (p *Pricer) balance(key string) abi.TokenAmount {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	return p.balanceLocked(key)
+}
+
+// priceFor returns the price of retrievals of the piece for the client
+func (p *Pricer) priceFor(id *authIdentity, pieceCid string) price {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	if pr, ok := p.cfg.pieces[pieceCid]; ok && pieceCid != "" {
+		return pr
+	}
+	if pr, ok := p.cfg.classes[id.Group]; ok {
+		return pr
+	}
+	return p.cfg.dflt
+}
+
+// requestPrice returns the price of retrieving the CID for the client. The
+// CID may be a piece CID, the CID of a block, or cid.Undef if the request
+// isn't for a particular CID. A block may be in several pieces, in which case
+// the highest price of those pieces applies. Similarly a piece CID may be the
+// CID of a sub-piece of several aggregate pieces.
+func (p *Pricer) requestPrice(ctx context.Context, id *authIdentity, c cid.Cid) (price, error) {
+	if !c.Defined() {
+		return p.priceFor(id, ""), nil
+	}
+
+	p.lk.Lock()
+	_, hasPiecePrice := p.cfg.pieces[c.String()]
+	piecePrices := len(p.cfg.pieces) > 0
+	p.lk.Unlock()
+	if hasPiecePrice || !piecePrices {
+		return p.priceFor(id, c.String()), nil
+	}
+
+	var pieces []cid.Cid
+	var err error
+	if c.Prefix().Codec == cid.FilCommitmentUnsealed {
+		if p.subPieces == nil {
+			return p.priceFor(id, ""), nil
+		}
+		pieces, err = p.subPieces(ctx, c)
+		if err != nil && !isNotFoundError(err) {
+			return price{}, fmt.Errorf("getting aggregate pieces containing sub-piece %s: %w", c, err)
+		}
+	} else {
+		if p.pieces == nil {
+			return p.priceFor(id, ""), nil
+		}
+		pieces, err = p.pieces(ctx, c.Hash())
+		if err != nil && !isNotFoundError(err) {
+			return price{}, fmt.Errorf("getting pieces containing %s: %w", c, err)
+		}
+	}
+
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	var highest *price
+	for _, pieceCid := range pieces {
+		pr, ok := p.cfg.pieces[pieceCid.String()]
+		if !ok {
+			continue
+		}
+		if highest == nil {
+			highest = &price{perByte: pr.perByte, perRequest: pr.perRequest}
+			continue
+		}
+		highest.perByte = big.Max(highest.perByte, pr.perByte)
+		highest.perRequest = big.Max(highest.perRequest, pr.perRequest)
+	}
+	if highest != nil {
+		return *highest, nil
+	}
+	if pr, ok := p.cfg.classes[id.Group]; ok {
+		return pr, nil
+	}
+	return p.cfg.dflt, nil
+}
+
+// begin charges the price per request. It returns an error if the client's
+// balance is exhausted.
+func (p *Pricer) begin(key string, pr price) error {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	bal := p.balanceLocked(key)
+	if bal.LessThanEqual(big.Zero()) || bal.LessThan(pr.perRequest) {
+		return fmt.Errorf("balance of %s attoFIL is insufficient: %w", bal, errPaymentRequired)
+	}
+	p.spendLocked(key, pr.perRequest)
+	return nil
+}
+
+// chargeBytes charges for up to n bytes at the price per byte, and returns
+// the number of bytes that the client's balance covers
+func (p *Pricer) chargeBytes(key string, perByte abi.TokenAmount, n int) int {
+	if perByte.IsZero() || n == 0 {
+		return n
+	}
+
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	bal := p.balanceLocked(key)
+	if bal.LessThanEqual(big.Zero()) {
+		return 0
+	}
+	if affordable := big.Div(bal, perByte); affordable.LessThan(big.NewInt(int64(n))) {
+		n = int(affordable.Int64())
+	}
+	p.spendLocked(key, big.Mul(perByte, big.NewInt(int64(n))))
+	return n
+}
+
+// Must be called with the lock held.
+func (p *Pricer) spendLocked(key string, amt abi.TokenAmount) {
+	if amt.IsZero() {
+		return
+	}
+	a := p.account(key)
+	a.Spent = big.Add(a.Spent, amt)
+	p.dirty = true
+}
+
+// redeemVoucher adds the payment voucher to the full node, and credits the
+// amount paid by the voucher to the client's balance.
+// The full node checks that the voucher is valid, and that the payment
+// channel pays an address in its wallet. The payment channel is bound to the
+// first client that redeems a voucher for it, and vouchers for the channel
+// from other clients are rejected, so that a client can't be credited with
+// another client's payments. The ledger is saved before returning, so that
+// the payment is not lost if booster-http stops.
+func (p *Pricer) redeemVoucher(ctx context.Context, key string, encoded string) (abi.TokenAmount, error) {
+	if p.paych == nil {
+		return big.Zero(), errors.New("payment vouchers are not accepted")
+	}
+
+	sv, err := decodeSignedVoucher(encoded)
+	if err != nil {
+		return big.Zero(), err
+	}
+
+	bound, err := p.bindChannel(key, sv.ChannelAddr)
+	if err != nil {
+		return big.Zero(), err
+	}
+
+	delta, err := p.paych.PaychVoucherAdd(ctx, sv.ChannelAddr, sv, nil, big.Zero())
+	if err == nil && delta.LessThanEqual(big.Zero()) {
+		err = fmt.Errorf("voucher for payment channel %s does not add any funds", sv.ChannelAddr)
+	} else if err != nil {
+		err = fmt.Errorf("adding voucher for payment channel %s: %w", sv.ChannelAddr, err)
+	}
+	if err != nil {
+		if bound {
+			p.unbindChannel(key, sv.ChannelAddr)
+		}
+		return big.Zero(), err
+	}
+
+	p.lk.Lock()
+	a := p.account(key)
+	a.Paid = big.Add(a.Paid, delta)
+	p.dirty = true
+	p.lk.Unlock()
+	log.Infow("redeemed payment voucher", "key", key, "channel", sv.ChannelAddr, "amount", delta)
+
+	if err := p.save(); err != nil {
+		// The payment has been credited, and the ledger will be saved again
+		// with the next periodic save
+		log.Errorw("saving payment ledger", "path", p.ledgerPath, "err", err)
+	}
+	return delta, nil
+}
+
+// bindChannel binds the payment channel to the client, and returns true if
+// the channel was not already bound. It returns an error if the channel is
+// bound to another client.
+func (p *Pricer) bindChannel(key string, ch address.Address) (bool, error) {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	if owner, ok := p.channels[ch]; ok {
+		if owner != key {
+			return false, fmt.Errorf("payment channel %s pays for another client", ch)
+		}
+		return false, nil
+	}
+	p.channels[ch] = key
+	a := p.account(key)
+	a.Channels = append(a.Channels, ch)
+	p.dirty = true
+	return true, nil
+}
+
+// unbindChannel removes a binding added by bindChannel for a voucher that
+// could not be redeemed
+func (p *Pricer) unbindChannel(key string, ch address.Address) {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	delete(p.channels, ch)
+	a := p.account(key)
+	for i, c := range a.Channels {
+		if c == ch {
+			a.Channels = append(a.Channels[:i], a.Channels[i+1:]...)
+			break
+		}
+	}
+}
+
+// decodeSignedVoucher decodes a voucher in the format output by
+// `lotus paych voucher create`
+func decodeSignedVoucher(s string) (*paych.SignedVoucher, error) {
+	bz, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, fmt.Errorf("decoding payment voucher: %w", err)
+	}
+	var sv paych.SignedVoucher
+	if err := sv.UnmarshalCBOR(bytes.NewReader(bz)); err != nil {
+		return nil, fmt.Errorf("unmarshalling payment voucher: %w", err)
+	}
+	return &sv, nil
+}
+
+// handler wraps the given handler so that the client is charged for the
+// request. It must be wrapped by the Authenticator handler.
+func (p *Pricer) handler(next http.Handler, requestCid func(*http.Request) cid.Cid) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := authIdentityFromContext(r.Context())
+		if id == nil {
+			writeError(w, r, http.StatusUnauthorized, fmt.Errorf("paid retrievals require an API key: %w", errUnauthorized))
+			return
+		}
+
+		if v := r.Header.Get(paymentVoucherHeader); v != "" {
+			if _, err := p.redeemVoucher(r.Context(), id.ID, v); err != nil {
+				writeError(w, r, http.StatusPaymentRequired, err)
+				return
+			}
+		}
+
+		pr, err := p.requestPrice(r.Context(), id, requestCid(r))
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, fmt.Errorf("getting price: %w", err))
+			return
+		}
+		if pr.isFree() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if err := p.begin(id.ID, pr); err != nil {
+			stats.Record(r.Context(), metrics.HttpPaymentRequiredCount.M(1))
+			writeError(w, r, http.StatusPaymentRequired, err)
+			return
+		}
+		next.ServeHTTP(&paidResponseWriter{ResponseWriter: w, p: p, key: id.ID, perByte: pr.perByte}, r)
+	}
+}
+
+// PriceQuote is the response to a request for a price quote
+type PriceQuote struct {
+	PieceCid        string `json:",omitempty"`
+	PricePerByte    abi.TokenAmount
+	PricePerRequest abi.TokenAmount
+	// The size of the piece, and the price of retrieving the whole piece
+	Size  uint64           `json:",omitempty"`
+	Total *abi.TokenAmount `json:",omitempty"`
+	// The client's current balance
+	Balance abi.TokenAmount
+}
+
+// handleQuote responds with the price of retrievals for the client, and the
+// price of retrieving the piece if the path is /quote/<piece cid>
+func (s *HttpServer) handleQuote(w http.ResponseWriter, r *http.Request) {
+	id := authIdentityFromContext(r.Context())
+	if id == nil {
+		writeError(w, r, http.StatusUnauthorized, fmt.Errorf("price quotes require an API key: %w", errUnauthorized))
+		return
+	}
+
+	var pieceCid cid.Cid
+	if pieceCidStr := strings.TrimPrefix(r.URL.Path, s.quoteBasePath()); pieceCidStr != "" {
+		var err error
+		pieceCid, err = cid.Parse(pieceCidStr)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, fmt.Errorf("parsing piece CID '%s': %s", pieceCidStr, err.Error()))
+			return
+		}
+	}
+
+	q := PriceQuote{Balance: s.opts.Pricer.balance(id.ID)}
+	var pieceCidStr string
+	if pieceCid.Defined() {
+		pieceCidStr = pieceCid.String()
+	}
+	pr := s.opts.Pricer.priceFor(id, pieceCidStr)
+	q.PricePerByte = pr.perByte
+	q.PricePerRequest = pr.perRequest
+
+	if pieceCid.Defined() {
+		deals, err := s.api.GetPieceDeals(r.Context(), pieceCid)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, fmt.Errorf("getting deals for piece %s: %w", pieceCid, err))
+			return
+		}
+		if len(deals) == 0 {
+			writeError(w, r, http.StatusNotFound, fmt.Errorf("no deals found for piece %s: %w", pieceCid, ErrNotFound))
+			return
+		}
+		q.PieceCid = pieceCidStr
+		q.Size = uint64(deals[0].PieceLength.Unpadded())
+		total := big.Add(big.Mul(pr.perByte, big.NewIntUnsigned(q.Size)), pr.perRequest)
+		q.Total = &total
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(q); err != nil {
+		log.Errorw("writing price quote response", "err", err)
+	}
+}
+
+// paidResponseWriter charges the client for each byte written to the
+// response, and stops writing when the client's balance is exhausted
+type paidResponseWriter struct {
+	http.ResponseWriter
+	p       *Pricer
+	key     string
+	perByte abi.TokenAmount
+}
+
+func (w *paidResponseWriter) Write(bz []byte) (int, error) {
+	n := w.p.chargeBytes(w.key, w.perByte, len(bz))
+	written, err := w.ResponseWriter.Write(bz[:n])
+	if err != nil {
+		return written, err
+	}
+	if n < len(bz) {
+		return written, fmt.Errorf("balance exhausted: %w", errPaymentRequired)
+	}
+	return written, nil
+}
+
+func (w *paidResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/builtin/v8/paych"
+	"github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+const testPieceCid = "baga6ea4seaqjtovkwk4myyzj56eztkh5pzsk5upksan6f5outesy62bsvl4dsha"
+
+type mockPaychAPI struct {
+	delta big.Int
+	added []*paych.SignedVoucher
+}
+
+func (m *mockPaychAPI) PaychVoucherAdd(ctx context.Context, ch address.Address, sv *paych.SignedVoucher, proof []byte, minDelta big.Int) (big.Int, error) {
+	m.added = append(m.added, sv)
+	return m.delta, nil
+}
+
+func writePricingConfig(t *testing.T, path string, cfg PricingConfig) {
+	bz, err := json.Marshal(cfg)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, bz, 0o644))
+}
+
+func TestPricingConfig(t *testing.T) {
+	cfgPath := filepath.Join(t.TempDir(), "pricing.json")
+
+	writePricingConfig(t, cfgPath, PricingConfig{
+		Price:   Price{PricePerByte: "1"},
+		Classes: []ClassPrice{{Class: "partner", Price: Price{PricePerRequest: "5"}}},
+		Pieces:  []PiecePrice{{PieceCid: testPieceCid, Price: Price{PricePerByte: "2", PricePerRequest: "3"}}},
+	})
+	p, err := NewPricer(cfgPath, filepath.Join(t.TempDir(), "payments.json"), nil, nil, nil)
+	require.NoError(t, err)
+
+	// The piece price takes precedence over the class price, which takes
+	// precedence over the default price
+	pr := p.priceFor(&authIdentity{ID: "partner", Group: "partner"}, testPieceCid)
+	require.Equal(t, big.NewInt(2), pr.perByte)
+	require.Equal(t, big.NewInt(3), pr.perRequest)
+	pr = p.priceFor(&authIdentity{ID: "partner", Group: "partner"}, "")
+	require.True(t, pr.perByte.IsZero())
+	require.Equal(t, big.NewInt(5), pr.perRequest)
+	pr = p.priceFor(&authIdentity{ID: "alice", Group: "alice"}, "")
+	require.Equal(t, big.NewInt(1), pr.perByte)
+	require.True(t, pr.perRequest.IsZero())
+
+	// The price of a block is the highest price of the pieces that
+	// contain it
+	otherPieceCid := "baga6ea4seaqhmks7eb3itzstkumzzb5d6v33wbnwk5ihgbwlk4xtonmnd3upqpa"
+	writePricingConfig(t, cfgPath, PricingConfig{
+		Price: Price{PricePerByte: "1"},
+		Pieces: []PiecePrice{
+			{PieceCid: testPieceCid, Price: Price{PricePerByte: "2", PricePerRequest: "3"}},
+			{PieceCid: otherPieceCid, Price: Price{PricePerByte: "4"}},
+		},
+	})
+	block := cid.MustParse("QmWATWQ7fVPP2EFGu71UkfnqhYXDYH566qy47CnJDgvs8u")
+	otherBlock := cid.MustParse("QmTn7prGSqKUd7cqvAjnULrH7zxBEBWrnj9kE7kZSGtDuQ")
+	pieceLookup := func(ctx context.Context, m mh.Multihash) ([]cid.Cid, error) {
+		if m.String() == block.Hash().String() {
+			return []cid.Cid{cid.MustParse(testPieceCid), cid.MustParse(otherPieceCid)}, nil
+		}
+		return nil, errors.New("multihash not found")
+	}
+	// The sub-piece is in an aggregate piece with a price
+	subPieceCid := "baga6ea4seaqjz2uoz3pqjkszxcrtsxmlgwuqaw4oy2c3ebtetwyfr4bdhqdq2ri"
+	subPieceLookup := func(ctx context.Context, c cid.Cid) ([]cid.Cid, error) {
+		if c.String() == subPieceCid {
+			return []cid.Cid{cid.MustParse(otherPieceCid)}, nil
+		}
+		return nil, nil
+	}
+	p, err = NewPricer(cfgPath, filepath.Join(t.TempDir(), "payments.json"), nil, pieceLookup, subPieceLookup)
+	require.NoError(t, err)
+	alice := &authIdentity{ID: "alice", Group: "alice"}
+	pr, err = p.requestPrice(context.Background(), alice, block)
+	require.NoError(t, err)
+	require.Equal(t, big.NewInt(4), pr.perByte)
+	require.Equal(t, big.NewInt(3), pr.perRequest)
+	pr, err = p.requestPrice(context.Background(), alice, cid.MustParse(testPieceCid))
+	require.NoError(t, err)
+	require.Equal(t, big.NewInt(2), pr.perByte)
+	pr, err = p.requestPrice(context.Background(), alice, cid.MustParse(subPieceCid))
+	require.NoError(t, err)
+	require.Equal(t, big.NewInt(4), pr.perByte)
+	require.True(t, pr.perRequest.IsZero())
+	pr, err = p.requestPrice(context.Background(), alice, otherBlock)
+	require.NoError(t, err)
+	require.Equal(t, big.NewInt(1), pr.perByte)
+	require.True(t, pr.perRequest.IsZero())
+
+	for _, cfg := range []PricingConfig{
+		{Price: Price{PricePerByte: "-1"}},
+		{Price: Price{PricePerRequest: "one"}},
+		{Pieces: []PiecePrice{{PieceCid: "not a cid"}}},
+		{Credits: map[string]string{"alice": "lots"}},
+	} {
+		writePricingConfig(t, cfgPath, cfg)
+		_, err := loadPricingConfig(cfgPath)
+		require.Error(t, err)
+	}
+}
+
+func TestPricerCharges(t *testing.T) {
+	dir := t.TempDir()
+	cfgPath := filepath.Join(dir, "pricing.json")
+	ledgerPath := filepath.Join(dir, "payments.json")
+	writePricingConfig(t, cfgPath, PricingConfig{
+		Price:   Price{PricePerByte: "1", PricePerRequest: "2"},
+		Credits: map[string]string{"alice": "10"},
+	})
+
+	paychAPI := &mockPaychAPI{delta: big.NewInt(100)}
+	p, err := NewPricer(cfgPath, ledgerPath, paychAPI, nil, nil)
+	require.NoError(t, err)
+
+	handler := p.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("0123456789"))
+	}), func(r *http.Request) cid.Cid {
+		return cid.MustParse(strings.TrimPrefix(r.URL.Path, "/piece/"))
+	})
+
+	get := func(key string, voucher string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/piece/"+testPieceCid, nil)
+		if key != "" {
+			id := &authIdentity{ID: key, Group: key}
+			req = req.WithContext(context.WithValue(req.Context(), authIdentityKey{}, id))
+		}
+		if voucher != "" {
+			req.Header.Set(paymentVoucherHeader, voucher)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	// Paid retrievals require an API key
+	require.Equal(t, http.StatusUnauthorized, get("", "").Code)
+
+	// A client with no balance can't retrieve
+	require.Equal(t, http.StatusPaymentRequired, get("bob", "").Code)
+
+	// The credit of 10 covers the price per request of 2 plus 8 bytes, so
+	// the response is cut off
+	rec := get("alice", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "01234567", rec.Body.String())
+	require.True(t, p.balance("alice").IsZero())
+	require.Equal(t, http.StatusPaymentRequired, get("alice", "").Code)
+
+	// An invalid voucher is rejected
+	require.Equal(t, http.StatusPaymentRequired, get("alice", "not a voucher").Code)
+	require.Empty(t, paychAPI.added)
+
+	// A valid voucher tops up the balance
+	ch, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	sv := &paych.SignedVoucher{ChannelAddr: ch, Lane: 1, Nonce: 1, Amount: big.NewInt(100)}
+	var buf bytes.Buffer
+	require.NoError(t, sv.MarshalCBOR(&buf))
+	rec = get("alice", base64.RawURLEncoding.EncodeToString(buf.Bytes()))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "0123456789", rec.Body.String())
+	require.Len(t, paychAPI.added, 1)
+	require.Equal(t, ch, paychAPI.added[0].ChannelAddr)
+	require.Equal(t, big.NewInt(88), p.balance("alice"))
+
+	// The payment is saved to the ledger when the voucher is redeemed,
+	// before the request is charged
+	redeemed, err := NewPricer(cfgPath, ledgerPath, paychAPI, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, big.NewInt(100), redeemed.balance("alice"))
+
+	// The payment channel is bound to alice, so bob can't redeem a voucher
+	// for it
+	sv.Nonce = 2
+	buf.Reset()
+	require.NoError(t, sv.MarshalCBOR(&buf))
+	require.Equal(t, http.StatusPaymentRequired, get("bob", base64.RawURLEncoding.EncodeToString(buf.Bytes())).Code)
+	require.Len(t, paychAPI.added, 1)
+
+	// The ledger is saved on close and loaded on restart
+	require.NoError(t, p.Close())
+	p, err = NewPricer(cfgPath, ledgerPath, paychAPI, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, big.NewInt(88), p.balance("alice"))
+	_, err = p.redeemVoucher(context.Background(), "bob", base64.RawURLEncoding.EncodeToString(buf.Bytes()))
+	require.Error(t, err)
+	_, err = p.redeemVoucher(context.Background(), "alice", base64.RawURLEncoding.EncodeToString(buf.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, big.NewInt(188), p.balance("alice"))
+
+	// Increasing the credit in the config tops up the balance
+	writePricingConfig(t, cfgPath, PricingConfig{
+		Price:   Price{PricePerByte: "1", PricePerRequest: "2"},
+		Credits: map[string]string{"alice": "20"},
+	})
+	require.NoError(t, p.reload())
+	require.Equal(t, big.NewInt(198), p.balance("alice"))
+}
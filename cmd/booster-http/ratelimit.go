@@ -0,0 +1,417 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/boost/metrics"
+	"go.opencensus.io/stats"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// How often to check if the rate limit config file has changed
+	rateLimitReloadInterval = 10 * time.Second
+	// Limiter state for a client is removed after it has been idle this long
+	rateLimitIdleTimeout = 10 * time.Minute
+	// The maximum number of bytes written at a time when the bandwidth is
+	// limited
+	throttledWriteSize = 32 * 1024
+)
+
+// RateLimitConfig is the format of the file passed to booster-http with
+// --rate-limit-config. A request must be within all of the limits that apply
+// to it: the limit for its client IP, the limit for each IP range that
+// contains its client IP, and the limit for its API key.
+type RateLimitConfig struct {
+	// Use the first address in the X-Forwarded-For header as the client IP.
+	// Only enable this when booster-http is behind a trusted reverse proxy.
+	TrustForwardedFor bool
+	// The limit for each client IP address
+	PerIP Limit
+	// Limits for IP ranges, shared by all clients in the range
+	Ranges []RangeLimit
+	// The limit for each API key that does not have its own limit
+	PerAPIKey Limit
+	// Limits for API keys by key name (or token issuer)
+	APIKeys map[string]Limit
+}
+
+// Limit is a set of limits. A zero value means no limit.
+type Limit struct {
+	// The number of requests per second
+	RequestsPerSecond float64
+	// The number of requests that may be made at once above the rate
+	// (defaults to one second's worth of requests)
+	Burst int
+	// The maximum number of requests that may be served concurrently
+	ConcurrentStreams int
+	// The maximum number of bytes per second served
+	BytesPerSecond int64
+}
+
+type RangeLimit struct {
+	CIDR  string
+	Limit Limit
+}
+
+func (l Limit) isZero() bool {
+	return l == Limit{}
+}
+
+type parsedRateLimitConfig struct {
+	RateLimitConfig
+	ranges []*net.IPNet
+}
+
+func loadRateLimitConfig(path string) (*parsedRateLimitConfig, error) {
+	bz, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading rate limit config file: %w", err)
+	}
+
+	cfg := &parsedRateLimitConfig{}
+	if err := json.Unmarshal(bz, &cfg.RateLimitConfig); err != nil {
+		return nil, fmt.Errorf("parsing rate limit config file %s: %w", path, err)
+	}
+	for _, r := range cfg.Ranges {
+		_, ipnet, err := net.ParseCIDR(r.CIDR)
+		if err != nil {
+			return nil, fmt.Errorf("parsing rate limit IP range '%s': %w", r.CIDR, err)
+		}
+		cfg.ranges = append(cfg.ranges, ipnet)
+	}
+	return cfg, nil
+}
+
+// limitState is the state of the limiters for a single client IP, IP range
+// or API key
+type limitState struct {
+	limit    Limit
+	requests *rate.Limiter
+	bytes    *rate.Limiter
+	active   int
+	lastSeen time.Time
+}
+
+func newLimitState(l Limit) *limitState {
+	st := &limitState{}
+	st.setLimit(l)
+	return st
+}
+
+// setLimit applies the limit to the state. The count of active streams is
+// kept, and existing limiters are updated in place so that their tokens are
+// kept and the new rates apply to responses that are already being written.
+func (st *limitState) setLimit(l Limit) {
+	st.limit = l
+
+	if l.RequestsPerSecond > 0 {
+		burst := l.Burst
+		if burst <= 0 {
+			burst = int(math.Ceil(l.RequestsPerSecond))
+		}
+		st.requests = updateLimiter(st.requests, rate.Limit(l.RequestsPerSecond), burst)
+	} else {
+		st.requests = nil
+	}
+
+	if l.BytesPerSecond > 0 {
+		burst := int(l.BytesPerSecond)
+		if burst < throttledWriteSize {
+			burst = throttledWriteSize
+		}
+		st.bytes = updateLimiter(st.bytes, rate.Limit(l.BytesPerSecond), burst)
+	} else {
+		st.bytes = nil
+	}
+}
+
+func updateLimiter(lim *rate.Limiter, r rate.Limit, burst int) *rate.Limiter {
+	if lim == nil {
+		return rate.NewLimiter(r, burst)
+	}
+	now := time.Now()
+	lim.SetLimitAt(now, r)
+	lim.SetBurstAt(now, burst)
+	return lim
+}
+
+// RateLimiter limits the rate of requests, the number of concurrent requests
+// and the bandwidth for each client IP, IP range and API key.
+// The limits are reloaded when the config file changes.
+type RateLimiter struct {
+	path string
+
+	lk      sync.Mutex
+	cfg     *parsedRateLimitConfig
+	modTime time.Time
+	states  map[string]*limitState
+}
+
+func NewRateLimiter(path string) (*RateLimiter, error) {
+	rl := &RateLimiter{path: path}
+	if err := rl.reload(); err != nil {
+		return nil, err
+	}
+	return rl, nil
+}
+
+func (rl *RateLimiter) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(rateLimitReloadInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			fi, err := os.Stat(rl.path)
+			if err != nil {
+				log.Warnw("checking rate limit config file", "path", rl.path, "err", err)
+			} else if !fi.ModTime().Equal(rl.getModTime()) {
+				if err := rl.reload(); err != nil {
+					log.Errorw("reloading rate limit config, keeping existing limits", "path", rl.path, "err", err)
+				} else {
+					log.Infow("reloaded rate limit config", "path", rl.path)
+				}
+			}
+
+			rl.removeIdle()
+		}
+	}()
+}
+
+func (rl *RateLimiter) getModTime() time.Time {
+	rl.lk.Lock()
+	defer rl.lk.Unlock()
+	return rl.modTime
+}
+
+// reload loads the config file. The limiter state for each client IP, IP
+// range and API key is kept, and the new limits are applied to it when it is
+// next used, so that streams that are already active still count towards
+// the concurrent stream limits.
+func (rl *RateLimiter) reload() error {
+	fi, err := os.Stat(rl.path)
+	if err != nil {
+		return fmt.Errorf("reading rate limit config file: %w", err)
+	}
+	cfg, err := loadRateLimitConfig(rl.path)
+	if err != nil {
+		return err
+	}
+
+	rl.lk.Lock()
+	defer rl.lk.Unlock()
+
+	rl.cfg = cfg
+	rl.modTime = fi.ModTime()
+	if rl.states == nil {
+		rl.states = make(map[string]*limitState)
+	}
+	return nil
+}
+
+func (rl *RateLimiter) removeIdle() {
+	rl.lk.Lock()
+	defer rl.lk.Unlock()
+
+	for k, st := range rl.states {
+		if st.active == 0 && time.Since(st.lastSeen) > rateLimitIdleTimeout {
+			delete(rl.states, k)
+		}
+	}
+}
+
+// clientIP returns the IP address of the client that made the request
+func clientIP(r *http.Request, trustForwardedFor bool) net.IP {
+	if trustForwardedFor {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			first, _, _ := strings.Cut(fwd, ",")
+			if ip := net.ParseIP(strings.TrimSpace(first)); ip != nil {
+				return ip
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// limitsFor returns the limiter state for each limit that applies to the
+// request. Must be called with the lock held.
+func (rl *RateLimiter) limitsFor(r *http.Request) []*limitState {
+	cfg := rl.cfg
+	var states []*limitState
+	add := func(key string, l Limit) {
+		if l.isZero() {
+			return
+		}
+		st, ok := rl.states[key]
+		if !ok {
+			st = newLimitState(l)
+			rl.states[key] = st
+		} else if st.limit != l {
+			// The config has been reloaded with a new limit
+			st.setLimit(l)
+		}
+		st.lastSeen = time.Now()
+		states = append(states, st)
+	}
+
+	if ip := clientIP(r, cfg.TrustForwardedFor); ip != nil {
+		add("ip:"+ip.String(), cfg.PerIP)
+		for i, ipnet := range cfg.ranges {
+			if ipnet.Contains(ip) {
+				add("range:"+ipnet.String(), cfg.Ranges[i].Limit)
+			}
+		}
+	}
+
+	if id := authIdentityFromContext(r.Context()); id != nil {
+		l, ok := cfg.APIKeys[id.Group]
+		if !ok {
+			l = cfg.PerAPIKey
+		}
+		add("key:"+id.ID, l)
+	}
+
+	return states
+}
+
+// begin checks that the request is within all the limits that apply to it,
+// and returns the bandwidth limiters for the response.
+// If it is not, it returns the duration after which the client should retry.
+func (rl *RateLimiter) begin(r *http.Request) ([]*limitState, []*rate.Limiter, time.Duration, error) {
+	rl.lk.Lock()
+	defer rl.lk.Unlock()
+
+	states := rl.limitsFor(r)
+	for _, st := range states {
+		if st.limit.ConcurrentStreams > 0 && st.active >= st.limit.ConcurrentStreams {
+			return nil, nil, time.Second, fmt.Errorf("limit of %d concurrent streams reached", st.limit.ConcurrentStreams)
+		}
+	}
+
+	now := time.Now()
+	var reservations []*rate.Reservation
+	cancel := func() {
+		for _, res := range reservations {
+			res.CancelAt(now)
+		}
+	}
+	for _, st := range states {
+		if st.requests == nil {
+			continue
+		}
+		res := st.requests.ReserveN(now, 1)
+		if !res.OK() {
+			cancel()
+			return nil, nil, time.Second, fmt.Errorf("limit of %g requests per second reached", st.limit.RequestsPerSecond)
+		}
+		reservations = append(reservations, res)
+		if delay := res.DelayFrom(now); delay > 0 {
+			cancel()
+			return nil, nil, delay, fmt.Errorf("limit of %g requests per second reached", st.limit.RequestsPerSecond)
+		}
+	}
+
+	// The limiters are collected while holding the lock, because a config
+	// reload may replace them
+	var bandwidth []*rate.Limiter
+	for _, st := range states {
+		st.active++
+		if st.bytes != nil {
+			bandwidth = append(bandwidth, st.bytes)
+		}
+	}
+	return states, bandwidth, 0, nil
+}
+
+func (rl *RateLimiter) end(states []*limitState) {
+	rl.lk.Lock()
+	defer rl.lk.Unlock()
+
+	for _, st := range states {
+		st.active--
+	}
+}
+
+// handler wraps the given handler so that requests are rejected with
+// 429 Too Many Requests when a limit is exceeded, and the response is
+// throttled to the bandwidth limit
+func (rl *RateLimiter) handler(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		states, bandwidth, retryAfter, err := rl.begin(r)
+		if err != nil {
+			stats.Record(r.Context(), metrics.HttpRateLimitedCount.M(1))
+			secs := int(math.Ceil(retryAfter.Seconds()))
+			if secs < 1 {
+				secs = 1
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(secs))
+			writeError(w, r, http.StatusTooManyRequests, err)
+			return
+		}
+		defer rl.end(states)
+
+		if len(bandwidth) > 0 {
+			w = &throttledResponseWriter{ResponseWriter: w, ctx: r.Context(), limiters: bandwidth}
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+// throttledResponseWriter limits the rate at which bytes are written to the
+// response
+type throttledResponseWriter struct {
+	http.ResponseWriter
+	ctx      context.Context
+	limiters []*rate.Limiter
+}
+
+func (w *throttledResponseWriter) Write(bz []byte) (int, error) {
+	var written int
+	for len(bz) > 0 {
+		n := len(bz)
+		if n > throttledWriteSize {
+			n = throttledWriteSize
+		}
+		for _, lim := range w.limiters {
+			if err := lim.WaitN(w.ctx, n); err != nil {
+				return written, err
+			}
+		}
+
+		count, err := w.ResponseWriter.Write(bz[:n])
+		written += count
+		if err != nil {
+			return written, err
+		}
+		bz = bz[n:]
+	}
+	return written, nil
+}
+
+func (w *throttledResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
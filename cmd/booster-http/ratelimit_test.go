@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiter(t *testing.T) {
+	cfgPath := filepath.Join(t.TempDir(), "ratelimit.json")
+	writeCfg := func(cfg RateLimitConfig) {
+		bz, err := json.Marshal(cfg)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(cfgPath, bz, 0o644))
+	}
+
+	writeCfg(RateLimitConfig{
+		PerIP: Limit{RequestsPerSecond: 0.001, Burst: 2},
+		Ranges: []RangeLimit{{
+			CIDR:  "10.0.0.0/8",
+			Limit: Limit{ConcurrentStreams: 1},
+		}},
+		APIKeys: map[string]Limit{"partner": {RequestsPerSecond: 0.001, Burst: 1}},
+	})
+	rl, err := NewRateLimiter(cfgPath)
+	require.NoError(t, err)
+
+	block := make(chan struct{})
+	handler := rl.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/block" {
+			<-block
+		}
+		_, _ = w.Write([]byte("data"))
+	}))
+
+	get := func(path string, remoteAddr string, id *authIdentity) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = remoteAddr
+		if id != nil {
+			req = req.WithContext(context.WithValue(req.Context(), authIdentityKey{}, id))
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	// The per IP limit allows a burst of two requests
+	require.Equal(t, http.StatusOK, get("/", "1.2.3.4:1000", nil).Code)
+	require.Equal(t, http.StatusOK, get("/", "1.2.3.4:1001", nil).Code)
+	rec := get("/", "1.2.3.4:1002", nil)
+	require.Equal(t, http.StatusTooManyRequests, rec.Code)
+	require.NotEmpty(t, rec.Header().Get("Retry-After"))
+
+	// Other clients should not be affected
+	require.Equal(t, http.StatusOK, get("/", "5.6.7.8:1000", nil).Code)
+
+	// The API key limit applies across client IPs
+	id := &authIdentity{ID: "partner", Group: "partner"}
+	require.Equal(t, http.StatusOK, get("/", "5.6.7.9:1000", id).Code)
+	require.Equal(t, http.StatusTooManyRequests, get("/", "5.6.7.10:1000", id).Code)
+
+	// Only one request at a time is allowed from the 10.0.0.0/8 range
+	done := make(chan int)
+	go func() {
+		done <- get("/block", "10.0.0.1:1000", nil).Code
+	}()
+	require.Eventually(t, func() bool {
+		rl.lk.Lock()
+		defer rl.lk.Unlock()
+		st, ok := rl.states["range:10.0.0.0/8"]
+		return ok && st.active == 1
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, http.StatusTooManyRequests, get("/", "10.0.0.2:1000", nil).Code)
+
+	// Reloading the config should keep the count of active streams
+	writeCfg(RateLimitConfig{
+		PerIP: Limit{RequestsPerSecond: 0.001, Burst: 2},
+		Ranges: []RangeLimit{{
+			CIDR:  "10.0.0.0/8",
+			Limit: Limit{ConcurrentStreams: 1, RequestsPerSecond: 100},
+		}},
+		APIKeys: map[string]Limit{"partner": {RequestsPerSecond: 0.001, Burst: 1}},
+	})
+	require.NoError(t, rl.reload())
+	require.Equal(t, http.StatusTooManyRequests, get("/", "10.0.0.2:1000", nil).Code)
+
+	// The API key should still be limited by the requests it already made
+	require.Equal(t, http.StatusTooManyRequests, get("/", "5.6.7.10:1000", id).Code)
+	close(block)
+	require.Equal(t, http.StatusOK, <-done)
+	require.Equal(t, http.StatusOK, get("/", "10.0.0.2:1000", nil).Code)
+
+	// Reloading the config should apply the new limits
+	writeCfg(RateLimitConfig{})
+	require.NoError(t, rl.reload())
+	for i := 0; i < 5; i++ {
+		require.Equal(t, http.StatusOK, get("/", "1.2.3.4:1000", nil).Code)
+	}
+}
+
+func TestThrottledResponseWriter(t *testing.T) {
+	cfgPath := filepath.Join(t.TempDir(), "ratelimit.json")
+	bz, err := json.Marshal(RateLimitConfig{PerIP: Limit{BytesPerSecond: 1 << 20}})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(cfgPath, bz, 0o644))
+	rl, err := NewRateLimiter(cfgPath)
+	require.NoError(t, err)
+
+	data := make([]byte, 3*throttledWriteSize+10)
+	handler := rl.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, ok := w.(*throttledResponseWriter)
+		require.True(t, ok)
+		n, err := w.Write(data)
+		require.NoError(t, err)
+		require.Equal(t, len(data), n)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Len(t, rec.Body.Bytes(), len(data))
+}
@@ -3,14 +3,20 @@ package main
 import (
 	"compress/gzip"
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
+	"path/filepath"
 	"time"
 
+	"github.com/docker/go-units"
+	"github.com/filecoin-project/boost/api"
 	"github.com/filecoin-project/boost/build"
+	"github.com/filecoin-project/boost/cmd/booster-http/accesslog"
 	"github.com/filecoin-project/boost/cmd/lib"
 	"github.com/filecoin-project/boost/cmd/lib/filters"
 	"github.com/filecoin-project/boost/cmd/lib/remoteblockstore"
@@ -20,11 +26,16 @@ import (
 	"github.com/filecoin-project/boost/metrics"
 	"github.com/filecoin-project/boost/node/config"
 	"github.com/filecoin-project/boost/piecedirectory"
+	smtypes "github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/boost/transport/types"
 	"github.com/filecoin-project/dagstore/mount"
 	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-jsonrpc"
 	"github.com/filecoin-project/go-state-types/abi"
 	lcli "github.com/filecoin-project/lotus/cli"
+	"github.com/ipfs/boxo/blockstore"
 	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/mitchellh/go-homedir"
 	"github.com/urfave/cli/v2"
 	"go.opencensus.io/stats"
@@ -33,7 +44,8 @@ import (
 )
 
 const (
-	trustlessMessage = "booster-http only supports trustless HTTP. For " +
+	trustlessMessage = "booster-http serves trustless HTTP by default. To serve " +
+		"files by path, run booster-http with --serve-files. Alternatively, for " +
 		"trusted HTTP use https://github.com/ipfs/bifrost-gateway to translate " +
 		"trustless responses. Run bifrost-gateway with the environment variable " +
 		"PROXY_GATEWAY_URL=http://localhost:7777 to point to booster-http, and " +
@@ -71,9 +83,9 @@ var runCmd = &cli.Command{
 			Usage: "the port the web server listens on",
 			Value: 7777,
 		},
-		&cli.StringFlag{
+		&cli.StringSliceFlag{
 			Name:     "api-lid",
-			Usage:    "the endpoint for the local index directory API, eg 'http://localhost:8042'",
+			Usage:    "the endpoint for the local index directory API, eg 'http://localhost:8042'. Set the flag once for each service, with the same connect strings as the LocalIndexDirectory.ServiceApiInfoShards config, to connect to a sharded local index directory",
 			Required: true,
 		},
 		&cli.IntFlag{
@@ -96,6 +108,11 @@ var runCmd = &cli.Command{
 			Usage:    "the endpoint for the storage node API",
 			Required: true,
 		},
+		&cli.StringFlag{
+			Name: "api-boost",
+			Usage: "the endpoint for the boost API, used to look up the aggregate piece that contains a sub-piece; " +
+				"if not set, sub-pieces of aggregate pieces cannot be retrieved by sub-piece CID",
+		},
 		&cli.BoolFlag{
 			Name:  "serve-pieces",
 			Usage: "enables serving raw pieces",
@@ -118,15 +135,10 @@ var runCmd = &cli.Command{
 			Value: true,
 		},
 		&cli.BoolFlag{
-			Name:   "serve-files",
-			Usage:  "(removed option)",
-			Hidden: true,
-			Action: func(ctx *cli.Context, _ bool) error {
-				if _, err := fmt.Fprintf(ctx.App.ErrWriter, "--serve-files is no longer supported.\n\n%s\n\n", trustlessMessage); err != nil {
-					return err
-				}
-				return errors.New("--serve-files is no longer supported, use bifrost-gateway instead")
-			},
+			Name: "serve-files",
+			Usage: "serve UnixFS files and directory listings by path (eg /ipfs/<root cid>/path/to/file.jpg); " +
+				"requests for CAR files and raw blocks are still served by the Trustless IPFS Gateway API",
+			Value: false,
 		},
 		&cli.IntFlag{
 			Name: "compression-level",
@@ -140,6 +152,26 @@ var runCmd = &cli.Command{
 			Usage: "path to file to append HTTP request and error logs to, defaults to stdout (-)",
 			Value: "-",
 		},
+		&cli.StringFlag{
+			Name: "access-log-dir",
+			Usage: "directory to write a structured (JSON) access log to, with an entry for each request; " +
+				"summarise it with the access-log-stats command",
+		},
+		&cli.StringFlag{
+			Name:  "access-log-max-size",
+			Usage: "the size at which the access log file is rotated (eg 100MiB)",
+			Value: "100MiB",
+		},
+		&cli.IntFlag{
+			Name:  "access-log-max-files",
+			Usage: "the maximum number of access log files to keep, including the current file",
+			Value: 30,
+		},
+		&cli.DurationFlag{
+			Name:  "access-log-max-age",
+			Usage: "rotated access log files older than this are removed (0 to keep files of any age)",
+			Value: 30 * 24 * time.Hour,
+		},
 		&cli.BoolFlag{
 			Name:  "tracing",
 			Usage: "enables tracing of booster-http calls",
@@ -158,6 +190,80 @@ var runCmd = &cli.Command{
 			Name:  "api-filter-auth",
 			Usage: "value to pass in the authorization header when sending a request to the API filter endpoint (e.g. 'Basic ~base64 encoded user/pass~'",
 		},
+		&cli.StringFlag{
+			Name: "auth-config",
+			Usage: "path to a JSON file with the API keys and bearer token issuers that may retrieve data, " +
+				"and the quota for each; if set, requests for pieces and blocks must be authenticated",
+		},
+		&cli.StringFlag{
+			Name: "rate-limit-config",
+			Usage: "path to a JSON file with the rate limits for each client IP, IP range and API key; " +
+				"the file is reloaded when it changes",
+		},
+		&cli.StringFlag{
+			Name: "pricing-config",
+			Usage: "path to a JSON file with the price of retrievals for each client class and piece, and the prepaid credit for each API key; " +
+				"if set, clients pay for retrievals from their balance, and may top it up with payment vouchers (requires --auth-config); " +
+				"the file is reloaded when it changes",
+		},
+		&cli.StringFlag{
+			Name: "geo-policy",
+			Usage: "path to a JSON file with rules that allow, deny or cap the bandwidth of retrievals by client IP range, country and ASN, " +
+				"and the MaxMind-format databases used to look up client locations; the file and databases are reloaded when they change",
+		},
+		&cli.StringFlag{
+			Name:  "tls-cert-file",
+			Usage: "path to the TLS certificate file; if set, booster-http serves HTTPS instead of HTTP (the certificate is reloaded when the file changes)",
+		},
+		&cli.StringFlag{
+			Name:  "tls-key-file",
+			Usage: "path to the TLS private key file",
+		},
+		&cli.BoolFlag{
+			Name:  "tls-self-signed",
+			Usage: "serve HTTPS with a self-signed certificate generated at startup (for testing only)",
+		},
+		&cli.BoolFlag{
+			Name:  "http3",
+			Usage: "also serve HTTP/3 (QUIC) on the same port over UDP; requires TLS",
+		},
+		&cli.BoolFlag{
+			Name: "libp2p",
+			Usage: "also serve HTTP over libp2p, for clients that can reach the libp2p host but not the web server; " +
+				"the libp2p peer key is stored in the repo directory",
+		},
+		&cli.IntFlag{
+			Name:  "libp2p-port",
+			Usage: "the port the libp2p host listens on (0 for a random port)",
+		},
+		&cli.StringFlag{
+			Name: "libp2p-proxy",
+			Usage: "the multiaddr of the libp2p proxy (eg boostd) that libp2p HTTP requests are received through, " +
+				"eg /ip4/127.0.0.1/tcp/24001/p2p/12D3KooW...",
+		},
+		&cli.StringFlag{
+			Name:  "piece-cache-dir",
+			Usage: "directory in which to cache frequently requested piece data, in a 'chunks' subdirectory that is cleared on startup; if not set, the piece cache is disabled",
+		},
+		&cli.StringFlag{
+			Name:  "piece-cache-size",
+			Usage: "the maximum size of the piece cache (eg 100GiB)",
+			Value: "100GiB",
+		},
+		&cli.StringFlag{
+			Name:  "block-cache-size",
+			Usage: "the maximum size of the in-memory cache of frequently requested blocks (eg 1GiB); if not set, the block cache is disabled",
+		},
+		&cli.StringFlag{
+			Name:  "block-cache-max-block-size",
+			Usage: "blocks larger than this size are not cached in the block cache",
+			Value: "128KiB",
+		},
+		&cli.IntFlag{
+			Name:  "cache-admit-after",
+			Usage: "the number of times a piece chunk or block must be requested before it is cached",
+			Value: 2,
+		},
 		&cli.StringSliceFlag{
 			Name:  "badbits-denylists",
 			Usage: "the endpoints for fetching one or more custom BadBits list instead of the default one at https://badbits.dwebops.pub/denylist.json",
@@ -177,9 +283,10 @@ var runCmd = &cli.Command{
 	Action: func(cctx *cli.Context) error {
 		servePieces := cctx.Bool("serve-pieces")
 		serveTrustless := cctx.Bool("serve-cars")
+		serveFiles := cctx.Bool("serve-files")
 
-		if !servePieces && !serveTrustless {
-			return errors.New("one of --serve-pieces, --serve-cars must be enabled")
+		if !servePieces && !serveTrustless && !serveFiles {
+			return errors.New("one of --serve-pieces, --serve-cars, --serve-files must be enabled")
 		}
 
 		if cctx.Bool("pprof") {
@@ -214,7 +321,7 @@ var runCmd = &cli.Command{
 		// Connect to the local index directory service
 		cl := bdclient.NewStore()
 		defer cl.Close(ctx)
-		err := cl.Dial(ctx, cctx.String("api-lid"))
+		err := cl.DialShards(ctx, cctx.StringSlice("api-lid"))
 		if err != nil {
 			return fmt.Errorf("connecting to local index directory service: %w", err)
 		}
@@ -234,6 +341,17 @@ var runCmd = &cli.Command{
 			}
 		}
 
+		// Connect to the boost API
+		var boostApi api.Boost
+		if boostApiInfo := cctx.String("api-boost"); boostApiInfo != "" {
+			var bcloser jsonrpc.ClientCloser
+			boostApi, bcloser, err = lib.GetBoostApi(ctx, boostApiInfo, log)
+			if err != nil {
+				return fmt.Errorf("getting boost API: %w", err)
+			}
+			defer bcloser()
+		}
+
 		// Instantiate the tracer and exporter
 		enableTracing := cctx.Bool("tracing")
 		var tracingStopper func(context.Context) error
@@ -260,10 +378,11 @@ var runCmd = &cli.Command{
 		opts := &HttpServerOptions{
 			ServePieces:      servePieces,
 			ServeTrustless:   serveTrustless,
+			ServeFiles:       serveFiles,
 			CompressionLevel: cctx.Int("compression-level"),
 		}
 
-		if serveTrustless {
+		if serveTrustless || serveFiles {
 			repoDir, err := createRepoDir(cctx.String(FlagRepo.Name))
 			if err != nil {
 				return err
@@ -289,10 +408,124 @@ var runCmd = &cli.Command{
 				GetSizeSuccessResponseCount: metrics.HttpRblsGetSizeSuccessResponseCount,
 			}
 			rbs := remoteblockstore.NewRemoteBlockstore(pd, &httpBlockMetrics)
-			filtered := filters.NewFilteredBlockstore(rbs, multiFilter)
+			var bs blockstore.Blockstore = rbs
+			if blockCacheSize := cctx.String("block-cache-size"); blockCacheSize != "" {
+				maxBytes, err := units.RAMInBytes(blockCacheSize)
+				if err != nil {
+					return fmt.Errorf("parsing block-cache-size '%s': %w", blockCacheSize, err)
+				}
+				maxBlockSize, err := units.RAMInBytes(cctx.String("block-cache-max-block-size"))
+				if err != nil {
+					return fmt.Errorf("parsing block-cache-max-block-size '%s': %w", cctx.String("block-cache-max-block-size"), err)
+				}
+				opts.BlockCache = NewCachingBlockstore(rbs, pd.PiecesContainingMultihash, maxBytes, int(maxBlockSize), cctx.Int("cache-admit-after"))
+				bs = opts.BlockCache
+				log.Infow("block cache enabled", "size", blockCacheSize)
+			}
+
+			// The filter is applied in front of the cache, so that blocks that
+			// are cached are still filtered
+			filtered := filters.NewFilteredBlockstore(bs, multiFilter)
 			opts.Blockstore = filtered
 		}
 
+		if pieceCacheDir := cctx.String("piece-cache-dir"); pieceCacheDir != "" {
+			maxBytes, err := units.RAMInBytes(cctx.String("piece-cache-size"))
+			if err != nil {
+				return fmt.Errorf("parsing piece-cache-size '%s': %w", cctx.String("piece-cache-size"), err)
+			}
+			opts.PieceCache, err = NewPieceCache(pieceCacheDir, maxBytes, cctx.Int("cache-admit-after"))
+			if err != nil {
+				return fmt.Errorf("creating piece cache: %w", err)
+			}
+			log.Infow("piece cache enabled", "dir", pieceCacheDir, "size", cctx.String("piece-cache-size"))
+		}
+
+		if authCfgPath := cctx.String("auth-config"); authCfgPath != "" {
+			authCfg, err := loadAuthConfig(authCfgPath)
+			if err != nil {
+				return err
+			}
+			opts.Auth, err = NewAuthenticator(*authCfg)
+			if err != nil {
+				return fmt.Errorf("creating authenticator: %w", err)
+			}
+			log.Infow("authentication enabled", "keys", len(authCfg.Keys), "issuers", len(authCfg.Issuers))
+		}
+
+		if rlCfgPath := cctx.String("rate-limit-config"); rlCfgPath != "" {
+			opts.RateLimiter, err = NewRateLimiter(rlCfgPath)
+			if err != nil {
+				return fmt.Errorf("creating rate limiter: %w", err)
+			}
+			opts.RateLimiter.Start(ctx)
+			log.Infow("rate limiting enabled", "config", rlCfgPath)
+		}
+
+		if pricingCfgPath := cctx.String("pricing-config"); pricingCfgPath != "" {
+			if opts.Auth == nil {
+				return errors.New("--pricing-config requires --auth-config")
+			}
+			repoDir, err := createRepoDir(cctx.String(FlagRepo.Name))
+			if err != nil {
+				return err
+			}
+			// Payment vouchers are added to the full node, which must have
+			// the wallet that the payment channels pay to
+			opts.Pricer, err = NewPricer(pricingCfgPath, filepath.Join(repoDir, "payments.json"), fullnodeApi, pd.PiecesContainingMultihash, subPieceLookup(boostApi))
+			if err != nil {
+				return fmt.Errorf("creating pricer: %w", err)
+			}
+			defer opts.Pricer.Close() //nolint:errcheck
+			opts.Pricer.Start(ctx)
+			log.Infow("paid retrievals enabled", "config", pricingCfgPath)
+		}
+
+		if geoPolicyPath := cctx.String("geo-policy"); geoPolicyPath != "" {
+			// Rules that apply to particular pieces are applied to requests
+			// for blocks using the pieces that contain the block, and to
+			// requests for sub-pieces using the aggregate pieces that
+			// contain the sub-piece
+			opts.GeoFilter, err = filters.NewGeoFilter(geoPolicyPath, pd.PiecesContainingMultihash, subPieceLookup(boostApi))
+			if err != nil {
+				return fmt.Errorf("creating geo filter: %w", err)
+			}
+			defer opts.GeoFilter.Close()
+			opts.GeoFilter.Start(ctx)
+			log.Infow("geo policy enabled", "config", geoPolicyPath)
+		}
+
+		opts.TLSConfig, err = tlsConfigFromFlags(ctx, cctx)
+		if err != nil {
+			return err
+		}
+		opts.HTTP3 = cctx.Bool("http3")
+		if opts.HTTP3 && opts.TLSConfig == nil {
+			return errors.New("--http3 requires --tls-cert-file and --tls-key-file, or --tls-self-signed")
+		}
+
+		if cctx.Bool("libp2p") {
+			repoDir, err := createRepoDir(cctx.String(FlagRepo.Name))
+			if err != nil {
+				return err
+			}
+			opts.Libp2pHost, err = setupHost(repoDir, cctx.Int("libp2p-port"))
+			if err != nil {
+				return fmt.Errorf("setting up libp2p host: %w", err)
+			}
+			defer opts.Libp2pHost.Close() //nolint:errcheck
+
+			if cctx.IsSet("libp2p-proxy") {
+				proxy := cctx.String("libp2p-proxy")
+				opts.Libp2pProxy, err = peer.AddrInfoFromString(proxy)
+				if err != nil {
+					return fmt.Errorf("parsing libp2p proxy multiaddr %s: %w", proxy, err)
+				}
+			}
+		} else if cctx.IsSet("libp2p-proxy") {
+			return errors.New("--libp2p-proxy requires --libp2p")
+		}
+
 		switch cctx.String("log-file") {
 		case "":
 		case "-":
@@ -304,7 +537,20 @@ var runCmd = &cli.Command{
 			}
 		}
 
-		sapi := serverApi{ctx: ctx, piecedirectory: pd, sa: sa}
+		if accessLogDir := cctx.String("access-log-dir"); accessLogDir != "" {
+			maxSize, err := units.RAMInBytes(cctx.String("access-log-max-size"))
+			if err != nil {
+				return fmt.Errorf("parsing access-log-max-size '%s': %w", cctx.String("access-log-max-size"), err)
+			}
+			opts.AccessLog, err = accesslog.NewWriter(accessLogDir, maxSize, cctx.Int("access-log-max-files"), cctx.Duration("access-log-max-age"))
+			if err != nil {
+				return fmt.Errorf("creating access log: %w", err)
+			}
+			defer opts.AccessLog.Close() //nolint:errcheck
+			log.Infow("writing access log", "dir", accessLogDir)
+		}
+
+		sapi := serverApi{ctx: ctx, piecedirectory: pd, sa: sa, boostApi: boostApi}
 		server := NewHttpServer(
 			cctx.String("base-path"),
 			cctx.String("address"),
@@ -317,12 +563,28 @@ var runCmd = &cli.Command{
 		pd.Start(ctx)
 
 		// Start the server
-		log.Infof("Starting booster-http node on listen address %s and port %d with base path '%s'",
-			cctx.String("address"), cctx.Int("port"), cctx.String("base-path"))
+		scheme := "http"
+		if opts.TLSConfig != nil {
+			scheme = "https"
+		}
+		log.Infof("Starting booster-http node (%s) on listen address %s and port %d with base path '%s'",
+			scheme, cctx.String("address"), cctx.Int("port"), cctx.String("base-path"))
+		if opts.HTTP3 {
+			log.Infof("serving HTTP/3 on UDP port %d", cctx.Int("port"))
+		}
 		err = server.Start(ctx)
 		if err != nil {
 			return fmt.Errorf("starting http server: %w", err)
 		}
+		if h := opts.Libp2pHost; h != nil {
+			log.Infow("serving HTTP over libp2p", "protocol", types.Libp2pHttpRetrievalProtocol, "peerId", h.ID(), "multiaddrs", h.Addrs())
+			if opts.Libp2pProxy != nil {
+				log.Infow("with proxy", "multiaddrs", opts.Libp2pProxy.Addrs, "peerId", opts.Libp2pProxy.ID)
+			} else {
+				log.Infof("to serve libp2p HTTP through boostd, set Retrievals.HTTP.HTTPRetrievalLibp2pPeerID to %s "+
+					"in the boostd config and run booster-http with --libp2p-proxy", h.ID())
+			}
+		}
 
 		if servePieces {
 			log.Infof("serving raw pieces at " + server.pieceBasePath())
@@ -334,6 +596,9 @@ var runCmd = &cli.Command{
 		} else {
 			log.Infof("serving IPFS Trustless Gateway CARs is disabled")
 		}
+		if serveFiles {
+			log.Infof("serving files at " + server.ipfsBasePath())
+		}
 
 		// Monitor for shutdown.
 		<-ctx.Done()
@@ -375,6 +640,7 @@ type serverApi struct {
 	ctx            context.Context
 	piecedirectory *piecedirectory.PieceDirectory
 	sa             *lib.MultiMinerAccessor
+	boostApi       api.Boost
 }
 
 var _ HttpServerApi = (*serverApi)(nil)
@@ -390,3 +656,69 @@ func (s serverApi) IsUnsealed(ctx context.Context, minerAddr address.Address, se
 func (s serverApi) UnsealSectorAt(ctx context.Context, minerAddr address.Address, sectorID abi.SectorNumber, offset abi.UnpaddedPieceSize, length abi.UnpaddedPieceSize) (mount.Reader, error) {
 	return s.sa.UnsealSectorAt(ctx, minerAddr, sectorID, offset, length)
 }
+
+func (s serverApi) GetSubPieceDeals(ctx context.Context, subPieceCid cid.Cid) ([]smtypes.DealSubPiece, error) {
+	if s.boostApi == nil {
+		return nil, nil
+	}
+	return s.boostApi.BoostSubPieceDeals(ctx, subPieceCid)
+}
+
+// subPieceLookup returns a function that gets the aggregate pieces that
+// contain a sub-piece from boost. If there is no boost API the function
+// returns no pieces.
+func subPieceLookup(boostApi api.Boost) filters.SubPieceLookup {
+	return func(ctx context.Context, subPieceCid cid.Cid) ([]cid.Cid, error) {
+		if boostApi == nil {
+			return nil, nil
+		}
+		subPieces, err := boostApi.BoostSubPieceDeals(ctx, subPieceCid)
+		if err != nil {
+			return nil, err
+		}
+		pieces := make([]cid.Cid, 0, len(subPieces))
+		for _, sp := range subPieces {
+			pieces = append(pieces, sp.PieceCID)
+		}
+		return pieces, nil
+	}
+}
+
+// tlsConfigFromFlags returns the TLS config for the server, or nil if TLS is
+// not enabled
+func tlsConfigFromFlags(ctx context.Context, cctx *cli.Context) (*tls.Config, error) {
+	certFile := cctx.String("tls-cert-file")
+	keyFile := cctx.String("tls-key-file")
+	selfSigned := cctx.Bool("tls-self-signed")
+
+	switch {
+	case certFile != "" || keyFile != "":
+		if certFile == "" || keyFile == "" {
+			return nil, errors.New("both --tls-cert-file and --tls-key-file must be set")
+		}
+		if selfSigned {
+			return nil, errors.New("--tls-self-signed cannot be used with --tls-cert-file")
+		}
+		cr, err := newCertReloader(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		cr.Start(ctx)
+		log.Infow("TLS enabled", "cert", certFile)
+		return newTLSConfig(cr.getCertificate), nil
+
+	case selfSigned:
+		hosts := []string{"localhost", "127.0.0.1", "::1"}
+		if ip := net.ParseIP(cctx.String("address")); ip == nil || !ip.IsUnspecified() {
+			hosts = append(hosts, cctx.String("address"))
+		}
+		cert, err := selfSignedCertificate(hosts)
+		if err != nil {
+			return nil, fmt.Errorf("generating self-signed certificate: %w", err)
+		}
+		log.Warnw("TLS enabled with a self-signed certificate: clients will not trust it unless configured to", "hosts", hosts)
+		return newTLSConfig(func(*tls.ClientHelloInfo) (*tls.Certificate, error) { return cert, nil }), nil
+	}
+
+	return nil, nil
+}
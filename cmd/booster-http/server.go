@@ -3,23 +3,31 @@ package main
 import (
 	"compress/gzip"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/filecoin-project/boost-graphsync/storeutil"
+	"github.com/filecoin-project/boost/cmd/booster-http/accesslog"
+	"github.com/filecoin-project/boost/cmd/lib/filters"
 	"github.com/filecoin-project/boost/extern/boostd-data/model"
 	"github.com/filecoin-project/boost/metrics"
+	smtypes "github.com/filecoin-project/boost/storagemarket/types"
 	"github.com/filecoin-project/dagstore/mount"
 	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/ipfs/boxo/blockstore"
 	"github.com/ipfs/go-cid"
 	"github.com/ipld/frisbii"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/quic-go/quic-go/http3"
 	"github.com/rs/cors"
 )
 
@@ -34,6 +42,8 @@ var lastModified = time.UnixMilli(1)
 
 type apiVersion struct {
 	Version string `json:"Version"`
+	// The usage of the API key that the request was made with, if any
+	Usage *KeyUsage `json:"Usage,omitempty"`
 }
 
 type HttpServer struct {
@@ -44,31 +54,73 @@ type HttpServer struct {
 	opts       HttpServerOptions
 	idxPage    string
 
-	ctx    context.Context
-	cancel context.CancelFunc
-	server *http.Server
+	ctx            context.Context
+	cancel         context.CancelFunc
+	server         *http.Server
+	h3server       *http3.Server
+	libp2pServer   *http.Server
+	libp2pListener net.Listener
+
+	// the location of the CAR file for each piece and payload root that has
+	// been requested
+	segmentsLk sync.Mutex
+	segments   *sizedLRU[segmentKey, segmentLocation]
+}
+
+// The maximum number of data segment locations that are cached
+const segmentCacheSize = 4096
+
+type segmentKey struct {
+	piece cid.Cid
+	root  cid.Cid
+}
+
+type segmentLocation struct {
+	offset uint64
+	length uint64
 }
 
 type HttpServerApi interface {
 	GetPieceDeals(ctx context.Context, pieceCID cid.Cid) ([]model.DealInfo, error)
 	IsUnsealed(ctx context.Context, minerAddr address.Address, sectorID abi.SectorNumber, offset abi.UnpaddedPieceSize, length abi.UnpaddedPieceSize) (bool, error)
 	UnsealSectorAt(ctx context.Context, minerAddr address.Address, sectorID abi.SectorNumber, pieceOffset abi.UnpaddedPieceSize, length abi.UnpaddedPieceSize) (mount.Reader, error)
+	GetSubPieceDeals(ctx context.Context, subPieceCid cid.Cid) ([]smtypes.DealSubPiece, error)
 }
 
 type HttpServerOptions struct {
 	Blockstore       blockstore.Blockstore
 	ServePieces      bool
 	ServeTrustless   bool
+	ServeFiles       bool // serve UnixFS files and directory listings from the blockstore
 	CompressionLevel int
 	LogWriter        io.Writer          // for a standardised log write format
 	LogHandler       frisbii.LogHandler // for more granular control over log output
+	AccessLog        *accesslog.Writer  // if set, a structured entry is written to the access log for each request
+	Auth             *Authenticator     // if set, requests for data must be authenticated
+	RateLimiter      *RateLimiter       // if set, requests for data are rate limited
+	Pricer           *Pricer            // if set, clients are charged for requests for data, requires Auth
+	GeoFilter        *filters.GeoFilter // if set, requests for data are allowed, denied or capped by client location
+	PieceCache       *PieceCache        // if set, frequently requested piece data is cached on disk
+	BlockCache       *CachingBlockstore // if set, frequently requested small blocks are cached in memory
+	TLSConfig        *tls.Config        // if set, the server serves HTTPS instead of HTTP
+	HTTP3            bool               // serve HTTP/3 (QUIC) on the same port, requires TLS
+	Libp2pHost       host.Host          // if set, the server also serves HTTP over libp2p on this host
+	Libp2pProxy      *peer.AddrInfo     // if set, libp2p HTTP requests are received through this proxy (eg boostd)
 }
 
 func NewHttpServer(path string, listenAddr string, port int, api HttpServerApi, opts *HttpServerOptions) *HttpServer {
 	if opts == nil {
 		opts = &HttpServerOptions{ServePieces: true, ServeTrustless: false, CompressionLevel: gzip.NoCompression}
 	}
-	return &HttpServer{path: path, listenAddr: listenAddr, port: port, api: api, opts: *opts, idxPage: parseTemplate(*opts)}
+	return &HttpServer{
+		path:       path,
+		listenAddr: listenAddr,
+		port:       port,
+		api:        api,
+		opts:       *opts,
+		idxPage:    parseTemplate(*opts),
+		segments:   newSizedLRU[segmentKey, segmentLocation](segmentCacheSize, nil),
+	}
 }
 
 func (s *HttpServer) pieceBasePath() string {
@@ -79,6 +131,10 @@ func (s *HttpServer) ipfsBasePath() string {
 	return s.path + "/ipfs/"
 }
 
+func (s *HttpServer) quoteBasePath() string {
+	return s.path + "/quote/"
+}
+
 func newCors() *cors.Cors {
 	options := cors.Options{
 		AllowedHeaders: []string{"*"},
@@ -88,38 +144,79 @@ func newCors() *cors.Cors {
 }
 
 func (s *HttpServer) Start(ctx context.Context) error {
-	if !s.opts.ServePieces && !s.opts.ServeTrustless {
+	if !s.opts.ServePieces && !s.opts.ServeTrustless && !s.opts.ServeFiles {
 		return errors.New("no content to serve")
 	}
+	if s.opts.Pricer != nil && s.opts.Auth == nil {
+		return errors.New("paid retrievals require authentication to be enabled")
+	}
 
 	s.ctx, s.cancel = context.WithCancel(ctx)
 	c := newCors()
 	handler := http.NewServeMux()
 
 	if s.opts.ServePieces {
-		handler.HandleFunc(s.pieceBasePath(), s.pieceHandler())
+		handler.HandleFunc(s.pieceBasePath(), s.withAccessControl(s.pieceHandler()))
 	}
 
-	if s.opts.ServeTrustless {
+	if s.opts.ServeTrustless || s.opts.ServeFiles {
 		if s.opts.Blockstore == nil {
 			return errors.New("no blockstore provided for trustless gateway")
 		}
-		lsys := storeutil.LinkSystemForBlockstore(s.opts.Blockstore)
-		handler.Handle(
-			s.ipfsBasePath(),
-			frisbii.NewHttpIpfs(ctx, lsys, frisbii.WithCompressionLevel(s.opts.CompressionLevel)),
-		)
+
+		// Requests for CAR files and raw blocks are served by the trustless
+		// gateway, and other requests are served as files
+		var trustless, files http.Handler
+		if s.opts.ServeTrustless {
+			lsys := storeutil.LinkSystemForBlockstore(s.opts.Blockstore)
+			trustless = frisbii.NewHttpIpfs(ctx, lsys, frisbii.WithCompressionLevel(s.opts.CompressionLevel))
+		}
+		if s.opts.ServeFiles {
+			files = newFileHandler(s.ipfsBasePath(), s.opts.Blockstore)
+		}
+		handler.Handle(s.ipfsBasePath(), s.withAccessControl(ipfsHandler(trustless, files)))
 	}
 
 	handler.HandleFunc("/", s.handleIndex)
 	handler.HandleFunc("/index.html", s.handleIndex)
 	handler.HandleFunc("/info", s.handleInfo)
+	if s.opts.Pricer != nil {
+		handler.HandleFunc(s.quoteBasePath(), s.opts.Auth.handler(http.HandlerFunc(s.handleQuote)))
+	}
 	handler.Handle("/metrics", metrics.Exporter("booster_http")) // metrics
+
+	if s.opts.HTTP3 && s.opts.TLSConfig == nil {
+		return errors.New("HTTP/3 requires TLS to be enabled")
+	}
+
+	addr := fmt.Sprintf("%s:%d", s.listenAddr, s.port)
+	var loggedHandler http.Handler = frisbii.NewLogMiddleware(handler, frisbii.WithLogWriter(s.opts.LogWriter), frisbii.WithLogHandler(s.opts.LogHandler))
+	if s.opts.AccessLog != nil {
+		loggedHandler = s.accessLogHandler(loggedHandler)
+	}
+	httpHandler := c.Handler(loggedHandler)
+	if s.opts.Libp2pHost != nil {
+		// Clients connecting over libp2p are served by the same handlers
+		if err := s.startLibp2p(httpHandler); err != nil {
+			return fmt.Errorf("starting libp2p http server: %w", err)
+		}
+	}
+
+	if s.opts.HTTP3 {
+		s.h3server = &http3.Server{
+			Addr:      addr,
+			Handler:   httpHandler,
+			TLSConfig: s.opts.TLSConfig,
+		}
+		// Tell clients connecting over TCP that they can use HTTP/3 on the
+		// same port
+		httpHandler = withAltSvc(httpHandler, s.port)
+	}
+
 	s.server = &http.Server{
-		Addr: fmt.Sprintf("%s:%d", s.listenAddr, s.port),
-		Handler: c.Handler(
-			frisbii.NewLogMiddleware(handler, frisbii.WithLogWriter(s.opts.LogWriter), frisbii.WithLogHandler(s.opts.LogHandler)),
-		),
+		Addr:      addr,
+		Handler:   httpHandler,
+		TLSConfig: s.opts.TLSConfig,
 		// This context will be the parent of the context associated with all
 		// incoming requests
 		BaseContext: func(listener net.Listener) context.Context {
@@ -127,17 +224,80 @@ func (s *HttpServer) Start(ctx context.Context) error {
 		},
 	}
 
+	if s.opts.PieceCache != nil || s.opts.BlockCache != nil {
+		go s.invalidateCachesLoop(s.ctx)
+	}
+
 	go func() {
-		if err := s.server.ListenAndServe(); err != http.ErrServerClosed {
+		var err error
+		if s.opts.TLSConfig != nil {
+			// The certificate is supplied by the TLS config
+			err = s.server.ListenAndServeTLS("", "")
+		} else {
+			err = s.server.ListenAndServe()
+		}
+		if err != http.ErrServerClosed {
 			log.Fatalf("http.ListenAndServe(): %v", err.Error())
 		}
 	}()
 
+	if s.h3server != nil {
+		go func() {
+			if err := s.h3server.ListenAndServe(); err != nil && err != http.ErrServerClosed && s.ctx.Err() == nil {
+				log.Fatalf("http3.ListenAndServe(): %v", err.Error())
+			}
+		}()
+	}
+
 	return nil
 }
 
+// withAltSvc sets the Alt-Svc header on responses to advertise that HTTP/3 is
+// available on the given UDP port
+func withAltSvc(h http.Handler, port int) http.Handler {
+	altSvc := fmt.Sprintf(`h3=":%d"; ma=2592000`, port)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor < 3 {
+			w.Header().Set("Alt-Svc", altSvc)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// withAccessControl applies the geo policy to requests to the handler, and
+// requires them to be authenticated, if authentication is enabled, and
+// applies rate limits and charges for the request, if configured
+func (s *HttpServer) withAccessControl(h http.Handler) http.HandlerFunc {
+	// Rate limits and prices are applied after authentication so that the
+	// limits and prices for the request's API key can be applied.
+	// Requests are only charged for once they are within the rate limits.
+	if s.opts.Pricer != nil {
+		h = s.opts.Pricer.handler(h, s.requestCid)
+	}
+	if s.opts.RateLimiter != nil {
+		h = s.opts.RateLimiter.handler(h)
+	}
+	if s.opts.Auth != nil {
+		h = s.opts.Auth.handler(h)
+	}
+	// The geo policy is applied first, so that requests from denied
+	// locations are not counted against quotas and rate limits
+	if s.opts.GeoFilter != nil {
+		h = s.geoHandler(h)
+	}
+	return h.ServeHTTP
+}
+
 func (s *HttpServer) Stop() error {
 	s.cancel()
+	if s.libp2pServer != nil {
+		s.stopLibp2p()
+	}
+	if s.h3server != nil {
+		if err := s.h3server.Close(); err != nil {
+			log.Warnw("closing HTTP/3 server", "err", err)
+		}
+	}
 	return s.server.Close()
 }
 
@@ -147,10 +307,20 @@ func (s *HttpServer) handleIndex(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *HttpServer) handleInfo(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
 	v := apiVersion{
 		Version: "0.3.0",
 	}
+	if s.opts.Auth != nil && r.Header.Get("Authorization") != "" {
+		usage, err := s.opts.Auth.usage(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="booster-http"`)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		v.Usage = usage
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(v) //nolint:errcheck
 }
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// How often to check if the certificate files have changed
+	certReloadInterval = 10 * time.Second
+	// How long a self-signed certificate is valid for
+	selfSignedCertValidity = 365 * 24 * time.Hour
+)
+
+// certReloader serves a TLS certificate loaded from files, and reloads the
+// certificate when the files change (eg when the certificate is renewed)
+type certReloader struct {
+	certPath string
+	keyPath  string
+
+	lk      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func newCertReloader(certPath string, keyPath string) (*certReloader, error) {
+	cr := &certReloader{certPath: certPath, keyPath: keyPath}
+	if err := cr.reload(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+func (cr *certReloader) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(certReloadInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			modTime, err := cr.filesModTime()
+			if err != nil {
+				log.Warnw("checking TLS certificate files", "cert", cr.certPath, "key", cr.keyPath, "err", err)
+				continue
+			}
+			if modTime.Equal(cr.getModTime()) {
+				continue
+			}
+			if err := cr.reload(); err != nil {
+				log.Errorw("reloading TLS certificate, keeping existing certificate", "cert", cr.certPath, "err", err)
+			} else {
+				log.Infow("reloaded TLS certificate", "cert", cr.certPath)
+			}
+		}
+	}()
+}
+
+// filesModTime returns the latest modification time of the certificate and
+// key files
+func (cr *certReloader) filesModTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{cr.certPath, cr.keyPath} {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (cr *certReloader) getModTime() time.Time {
+	cr.lk.RLock()
+	defer cr.lk.RUnlock()
+	return cr.modTime
+}
+
+func (cr *certReloader) reload() error {
+	modTime, err := cr.filesModTime()
+	if err != nil {
+		return fmt.Errorf("reading TLS certificate files: %w", err)
+	}
+	cert, err := tls.LoadX509KeyPair(cr.certPath, cr.keyPath)
+	if err != nil {
+		return fmt.Errorf("loading TLS certificate from %s and key from %s: %w", cr.certPath, cr.keyPath, err)
+	}
+
+	cr.lk.Lock()
+	defer cr.lk.Unlock()
+	cr.cert = &cert
+	cr.modTime = modTime
+	return nil
+}
+
+func (cr *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.lk.RLock()
+	defer cr.lk.RUnlock()
+	return cr.cert, nil
+}
+
+// newTLSConfig returns a TLS config that serves the certificate returned by
+// getCertificate
+func newTLSConfig(getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)) *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: getCertificate,
+	}
+}
+
+// selfSignedCertificate generates a self-signed certificate for the given
+// hosts (DNS names or IP addresses). It should only be used for testing.
+func selfSignedCertificate(hosts []string) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generating key: %w", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("generating serial number: %w", err)
+	}
+
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"booster-http self-signed"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedCertValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else if h != "" {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("creating certificate: %w", err)
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "key.pem")
+
+	writeCert := func(cert *tls.Certificate) {
+		certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+		keyDer, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+		require.NoError(t, err)
+		keyPem := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer})
+		require.NoError(t, os.WriteFile(certPath, certPem, 0o644))
+		require.NoError(t, os.WriteFile(keyPath, keyPem, 0o600))
+	}
+
+	cert1, err := selfSignedCertificate([]string{"localhost", "127.0.0.1"})
+	require.NoError(t, err)
+	writeCert(cert1)
+
+	cr, err := newCertReloader(certPath, keyPath)
+	require.NoError(t, err)
+	got, err := cr.getCertificate(nil)
+	require.NoError(t, err)
+	require.Equal(t, cert1.Certificate[0], got.Certificate[0])
+
+	x509Cert, err := x509.ParseCertificate(got.Certificate[0])
+	require.NoError(t, err)
+	require.NoError(t, x509Cert.VerifyHostname("localhost"))
+	require.NoError(t, x509Cert.VerifyHostname("127.0.0.1"))
+
+	// After the files are replaced, the new certificate should be served
+	cert2, err := selfSignedCertificate([]string{"localhost"})
+	require.NoError(t, err)
+	writeCert(cert2)
+	require.NoError(t, cr.reload())
+	got, err = cr.getCertificate(nil)
+	require.NoError(t, err)
+	require.Equal(t, cert2.Certificate[0], got.Certificate[0])
+
+	// If the new files are invalid, the existing certificate should be kept
+	require.NoError(t, os.WriteFile(keyPath, []byte("invalid"), 0o600))
+	require.Error(t, cr.reload())
+	got, err = cr.getCertificate(nil)
+	require.NoError(t, err)
+	require.Equal(t, cert2.Certificate[0], got.Certificate[0])
+}
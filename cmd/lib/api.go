@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/filecoin-project/boost/api"
+	bclient "github.com/filecoin-project/boost/api/client"
 	cliutil "github.com/filecoin-project/boost/cli/util"
 	"github.com/filecoin-project/boost/lib/sa"
 	"github.com/filecoin-project/boost/markets/sectoraccessor"
@@ -50,6 +51,23 @@ func GetFullNodeApi(ctx context.Context, ai string, log *logging.ZapEventLogger)
 	return fnapi, closer, nil
 }
 
+func GetBoostApi(ctx context.Context, ai string, log *logging.ZapEventLogger) (api.Boost, jsonrpc.ClientCloser, error) {
+	ai = strings.TrimPrefix(strings.TrimSpace(ai), "BOOST_API_INFO=")
+	info := cliutil.ParseApiInfo(ai)
+	addr, err := info.DialArgs("v0")
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not get DialArgs: %w", err)
+	}
+
+	log.Infof("Using boost API at %s", addr)
+	bapi, closer, err := bclient.NewBoostRPCV0(ctx, addr, info.AuthHeader())
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating boost API: %w", err)
+	}
+
+	return bapi, closer, nil
+}
+
 func CheckFullNodeApiVersion(ctx context.Context, fnapi v1api.FullNode) error {
 	v, err := fnapi.Version(ctx)
 	if err != nil {
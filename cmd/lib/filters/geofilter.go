@@ -0,0 +1,470 @@
+package filters
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+	"github.com/oschwald/maxminddb-golang"
+	"golang.org/x/time/rate"
+)
+
+// How often to check if the geo policy file or the databases have changed
+const geoPolicyReloadInterval = 10 * time.Second
+
+// The minimum burst size of a bandwidth cap, so that a whole bitswap block
+// (at most 2MiB) can always be sent
+const minGeoBandwidthBurst = 2 << 20
+
+// GeoAction is the action taken when a geo policy rule matches a request
+type GeoAction string
+
+// GeoAllow serves requests that match the rule, subject to the rule's bandwidth cap
+const GeoAllow GeoAction = "allow"
+
+// GeoDeny refuses requests that match the rule
+const GeoDeny GeoAction = "deny"
+
+// GeoPolicyConfig is the format of the geo policy file. The rules are
+// checked in order, and the first rule that matches a request decides
+// whether it is served. Requests that don't match any rule are served.
+type GeoPolicyConfig struct {
+	// Paths to MaxMind-format databases (eg GeoLite2-Country.mmdb and
+	// GeoLite2-ASN.mmdb) used to look up the country and ASN of client IPs
+	Databases []string
+	// Use the first address in the X-Forwarded-For header as the client IP
+	// for HTTP requests. Only enable this when booster-http is behind a
+	// trusted reverse proxy.
+	TrustForwardedFor bool
+	// The action taken when a rule depends on the client's IP range,
+	// country or ASN, but the client IP is not known, eg because the
+	// request came through a proxy that doesn't forward the client address.
+	// Either "allow" to skip the rule, or "deny" to refuse the request
+	// (defaults to "deny").
+	UnknownLocation GeoAction
+	Rules           []GeoRule
+}
+
+// GeoRule matches requests from clients in any of its IP ranges, countries
+// or ASNs. A rule with no IP ranges, countries or ASNs matches all clients.
+type GeoRule struct {
+	// The name of the rule, used in logs and errors
+	Name string
+	// IP ranges in CIDR notation
+	CIDRs []string
+	// ISO 3166-1 country codes, eg "DE"
+	Countries []string
+	// Autonomous system numbers
+	ASNs []uint
+	// If set, the rule only applies to retrievals of data in these pieces
+	Pieces []string
+	// Either "allow" or "deny" (defaults to "allow")
+	Action GeoAction
+	// The maximum number of bytes per second served to all of the clients
+	// that match the rule. Zero means no limit.
+	BytesPerSecond int64
+}
+
+type geoRule struct {
+	GeoRule
+	ipnets    []*net.IPNet
+	countries map[string]struct{}
+	asns      map[uint]struct{}
+	pieces    map[cid.Cid]struct{}
+	bandwidth *rate.Limiter
+}
+
+// locationScoped returns true if the rule only matches some clients
+func (r *geoRule) locationScoped() bool {
+	return len(r.ipnets) > 0 || len(r.countries) > 0 || len(r.asns) > 0
+}
+
+// matchesClient returns true if the client's IP, country or ASN is in the rule
+func (r *geoRule) matchesClient(ip net.IP, loc GeoLocation) bool {
+	if !r.locationScoped() {
+		return true
+	}
+	for _, ipnet := range r.ipnets {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	if _, ok := r.countries[loc.Country]; ok && loc.Country != "" {
+		return true
+	}
+	if _, ok := r.asns[loc.ASN]; ok && loc.ASN != 0 {
+		return true
+	}
+	return false
+}
+
+// matchesPieces returns true if the rule applies to any of the pieces
+func (r *geoRule) matchesPieces(pieces []cid.Cid) bool {
+	if len(r.pieces) == 0 {
+		return true
+	}
+	for _, p := range pieces {
+		if _, ok := r.pieces[p]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+type parsedGeoPolicy struct {
+	cfg         GeoPolicyConfig
+	rules       []*geoRule
+	pieceScoped bool
+	dbs         []*maxminddb.Reader
+}
+
+func (p *parsedGeoPolicy) close() {
+	for _, db := range p.dbs {
+		_ = db.Close()
+	}
+}
+
+func loadGeoPolicy(path string) (*parsedGeoPolicy, error) {
+	bz, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading geo policy file: %w", err)
+	}
+
+	p := &parsedGeoPolicy{}
+	if err := json.Unmarshal(bz, &p.cfg); err != nil {
+		return nil, fmt.Errorf("parsing geo policy file %s: %w", path, err)
+	}
+
+	switch p.cfg.UnknownLocation {
+	case "":
+		p.cfg.UnknownLocation = GeoDeny
+	case GeoAllow, GeoDeny:
+	default:
+		return nil, fmt.Errorf("geo policy 'UnknownLocation' must be either '%s' or '%s'", GeoAllow, GeoDeny)
+	}
+
+	for i, r := range p.cfg.Rules {
+		name := r.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i+1)
+		}
+		rule := &geoRule{
+			GeoRule:   r,
+			countries: make(map[string]struct{}, len(r.Countries)),
+			asns:      make(map[uint]struct{}, len(r.ASNs)),
+			pieces:    make(map[cid.Cid]struct{}, len(r.Pieces)),
+		}
+		rule.Name = name
+
+		switch r.Action {
+		case "":
+			rule.Action = GeoAllow
+		case GeoAllow, GeoDeny:
+		default:
+			return nil, fmt.Errorf("geo policy rule %s: 'Action' must be either '%s' or '%s'", name, GeoAllow, GeoDeny)
+		}
+		for _, s := range r.CIDRs {
+			_, ipnet, err := net.ParseCIDR(s)
+			if err != nil {
+				return nil, fmt.Errorf("geo policy rule %s: parsing IP range '%s': %w", name, s, err)
+			}
+			rule.ipnets = append(rule.ipnets, ipnet)
+		}
+		for _, c := range r.Countries {
+			rule.countries[strings.ToUpper(c)] = struct{}{}
+		}
+		for _, asn := range r.ASNs {
+			rule.asns[asn] = struct{}{}
+		}
+		for _, s := range r.Pieces {
+			pieceCid, err := cid.Parse(s)
+			if err != nil {
+				return nil, fmt.Errorf("geo policy rule %s: parsing piece CID '%s': %w", name, s, err)
+			}
+			rule.pieces[pieceCid] = struct{}{}
+			p.pieceScoped = true
+		}
+		if r.BytesPerSecond > 0 {
+			burst := int(r.BytesPerSecond)
+			if burst < minGeoBandwidthBurst {
+				burst = minGeoBandwidthBurst
+			}
+			rule.bandwidth = rate.NewLimiter(rate.Limit(r.BytesPerSecond), burst)
+		}
+		p.rules = append(p.rules, rule)
+	}
+
+	for _, dbPath := range p.cfg.Databases {
+		db, err := maxminddb.Open(dbPath)
+		if err != nil {
+			p.close()
+			return nil, fmt.Errorf("opening geo database %s: %w", dbPath, err)
+		}
+		p.dbs = append(p.dbs, db)
+	}
+
+	return p, nil
+}
+
+// GeoLocation is the location and network of an IP address
+type GeoLocation struct {
+	// The ISO 3166-1 country code
+	Country string
+	// The autonomous system number
+	ASN uint
+}
+
+// geoRecord is the subset of the fields in the MaxMind country, city and
+// ASN databases that are used by the geo filter
+type geoRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	ASN uint `maxminddb:"autonomous_system_number"`
+}
+
+// lookup looks up the IP address in each database
+func (p *parsedGeoPolicy) lookup(ip net.IP) (GeoLocation, error) {
+	var loc GeoLocation
+	if ip == nil {
+		return loc, nil
+	}
+	for _, db := range p.dbs {
+		var rec geoRecord
+		if err := db.Lookup(ip, &rec); err != nil {
+			return loc, fmt.Errorf("looking up %s in geo database: %w", ip, err)
+		}
+		if rec.Country.ISOCode != "" {
+			loc.Country = rec.Country.ISOCode
+		}
+		if rec.ASN != 0 {
+			loc.ASN = rec.ASN
+		}
+	}
+	return loc, nil
+}
+
+// PieceLookup returns the pieces that contain a block
+type PieceLookup func(ctx context.Context, m mh.Multihash) ([]cid.Cid, error)
+
+// SubPieceLookup returns the aggregate pieces that contain a sub-piece
+type SubPieceLookup func(ctx context.Context, subPieceCid cid.Cid) ([]cid.Cid, error)
+
+// GeoDecision is the result of applying the geo policy to a request
+type GeoDecision struct {
+	// The name of the rule that matched, or empty if no rule matched
+	Rule string
+	// Whether the request should be served
+	Allow bool
+	// The bandwidth cap for the request, or nil if there is none
+	Bandwidth *rate.Limiter
+}
+
+// GeoFilter decides whether to serve retrievals based on the client's IP
+// range, country and ASN, and on the pieces that are being retrieved.
+// The policy file and the databases are reloaded when they change.
+type GeoFilter struct {
+	path      string
+	pieces    PieceLookup
+	subPieces SubPieceLookup
+
+	lk       sync.RWMutex
+	policy   *parsedGeoPolicy
+	modTimes []time.Time
+}
+
+// NewGeoFilter loads the geo policy file. The piece lookup and the sub-piece
+// lookup are used to find the pieces that contain the requested data when
+// there are rules that only apply to some pieces. The sub-piece lookup may be
+// nil if requests are never for sub-pieces of aggregate pieces.
+func NewGeoFilter(path string, pieces PieceLookup, subPieces SubPieceLookup) (*GeoFilter, error) {
+	gf := &GeoFilter{path: path, pieces: pieces, subPieces: subPieces}
+	if err := gf.reload(); err != nil {
+		return nil, err
+	}
+	return gf, nil
+}
+
+func (gf *GeoFilter) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(geoPolicyReloadInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			if !gf.changed() {
+				continue
+			}
+			if err := gf.reload(); err != nil {
+				log.Errorw("reloading geo policy, keeping existing policy", "path", gf.path, "err", err)
+			} else {
+				log.Infow("reloaded geo policy", "path", gf.path)
+			}
+		}
+	}()
+}
+
+// Close closes the geo databases
+func (gf *GeoFilter) Close() {
+	gf.lk.Lock()
+	defer gf.lk.Unlock()
+	gf.policy.close()
+}
+
+// TrustForwardedFor returns true if the client IP of HTTP requests should be
+// read from the X-Forwarded-For header
+func (gf *GeoFilter) TrustForwardedFor() bool {
+	gf.lk.RLock()
+	defer gf.lk.RUnlock()
+	return gf.policy.cfg.TrustForwardedFor
+}
+
+// paths returns the policy file path followed by the database paths.
+// Must be called with the lock held.
+func (gf *GeoFilter) paths() []string {
+	return append([]string{gf.path}, gf.policy.cfg.Databases...)
+}
+
+// changed returns true if the policy file or any of the databases has been
+// modified since it was loaded
+func (gf *GeoFilter) changed() bool {
+	gf.lk.RLock()
+	paths := gf.paths()
+	modTimes := gf.modTimes
+	gf.lk.RUnlock()
+
+	for i, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			log.Warnw("checking geo policy file", "path", path, "err", err)
+			continue
+		}
+		if !fi.ModTime().Equal(modTimes[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (gf *GeoFilter) reload() error {
+	// Get the modification times before loading, so that a change made
+	// while loading is picked up by the next check
+	fi, err := os.Stat(gf.path)
+	if err != nil {
+		return fmt.Errorf("reading geo policy file: %w", err)
+	}
+	modTimes := []time.Time{fi.ModTime()}
+	policy, err := loadGeoPolicy(gf.path)
+	if err != nil {
+		return err
+	}
+	for _, dbPath := range policy.cfg.Databases {
+		fi, err := os.Stat(dbPath)
+		if err != nil {
+			policy.close()
+			return fmt.Errorf("reading geo database: %w", err)
+		}
+		modTimes = append(modTimes, fi.ModTime())
+	}
+
+	gf.lk.Lock()
+	defer gf.lk.Unlock()
+
+	if gf.policy != nil {
+		gf.policy.close()
+	}
+	gf.policy = policy
+	gf.modTimes = modTimes
+	return nil
+}
+
+// Decide applies the geo policy to a request from the given IP for the
+// given CID, which may be a piece CID or the CID of a block. The IP may be
+// nil if it is not known, in which case the rules that depend on the
+// client's location are applied according to the UnknownLocation setting.
+func (gf *GeoFilter) Decide(ctx context.Context, ip net.IP, c cid.Cid) (GeoDecision, error) {
+	return gf.decide(ctx, ip, c, false)
+}
+
+// DecideWithoutLocation applies the geo policy to a request for the given
+// CID when the client's location can never be known, eg because the request
+// was received through a libp2p proxy, which doesn't forward the client's
+// address. The rules that depend on the client's location are skipped,
+// whatever the UnknownLocation setting, so that only the rules that match
+// all clients apply.
+func (gf *GeoFilter) DecideWithoutLocation(ctx context.Context, c cid.Cid) (GeoDecision, error) {
+	return gf.decide(ctx, nil, c, true)
+}
+
+func (gf *GeoFilter) decide(ctx context.Context, ip net.IP, c cid.Cid, skipLocation bool) (GeoDecision, error) {
+	gf.lk.RLock()
+	policy := gf.policy
+	if len(policy.rules) == 0 {
+		gf.lk.RUnlock()
+		return GeoDecision{Allow: true}, nil
+	}
+	// The databases are closed when the policy is reloaded, so the lookup
+	// must be made with the lock held
+	loc, err := policy.lookup(ip)
+	gf.lk.RUnlock()
+	if err != nil {
+		return GeoDecision{}, err
+	}
+
+	// Only look up the pieces containing the block if there are rules that
+	// depend on it
+	var pieces []cid.Cid
+	if policy.pieceScoped && c.Defined() {
+		if c.Prefix().Codec == cid.FilCommitmentUnsealed {
+			// The piece may be a sub-piece of aggregate pieces, in which
+			// case the rules for the aggregate pieces also apply
+			pieces = []cid.Cid{c}
+			if gf.subPieces != nil {
+				aggregates, err := gf.subPieces(ctx, c)
+				if err != nil {
+					return GeoDecision{}, fmt.Errorf("getting aggregate pieces containing %s: %w", c, err)
+				}
+				pieces = append(pieces, aggregates...)
+			}
+		} else if gf.pieces != nil {
+			pieces, err = gf.pieces(ctx, c.Hash())
+			if err != nil {
+				return GeoDecision{}, fmt.Errorf("getting pieces containing %s: %w", c, err)
+			}
+		}
+	}
+
+	for _, r := range policy.rules {
+		if !r.matchesPieces(pieces) {
+			continue
+		}
+		if ip == nil && r.locationScoped() {
+			if policy.cfg.UnknownLocation == GeoDeny && !skipLocation {
+				log.Debugw("geo policy denied request from unknown location", "rule", r.Name, "cid", c)
+				return GeoDecision{Rule: r.Name}, nil
+			}
+			continue
+		}
+		if !r.matchesClient(ip, loc) {
+			continue
+		}
+		if r.Action == GeoDeny {
+			log.Debugw("geo policy denied request", "rule", r.Name, "ip", ip, "country", loc.Country, "asn", loc.ASN, "cid", c)
+			return GeoDecision{Rule: r.Name}, nil
+		}
+		return GeoDecision{Rule: r.Name, Allow: true, Bandwidth: r.bandwidth}, nil
+	}
+	return GeoDecision{Allow: true}, nil
+}
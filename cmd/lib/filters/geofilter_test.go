@@ -0,0 +1,183 @@
+package filters_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/filecoin-project/boost/cmd/lib/filters"
+	"github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeoFilter(t *testing.T) {
+	ctx := context.Background()
+	piece1, err := cid.Parse("baga6ea4seaqjtovkwk4myyzj56eztkh5pzsk5upksan6f5outesy62bsvl4dsha")
+	require.NoError(t, err)
+	piece2, err := cid.Parse("baga6ea4seaqhmks7eb3itzstkumzzb5d6v33wbnwk5ihgbwlk4xtonmnd3upqpa")
+	require.NoError(t, err)
+	block1, err := cid.Parse("QmWATWQ7fVPP2EFGu71UkfnqhYXDYH566qy47CnJDgvs8u")
+	require.NoError(t, err)
+	block2, err := cid.Parse("QmTn7prGSqKUd7cqvAjnULrH7zxBEBWrnj9kE7kZSGtDuQ")
+	require.NoError(t, err)
+
+	// block1 is in piece1, block2 is in piece2
+	pieceLookup := func(ctx context.Context, m mh.Multihash) ([]cid.Cid, error) {
+		switch {
+		case m.String() == block1.Hash().String():
+			return []cid.Cid{piece1}, nil
+		case m.String() == block2.Hash().String():
+			return []cid.Cid{piece2}, nil
+		}
+		return nil, errors.New("not found")
+	}
+
+	// subPiece is a sub-piece of the aggregate piece1
+	subPiece, err := cid.Parse("baga6ea4seaqjz2uoz3pqjkszxcrtsxmlgwuqaw4oy2c3ebtetwyfr4bdhqdq2ri")
+	require.NoError(t, err)
+	subPieceLookup := func(ctx context.Context, c cid.Cid) ([]cid.Cid, error) {
+		if c.Equals(subPiece) {
+			return []cid.Cid{piece1}, nil
+		}
+		return nil, nil
+	}
+
+	cfgPath := filepath.Join(t.TempDir(), "geo-policy.json")
+	writeCfg := func(cfg filters.GeoPolicyConfig) {
+		bz, err := json.Marshal(cfg)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(cfgPath, bz, 0o644))
+	}
+
+	// No rules: everything is allowed
+	writeCfg(filters.GeoPolicyConfig{})
+	gf, err := filters.NewGeoFilter(cfgPath, pieceLookup, subPieceLookup)
+	require.NoError(t, err)
+	defer gf.Close()
+	d, err := gf.Decide(ctx, net.ParseIP("10.0.0.1"), block1)
+	require.NoError(t, err)
+	require.True(t, d.Allow)
+	require.Empty(t, d.Rule)
+
+	writeCfg(filters.GeoPolicyConfig{
+		Rules: []filters.GeoRule{{
+			// piece1 may only be retrieved from 10.0.0.0/8
+			Name:   "residency-allow",
+			CIDRs:  []string{"10.0.0.0/8"},
+			Pieces: []string{piece1.String()},
+		}, {
+			Name:   "residency-deny",
+			Pieces: []string{piece1.String()},
+			Action: filters.GeoDeny,
+		}, {
+			Name:   "blocked",
+			CIDRs:  []string{"192.168.0.0/16"},
+			Action: filters.GeoDeny,
+		}, {
+			Name:           "capped",
+			CIDRs:          []string{"172.16.0.0/12"},
+			BytesPerSecond: 1024,
+		}},
+	})
+	gf, err = filters.NewGeoFilter(cfgPath, pieceLookup, subPieceLookup)
+	require.NoError(t, err)
+	defer gf.Close()
+
+	testCases := []struct {
+		name      string
+		ip        string
+		c         cid.Cid
+		rule      string
+		allow     bool
+		bandwidth bool
+	}{
+		{name: "piece in region", ip: "10.0.0.1", c: piece1, rule: "residency-allow", allow: true},
+		{name: "block in region", ip: "10.0.0.1", c: block1, rule: "residency-allow", allow: true},
+		{name: "piece out of region", ip: "8.8.8.8", c: piece1, rule: "residency-deny"},
+		{name: "block out of region", ip: "8.8.8.8", c: block1, rule: "residency-deny"},
+		{name: "sub-piece in region", ip: "10.0.0.1", c: subPiece, rule: "residency-allow", allow: true},
+		{name: "sub-piece out of region", ip: "8.8.8.8", c: subPiece, rule: "residency-deny"},
+		{name: "unknown IP", c: block1, rule: "residency-allow"},
+		{name: "other piece", ip: "8.8.8.8", c: block2, allow: true},
+		{name: "blocked range", ip: "192.168.1.1", c: block2, rule: "blocked"},
+		{name: "capped range", ip: "172.16.1.1", c: piece2, rule: "capped", allow: true, bandwidth: true},
+		{name: "unknown IP other piece", c: block2, rule: "blocked"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d, err := gf.Decide(ctx, net.ParseIP(tc.ip), tc.c)
+			require.NoError(t, err)
+			require.Equal(t, tc.rule, d.Rule)
+			require.Equal(t, tc.allow, d.Allow)
+			require.Equal(t, tc.bandwidth, d.Bandwidth != nil)
+		})
+	}
+
+	// When the client's location can never be known the rules that depend
+	// on it are skipped
+	d, err = gf.DecideWithoutLocation(ctx, block1)
+	require.NoError(t, err)
+	require.Equal(t, "residency-deny", d.Rule)
+	require.False(t, d.Allow)
+	d, err = gf.DecideWithoutLocation(ctx, block2)
+	require.NoError(t, err)
+	require.Empty(t, d.Rule)
+	require.True(t, d.Allow)
+
+	// When requests from an unknown location are allowed, the rules that
+	// depend on the client's location are skipped
+	writeCfg(filters.GeoPolicyConfig{
+		UnknownLocation: filters.GeoAllow,
+		Rules: []filters.GeoRule{{
+			Name:   "residency-allow",
+			CIDRs:  []string{"10.0.0.0/8"},
+			Pieces: []string{piece1.String()},
+		}, {
+			Name:   "residency-deny",
+			Pieces: []string{piece1.String()},
+			Action: filters.GeoDeny,
+		}, {
+			Name:   "blocked",
+			CIDRs:  []string{"192.168.0.0/16"},
+			Action: filters.GeoDeny,
+		}},
+	})
+	gf, err = filters.NewGeoFilter(cfgPath, pieceLookup, subPieceLookup)
+	require.NoError(t, err)
+	defer gf.Close()
+	d, err = gf.Decide(ctx, nil, block1)
+	require.NoError(t, err)
+	require.Equal(t, "residency-deny", d.Rule)
+	require.False(t, d.Allow)
+	d, err = gf.Decide(ctx, nil, block2)
+	require.NoError(t, err)
+	require.Empty(t, d.Rule)
+	require.True(t, d.Allow)
+
+	// An error looking up the pieces that contain a block is returned
+	unknown, err := cid.Parse("QmcfgsJsMtx6qJb74akCw1M24X1zFwgGo11h1cuhwQjtJP")
+	require.NoError(t, err)
+	_, err = gf.Decide(ctx, net.ParseIP("10.0.0.1"), unknown)
+	require.Error(t, err)
+}
+
+func TestGeoPolicyConfigErrors(t *testing.T) {
+	cfgPath := filepath.Join(t.TempDir(), "geo-policy.json")
+	for _, cfg := range []string{
+		`{"Rules": [{"CIDRs": ["not a cidr"]}]}`,
+		`{"Rules": [{"Pieces": ["not a cid"]}]}`,
+		`{"Rules": [{"Action": "block"}]}`,
+		`{"UnknownLocation": "block"}`,
+		`{"Databases": ["does-not-exist.mmdb"]}`,
+		`{"Rules": `,
+	} {
+		require.NoError(t, os.WriteFile(cfgPath, []byte(cfg), 0o644))
+		_, err := filters.NewGeoFilter(cfgPath, nil, nil)
+		require.Error(t, err, cfg)
+	}
+}
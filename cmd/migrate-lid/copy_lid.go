@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/filecoin-project/boost/extern/boostd-data/badger"
+	"github.com/filecoin-project/boost/extern/boostd-data/ldb"
+	"github.com/filecoin-project/boost/extern/boostd-data/model"
+	"github.com/filecoin-project/boost/extern/boostd-data/svc"
+	lcli "github.com/filecoin-project/lotus/cli"
+	"github.com/ipfs/go-cid"
+	"github.com/mitchellh/go-homedir"
+	"github.com/schollz/progressbar/v3"
+	"github.com/urfave/cli/v2"
+	"go.uber.org/zap"
+)
+
+// The embedded local index directory backends that can be copied between
+var embeddedDBTypes = []string{"leveldb", "badger"}
+
+var copyCmd = &cli.Command{
+	Name:  "copy",
+	Usage: "migrate-lid copy --from leveldb --to badger",
+	Description: "Copy the piece metadata, deals and indexes from one embedded local index directory backend " +
+		"to another (leveldb or badger). Boost must be stopped while the copy is running. " +
+		"Flagged pieces are not copied: they will be flagged again by the piece doctor. " +
+		"It is safe to stop and restart the process. It will skip pieces that have already been copied.",
+	Before: before,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "from",
+			Usage:    "the local index directory backend to copy from (leveldb or badger)",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:     "to",
+			Usage:    "the local index directory backend to copy to (leveldb or badger)",
+			Required: true,
+		},
+		&cli.BoolFlag{
+			Name:  "force",
+			Usage: "if the index has already been copied, overwrite it",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		from := cctx.String("from")
+		to := cctx.String("to")
+		if from == to {
+			return fmt.Errorf("the source and destination backends must be different")
+		}
+
+		repoDir, err := homedir.Expand(cctx.String(FlagBoostRepo))
+		if err != nil {
+			return err
+		}
+
+		src, err := openEmbeddedStore(repoDir, from)
+		if err != nil {
+			return err
+		}
+		dst, err := openEmbeddedStore(repoDir, to)
+		if err != nil {
+			return err
+		}
+
+		return copyLID(cctx, from, src, to, dst, cctx.Bool("force"))
+	},
+}
+
+func openEmbeddedStore(repoDir string, dbType string) (StoreMigrationApi, error) {
+	switch dbType {
+	case "leveldb":
+		repoPath, err := svc.MakeLevelDBDir(repoDir)
+		if err != nil {
+			return nil, err
+		}
+		return ldb.NewStore(repoPath), nil
+	case "badger":
+		repoPath, err := svc.MakeBadgerDir(repoDir)
+		if err != nil {
+			return nil, err
+		}
+		return badger.NewStore(repoPath), nil
+	default:
+		return nil, fmt.Errorf("invalid backend '%s': must be one of %v", dbType, embeddedDBTypes)
+	}
+}
+
+func copyLID(cctx *cli.Context, from string, src StoreMigrationApi, to string, dst StoreMigrationApi, force bool) error {
+	ctx := lcli.ReqContext(cctx)
+	svcCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	err := src.Start(svcCtx)
+	if err != nil {
+		return fmt.Errorf("starting "+from+" store: %w", err)
+	}
+	err = dst.Start(svcCtx)
+	if err != nil {
+		return fmt.Errorf("starting "+to+" store: %w", err)
+	}
+
+	// Create a logger for the copy that outputs to a file in the
+	// current working directory
+	logPath := "copy-" + from + "-to-" + to + ".log"
+	logger, err := createLogger(logPath)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Copying %s Local Index Directory to %s. ", from, to)
+	fmt.Println("See detailed logs of the copy at")
+	fmt.Println(logPath)
+
+	pcids, err := src.ListPieces(ctx)
+	if err != nil {
+		return fmt.Errorf("listing %s pieces: %w", from, err)
+	}
+
+	bar := progressbar.NewOptions(len(pcids),
+		progressbar.OptionEnableColorCodes(true),
+		progressbar.OptionFullWidth(),
+		progressbar.OptionSetPredictTime(true),
+		progressbar.OptionSetElapsedTime(false),
+		progressbar.OptionShowCount(),
+		progressbar.OptionSetTheme(progressbar.Theme{
+			Saucer:        "[green]=[reset]",
+			SaucerHead:    "[green]>[reset]",
+			SaucerPadding: " ",
+			BarStart:      "[",
+			BarEnd:        "]",
+		}))
+	bar.Describe("Copying pieces...")
+
+	start := time.Now()
+	logger.Infof("starting copy of %d pieces from %s to %s", len(pcids), from, to)
+
+	var errCount int
+	for i, pieceCid := range pcids {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		copyStart := time.Now()
+		copied, err := copyPiece(ctx, pieceCid, src, dst, force)
+		bar.Add(1) //nolint:errcheck
+		if err != nil {
+			errCount++
+			logger.Errorw("failed to copy piece", "pieceCid", pieceCid, "index", i, "total", len(pcids), "error", err)
+		} else if copied {
+			logger.Infow("copied piece", "pieceCid", pieceCid, "index", i, "total", len(pcids), "took", time.Since(copyStart).String())
+		} else {
+			logger.Infow("piece already copied", "pieceCid", pieceCid, "index", i, "total", len(pcids))
+		}
+	}
+
+	logger.Infow("copy complete", "count", len(pcids), "errors", errCount, "took", time.Since(start).String())
+	fmt.Println()
+	if errCount > 0 {
+		msg := fmt.Sprintf("Warning: there were errors copying %d pieces.", errCount)
+		msg += " See the log for details:\n" + logPath
+		fmt.Fprintf(os.Stderr, "\n"+msg+"\n")
+	}
+	return nil
+}
+
+// copyPiece copies the index and deals of a piece from the source store to
+// the destination store. It returns false if the piece has already been
+// copied.
+func copyPiece(ctx context.Context, pieceCid cid.Cid, src StoreMigrationApi, dst StoreMigrationApi, force bool) (bool, error) {
+	md, err := src.GetPieceMetadata(ctx, pieceCid)
+	if err != nil {
+		return false, fmt.Errorf("getting piece metadata: %w", err)
+	}
+
+	if !force {
+		isIndexed, err := dst.IsIndexed(ctx, pieceCid)
+		if err != nil {
+			return false, fmt.Errorf("checking if piece is already copied: %w", err)
+		}
+		if isIndexed || md.IndexedAt.IsZero() {
+			deals, err := dst.GetPieceDeals(ctx, pieceCid)
+			if err == nil && len(deals) == len(md.Deals) {
+				return false, nil
+			}
+		}
+	}
+
+	// Copy the index, if there is one
+	if !md.IndexedAt.IsZero() {
+		recs, err := src.GetIndex(ctx, pieceCid)
+		if err != nil {
+			return false, fmt.Errorf("getting index: %w", err)
+		}
+
+		var records []model.Record
+		for r := range recs {
+			if r.Error != nil {
+				return false, fmt.Errorf("reading index: %w", r.Error)
+			}
+			records = append(records, r.Record)
+		}
+
+		respch := dst.AddIndex(ctx, pieceCid, records, md.CompleteIndex)
+		for resp := range respch {
+			if resp.Err != "" {
+				return false, fmt.Errorf("adding index: %s", resp.Err)
+			}
+		}
+	}
+
+	// Copy the deals
+	for _, dl := range md.Deals {
+		err := dst.AddDealForPiece(ctx, pieceCid, dl)
+		if err != nil {
+			return false, fmt.Errorf("adding deal %s: %w", dl.DealUuid, err)
+		}
+	}
+
+	return true, nil
+}
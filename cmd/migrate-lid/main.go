@@ -43,6 +43,7 @@ func main() {
 			migrateLevelDBCmd,
 			migrateYugabyteDBCmd,
 			migrateReverseCmd,
+			copyCmd,
 		},
 	}
 	app.Setup()
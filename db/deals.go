@@ -289,6 +289,16 @@ func (d *DealsDB) Count(ctx context.Context, query string, filter *FilterOptions
 	return count, err
 }
 
+// CountAtCheckpoint counts the deals at the given checkpoint that have not
+// failed
+func (d *DealsDB) CountAtCheckpoint(ctx context.Context, checkpoint dealcheckpoints.Checkpoint) (int, error) {
+	row := d.db.QueryRowContext(ctx, "SELECT count(*) FROM Deals WHERE Checkpoint = ? AND Error = ''", checkpoint.String())
+
+	var count int
+	err := row.Scan(&count)
+	return count, err
+}
+
 func (d *DealsDB) ListActive(ctx context.Context) ([]*types.ProviderDealState, error) {
 	return d.list(ctx, 0, 0, "Checkpoint != ?", dealcheckpoints.Complete.String())
 }
@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS DealSubPieces (
+    DealUUID TEXT,
+    PieceCID TEXT,
+    SubPieceCID TEXT,
+    SubPieceOffset INT,
+    SubPieceSize INT,
+    CreatedAt DateTime
+);
+
+CREATE INDEX IF NOT EXISTS index_deal_sub_pieces_deal_uuid on DealSubPieces(DealUUID);
+CREATE INDEX IF NOT EXISTS index_deal_sub_pieces_sub_piece_cid on DealSubPieces(SubPieceCID);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS index_deal_sub_pieces_sub_piece_cid;
+DROP INDEX IF EXISTS index_deal_sub_pieces_deal_uuid;
+DROP TABLE IF EXISTS DealSubPieces;
+-- +goose StatementEnd
@@ -0,0 +1,101 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/google/uuid"
+	"github.com/ipfs/go-cid"
+)
+
+// SubPiecesDB stores the sub-pieces of aggregate deal pieces
+type SubPiecesDB struct {
+	db *sql.DB
+}
+
+func NewSubPiecesDB(db *sql.DB) *SubPiecesDB {
+	return &SubPiecesDB{db: db}
+}
+
+// Insert replaces the sub-pieces of the deal with the given sub-pieces
+func (s *SubPiecesDB) Insert(ctx context.Context, dealUuid uuid.UUID, subPieces []types.DealSubPiece) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("starting transaction: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM DealSubPieces WHERE DealUUID=?", dealUuid.String())
+	if err != nil {
+		return fmt.Errorf("deleting sub-pieces for deal %s: %w", dealUuid, err)
+	}
+
+	now := time.Now()
+	qry := "INSERT INTO DealSubPieces (DealUUID, PieceCID, SubPieceCID, SubPieceOffset, SubPieceSize, CreatedAt) VALUES (?, ?, ?, ?, ?, ?)"
+	for _, sp := range subPieces {
+		_, err = tx.ExecContext(ctx, qry, dealUuid.String(), sp.PieceCID.String(), sp.SubPieceCID.String(), sp.Offset, sp.Size, now)
+		if err != nil {
+			return fmt.Errorf("inserting sub-piece %s for deal %s: %w", sp.SubPieceCID, dealUuid, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Delete removes the sub-pieces of the deal
+func (s *SubPiecesDB) Delete(ctx context.Context, dealUuid uuid.UUID) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM DealSubPieces WHERE DealUUID=?", dealUuid.String())
+	if err != nil {
+		return fmt.Errorf("deleting sub-pieces for deal %s: %w", dealUuid, err)
+	}
+	return nil
+}
+
+// ByDeal lists the sub-pieces of the deal in the order of their offset
+func (s *SubPiecesDB) ByDeal(ctx context.Context, dealUuid uuid.UUID) ([]types.DealSubPiece, error) {
+	return s.list(ctx, "DealUUID=?", dealUuid.String())
+}
+
+// BySubPieceCID lists the deals containing the sub-piece
+func (s *SubPiecesDB) BySubPieceCID(ctx context.Context, subPieceCid cid.Cid) ([]types.DealSubPiece, error) {
+	return s.list(ctx, "SubPieceCID=?", subPieceCid.String())
+}
+
+func (s *SubPiecesDB) list(ctx context.Context, where string, whereArgs ...interface{}) ([]types.DealSubPiece, error) {
+	qry := "SELECT DealUUID, PieceCID, SubPieceCID, SubPieceOffset, SubPieceSize FROM DealSubPieces WHERE " + where + " ORDER BY SubPieceOffset"
+	rows, err := s.db.QueryContext(ctx, qry, whereArgs...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subPieces := make([]types.DealSubPiece, 0, 16)
+	for rows.Next() {
+		var dealUuid, pieceCid, subPieceCid string
+		var offset, size uint64
+		if err := rows.Scan(&dealUuid, &pieceCid, &subPieceCid, &offset, &size); err != nil {
+			return nil, err
+		}
+
+		sp := types.DealSubPiece{Offset: abi.PaddedPieceSize(offset), Size: abi.PaddedPieceSize(size)}
+		if sp.DealUuid, err = uuid.Parse(dealUuid); err != nil {
+			return nil, fmt.Errorf("parsing deal uuid '%s': %w", dealUuid, err)
+		}
+		if sp.PieceCID, err = cid.Parse(pieceCid); err != nil {
+			return nil, fmt.Errorf("parsing piece cid '%s': %w", pieceCid, err)
+		}
+		if sp.SubPieceCID, err = cid.Parse(subPieceCid); err != nil {
+			return nil, fmt.Errorf("parsing sub-piece cid '%s': %w", subPieceCid, err)
+		}
+		subPieces = append(subPieces, sp)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return subPieces, nil
+}
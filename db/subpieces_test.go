@@ -0,0 +1,76 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/filecoin-project/boost/db/migrations"
+	"github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/boost/testutil"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubPiecesDB(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	sqldb := CreateTestTmpDB(t)
+	req.NoError(CreateAllBoostTables(ctx, sqldb, sqldb))
+	req.NoError(migrations.Migrate(sqldb))
+
+	db := NewSubPiecesDB(sqldb)
+
+	dealUuid := uuid.New()
+	pieceCid := testutil.GenerateCid()
+	subPieceCids := testutil.GenerateCids(3)
+	var subPieces []types.DealSubPiece
+	for i, c := range subPieceCids {
+		subPieces = append(subPieces, types.DealSubPiece{
+			DealUuid:    dealUuid,
+			PieceCID:    pieceCid,
+			SubPieceCID: c,
+			Offset:      abi.PaddedPieceSize(2048 * i),
+			Size:        2048,
+		})
+	}
+	req.NoError(db.Insert(ctx, dealUuid, subPieces))
+
+	stored, err := db.ByDeal(ctx, dealUuid)
+	req.NoError(err)
+	req.Equal(subPieces, stored)
+
+	// Another deal with the same sub-piece
+	otherDeal := uuid.New()
+	otherSubPiece := subPieces[1]
+	otherSubPiece.DealUuid = otherDeal
+	req.NoError(db.Insert(ctx, otherDeal, []types.DealSubPiece{otherSubPiece}))
+
+	deals, err := db.BySubPieceCID(ctx, subPieceCids[1])
+	req.NoError(err)
+	req.Len(deals, 2)
+
+	// Inserting the sub-pieces again should replace the existing ones
+	req.NoError(db.Insert(ctx, dealUuid, subPieces[:1]))
+	stored, err = db.ByDeal(ctx, dealUuid)
+	req.NoError(err)
+	req.Equal(subPieces[:1], stored)
+
+	deals, err = db.BySubPieceCID(ctx, subPieceCids[1])
+	req.NoError(err)
+	req.Len(deals, 1)
+	req.Equal(otherDeal, deals[0].DealUuid)
+
+	// Deleting the sub-pieces of a deal should not affect other deals
+	req.NoError(db.Insert(ctx, dealUuid, subPieces))
+	req.NoError(db.Delete(ctx, dealUuid))
+	stored, err = db.ByDeal(ctx, dealUuid)
+	req.NoError(err)
+	req.Empty(stored)
+
+	deals, err = db.BySubPieceCID(ctx, subPieceCids[1])
+	req.NoError(err)
+	req.Len(deals, 1)
+	req.Equal(otherDeal, deals[0].DealUuid)
+}
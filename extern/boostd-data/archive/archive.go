@@ -0,0 +1,448 @@
+// Package archive implements a portable archive format for the contents of
+// the local index directory, so that piece metadata, deals and indexes can be
+// moved between local index directory backends and between sites.
+//
+// An archive is a stream of frames. Each frame is a one byte frame type,
+// followed by the uvarint encoded length of the payload, followed by the
+// payload:
+//
+//	magic
+//	header frame     json encoded Header
+//	for each piece:
+//	  piece frame    json encoded PieceHeader
+//	  records frames uvarint record count, then for each record:
+//	                 uvarint multihash length, multihash, uvarint offset, uvarint size
+//	  checksum frame sha256 of the payloads of the piece frame and records frames
+//	end frame        json encoded Footer
+//
+// The archive can be read as a stream: each piece can be imported as soon as
+// its checksum frame has been read and verified.
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+
+	"github.com/filecoin-project/boost/extern/boostd-data/model"
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+)
+
+// Version is the current archive format version
+const Version = 1
+
+var magic = []byte("lid-archive\n")
+
+const (
+	frameHeader   byte = 'h'
+	framePiece    byte = 'p'
+	frameRecords  byte = 'r'
+	frameChecksum byte = 'c'
+	frameEnd      byte = 'e'
+)
+
+// The maximum number of records written in a single records frame
+var RecordsPerFrame = 64 * 1024
+
+// The maximum size of a frame payload accepted by the reader
+const maxFrameSize = 64 << 20
+
+// The smallest encoded size of a record: a one byte multihash length, a
+// two byte multihash (code and length), a one byte offset and a one byte
+// size
+const minRecordSize = 5
+
+// The maximum number of records the reader allocates space for before the
+// records have been read
+const maxRecordsPrealloc = 64 * 1024
+
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// Header is written at the start of the archive
+type Header struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"createdAt"`
+	// Pieces is the number of pieces in the archive, or zero if the number
+	// was not known when the archive was created
+	Pieces int `json:"pieces"`
+}
+
+// PieceHeader is written at the start of each piece
+type PieceHeader struct {
+	PieceCid cid.Cid        `json:"pieceCid"`
+	Metadata model.Metadata `json:"metadata"`
+	Records  int            `json:"records"`
+}
+
+// Footer is written at the end of the archive
+type Footer struct {
+	Pieces int `json:"pieces"`
+}
+
+// Piece is the piece metadata, deals and index records of a single piece
+type Piece struct {
+	PieceCid cid.Cid
+	Metadata model.Metadata
+	Records  []model.Record
+}
+
+// Writer writes an archive to an underlying writer
+type Writer struct {
+	w      *bufio.Writer
+	pieces int
+	lenBuf []byte
+	recBuf bytes.Buffer
+}
+
+// NewWriter writes the archive magic and header, and returns a Writer that
+// can be used to write pieces to the archive. Close must be called to
+// complete the archive.
+func NewWriter(w io.Writer, pieces int) (*Writer, error) {
+	aw := &Writer{
+		w:      bufio.NewWriter(w),
+		lenBuf: make([]byte, binary.MaxVarintLen64),
+	}
+
+	if _, err := aw.w.Write(magic); err != nil {
+		return nil, fmt.Errorf("writing archive magic: %w", err)
+	}
+
+	hdr := Header{Version: Version, CreatedAt: time.Now(), Pieces: pieces}
+	if err := aw.writeJSONFrame(frameHeader, hdr, nil); err != nil {
+		return nil, fmt.Errorf("writing archive header: %w", err)
+	}
+
+	return aw, nil
+}
+
+// WritePiece writes the metadata, deals and index records of a piece
+func (aw *Writer) WritePiece(p Piece) error {
+	sum := sha256.New()
+
+	ph := PieceHeader{PieceCid: p.PieceCid, Metadata: p.Metadata, Records: len(p.Records)}
+	if err := aw.writeJSONFrame(framePiece, ph, sum); err != nil {
+		return fmt.Errorf("writing piece %s header: %w", p.PieceCid, err)
+	}
+
+	for start := 0; start < len(p.Records); start += RecordsPerFrame {
+		end := start + RecordsPerFrame
+		if end > len(p.Records) {
+			end = len(p.Records)
+		}
+		if err := aw.writeRecordsFrame(p.Records[start:end], sum); err != nil {
+			return fmt.Errorf("writing piece %s records: %w", p.PieceCid, err)
+		}
+	}
+
+	if err := aw.writeFrame(frameChecksum, sum.Sum(nil), nil); err != nil {
+		return fmt.Errorf("writing piece %s checksum: %w", p.PieceCid, err)
+	}
+
+	aw.pieces++
+	return nil
+}
+
+// Close writes the end of the archive and flushes any buffered data. It
+// does not close the underlying writer.
+func (aw *Writer) Close() error {
+	if err := aw.writeJSONFrame(frameEnd, Footer{Pieces: aw.pieces}, nil); err != nil {
+		return fmt.Errorf("writing archive footer: %w", err)
+	}
+	return aw.w.Flush()
+}
+
+func (aw *Writer) writeRecordsFrame(recs []model.Record, sum hash.Hash) error {
+	aw.recBuf.Reset()
+	aw.putUvarint(&aw.recBuf, uint64(len(recs)))
+	for _, r := range recs {
+		mh := r.Cid.Hash()
+		aw.putUvarint(&aw.recBuf, uint64(len(mh)))
+		aw.recBuf.Write(mh)
+		aw.putUvarint(&aw.recBuf, r.Offset)
+		aw.putUvarint(&aw.recBuf, r.Size)
+	}
+	return aw.writeFrame(frameRecords, aw.recBuf.Bytes(), sum)
+}
+
+func (aw *Writer) putUvarint(buf *bytes.Buffer, v uint64) {
+	n := binary.PutUvarint(aw.lenBuf, v)
+	buf.Write(aw.lenBuf[:n])
+}
+
+func (aw *Writer) writeJSONFrame(typ byte, v interface{}, sum hash.Hash) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return aw.writeFrame(typ, b, sum)
+}
+
+func (aw *Writer) writeFrame(typ byte, payload []byte, sum hash.Hash) error {
+	if err := aw.w.WriteByte(typ); err != nil {
+		return err
+	}
+	n := binary.PutUvarint(aw.lenBuf, uint64(len(payload)))
+	if _, err := aw.w.Write(aw.lenBuf[:n]); err != nil {
+		return err
+	}
+	if _, err := aw.w.Write(payload); err != nil {
+		return err
+	}
+	if sum != nil {
+		sum.Write(payload)
+	}
+	return nil
+}
+
+// Reader reads pieces from an archive
+type Reader struct {
+	r      *bufio.Reader
+	cr     *countingReader
+	header Header
+	pieces int
+	done   bool
+	// The size of the archive, or -1 if the size is not known
+	size int64
+}
+
+// NewReader reads and validates the archive magic and header. If the
+// underlying reader is seekable (for example a file) the record counts in
+// the archive are validated against the size of the archive.
+func NewReader(r io.Reader) (*Reader, error) {
+	size := int64(-1)
+	if s, ok := r.(io.Seeker); ok {
+		cur, err := s.Seek(0, io.SeekCurrent)
+		if err == nil {
+			end, err := s.Seek(0, io.SeekEnd)
+			if err != nil {
+				return nil, fmt.Errorf("getting archive size: %w", err)
+			}
+			if _, err := s.Seek(cur, io.SeekStart); err != nil {
+				return nil, fmt.Errorf("getting archive size: %w", err)
+			}
+			size = end - cur
+		}
+	}
+
+	cr := &countingReader{r: r}
+	ar := &Reader{r: bufio.NewReader(cr), cr: cr, size: size}
+
+	m := make([]byte, len(magic))
+	if _, err := io.ReadFull(ar.r, m); err != nil {
+		return nil, fmt.Errorf("reading archive magic: %w", err)
+	}
+	if !bytes.Equal(m, magic) {
+		return nil, fmt.Errorf("not a local index directory archive")
+	}
+
+	if err := ar.readJSONFrame(frameHeader, &ar.header, nil); err != nil {
+		return nil, fmt.Errorf("reading archive header: %w", err)
+	}
+	if ar.header.Version != Version {
+		return nil, fmt.Errorf("unsupported archive version %d (expected version %d)", ar.header.Version, Version)
+	}
+
+	return ar, nil
+}
+
+// Header returns the archive header
+func (ar *Reader) Header() Header {
+	return ar.header
+}
+
+// Next reads the next piece from the archive, and verifies its checksum.
+// It returns io.EOF when the end of the archive has been reached.
+func (ar *Reader) Next() (*Piece, error) {
+	if ar.done {
+		return nil, io.EOF
+	}
+
+	typ, payload, err := ar.readFrame()
+	if err != nil {
+		return nil, err
+	}
+
+	switch typ {
+	case frameEnd:
+		var footer Footer
+		if err := json.Unmarshal(payload, &footer); err != nil {
+			return nil, fmt.Errorf("unmarshalling archive footer: %w", err)
+		}
+		if footer.Pieces != ar.pieces {
+			return nil, fmt.Errorf("archive footer has %d pieces but %d pieces were read", footer.Pieces, ar.pieces)
+		}
+		ar.done = true
+		return nil, io.EOF
+	case framePiece:
+	default:
+		return nil, fmt.Errorf("unexpected frame type '%c': expected piece frame", typ)
+	}
+
+	sum := sha256.New()
+	sum.Write(payload)
+
+	var ph PieceHeader
+	if err := json.Unmarshal(payload, &ph); err != nil {
+		return nil, fmt.Errorf("unmarshalling piece header: %w", err)
+	}
+	if ph.Records < 0 {
+		return nil, fmt.Errorf("piece %s header has invalid record count %d", ph.PieceCid, ph.Records)
+	}
+	if remaining := ar.remaining(); remaining >= 0 && int64(ph.Records) > remaining/minRecordSize {
+		return nil, fmt.Errorf("piece %s header has %d records but only %d bytes of the archive remain", ph.PieceCid, ph.Records, remaining)
+	}
+
+	p := &Piece{
+		PieceCid: ph.PieceCid,
+		Metadata: ph.Metadata,
+		Records:  make([]model.Record, 0, min(ph.Records, maxRecordsPrealloc)),
+	}
+	for len(p.Records) < ph.Records {
+		if err := ar.readRecordsFrame(p, ph.Records-len(p.Records), sum); err != nil {
+			return nil, fmt.Errorf("reading piece %s records: %w", ph.PieceCid, err)
+		}
+	}
+	if len(p.Records) != ph.Records {
+		return nil, fmt.Errorf("piece %s header has %d records but %d records were read", ph.PieceCid, ph.Records, len(p.Records))
+	}
+
+	typ, payload, err = ar.readFrame()
+	if err != nil {
+		return nil, fmt.Errorf("reading piece %s checksum: %w", ph.PieceCid, err)
+	}
+	if typ != frameChecksum {
+		return nil, fmt.Errorf("unexpected frame type '%c': expected piece %s checksum frame", typ, ph.PieceCid)
+	}
+	if !bytes.Equal(payload, sum.Sum(nil)) {
+		return nil, fmt.Errorf("piece %s: %w", ph.PieceCid, ErrChecksumMismatch)
+	}
+
+	ar.pieces++
+	return p, nil
+}
+
+// remaining returns the number of bytes of the archive that have not been
+// read, or -1 if the size of the archive is not known
+func (ar *Reader) remaining() int64 {
+	if ar.size < 0 {
+		return -1
+	}
+	return ar.size - (ar.cr.n - int64(ar.r.Buffered()))
+}
+
+func (ar *Reader) readRecordsFrame(p *Piece, maxCount int, sum hash.Hash) error {
+	typ, payload, err := ar.readFrame()
+	if err != nil {
+		return err
+	}
+	if typ != frameRecords {
+		return fmt.Errorf("unexpected frame type '%c': expected records frame", typ)
+	}
+	sum.Write(payload)
+
+	buf := bytes.NewReader(payload)
+	count, err := binary.ReadUvarint(buf)
+	if err != nil {
+		return fmt.Errorf("reading record count: %w", err)
+	}
+	if count > uint64(maxCount) {
+		return fmt.Errorf("records frame has %d records but only %d records remain in the piece", count, maxCount)
+	}
+	if count > uint64(buf.Len()/minRecordSize) {
+		return fmt.Errorf("records frame has %d records but only %d bytes", count, buf.Len())
+	}
+	for i := uint64(0); i < count; i++ {
+		mhlen, err := binary.ReadUvarint(buf)
+		if err != nil {
+			return fmt.Errorf("reading multihash length: %w", err)
+		}
+		if mhlen > uint64(buf.Len()) {
+			return fmt.Errorf("multihash length %d exceeds frame size", mhlen)
+		}
+		mhb := make([]byte, mhlen)
+		if _, err := io.ReadFull(buf, mhb); err != nil {
+			return fmt.Errorf("reading multihash: %w", err)
+		}
+		mh, err := multihash.Cast(mhb)
+		if err != nil {
+			return fmt.Errorf("parsing multihash: %w", err)
+		}
+		offset, err := binary.ReadUvarint(buf)
+		if err != nil {
+			return fmt.Errorf("reading offset: %w", err)
+		}
+		size, err := binary.ReadUvarint(buf)
+		if err != nil {
+			return fmt.Errorf("reading size: %w", err)
+		}
+
+		p.Records = append(p.Records, model.Record{
+			Cid:        cid.NewCidV1(cid.Raw, mh),
+			OffsetSize: model.OffsetSize{Offset: offset, Size: size},
+		})
+	}
+	return nil
+}
+
+func (ar *Reader) readJSONFrame(expected byte, v interface{}, sum hash.Hash) error {
+	typ, payload, err := ar.readFrame()
+	if err != nil {
+		return err
+	}
+	if typ != expected {
+		return fmt.Errorf("unexpected frame type '%c': expected '%c'", typ, expected)
+	}
+	if sum != nil {
+		sum.Write(payload)
+	}
+	return json.Unmarshal(payload, v)
+}
+
+func (ar *Reader) readFrame() (byte, []byte, error) {
+	typ, err := ar.r.ReadByte()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			// The archive should always end with an end frame
+			return 0, nil, io.ErrUnexpectedEOF
+		}
+		return 0, nil, err
+	}
+
+	l, err := binary.ReadUvarint(ar.r)
+	if err != nil {
+		return 0, nil, fmt.Errorf("reading frame length: %w", err)
+	}
+	if l > maxFrameSize {
+		return 0, nil, fmt.Errorf("frame length %d exceeds maximum of %d", l, maxFrameSize)
+	}
+
+	payload := make([]byte, l)
+	if _, err := io.ReadFull(ar.r, payload); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, fmt.Errorf("reading frame payload: %w", err)
+	}
+
+	return typ, payload, nil
+}
+
+// countingReader counts the bytes read from the underlying reader
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,162 @@
+package archive
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/boost/extern/boostd-data/model"
+	"github.com/filecoin-project/boost/extern/boostd-data/testutils"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchiveRoundTrip(t *testing.T) {
+	// Use a small frame size so that pieces are split across several
+	// records frames
+	prev := RecordsPerFrame
+	RecordsPerFrame = 3
+	defer func() { RecordsPerFrame = prev }()
+
+	pieces := []Piece{
+		generatePiece(10),
+		generatePiece(3),
+		// A piece with deals but no index
+		generatePiece(0),
+	}
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, len(pieces))
+	require.NoError(t, err)
+	for _, p := range pieces {
+		require.NoError(t, w.WritePiece(p))
+	}
+	require.NoError(t, w.Close())
+
+	r, err := NewReader(&buf)
+	require.NoError(t, err)
+	require.Equal(t, Version, r.Header().Version)
+	require.Equal(t, len(pieces), r.Header().Pieces)
+
+	for _, expected := range pieces {
+		p, err := r.Next()
+		require.NoError(t, err)
+		require.Equal(t, expected.PieceCid, p.PieceCid)
+		require.Equal(t, expected.Metadata.CompleteIndex, p.Metadata.CompleteIndex)
+		require.True(t, expected.Metadata.IndexedAt.Equal(p.Metadata.IndexedAt))
+		require.Equal(t, expected.Metadata.Deals, p.Metadata.Deals)
+		require.Len(t, p.Records, len(expected.Records))
+		for i, rec := range p.Records {
+			require.Equal(t, expected.Records[i].Cid.Hash(), rec.Cid.Hash())
+			require.Equal(t, expected.Records[i].OffsetSize, rec.OffsetSize)
+		}
+	}
+
+	_, err = r.Next()
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestArchiveCorrupted(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, 1)
+	require.NoError(t, err)
+	require.NoError(t, w.WritePiece(generatePiece(5)))
+	require.NoError(t, w.Close())
+	archive := buf.Bytes()
+
+	t.Run("checksum mismatch", func(t *testing.T) {
+		corrupt := bytes.Clone(archive)
+		// Flip a bit in the last byte of the records frame, which comes
+		// just before the checksum frame (1 byte type, 1 byte length,
+		// 32 byte sha256) and the end frame
+		footerLen := 2 + len(`{"pieces":1}`)
+		corrupt[len(corrupt)-footerLen-34-1] ^= 0x01
+
+		r, err := NewReader(bytes.NewReader(corrupt))
+		require.NoError(t, err)
+		_, err = r.Next()
+		require.True(t, errors.Is(err, ErrChecksumMismatch), err)
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		r, err := NewReader(bytes.NewReader(archive[:len(archive)-5]))
+		require.NoError(t, err)
+		_, err = r.Next()
+		require.NoError(t, err)
+		_, err = r.Next()
+		require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+
+	// A piece header with a record count that is much larger than the
+	// archive, followed by a records frame with too many records
+	var forged bytes.Buffer
+	fw, err := NewWriter(&forged, 1)
+	require.NoError(t, err)
+	p := generatePiece(5)
+	require.NoError(t, fw.writeJSONFrame(framePiece, PieceHeader{PieceCid: p.PieceCid, Metadata: p.Metadata, Records: 1 << 40}, nil))
+	require.NoError(t, fw.writeRecordsFrame(p.Records, sha256.New()))
+	require.NoError(t, fw.Close())
+
+	t.Run("record count exceeds archive size", func(t *testing.T) {
+		r, err := NewReader(bytes.NewReader(forged.Bytes()))
+		require.NoError(t, err)
+		_, err = r.Next()
+		require.ErrorContains(t, err, "bytes of the archive remain")
+	})
+
+	t.Run("record count exceeds records read", func(t *testing.T) {
+		// The size of the archive is not known when it is not seekable
+		r, err := NewReader(io.MultiReader(bytes.NewReader(forged.Bytes())))
+		require.NoError(t, err)
+		_, err = r.Next()
+		require.Error(t, err)
+
+		// A records frame with more records than the piece header
+		var extra bytes.Buffer
+		ew, err := NewWriter(&extra, 1)
+		require.NoError(t, err)
+		require.NoError(t, ew.writeJSONFrame(framePiece, PieceHeader{PieceCid: p.PieceCid, Metadata: p.Metadata, Records: 2}, nil))
+		require.NoError(t, ew.writeRecordsFrame(p.Records, sha256.New()))
+		require.NoError(t, ew.Close())
+		r, err = NewReader(io.MultiReader(bytes.NewReader(extra.Bytes())))
+		require.NoError(t, err)
+		_, err = r.Next()
+		require.ErrorContains(t, err, "only 2 records remain")
+	})
+
+	t.Run("bad magic", func(t *testing.T) {
+		_, err := NewReader(bytes.NewReader([]byte("not an archive at all")))
+		require.Error(t, err)
+	})
+}
+
+func generatePiece(recordCount int) Piece {
+	p := Piece{
+		PieceCid: testutils.GenerateCid(),
+		Metadata: model.Metadata{
+			Version:       "1",
+			CompleteIndex: recordCount > 0,
+			Deals: []model.DealInfo{{
+				DealUuid:    uuid.NewString(),
+				ChainDealID: 1234,
+				SectorID:    5,
+				PieceOffset: 2048,
+				PieceLength: 4096,
+				CarLength:   4000,
+			}},
+		},
+	}
+	if recordCount > 0 {
+		p.Metadata.IndexedAt = time.Now().Truncate(time.Second)
+	}
+	for i, c := range testutils.GenerateCids(recordCount) {
+		p.Records = append(p.Records, model.Record{
+			Cid:        c,
+			OffsetSize: model.OffsetSize{Offset: uint64(i * 100), Size: uint64(i + 1)},
+		})
+	}
+	return p
+}
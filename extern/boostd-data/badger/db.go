@@ -0,0 +1,790 @@
+package badger
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	badgerdb "github.com/dgraph-io/badger/v4"
+	"github.com/dgraph-io/badger/v4/pb"
+	"github.com/dgraph-io/ristretto/z"
+	"github.com/filecoin-project/boost/extern/boostd-data/model"
+	"github.com/filecoin-project/boost/extern/boostd-data/shared/tracing"
+	"github.com/filecoin-project/boost/extern/boostd-data/svc/types"
+	"github.com/filecoin-project/go-address"
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+)
+
+// Key layout
+//
+// All keys are binary. Piece cids and multihashes are self-delimiting (they
+// encode their own length), so they can be concatenated without a separator
+// and a prefix scan over one of them never matches a longer value.
+//
+//	m/<piece cid>                 -> json encoded model.Metadata
+//	o/<piece cid><multihash>      -> uvarint offset, uvarint size
+//	p/<multihash><piece cid>      -> empty
+//	f/<miner address>/<piece cid> -> json encoded flaggedMetadata
+//	u/<miner address>/<piece cid> -> empty
+//
+// The u/ keys mark the pieces that the piece doctor should no longer check
+// on the miner.
+//
+// The metadata, flagged and untracked keys are kept in the main database and written
+// with transactions. The offset and multihash to piece keys are kept in a
+// separate index database that is only written by ingesting sorted tables
+// with a stream writer, and by dropping prefixes. This means that adding an
+// index never goes through the memtable and the write-ahead log, and
+// removing the offsets of a piece is a single range delete.
+//
+// Keeping one key per (multihash, piece) pair instead of a list of pieces per
+// multihash means that adding an index never needs to read existing values.
+// Keeping the offsets of a piece under a common prefix means that they can
+// all be dropped together when the piece is removed.
+var (
+	prefixMetadata          = []byte("m/")
+	prefixPieceOffsets      = []byte("o/")
+	prefixMultihashToPieces = []byte("p/")
+	prefixFlagged           = []byte("f/")
+	prefixUntracked         = []byte("u/")
+)
+
+func metadataKey(pieceCid cid.Cid) []byte {
+	return concat(prefixMetadata, pieceCid.Bytes())
+}
+
+func pieceOffsetsPrefix(pieceCid cid.Cid) []byte {
+	return concat(prefixPieceOffsets, pieceCid.Bytes())
+}
+
+func pieceOffsetKey(pieceCid cid.Cid, mh multihash.Multihash) []byte {
+	return concat(prefixPieceOffsets, pieceCid.Bytes(), mh)
+}
+
+func multihashPrefix(mh multihash.Multihash) []byte {
+	return concat(prefixMultihashToPieces, mh)
+}
+
+func multihashToPieceKey(mh multihash.Multihash, pieceCid cid.Cid) []byte {
+	return concat(prefixMultihashToPieces, mh, pieceCid.Bytes())
+}
+
+func flaggedPrefix(maddr address.Address) []byte {
+	return concat(prefixFlagged, []byte(maddr.String()+"/"))
+}
+
+func flaggedKey(maddr address.Address, pieceCid cid.Cid) []byte {
+	return concat(flaggedPrefix(maddr), pieceCid.Bytes())
+}
+
+func untrackedKey(maddr address.Address, pieceCid cid.Cid) []byte {
+	return concat(prefixUntracked, []byte(maddr.String()+"/"), pieceCid.Bytes())
+}
+
+func concat(parts ...[]byte) []byte {
+	var l int
+	for _, p := range parts {
+		l += len(p)
+	}
+	k := make([]byte, 0, l)
+	for _, p := range parts {
+		k = append(k, p...)
+	}
+	return k
+}
+
+func encodeOffsetSize(ofsz model.OffsetSize) []byte {
+	value := make([]byte, 2*binary.MaxVarintLen64)
+	no := binary.PutUvarint(value, ofsz.Offset)
+	ns := binary.PutUvarint(value[no:], ofsz.Size)
+	return value[:no+ns]
+}
+
+func decodeOffsetSize(b []byte) model.OffsetSize {
+	offset, n := binary.Uvarint(b)
+	size, _ := binary.Uvarint(b[n:])
+	return model.OffsetSize{Offset: offset, Size: size}
+}
+
+type flaggedMetadata struct {
+	CreatedAt       time.Time        `json:"c"`
+	UpdatedAt       time.Time        `json:"u"`
+	HasUnsealedCopy bool             `json:"huc"`
+	MinerAddr       address.Address  `json:"m"`
+	Reason          model.FlagReason `json:"r"`
+	Details         string           `json:"d"`
+}
+
+// bitDelete is the meta bit that badger uses to mark a deleted entry. The
+// stream writer copies the meta byte of each entry into the table, so
+// entries written with this bit are tombstones.
+const bitDelete byte = 1 << 0
+
+// ingestBufferSize is the size of the encoded entries that are passed to
+// the stream writer at a time
+const ingestBufferSize = 64 << 20
+
+type DB struct {
+	*badgerdb.DB
+
+	// index holds the offset and multihash to piece keys
+	index *badgerdb.DB
+	// ingestLk serializes the stream writes and prefix drops on the index
+	// database: the stream writer can't run while there are other writes
+	ingestLk sync.Mutex
+}
+
+func newDB(path string, readonly bool) (*DB, error) {
+	db, err := openBadger(path, readonly)
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := openBadger(filepath.Join(path, "index"), readonly)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return &DB{DB: db, index: index}, nil
+}
+
+func openBadger(path string, readonly bool) (*badgerdb.DB, error) {
+	opts := badgerdb.DefaultOptions(path).
+		WithLogger(&logger{}).
+		WithReadOnly(readonly).
+		// Writes that modify the same key are serialized by the store, so
+		// there is no need to track the keys read by each transaction
+		WithDetectConflicts(false)
+
+	db, err := badgerdb.Open(opts)
+	if err != nil {
+		return nil, fmt.Errorf("opening badger db at %s: %w", path, err)
+	}
+
+	return db, nil
+}
+
+// Close closes the index database and the main database
+func (db *DB) Close() error {
+	return errors.Join(db.index.Close(), db.DB.Close())
+}
+
+// GetPieceCidToMetadata
+func (db *DB) GetPieceCidToMetadata(ctx context.Context, pieceCid cid.Cid) (model.Metadata, error) {
+	_, span := tracing.Tracer.Start(ctx, "db.get_piece_cid_to_metadata")
+	defer span.End()
+
+	var md model.Metadata
+	err := db.View(func(txn *badgerdb.Txn) error {
+		item, err := txn.Get(metadataKey(pieceCid))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &md)
+		})
+	})
+	if err != nil {
+		return md, fmt.Errorf("getting piece metadata for piece %s: %w", pieceCid, err)
+	}
+
+	return md, nil
+}
+
+// SetPieceCidToMetadata
+func (db *DB) SetPieceCidToMetadata(ctx context.Context, pieceCid cid.Cid, md model.Metadata) error {
+	_, span := tracing.Tracer.Start(ctx, "db.set_piece_cid_to_metadata")
+	defer span.End()
+
+	b, err := json.Marshal(md)
+	if err != nil {
+		return err
+	}
+
+	return db.Update(func(txn *badgerdb.Txn) error {
+		return txn.Set(metadataKey(pieceCid), b)
+	})
+}
+
+// AddIndexRecords ingests the offset and the multihash to piece mapping of
+// each record into the index database as sorted tables
+func (db *DB) AddIndexRecords(ctx context.Context, pieceCid cid.Cid, recs []model.Record, progress func(float64)) error {
+	ctx, span := tracing.Tracer.Start(ctx, "db.add_index_records")
+	defer span.End()
+
+	kvs := make([]*pb.KV, 0, 2*len(recs))
+	for i, rec := range recs {
+		mh := rec.Cid.Hash()
+		kvs = append(kvs,
+			&pb.KV{Key: pieceOffsetKey(pieceCid, mh), Value: encodeOffsetSize(rec.OffsetSize)},
+			&pb.KV{Key: multihashToPieceKey(mh, pieceCid)},
+		)
+
+		if i%100_000 == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			progress(float64(i) / float64(len(recs)))
+		}
+	}
+
+	if err := db.ingest(ctx, kvs); err != nil {
+		return fmt.Errorf("ingesting index records: %w", err)
+	}
+	return nil
+}
+
+// ingest writes the entries into the index database with an incremental
+// stream writer, which builds sorted tables and adds them to the LSM tree
+// one level above the highest level that has data. All of the entries are
+// given a version greater than any existing version, so they replace any
+// existing entries with the same key. When the tables reach level 0 the
+// stream writer first flattens the tree into a single level.
+// The tables are only added to the tree when the stream writer is flushed,
+// so if ingest fails none of the entries are written.
+func (db *DB) ingest(ctx context.Context, kvs []*pb.KV) error {
+	if len(kvs) == 0 {
+		return nil
+	}
+
+	// The stream writer requires the keys to be sorted and unique. If a key
+	// appears more than once the last entry is kept.
+	sort.SliceStable(kvs, func(i, j int) bool {
+		return bytes.Compare(kvs[i].Key, kvs[j].Key) < 0
+	})
+	uniq := kvs[:0]
+	for _, kv := range kvs {
+		if len(uniq) > 0 && bytes.Equal(uniq[len(uniq)-1].Key, kv.Key) {
+			uniq[len(uniq)-1] = kv
+			continue
+		}
+		uniq = append(uniq, kv)
+	}
+	kvs = uniq
+
+	db.ingestLk.Lock()
+	defer db.ingestLk.Unlock()
+
+	version := db.index.MaxVersion() + 1
+
+	sw := db.index.NewStreamWriter()
+	if err := sw.PrepareIncremental(); err != nil {
+		sw.Cancel()
+		return fmt.Errorf("preparing stream writer: %w", err)
+	}
+
+	write := func(kvs []*pb.KV) error {
+		buf := z.NewBuffer(ingestBufferSize, "boostd-data.badger.ingest")
+		defer buf.Release() //nolint:errcheck
+
+		for _, kv := range kvs {
+			kv.Version = version
+			badgerdb.KVToBuffer(kv, buf)
+		}
+		return sw.Write(buf)
+	}
+
+	// Encode the entries in chunks so that the buffer stays within
+	// ingestBufferSize
+	const chunkSize = 100_000
+	for start := 0; start < len(kvs); start += chunkSize {
+		if err := ctx.Err(); err != nil {
+			sw.Cancel()
+			return err
+		}
+		if err := write(kvs[start:min(start+chunkSize, len(kvs))]); err != nil {
+			sw.Cancel()
+			return fmt.Errorf("writing to stream writer: %w", err)
+		}
+	}
+
+	if err := sw.Flush(); err != nil {
+		return fmt.Errorf("flushing stream writer: %w", err)
+	}
+	return nil
+}
+
+// AllRecords
+func (db *DB) AllRecords(ctx context.Context, pieceCid cid.Cid) ([]model.Record, error) {
+	_, span := tracing.Tracer.Start(ctx, "db.all_records")
+	defer span.End()
+
+	var records []model.Record
+	prefix := pieceOffsetsPrefix(pieceCid)
+	err := db.index.View(func(txn *badgerdb.Txn) error {
+		opts := badgerdb.DefaultIteratorOptions
+		opts.Prefix = prefix
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			m, err := multihash.Cast(item.KeyCopy(nil)[len(prefix):])
+			if err != nil {
+				return fmt.Errorf("parsing multihash from key %x: %w", item.Key(), err)
+			}
+
+			var ofsz model.OffsetSize
+			err = item.Value(func(val []byte) error {
+				ofsz = decodeOffsetSize(val)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			records = append(records, model.Record{
+				Cid:        cid.NewCidV1(cid.Raw, m),
+				OffsetSize: ofsz,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// GetOffsetSize
+func (db *DB) GetOffsetSize(ctx context.Context, pieceCid cid.Cid, m multihash.Multihash) (*model.OffsetSize, error) {
+	_, span := tracing.Tracer.Start(ctx, "db.get_offset")
+	defer span.End()
+
+	var ofsz model.OffsetSize
+	err := db.index.View(func(txn *badgerdb.Txn) error {
+		item, err := txn.Get(pieceOffsetKey(pieceCid, m))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			ofsz = decodeOffsetSize(val)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &ofsz, nil
+}
+
+// GetPieceCidsByMultihash
+func (db *DB) GetPieceCidsByMultihash(ctx context.Context, mh multihash.Multihash) ([]cid.Cid, error) {
+	_, span := tracing.Tracer.Start(ctx, "db.get_piece_cids_by_multihash")
+	defer span.End()
+
+	var pcids []cid.Cid
+	prefix := multihashPrefix(mh)
+	err := db.index.View(func(txn *badgerdb.Txn) error {
+		opts := badgerdb.DefaultIteratorOptions
+		opts.Prefix = prefix
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			k := it.Item().KeyCopy(nil)
+			pcid, err := cid.Cast(k[len(prefix):])
+			if err != nil {
+				return fmt.Errorf("parsing piece cid from key %x: %w", k, err)
+			}
+			pcids = append(pcids, pcid)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pieces for multihash %s, err: %w", mh, err)
+	}
+
+	if len(pcids) == 0 {
+		return nil, fmt.Errorf("failed to get pieces for multihash %s, err: %w", mh, badgerdb.ErrKeyNotFound)
+	}
+
+	return pcids, nil
+}
+
+// RemovePieceMetadata
+func (db *DB) RemovePieceMetadata(ctx context.Context, pieceCid cid.Cid) error {
+	ctx, span := tracing.Tracer.Start(ctx, "db.remove_piece_metadata")
+	defer span.End()
+
+	// Check that the piece exists
+	if _, err := db.GetPieceCidToMetadata(ctx, pieceCid); err != nil {
+		return err
+	}
+
+	// Remove all multihashes before, as without Metadata, they are useless
+	if err := db.RemoveIndexes(ctx, pieceCid); err != nil {
+		return err
+	}
+
+	return db.Update(func(txn *badgerdb.Txn) error {
+		return txn.Delete(metadataKey(pieceCid))
+	})
+}
+
+// RemoveIndexes removes the offsets and the multihash -> piece entries of
+// the piece. The multihash -> piece keys are spread across the key space,
+// so they are removed by ingesting a tombstone for each of them. The
+// offsets of the piece are then removed with a single range delete of the
+// piece's offsets prefix. If the range delete fails the offsets remain, so
+// calling RemoveIndexes again removes the rest of the index.
+func (db *DB) RemoveIndexes(ctx context.Context, pieceCid cid.Cid) error {
+	ctx, span := tracing.Tracer.Start(ctx, "db.remove_indexes")
+	defer span.End()
+
+	var tombstones []*pb.KV
+	prefix := pieceOffsetsPrefix(pieceCid)
+	err := db.index.View(func(txn *badgerdb.Txn) error {
+		opts := badgerdb.DefaultIteratorOptions
+		opts.Prefix = prefix
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			m := it.Item().Key()[len(prefix):]
+			tombstones = append(tombstones, &pb.KV{
+				Key:  multihashToPieceKey(m, pieceCid),
+				Meta: []byte{bitDelete},
+			})
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error querying the database: %w", err)
+	}
+
+	if err := db.ingest(ctx, tombstones); err != nil {
+		return fmt.Errorf("removing multihash to piece entries: %w", err)
+	}
+
+	db.ingestLk.Lock()
+	defer db.ingestLk.Unlock()
+
+	if err := db.index.DropPrefix(prefix); err != nil {
+		return fmt.Errorf("dropping offsets of piece %s: %w", pieceCid, err)
+	}
+
+	return nil
+}
+
+// ListPieces
+func (db *DB) ListPieces(ctx context.Context) ([]cid.Cid, error) {
+	_, span := tracing.Tracer.Start(ctx, "db.list_pieces")
+	defer span.End()
+
+	var pieceCids []cid.Cid
+	err := db.forEachPiece(nil, func(pieceCid cid.Cid, _ func() (model.Metadata, error)) (bool, error) {
+		pieceCids = append(pieceCids, pieceCid)
+		return true, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing pieces in database: %w", err)
+	}
+
+	return pieceCids, nil
+}
+
+// forEachPiece iterates over the piece metadata keys, starting after the
+// given piece metadata key (or from the first key if after is nil). The
+// callback returns false to stop iterating.
+func (db *DB) forEachPiece(after []byte, cb func(cid.Cid, func() (model.Metadata, error)) (bool, error)) error {
+	return db.View(func(txn *badgerdb.Txn) error {
+		opts := badgerdb.DefaultIteratorOptions
+		opts.Prefix = prefixMetadata
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		if after == nil {
+			it.Rewind()
+		} else {
+			it.Seek(after)
+			if it.Valid() && bytes.Equal(it.Item().Key(), after) {
+				it.Next()
+			}
+		}
+
+		for ; it.Valid(); it.Next() {
+			item := it.Item()
+			k := item.KeyCopy(nil)
+			pieceCid, err := cid.Cast(k[len(prefixMetadata):])
+			if err != nil {
+				return fmt.Errorf("parsing piece cid from key %x: %w", k, err)
+			}
+
+			getMetadata := func() (model.Metadata, error) {
+				var md model.Metadata
+				err := item.Value(func(val []byte) error {
+					return json.Unmarshal(val, &md)
+				})
+				return md, err
+			}
+
+			ok, err := cb(pieceCid, getMetadata)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+// Get the number of pieces that have an associated deal on the given miner
+func (db *DB) PiecesCount(ctx context.Context, maddr address.Address) (int, error) {
+	_, span := tracing.Tracer.Start(ctx, "db.pieces_count")
+	defer span.End()
+
+	var count int
+	err := db.forEachPiece(nil, func(pieceCid cid.Cid, getMetadata func() (model.Metadata, error)) (bool, error) {
+		md, err := getMetadata()
+		if err != nil {
+			return false, fmt.Errorf("getting piece cid '%s' metadata: %w", pieceCid, err)
+		}
+		if hasDealOnMiner(md, maddr) {
+			count++
+		}
+		return true, nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("listing pieces in database: %w", err)
+	}
+
+	return count, nil
+}
+
+func hasDealOnMiner(md model.Metadata, maddr address.Address) bool {
+	for _, dl := range md.Deals {
+		if dl.MinerAddr == maddr {
+			return true
+		}
+	}
+	return false
+}
+
+// SetPieceCidToFlagged
+func (db *DB) SetPieceCidToFlagged(ctx context.Context, pieceCid cid.Cid, maddr address.Address, fm flaggedMetadata) error {
+	_, span := tracing.Tracer.Start(ctx, "db.set_piece_cid_to_flagged")
+	defer span.End()
+
+	b, err := json.Marshal(fm)
+	if err != nil {
+		return err
+	}
+
+	return db.Update(func(txn *badgerdb.Txn) error {
+		return txn.Set(flaggedKey(maddr, pieceCid), b)
+	})
+}
+
+// GetPieceCidToFlagged
+func (db *DB) GetPieceCidToFlagged(ctx context.Context, pieceCid cid.Cid, maddr address.Address) (flaggedMetadata, error) {
+	_, span := tracing.Tracer.Start(ctx, "db.get_piece_cid_to_flagged")
+	defer span.End()
+
+	var fm flaggedMetadata
+	err := db.View(func(txn *badgerdb.Txn) error {
+		item, err := txn.Get(flaggedKey(maddr, pieceCid))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &fm)
+		})
+	})
+	if err != nil {
+		return fm, fmt.Errorf("getting flagged metadata for piece %s: %w", pieceCid, err)
+	}
+
+	return fm, nil
+}
+
+// DeletePieceCidToFlagged
+func (db *DB) DeletePieceCidToFlagged(ctx context.Context, pieceCid cid.Cid, maddr address.Address) error {
+	_, span := tracing.Tracer.Start(ctx, "db.delete_piece_flagged_metadata")
+	defer span.End()
+
+	return db.Update(func(txn *badgerdb.Txn) error {
+		return txn.Delete(flaggedKey(maddr, pieceCid))
+	})
+}
+
+// SetPieceUntracked stops the piece doctor from checking the piece on the miner
+func (db *DB) SetPieceUntracked(ctx context.Context, pieceCid cid.Cid, maddr address.Address) error {
+	_, span := tracing.Tracer.Start(ctx, "db.set_piece_untracked")
+	defer span.End()
+
+	return db.Update(func(txn *badgerdb.Txn) error {
+		return txn.Set(untrackedKey(maddr, pieceCid), []byte{})
+	})
+}
+
+// RemovePieceUntracked resumes checking the piece on the miner
+func (db *DB) RemovePieceUntracked(ctx context.Context, pieceCid cid.Cid, maddr address.Address) error {
+	_, span := tracing.Tracer.Start(ctx, "db.remove_piece_untracked")
+	defer span.End()
+
+	return db.Update(func(txn *badgerdb.Txn) error {
+		return txn.Delete(untrackedKey(maddr, pieceCid))
+	})
+}
+
+// IsPieceUntracked
+func (db *DB) IsPieceUntracked(pieceCid cid.Cid, maddr address.Address) (bool, error) {
+	var untracked bool
+	err := db.View(func(txn *badgerdb.Txn) error {
+		_, err := txn.Get(untrackedKey(maddr, pieceCid))
+		if err == nil {
+			untracked = true
+			return nil
+		}
+		if errors.Is(err, badgerdb.ErrKeyNotFound) {
+			return nil
+		}
+		return err
+	})
+	return untracked, err
+}
+
+// forEachFlagged calls the callback for each flagged piece that matches the
+// filter
+func (db *DB) forEachFlagged(filter *types.FlaggedPiecesListFilter, cb func(cid.Cid, flaggedMetadata)) error {
+	prefix := prefixFlagged
+	if filter != nil && !filter.MinerAddr.Empty() {
+		prefix = flaggedPrefix(filter.MinerAddr)
+	}
+
+	return db.View(func(txn *badgerdb.Txn) error {
+		opts := badgerdb.DefaultIteratorOptions
+		opts.Prefix = prefix
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+
+			var v flaggedMetadata
+			err := item.Value(func(val []byte) error {
+				return json.Unmarshal(val, &v)
+			})
+			if err != nil {
+				return fmt.Errorf("failed to unmarshal flagged metadata for key %x: %w", item.Key(), err)
+			}
+
+			if !filter.Matches(v.MinerAddr, v.HasUnsealedCopy, v.Reason) {
+				continue
+			}
+
+			k := item.KeyCopy(nil)
+			pcidBytes := k[len(flaggedPrefix(v.MinerAddr)):]
+			pieceCid, err := cid.Cast(pcidBytes)
+			if err != nil {
+				return fmt.Errorf("parsing piece cid from key %x: %w", k, err)
+			}
+
+			cb(pieceCid, v)
+		}
+		return nil
+	})
+}
+
+func (db *DB) ListFlaggedPieces(ctx context.Context, filter *types.FlaggedPiecesListFilter, cursor *time.Time, offset int, limit int) ([]model.FlaggedPiece, error) {
+	_, span := tracing.Tracer.Start(ctx, "db.list_flagged_pieces")
+	defer span.End()
+
+	var records []model.FlaggedPiece
+	err := db.forEachFlagged(filter, func(pieceCid cid.Cid, v flaggedMetadata) {
+		if cursor != nil && v.CreatedAt.Before(*cursor) {
+			return
+		}
+
+		records = append(records, model.FlaggedPiece{
+			MinerAddr:       v.MinerAddr,
+			CreatedAt:       v.CreatedAt,
+			UpdatedAt:       v.UpdatedAt,
+			PieceCid:        pieceCid,
+			HasUnsealedCopy: v.HasUnsealedCopy,
+			Reason:          v.Reason,
+			Details:         v.Details,
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing flagged pieces in database: %w", err)
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].CreatedAt.Before(records[j].CreatedAt)
+	})
+
+	if offset > 0 {
+		if offset >= len(records) {
+			records = []model.FlaggedPiece{}
+		} else {
+			records = records[offset:]
+		}
+	}
+
+	if limit > 0 && len(records) > limit {
+		records = records[:limit]
+	}
+
+	return records, nil
+}
+
+func (db *DB) FlaggedPiecesCount(ctx context.Context, filter *types.FlaggedPiecesListFilter) (int, error) {
+	_, span := tracing.Tracer.Start(ctx, "db.flagged_pieces_count")
+	defer span.End()
+
+	var count int
+	err := db.forEachFlagged(filter, func(cid.Cid, flaggedMetadata) {
+		count++
+	})
+	if err != nil {
+		return 0, fmt.Errorf("counting flagged pieces in database: %w", err)
+	}
+
+	return count, nil
+}
+
+func isNotFound(err error) bool {
+	return errors.Is(err, badgerdb.ErrKeyNotFound)
+}
+
+// logger adapts the boostd-data logger to the badger logger interface
+type logger struct{}
+
+var _ badgerdb.Logger = (*logger)(nil)
+
+func (l *logger) Errorf(format string, args ...interface{}) {
+	log.Errorf(format, args...)
+}
+
+func (l *logger) Warningf(format string, args ...interface{}) {
+	log.Warnf(format, args...)
+}
+
+func (l *logger) Infof(format string, args ...interface{}) {
+	log.Debugf(format, args...)
+}
+
+func (l *logger) Debugf(format string, args ...interface{}) {
+	log.Debugf(format, args...)
+}
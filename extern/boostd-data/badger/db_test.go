@@ -0,0 +1,94 @@
+package badger
+
+import (
+	"context"
+	"testing"
+
+	"github.com/filecoin-project/boost/extern/boostd-data/model"
+	"github.com/filecoin-project/boost/extern/boostd-data/testutils"
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIngestIndexRecords(t *testing.T) {
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	db, err := newDB(dir, false)
+	require.NoError(t, err)
+
+	// All of the pieces share the first multihash
+	shared := testutils.GenerateCid()
+	pieceRecords := func() []model.Record {
+		recs := []model.Record{{Cid: shared, OffsetSize: model.OffsetSize{Offset: 0, Size: 10}}}
+		for i, c := range testutils.GenerateCids(100) {
+			recs = append(recs, model.Record{Cid: c, OffsetSize: model.OffsetSize{Offset: uint64(i+1) * 10, Size: 10}})
+		}
+		return recs
+	}
+
+	// Add more indexes than there are levels in the LSM tree, so that the
+	// stream writer has to flatten the tree
+	pieces := make(map[cid.Cid][]model.Record)
+	for i := 0; i < 12; i++ {
+		pieceCid := testutils.GenerateCid()
+		recs := pieceRecords()
+		require.NoError(t, db.AddIndexRecords(ctx, pieceCid, recs, func(float64) {}))
+		pieces[pieceCid] = recs
+	}
+
+	requireIndexed := func(db *DB, pieceCid cid.Cid, recs []model.Record) {
+		all, err := db.AllRecords(ctx, pieceCid)
+		require.NoError(t, err)
+		require.Len(t, all, len(recs))
+		for _, rec := range recs {
+			ofsz, err := db.GetOffsetSize(ctx, pieceCid, rec.Cid.Hash())
+			require.NoError(t, err)
+			require.Equal(t, rec.OffsetSize, *ofsz)
+
+			pcids, err := db.GetPieceCidsByMultihash(ctx, rec.Cid.Hash())
+			require.NoError(t, err)
+			require.Contains(t, pcids, pieceCid)
+		}
+	}
+	for pieceCid, recs := range pieces {
+		requireIndexed(db, pieceCid, recs)
+	}
+	pcids, err := db.GetPieceCidsByMultihash(ctx, shared.Hash())
+	require.NoError(t, err)
+	require.Len(t, pcids, len(pieces))
+
+	// Remove the index of one piece
+	var removed cid.Cid
+	for pieceCid := range pieces {
+		removed = pieceCid
+		break
+	}
+	require.NoError(t, db.RemoveIndexes(ctx, removed))
+
+	all, err := db.AllRecords(ctx, removed)
+	require.NoError(t, err)
+	require.Empty(t, all)
+	_, err = db.GetOffsetSize(ctx, removed, pieces[removed][1].Cid.Hash())
+	require.True(t, isNotFound(err))
+	_, err = db.GetPieceCidsByMultihash(ctx, pieces[removed][1].Cid.Hash())
+	require.True(t, isNotFound(err))
+	pcids, err = db.GetPieceCidsByMultihash(ctx, shared.Hash())
+	require.NoError(t, err)
+	require.Len(t, pcids, len(pieces)-1)
+	require.NotContains(t, pcids, removed)
+
+	// Adding the index again should replace the tombstones
+	require.NoError(t, db.AddIndexRecords(ctx, removed, pieces[removed], func(float64) {}))
+	requireIndexed(db, removed, pieces[removed])
+
+	// The indexes should still be there after the database is reopened
+	require.NoError(t, db.Close())
+	db, err = newDB(dir, false)
+	require.NoError(t, err)
+	defer db.Close() //nolint:errcheck
+
+	for pieceCid, recs := range pieces {
+		requireIndexed(db, pieceCid, recs)
+	}
+}
@@ -0,0 +1,286 @@
+package badger
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/boost/extern/boostd-data/metrics"
+	"github.com/filecoin-project/boost/extern/boostd-data/model"
+	"github.com/filecoin-project/boost/extern/boostd-data/shared/tracing"
+	"github.com/filecoin-project/boost/extern/boostd-data/svc/types"
+	"github.com/filecoin-project/go-address"
+	"github.com/ipfs/go-cid"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+)
+
+var (
+	// The minimum frequency with which to check pieces for errors (eg bad index)
+	MinPieceCheckPeriod = 5 * time.Minute
+
+	// batch limit for each NextPiecesToCheck call
+	PiecesToTrackerBatchSize = 1024
+)
+
+// pieceDoctorState keeps track in memory of the position reached in the
+// piece metadata keys, and when each piece was last checked
+type pieceDoctorState struct {
+	lk      sync.Mutex
+	lastKey []byte
+	checked map[string]time.Time
+}
+
+func newPieceDoctorState() pieceDoctorState {
+	return pieceDoctorState{checked: make(map[string]time.Time)}
+}
+
+func (s *Store) NextPiecesToCheck(ctx context.Context, maddr address.Address) ([]cid.Cid, error) {
+	ctx, span := tracing.Tracer.Start(ctx, "store.next_pieces_to_check")
+	defer span.End()
+
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.Endpoint, "badger.next_pieces_to_check"))
+	stop := metrics.Timer(ctx, metrics.APIRequestDuration)
+	defer stop()
+
+	defer func(now time.Time) {
+		log.Debugw("handled.next-pieces-to-check", "took", time.Since(now).String())
+	}(time.Now())
+
+	s.doctor.lk.Lock()
+	defer s.doctor.lk.Unlock()
+
+	maddrStr := maddr.String()
+	now := time.Now()
+
+	var pieceCids []cid.Cid
+	var count int
+	var lastKey []byte
+	err := s.db.forEachPiece(s.doctor.lastKey, func(pieceCid cid.Cid, getMetadata func() (model.Metadata, error)) (bool, error) {
+		count++
+		lastKey = metadataKey(pieceCid)
+
+		minerPiece := maddrStr + pieceCid.String()
+		t, ok := s.doctor.checked[minerPiece]
+		if ok && t.After(now.Add(-MinPieceCheckPeriod)) {
+			return count < PiecesToTrackerBatchSize, nil
+		}
+
+		// Filter for pieces that match the miner address
+		md, err := getMetadata()
+		if err != nil {
+			return false, fmt.Errorf("getting piece metadata: %w", err)
+		}
+		if !hasDealOnMiner(md, maddr) {
+			return count < PiecesToTrackerBatchSize, nil
+		}
+
+		untracked, err := s.db.IsPieceUntracked(pieceCid, maddr)
+		if err != nil {
+			return false, fmt.Errorf("getting untracked state of piece: %w", err)
+		}
+		if !untracked {
+			s.doctor.checked[minerPiece] = now
+			pieceCids = append(pieceCids, pieceCid)
+		}
+
+		return count < PiecesToTrackerBatchSize, nil
+	})
+	if err != nil {
+		stats.Record(s.ctx, metrics.FailureNextPiecesToCheckCount.M(1))
+		return nil, fmt.Errorf("listing pieces in database: %w", err)
+	}
+
+	// if we got less pieces than the specified limit, we must be at the end
+	// of the table, so reset the cursor
+	if count < PiecesToTrackerBatchSize {
+		s.doctor.lastKey = nil
+	} else {
+		s.doctor.lastKey = lastKey
+	}
+
+	log.Debugw("NextPiecesToCheck: returning piececids", "len", len(pieceCids))
+
+	stats.Record(s.ctx, metrics.SuccessNextPiecesToCheckCount.M(1))
+	return pieceCids, nil
+}
+
+func (s *Store) PiecesCount(ctx context.Context, maddr address.Address) (int, error) {
+	log.Debugw("handle.pieces-count")
+
+	ctx, span := tracing.Tracer.Start(ctx, "store.pieces_count")
+	defer span.End()
+
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.Endpoint, "badger.pieces_count"))
+	stop := metrics.Timer(ctx, metrics.APIRequestDuration)
+	defer stop()
+
+	defer func(now time.Time) {
+		log.Debugw("handled.pieces-count", "took", time.Since(now).String())
+	}(time.Now())
+
+	out, err := s.db.PiecesCount(ctx, maddr)
+	if err != nil {
+		stats.Record(s.ctx, metrics.FailurePiecesCountCount.M(1))
+		return 0, err
+	}
+	stats.Record(s.ctx, metrics.SuccessPiecesCountCount.M(1))
+	return out, nil
+}
+
+func (s *Store) ScanProgress(ctx context.Context, maddr address.Address) (*types.ScanProgress, error) {
+	log.Debugw("handle.scan-progress")
+
+	ctx, span := tracing.Tracer.Start(ctx, "store.scan_progress")
+	defer span.End()
+
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.Endpoint, "badger.scan_progress"))
+	stop := metrics.Timer(ctx, metrics.APIRequestDuration)
+	defer stop()
+
+	defer func(now time.Time) {
+		log.Debugw("handled.scan-progress", "took", time.Since(now).String())
+	}(time.Now())
+
+	count, err := s.db.PiecesCount(ctx, maddr)
+	if err != nil {
+		stats.Record(s.ctx, metrics.FailureScanProgressCount.M(1))
+		return nil, err
+	}
+
+	s.doctor.lk.Lock()
+	checkedCount := len(s.doctor.checked)
+	var lastScan time.Time
+	for _, t := range s.doctor.checked {
+		if t.After(lastScan) {
+			lastScan = t
+		}
+	}
+	s.doctor.lk.Unlock()
+
+	progress := float64(1.0)
+	if count != 0 {
+		progress = float64(checkedCount) / float64(count)
+	}
+
+	stats.Record(s.ctx, metrics.SuccessScanProgressCount.M(1))
+	return &types.ScanProgress{
+		Progress: progress,
+		LastScan: lastScan,
+	}, nil
+}
+
+func (s *Store) FlagPiece(ctx context.Context, pieceCid cid.Cid, hasUnsealedCopy bool, maddr address.Address, reason model.FlagReason, details string) error {
+	log.Debugw("handle.flag-piece", "piece-cid", pieceCid, "hasUnsealedCopy", hasUnsealedCopy, "reason", reason)
+
+	ctx, span := tracing.Tracer.Start(ctx, "store.flag_piece")
+	defer span.End()
+
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.Endpoint, "badger.flag_piece"))
+	stop := metrics.Timer(ctx, metrics.APIRequestDuration)
+	defer stop()
+
+	defer func(now time.Time) {
+		log.Debugw("handled.flag-piece", "took", time.Since(now).String())
+	}(time.Now())
+
+	s.Lock()
+	defer s.Unlock()
+
+	now := time.Now()
+
+	// Get the existing flagged metadata for the piece
+	fm, err := s.db.GetPieceCidToFlagged(ctx, pieceCid, maddr)
+	if err != nil {
+		if !isNotFound(err) {
+			stats.Record(s.ctx, metrics.FailureFlagPieceCount.M(1))
+			return fmt.Errorf("getting piece cid flagged metadata for piece %s: %w", pieceCid, err)
+		}
+		// there isn't yet any flagged metadata, so create new metadata
+		fm = flaggedMetadata{CreatedAt: now, MinerAddr: maddr}
+	}
+
+	fm.UpdatedAt = now
+	fm.HasUnsealedCopy = hasUnsealedCopy
+	fm.Reason = reason
+	fm.Details = details
+
+	err = s.db.SetPieceCidToFlagged(ctx, pieceCid, maddr, fm)
+	if err != nil {
+		stats.Record(s.ctx, metrics.FailureFlagPieceCount.M(1))
+		return err
+	}
+
+	stats.Record(s.ctx, metrics.SuccessFlagPieceCount.M(1))
+	return nil
+}
+
+func (s *Store) UnflagPiece(ctx context.Context, pieceCid cid.Cid, maddr address.Address) error {
+	log.Debugw("handle.unflag-piece", "piece-cid", pieceCid)
+
+	ctx, span := tracing.Tracer.Start(ctx, "store.unflag_piece")
+	defer span.End()
+
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.Endpoint, "badger.unflag_piece"))
+	stop := metrics.Timer(ctx, metrics.APIRequestDuration)
+	defer stop()
+
+	defer func(now time.Time) {
+		log.Debugw("handled.unflag-piece", "took", time.Since(now).String())
+	}(time.Now())
+
+	err := s.db.DeletePieceCidToFlagged(ctx, pieceCid, maddr)
+	if err != nil {
+		stats.Record(s.ctx, metrics.FailureUnflagPieceCount.M(1))
+		return fmt.Errorf("deleting piece cid flagged metadata for piece %s: %w", pieceCid, err)
+	}
+	stats.Record(s.ctx, metrics.SuccessUnflagPieceCount.M(1))
+	return nil
+}
+
+func (s *Store) FlaggedPiecesList(ctx context.Context, filter *types.FlaggedPiecesListFilter, cursor *time.Time, offset int, limit int) ([]model.FlaggedPiece, error) {
+	log.Debugw("handle.flagged-pieces-list")
+
+	ctx, span := tracing.Tracer.Start(ctx, "store.flagged_pieces_list")
+	defer span.End()
+
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.Endpoint, "badger.flagged_pieces_list"))
+	stop := metrics.Timer(ctx, metrics.APIRequestDuration)
+	defer stop()
+
+	defer func(now time.Time) {
+		log.Debugw("handled.flagged-pieces-list", "took", time.Since(now).String())
+	}(time.Now())
+
+	out, err := s.db.ListFlaggedPieces(ctx, filter, cursor, offset, limit)
+	if err != nil {
+		stats.Record(s.ctx, metrics.FailureFlaggedPiecesListCount.M(1))
+		return nil, err
+	}
+	stats.Record(s.ctx, metrics.SuccessFlaggedPiecesListCount.M(1))
+	return out, nil
+}
+
+func (s *Store) FlaggedPiecesCount(ctx context.Context, filter *types.FlaggedPiecesListFilter) (int, error) {
+	log.Debugw("handle.flagged-pieces-count")
+
+	ctx, span := tracing.Tracer.Start(ctx, "store.flagged_pieces_count")
+	defer span.End()
+
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.Endpoint, "badger.flagged_pieces_count"))
+	stop := metrics.Timer(ctx, metrics.APIRequestDuration)
+	defer stop()
+
+	defer func(now time.Time) {
+		log.Debugw("handled.flagged-pieces-count", "took", time.Since(now).String())
+	}(time.Now())
+
+	out, err := s.db.FlaggedPiecesCount(ctx, filter)
+	if err != nil {
+		stats.Record(s.ctx, metrics.FailureFlaggedPiecesCountCount.M(1))
+		return 0, err
+	}
+	stats.Record(s.ctx, metrics.SuccessFlaggedPiecesCountCount.M(1))
+	return out, nil
+}
@@ -0,0 +1,647 @@
+package badger
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/boost/extern/boostd-data/metrics"
+	"github.com/filecoin-project/boost/extern/boostd-data/model"
+	"github.com/filecoin-project/boost/extern/boostd-data/shared/tracing"
+	"github.com/filecoin-project/boost/extern/boostd-data/svc/types"
+	"github.com/filecoin-project/go-address"
+	"github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log/v2"
+	mh "github.com/multiformats/go-multihash"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+)
+
+// The current piece metadata version. This version will be used when doing
+// data migrations (migrations are not yet implemented in version 1).
+const pieceMetadataVersion = "1"
+
+var log = logging.Logger("boostd-data-badger")
+
+type Store struct {
+	sync.Mutex
+	db       *DB
+	repopath string
+	ctx      context.Context
+
+	doctor pieceDoctorState
+}
+
+var _ types.ServiceImpl = (*Store)(nil)
+
+func NewStore(repopath string) *Store {
+	return &Store{repopath: repopath, doctor: newPieceDoctorState()}
+}
+
+func (s *Store) Start(ctx context.Context) error {
+	repopath := s.repopath
+	if repopath == "" {
+		// used by tests
+		var err error
+		repopath, err = os.MkdirTemp("", "ds-badger")
+		if err != nil {
+			return fmt.Errorf("creating badger tmp dir: %w", err)
+		}
+	}
+
+	var err error
+	s.db, err = newDB(repopath, false)
+	if err != nil {
+		return err
+	}
+
+	s.ctx = ctx
+
+	go func() {
+		<-ctx.Done()
+		if err := s.db.Close(); err != nil {
+			log.Errorw("closing badger db", "err", err)
+		}
+	}()
+
+	log.Debugw("new badger local index directory service", "repo path", repopath)
+	return nil
+}
+
+func (s *Store) AddDealForPiece(ctx context.Context, pieceCid cid.Cid, dealInfo model.DealInfo) error {
+	log.Debugw("handle.add-deal-for-piece", "piece-cid", pieceCid)
+
+	ctx, span := tracing.Tracer.Start(ctx, "store.add_deal_for_piece")
+	defer span.End()
+
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.Endpoint, "badger.add_deal_for_piece"))
+	stop := metrics.Timer(ctx, metrics.APIRequestDuration)
+	defer stop()
+
+	defer func(now time.Time) {
+		log.Debugw("handled.add-deal-for-piece", "took", time.Since(now).String())
+	}(time.Now())
+
+	s.Lock()
+	defer s.Unlock()
+
+	// Get the existing deals for the piece
+	md, err := s.db.GetPieceCidToMetadata(ctx, pieceCid)
+	if err != nil {
+		if !isNotFound(err) {
+			stats.Record(s.ctx, metrics.FailureAddDealForPieceCount.M(1))
+			return fmt.Errorf("getting piece cid metadata for piece %s: %w", pieceCid, err)
+		}
+		// there isn't yet any metadata, so create new metadata
+		md = model.Metadata{Version: pieceMetadataVersion}
+	}
+
+	// Check if the deal has already been added
+	for _, dl := range md.Deals {
+		if dl == dealInfo {
+			stats.Record(s.ctx, metrics.SuccessAddDealForPieceCount.M(1))
+			return nil
+		}
+	}
+
+	// Add the deal to the list
+	md.Deals = append(md.Deals, dealInfo)
+
+	// Write the piece metadata back to the db
+	err = s.db.SetPieceCidToMetadata(ctx, pieceCid, md)
+	if err != nil {
+		stats.Record(s.ctx, metrics.FailureAddDealForPieceCount.M(1))
+		return err
+	}
+
+	// A new deal on the miner means the piece should be checked again
+	err = s.db.RemovePieceUntracked(ctx, pieceCid, dealInfo.MinerAddr)
+	if err != nil {
+		stats.Record(s.ctx, metrics.FailureAddDealForPieceCount.M(1))
+		return fmt.Errorf("tracking piece %s: %w", pieceCid, err)
+	}
+
+	stats.Record(s.ctx, metrics.SuccessAddDealForPieceCount.M(1))
+	return nil
+}
+
+func (s *Store) GetOffsetSize(ctx context.Context, pieceCid cid.Cid, hash mh.Multihash) (*model.OffsetSize, error) {
+	log.Debugw("handle.get-offset-size", "piece-cid", pieceCid)
+
+	ctx, span := tracing.Tracer.Start(ctx, "store.get_offset_size")
+	defer span.End()
+
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.Endpoint, "badger.get_offset_size"))
+	stop := metrics.Timer(ctx, metrics.APIRequestDuration)
+	defer stop()
+
+	defer func(now time.Time) {
+		log.Debugw("handled.get-offset-size", "took", time.Since(now).String())
+	}(time.Now())
+
+	// Check that the piece exists
+	_, err := s.db.GetPieceCidToMetadata(ctx, pieceCid)
+	if err != nil {
+		stats.Record(s.ctx, metrics.FailureGetOffsetSizeCount.M(1))
+		return nil, normalizePieceCidError(pieceCid, err)
+	}
+
+	out, err := s.db.GetOffsetSize(ctx, pieceCid, hash)
+	if err != nil {
+		stats.Record(s.ctx, metrics.FailureGetOffsetSizeCount.M(1))
+		return nil, normalizeMultihashError(hash, err)
+	}
+
+	stats.Record(s.ctx, metrics.SuccessGetOffsetSizeCount.M(1))
+	return out, nil
+}
+
+func (s *Store) GetPieceMetadata(ctx context.Context, pieceCid cid.Cid) (model.Metadata, error) {
+	log.Debugw("handle.get-piece-metadata", "piece-cid", pieceCid)
+
+	ctx, span := tracing.Tracer.Start(ctx, "store.get_piece_metadata")
+	defer span.End()
+
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.Endpoint, "badger.get_piece_metadata"))
+	stop := metrics.Timer(ctx, metrics.APIRequestDuration)
+	defer stop()
+
+	defer func(now time.Time) {
+		log.Debugw("handled.get-piece-metadata", "took", time.Since(now).String())
+	}(time.Now())
+
+	md, err := s.db.GetPieceCidToMetadata(ctx, pieceCid)
+	if err != nil {
+		err = normalizePieceCidError(pieceCid, err)
+		stats.Record(s.ctx, metrics.FailureGetPieceMetadataCount.M(1))
+		return model.Metadata{}, fmt.Errorf("getting piece metadata for piece %s: %w", pieceCid, err)
+	}
+
+	stats.Record(s.ctx, metrics.SuccessGetPieceMetadataCount.M(1))
+	return md, nil
+}
+
+func (s *Store) GetPieceDeals(ctx context.Context, pieceCid cid.Cid) ([]model.DealInfo, error) {
+	log.Debugw("handle.get-piece-deals", "piece-cid", pieceCid)
+
+	ctx, span := tracing.Tracer.Start(ctx, "store.get_piece_deals")
+	defer span.End()
+
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.Endpoint, "badger.get_piece_deals"))
+	stop := metrics.Timer(ctx, metrics.APIRequestDuration)
+	defer stop()
+
+	defer func(now time.Time) {
+		log.Debugw("handled.get-piece-deals", "took", time.Since(now).String())
+	}(time.Now())
+
+	md, err := s.db.GetPieceCidToMetadata(ctx, pieceCid)
+	if err != nil {
+		err = normalizePieceCidError(pieceCid, err)
+		stats.Record(s.ctx, metrics.FailureGetPieceDealsCount.M(1))
+		return nil, fmt.Errorf("getting piece deals for piece %s: %w", pieceCid, err)
+	}
+
+	stats.Record(s.ctx, metrics.SuccessGetPieceDealsCount.M(1))
+	return md.Deals, nil
+}
+
+// Get all pieces that contain a multihash (used when retrieving by payload CID)
+func (s *Store) PiecesContainingMultihash(ctx context.Context, m mh.Multihash) ([]cid.Cid, error) {
+	log.Debugw("handle.pieces-containing-mh", "mh", m)
+
+	ctx, span := tracing.Tracer.Start(ctx, "store.pieces_containing_multihash")
+	defer span.End()
+
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.Endpoint, "badger.pieces_containing_multihash"))
+	stop := metrics.Timer(ctx, metrics.APIRequestDuration)
+	defer stop()
+
+	defer func(now time.Time) {
+		log.Debugw("handled.pieces-containing-mh", "took", time.Since(now).String())
+	}(time.Now())
+
+	pcs, err := s.db.GetPieceCidsByMultihash(ctx, m)
+	if err != nil {
+		stats.Record(s.ctx, metrics.FailurePiecesContainingMultihashCount.M(1))
+	} else {
+		stats.Record(s.ctx, metrics.SuccessPiecesContainingMultihashCount.M(1))
+	}
+	return pcs, normalizeMultihashError(m, err)
+}
+
+func (s *Store) GetIndex(ctx context.Context, pieceCid cid.Cid) (<-chan types.IndexRecord, error) {
+	log.Debugw("handle.get-index", "pieceCid", pieceCid)
+
+	ctx, span := tracing.Tracer.Start(ctx, "store.get_index")
+	defer span.End()
+
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.Endpoint, "badger.get_index"))
+	stop := metrics.Timer(ctx, metrics.APIRequestDuration)
+	defer stop()
+
+	defer func(now time.Time) {
+		log.Debugw("handled.get-index", "took", time.Since(now).String())
+	}(time.Now())
+
+	_, err := s.db.GetPieceCidToMetadata(ctx, pieceCid)
+	if err != nil {
+		stats.Record(s.ctx, metrics.FailureGetIndexCount.M(1))
+		return nil, normalizePieceCidError(pieceCid, err)
+	}
+
+	records, err := s.db.AllRecords(ctx, pieceCid)
+	if err != nil {
+		stats.Record(s.ctx, metrics.FailureGetIndexCount.M(1))
+		return nil, fmt.Errorf("getting all records for piece %s: %w", pieceCid, err)
+	}
+
+	recs := make(chan types.IndexRecord, len(records))
+	for _, r := range records {
+		recs <- types.IndexRecord{Record: r}
+	}
+	close(recs)
+
+	stats.Record(s.ctx, metrics.SuccessGetIndexCount.M(1))
+	return recs, nil
+}
+
+func (s *Store) IsIndexed(ctx context.Context, pieceCid cid.Cid) (bool, error) {
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.Endpoint, "badger.is_indexed"))
+	stop := metrics.Timer(ctx, metrics.APIRequestDuration)
+	defer stop()
+	md, err := s.db.GetPieceCidToMetadata(ctx, pieceCid)
+	if err != nil && !isNotFound(err) {
+		stats.Record(s.ctx, metrics.FailureIsIndexedCount.M(1))
+		return false, err
+	}
+	stats.Record(s.ctx, metrics.SuccessIsIndexedCount.M(1))
+	// A piece with a checkpoint is only partially indexed
+	return !md.IndexedAt.IsZero() && md.IndexCheckpoint == 0, nil
+}
+
+func (s *Store) IsCompleteIndex(ctx context.Context, pieceCid cid.Cid) (bool, error) {
+	log.Debugw("handle.is-complete-index", "pieceCid", pieceCid)
+
+	ctx, span := tracing.Tracer.Start(ctx, "store.is_incomplete_index")
+	defer span.End()
+
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.Endpoint, "badger.is_complete_index"))
+	stop := metrics.Timer(ctx, metrics.APIRequestDuration)
+	defer stop()
+
+	defer func(now time.Time) {
+		log.Debugw("handled.is-complete-index", "took", time.Since(now).String())
+	}(time.Now())
+
+	md, err := s.db.GetPieceCidToMetadata(ctx, pieceCid)
+	if err != nil {
+		stats.Record(s.ctx, metrics.FailureIsCompleteIndexCount.M(1))
+		return false, normalizePieceCidError(pieceCid, err)
+	}
+
+	stats.Record(s.ctx, metrics.SuccessIsCompleteIndexCount.M(1))
+	return md.CompleteIndex, nil
+}
+
+func (s *Store) SetIndexCheckpoint(ctx context.Context, pieceCid cid.Cid, checkpoint uint64) error {
+	log.Debugw("handle.set-index-checkpoint", "pieceCid", pieceCid, "checkpoint", checkpoint)
+
+	ctx, span := tracing.Tracer.Start(ctx, "store.set_index_checkpoint")
+	defer span.End()
+
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.Endpoint, "badger.set_index_checkpoint"))
+	stop := metrics.Timer(ctx, metrics.APIRequestDuration)
+	defer stop()
+
+	defer func(now time.Time) {
+		log.Debugw("handled.set-index-checkpoint", "took", time.Since(now).String())
+	}(time.Now())
+
+	s.Lock()
+	defer s.Unlock()
+
+	md, err := s.db.GetPieceCidToMetadata(ctx, pieceCid)
+	if err != nil {
+		if !isNotFound(err) {
+			stats.Record(s.ctx, metrics.FailureSetIndexCheckpointCount.M(1))
+			return fmt.Errorf("getting piece cid metadata for piece %s: %w", pieceCid, err)
+		}
+		// the checkpoint may be set before the first records are added,
+		// so create new metadata
+		md = model.Metadata{Version: pieceMetadataVersion}
+	}
+
+	md.IndexCheckpoint = checkpoint
+	err = s.db.SetPieceCidToMetadata(ctx, pieceCid, md)
+	if err != nil {
+		stats.Record(s.ctx, metrics.FailureSetIndexCheckpointCount.M(1))
+		return err
+	}
+
+	stats.Record(s.ctx, metrics.SuccessSetIndexCheckpointCount.M(1))
+	return nil
+}
+
+func (s *Store) AddIndex(ctx context.Context, pieceCid cid.Cid, records []model.Record, isCompleteIndex bool) <-chan types.AddIndexProgress {
+	log.Debugw("handle.add-index", "records", len(records))
+
+	ctx, span := tracing.Tracer.Start(ctx, "store.add_index")
+	defer span.End()
+
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.Endpoint, "badger.add_index"))
+	stop := metrics.Timer(ctx, metrics.APIRequestDuration)
+	defer stop()
+
+	defer func(now time.Time) {
+		log.Debugw("handled.add-index", "took", time.Since(now).String())
+	}(time.Now())
+
+	progress := make(chan types.AddIndexProgress, 1)
+	go func() {
+		defer close(progress)
+
+		s.Lock()
+		defer s.Unlock()
+
+		failureMetrics := true
+		defer func() {
+			if failureMetrics {
+				stats.Record(s.ctx, metrics.FailureAddIndexCount.M(1))
+			} else {
+				stats.Record(s.ctx, metrics.SuccessAddIndexCount.M(1))
+			}
+		}()
+
+		err := s.db.AddIndexRecords(ctx, pieceCid, records, func(p float64) {
+			select {
+			case progress <- types.AddIndexProgress{Progress: 0.9 * p}:
+			default:
+			}
+		})
+		if err != nil {
+			progress <- types.AddIndexProgress{Err: err.Error()}
+			return
+		}
+		progress <- types.AddIndexProgress{Progress: 0.9}
+
+		md, err := s.db.GetPieceCidToMetadata(ctx, pieceCid)
+		if err != nil {
+			if !isNotFound(err) {
+				progress <- types.AddIndexProgress{Err: err.Error()}
+				return
+			}
+			// there isn't yet any metadata, so create new metadata
+			md = model.Metadata{Version: pieceMetadataVersion}
+		}
+
+		// mark indexing as complete
+		md.CompleteIndex = isCompleteIndex
+		md.IndexedAt = time.Now()
+
+		err = s.db.SetPieceCidToMetadata(ctx, pieceCid, md)
+		if err != nil {
+			progress <- types.AddIndexProgress{Err: err.Error()}
+			return
+		}
+		progress <- types.AddIndexProgress{Progress: 1}
+		failureMetrics = false
+	}()
+
+	return progress
+}
+
+func (s *Store) IndexedAt(ctx context.Context, pieceCid cid.Cid) (time.Time, error) {
+	log.Debugw("handle.indexed-at", "pieceCid", pieceCid)
+
+	ctx, span := tracing.Tracer.Start(ctx, "store.indexed_at")
+	defer span.End()
+
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.Endpoint, "badger.indexed_at"))
+	stop := metrics.Timer(ctx, metrics.APIRequestDuration)
+	defer stop()
+
+	defer func(now time.Time) {
+		log.Debugw("handled.indexed-at", "took", time.Since(now).String())
+	}(time.Now())
+
+	md, err := s.db.GetPieceCidToMetadata(ctx, pieceCid)
+	if err != nil && !isNotFound(err) {
+		stats.Record(s.ctx, metrics.FailureIndexedAtCount.M(1))
+		return time.Time{}, err
+	}
+
+	stats.Record(s.ctx, metrics.SuccessIndexedAtCount.M(1))
+	return md.IndexedAt, nil
+}
+
+func (s *Store) ListPieces(ctx context.Context) ([]cid.Cid, error) {
+	log.Debugw("handle.list-pieces")
+
+	ctx, span := tracing.Tracer.Start(ctx, "store.list_pieces")
+	defer span.End()
+
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.Endpoint, "badger.list_pieces"))
+	stop := metrics.Timer(ctx, metrics.APIRequestDuration)
+	defer stop()
+
+	defer func(now time.Time) {
+		log.Debugw("handled.list-pieces", "took", time.Since(now).String())
+	}(time.Now())
+
+	out, err := s.db.ListPieces(ctx)
+	if err != nil {
+		stats.Record(s.ctx, metrics.FailureListPiecesCount.M(1))
+		return nil, err
+	}
+	stats.Record(s.ctx, metrics.SuccessListPiecesCount.M(1))
+	return out, nil
+}
+
+func normalizePieceCidError(pieceCid cid.Cid, err error) error {
+	if err == nil {
+		return nil
+	}
+	if isNotFound(err) {
+		return fmt.Errorf("piece %s: %s", pieceCid, types.ErrNotFound)
+	}
+	return err
+}
+
+func normalizeMultihashError(m mh.Multihash, err error) error {
+	if err == nil {
+		return nil
+	}
+	if isNotFound(err) {
+		return fmt.Errorf("multihash %s: %s", m, types.ErrNotFound)
+	}
+	return err
+}
+
+// RemoveDealForPiece removes a single deal for the piece. If there are no
+// deals left, the piece metadata and indexes are removed as well.
+func (s *Store) RemoveDealForPiece(ctx context.Context, pieceCid cid.Cid, dealUuid string) error {
+	log.Debugw("handle.remove-deal-for-piece", "piece-cid", pieceCid, "deal-uuid", dealUuid)
+
+	ctx, span := tracing.Tracer.Start(ctx, "store.remove_deal_for_piece")
+	defer span.End()
+
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.Endpoint, "badger.remove_deal_for_piece"))
+	stop := metrics.Timer(ctx, metrics.APIRequestDuration)
+	defer stop()
+
+	defer func(now time.Time) {
+		log.Debugw("handled.remove-deal-for-piece", "took", time.Since(now).String())
+	}(time.Now())
+
+	s.Lock()
+	defer s.Unlock()
+
+	failureMetrics := true
+	defer func() {
+		if failureMetrics {
+			stats.Record(s.ctx, metrics.FailureRemoveDealForPieceCount.M(1))
+		} else {
+			stats.Record(s.ctx, metrics.SuccessRemoveDealForPieceCount.M(1))
+		}
+	}()
+
+	md, err := s.db.GetPieceCidToMetadata(ctx, pieceCid)
+	if err != nil {
+		if isNotFound(err) {
+			failureMetrics = false
+			return nil
+		}
+		return err
+	}
+
+	for i, v := range md.Deals {
+		if v.DealUuid == dealUuid {
+			md.Deals[i] = md.Deals[len(md.Deals)-1]
+			md.Deals = md.Deals[:len(md.Deals)-1]
+			break
+		}
+	}
+
+	if len(md.Deals) == 0 {
+		// Remove Metadata if removed deal was last one
+		if err := s.db.RemovePieceMetadata(ctx, pieceCid); err != nil {
+			return fmt.Errorf("failed to remove the Metadata after removing the last deal: %w", err)
+		}
+		failureMetrics = false
+		return nil
+	}
+
+	err = s.db.SetPieceCidToMetadata(ctx, pieceCid, md)
+	if err != nil {
+		return err
+	}
+
+	failureMetrics = false
+	return nil
+}
+
+// RemovePieceMetadata removes the metadata and the indexes for the piece
+func (s *Store) RemovePieceMetadata(ctx context.Context, pieceCid cid.Cid) error {
+	log.Debugw("handle.remove-piece-metadata", "piece-cid", pieceCid)
+
+	ctx, span := tracing.Tracer.Start(ctx, "store.remove_piece_metadata")
+	defer span.End()
+
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.Endpoint, "badger.remove_piece_metadata"))
+	stop := metrics.Timer(ctx, metrics.APIRequestDuration)
+	defer stop()
+
+	defer func(now time.Time) {
+		log.Debugw("handled.remove-piece-metadata", "took", time.Since(now).String())
+	}(time.Now())
+
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.db.RemovePieceMetadata(ctx, pieceCid); err != nil {
+		stats.Record(s.ctx, metrics.FailureRemovePieceMetadataCount.M(1))
+		return normalizePieceCidError(pieceCid, err)
+	}
+
+	stats.Record(s.ctx, metrics.SuccessRemovePieceMetadataCount.M(1))
+	return nil
+}
+
+// RemoveIndexes removes all multihashes for the piece. To be used manually in
+// case of failure in RemoveDealForPiece or RemovePieceMetadata. Metadata for
+// the piece must be present in the database
+func (s *Store) RemoveIndexes(ctx context.Context, pieceCid cid.Cid) error {
+	log.Debugw("handle.remove-indexes", "piece-cid", pieceCid)
+
+	ctx, span := tracing.Tracer.Start(ctx, "store.remove_indexes")
+	defer span.End()
+
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.Endpoint, "badger.remove_indexes"))
+	stop := metrics.Timer(ctx, metrics.APIRequestDuration)
+	defer stop()
+
+	defer func(now time.Time) {
+		log.Debugw("handled.remove-indexes", "took", time.Since(now).String())
+	}(time.Now())
+
+	s.Lock()
+	defer s.Unlock()
+
+	failureMetrics := true
+	defer func() {
+		if failureMetrics {
+			stats.Record(s.ctx, metrics.FailureRemoveIndexesCount.M(1))
+		} else {
+			stats.Record(s.ctx, metrics.SuccessRemoveIndexesCount.M(1))
+		}
+	}()
+
+	md, err := s.db.GetPieceCidToMetadata(ctx, pieceCid)
+	if err != nil {
+		return normalizePieceCidError(pieceCid, err)
+	}
+
+	if err := s.db.RemoveIndexes(ctx, pieceCid); err != nil {
+		return err
+	}
+
+	md.IndexedAt = time.Time{}
+	md.IndexCheckpoint = 0
+
+	if err = s.db.SetPieceCidToMetadata(ctx, pieceCid, md); err == nil {
+		failureMetrics = false
+	}
+
+	return err
+}
+
+func (s *Store) UntrackPiece(ctx context.Context, pieceCid cid.Cid, maddr address.Address) error {
+	log.Debugw("handle.untrack-piece", "piece-cid", pieceCid)
+
+	ctx, span := tracing.Tracer.Start(ctx, "store.untrack_piece")
+	defer span.End()
+
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.Endpoint, "badger.untrack_piece"))
+	stop := metrics.Timer(ctx, metrics.APIRequestDuration)
+	defer stop()
+
+	defer func(now time.Time) {
+		log.Debugw("handled.untrack-piece", "took", time.Since(now).String())
+	}(time.Now())
+
+	// Badger does not have a separate piece tracker table: the pieces to be
+	// checked are picked from the piece metadata, so mark the piece as
+	// untracked for the miner instead. The mark is cleared if a new deal for
+	// the piece is added on the miner.
+	err := s.db.SetPieceUntracked(ctx, pieceCid, maddr)
+	if err != nil {
+		stats.Record(s.ctx, metrics.FailureUntrackPieceCount.M(1))
+		return fmt.Errorf("untracking piece %s: %w", pieceCid, err)
+	}
+
+	stats.Record(s.ctx, metrics.SuccessUntrackPieceCount.M(1))
+	return nil
+}
@@ -3,6 +3,8 @@ package client
 import (
 	"context"
 	"fmt"
+	"sort"
+	"sync/atomic"
 	"time"
 
 	"github.com/filecoin-project/boost/extern/boostd-data/model"
@@ -13,37 +15,59 @@ import (
 	logger "github.com/ipfs/go-log/v2"
 	"github.com/ipld/go-car/v2/index"
 	mh "github.com/multiformats/go-multihash"
+	"golang.org/x/sync/errgroup"
 )
 
 var log = logger.Logger("boostd-data-client")
 
+// rpcClient is the RPC client for a single boostd-data service
+type rpcClient struct {
+	AddDealForPiece           func(context.Context, cid.Cid, model.DealInfo) error
+	AddIndex                  func(context.Context, cid.Cid, []model.Record, bool) <-chan types.AddIndexProgress
+	IsIndexed                 func(ctx context.Context, pieceCid cid.Cid) (bool, error)
+	IsCompleteIndex           func(ctx context.Context, pieceCid cid.Cid) (bool, error)
+	SetIndexCheckpoint        func(ctx context.Context, pieceCid cid.Cid, checkpoint uint64) error
+	GetIndex                  func(context.Context, cid.Cid) (<-chan types.IndexRecord, error)
+	GetOffsetSize             func(context.Context, cid.Cid, mh.Multihash) (*model.OffsetSize, error)
+	ListPieces                func(ctx context.Context) ([]cid.Cid, error)
+	PiecesCount               func(ctx context.Context, maddr address.Address) (int, error)
+	ScanProgress              func(ctx context.Context, maddr address.Address) (*types.ScanProgress, error)
+	GetPieceMetadata          func(ctx context.Context, pieceCid cid.Cid) (model.Metadata, error)
+	GetPieceDeals             func(context.Context, cid.Cid) ([]model.DealInfo, error)
+	IndexedAt                 func(context.Context, cid.Cid) (time.Time, error)
+	PiecesContainingMultihash func(context.Context, mh.Multihash) ([]cid.Cid, error)
+	RemoveDealForPiece        func(context.Context, cid.Cid, string) error
+	RemovePieceMetadata       func(context.Context, cid.Cid) error
+	RemoveIndexes             func(context.Context, cid.Cid) error
+	NextPiecesToCheck         func(ctx context.Context, maddr address.Address) ([]cid.Cid, error)
+	FlagPiece                 func(ctx context.Context, pieceCid cid.Cid, hasUnsealedDeal bool, maddr address.Address, reason model.FlagReason, details string) error
+	UnflagPiece               func(ctx context.Context, pieceCid cid.Cid, maddr address.Address) error
+	FlaggedPiecesList         func(ctx context.Context, filter *types.FlaggedPiecesListFilter, cursor *time.Time, offset int, limit int) ([]model.FlaggedPiece, error)
+	FlaggedPiecesCount        func(ctx context.Context, filter *types.FlaggedPiecesListFilter) (int, error)
+	UntrackPiece              func(ctx context.Context, pieceCid cid.Cid, maddr address.Address) error
+}
+
+// shard is a connection to one of the boostd-data services that the pieces
+// are distributed across
+type shard struct {
+	addr   string
+	client rpcClient
+	closer jsonrpc.ClientCloser
+}
+
+// Store is a client for the local index directory.
+// The pieces may be distributed across a sharded set of boostd-data
+// services, in which case each piece is stored on the shard that it maps to
+// on a consistent hash ring, and queries that are not for a particular piece
+// are sent to all shards.
 type Store struct {
-	client struct {
-		AddDealForPiece           func(context.Context, cid.Cid, model.DealInfo) error
-		AddIndex                  func(context.Context, cid.Cid, []model.Record, bool) <-chan types.AddIndexProgress
-		IsIndexed                 func(ctx context.Context, pieceCid cid.Cid) (bool, error)
-		IsCompleteIndex           func(ctx context.Context, pieceCid cid.Cid) (bool, error)
-		GetIndex                  func(context.Context, cid.Cid) (<-chan types.IndexRecord, error)
-		GetOffsetSize             func(context.Context, cid.Cid, mh.Multihash) (*model.OffsetSize, error)
-		ListPieces                func(ctx context.Context) ([]cid.Cid, error)
-		PiecesCount               func(ctx context.Context, maddr address.Address) (int, error)
-		ScanProgress              func(ctx context.Context, maddr address.Address) (*types.ScanProgress, error)
-		GetPieceMetadata          func(ctx context.Context, pieceCid cid.Cid) (model.Metadata, error)
-		GetPieceDeals             func(context.Context, cid.Cid) ([]model.DealInfo, error)
-		IndexedAt                 func(context.Context, cid.Cid) (time.Time, error)
-		PiecesContainingMultihash func(context.Context, mh.Multihash) ([]cid.Cid, error)
-		RemoveDealForPiece        func(context.Context, cid.Cid, string) error
-		RemovePieceMetadata       func(context.Context, cid.Cid) error
-		RemoveIndexes             func(context.Context, cid.Cid) error
-		NextPiecesToCheck         func(ctx context.Context, maddr address.Address) ([]cid.Cid, error)
-		FlagPiece                 func(ctx context.Context, pieceCid cid.Cid, hasUnsealedDeal bool, maddr address.Address) error
-		UnflagPiece               func(ctx context.Context, pieceCid cid.Cid, maddr address.Address) error
-		FlaggedPiecesList         func(ctx context.Context, filter *types.FlaggedPiecesListFilter, cursor *time.Time, offset int, limit int) ([]model.FlaggedPiece, error)
-		FlaggedPiecesCount        func(ctx context.Context, filter *types.FlaggedPiecesListFilter) (int, error)
-		UntrackPiece              func(ctx context.Context, pieceCid cid.Cid, maddr address.Address) error
-	}
-	closer   jsonrpc.ClientCloser
+	shards   []*shard
+	ring     *hashRing
 	dialOpts []jsonrpc.Option
+
+	// set until a rebalance completes, while pieces may not yet have been
+	// moved to the shard they map to
+	rebalancing atomic.Bool
 }
 
 func NewStore(dialOpts ...jsonrpc.Option) *Store {
@@ -51,22 +75,90 @@ func NewStore(dialOpts ...jsonrpc.Option) *Store {
 }
 
 func (s *Store) Dial(ctx context.Context, addr string) error {
-	var err error
-	s.closer, err = jsonrpc.NewMergeClient(ctx, addr, "boostddata", []interface{}{&s.client}, nil, s.dialOpts...)
-	if err != nil {
-		return fmt.Errorf("dialing local index directory server: %w", err)
+	return s.DialShards(ctx, []string{addr})
+}
+
+// DialShards connects to a sharded set of boostd-data services.
+// Pieces are placed on shards according to the shard address, so the
+// same addresses must be used each time the store is dialed.
+func (s *Store) DialShards(ctx context.Context, addrs []string) error {
+	if len(addrs) == 0 {
+		return fmt.Errorf("dialing local index directory server: no address specified")
+	}
+
+	for _, addr := range addrs {
+		sh := &shard{addr: addr}
+		closer, err := jsonrpc.NewMergeClient(ctx, addr, "boostddata", []interface{}{&sh.client}, nil, s.dialOpts...)
+		if err != nil {
+			s.Close(ctx)
+			return fmt.Errorf("dialing local index directory server %s: %w", addr, err)
+		}
+		sh.closer = closer
+		s.shards = append(s.shards, sh)
 	}
+	s.ring = newHashRing(addrs)
+	s.rebalancing.Store(len(s.shards) > 1)
 	return nil
 }
 
 func (s *Store) Close(_ context.Context) {
-	if s != nil && s.closer != nil {
-		s.closer()
+	if s == nil {
+		return
+	}
+	for _, sh := range s.shards {
+		if sh.closer != nil {
+			sh.closer()
+		}
+	}
+}
+
+// shardFor returns the shard that the piece is placed on
+func (s *Store) shardFor(pieceCid cid.Cid) *shard {
+	return s.shards[s.ring.locate(pieceCid.Bytes())]
+}
+
+// withPieceShard calls fn with the client for the shard that the piece is
+// placed on. Until a rebalance has completed the piece may not have been
+// moved to that shard yet, so if it is not found there the other shards are
+// tried.
+func withPieceShard[T any](s *Store, pieceCid cid.Cid, fn func(*rpcClient) (T, error)) (T, error) {
+	owner := s.shardFor(pieceCid)
+	res, err := fn(&owner.client)
+	if !types.IsNotFound(err) || !s.rebalancing.Load() {
+		return res, err
+	}
+
+	for _, sh := range s.shards {
+		if sh == owner {
+			continue
+		}
+		shRes, shErr := fn(&sh.client)
+		if !types.IsNotFound(shErr) {
+			return shRes, shErr
+		}
 	}
+	return res, err
+}
+
+// forEachShard calls fn with the client for each shard in parallel
+func (s *Store) forEachShard(ctx context.Context, fn func(ctx context.Context, i int, cl *rpcClient) error) error {
+	eg, ctx := errgroup.WithContext(ctx)
+	for i, sh := range s.shards {
+		i, sh := i, sh
+		eg.Go(func() error {
+			if err := fn(ctx, i, &sh.client); err != nil {
+				return fmt.Errorf("local index directory shard %s: %w", sh.addr, err)
+			}
+			return nil
+		})
+	}
+	return eg.Wait()
 }
 
 func (s *Store) GetIndex(ctx context.Context, pieceCid cid.Cid) (index.Index, error) {
-	resp, err := s.client.GetIndex(ctx, pieceCid)
+	resp, err := withPieceShard(s, pieceCid, func(cl *rpcClient) (<-chan types.IndexRecord, error) {
+		return cl.GetIndex(ctx, pieceCid)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -92,7 +184,9 @@ func (s *Store) GetIndex(ctx context.Context, pieceCid cid.Cid) (index.Index, er
 }
 
 func (s *Store) GetRecords(ctx context.Context, pieceCid cid.Cid) ([]model.Record, error) {
-	resp, err := s.client.GetIndex(ctx, pieceCid)
+	resp, err := withPieceShard(s, pieceCid, func(cl *rpcClient) (<-chan types.IndexRecord, error) {
+		return cl.GetIndex(ctx, pieceCid)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -111,25 +205,61 @@ func (s *Store) GetRecords(ctx context.Context, pieceCid cid.Cid) ([]model.Recor
 }
 
 func (s *Store) GetPieceMetadata(ctx context.Context, pieceCid cid.Cid) (model.Metadata, error) {
-	return s.client.GetPieceMetadata(ctx, pieceCid)
+	return withPieceShard(s, pieceCid, func(cl *rpcClient) (model.Metadata, error) {
+		return cl.GetPieceMetadata(ctx, pieceCid)
+	})
 }
 
 func (s *Store) GetPieceDeals(ctx context.Context, pieceCid cid.Cid) ([]model.DealInfo, error) {
-	return s.client.GetPieceDeals(ctx, pieceCid)
+	return withPieceShard(s, pieceCid, func(cl *rpcClient) ([]model.DealInfo, error) {
+		return cl.GetPieceDeals(ctx, pieceCid)
+	})
 }
 
+// PiecesContainingMultihash queries all shards for pieces containing the
+// multihash, and returns the combined set of pieces
 func (s *Store) PiecesContainingMultihash(ctx context.Context, m mh.Multihash) ([]cid.Cid, error) {
-	return s.client.PiecesContainingMultihash(ctx, m)
+	if len(s.shards) == 1 {
+		return s.shards[0].client.PiecesContainingMultihash(ctx, m)
+	}
+
+	results := make([][]cid.Cid, len(s.shards))
+	err := s.forEachShard(ctx, func(ctx context.Context, i int, cl *rpcClient) error {
+		pcids, err := cl.PiecesContainingMultihash(ctx, m)
+		if err != nil && !types.IsNotFound(err) {
+			return err
+		}
+		results[i] = pcids
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	pieceCids := mergeCids(results)
+	if len(pieceCids) == 0 {
+		return nil, fmt.Errorf("multihash %s: %w", m, types.ErrNotFound)
+	}
+	return pieceCids, nil
 }
 
 func (s *Store) AddDealForPiece(ctx context.Context, pieceCid cid.Cid, dealInfo model.DealInfo) error {
-	return s.client.AddDealForPiece(ctx, pieceCid, dealInfo)
+	return s.shardFor(pieceCid).client.AddDealForPiece(ctx, pieceCid, dealInfo)
 }
 
 func (s *Store) AddIndex(ctx context.Context, pieceCid cid.Cid, records []model.Record, isCompleteIndex bool) error {
 	log.Debugw("add-index", "piece-cid", pieceCid, "records", len(records))
 
-	respch := s.client.AddIndex(ctx, pieceCid, records, isCompleteIndex)
+	return addIndex(ctx, &s.shardFor(pieceCid).client, pieceCid, records, isCompleteIndex)
+}
+
+func addIndex(ctx context.Context, cl *rpcClient, pieceCid cid.Cid, records []model.Record, isCompleteIndex bool) error {
+	respch := cl.AddIndex(ctx, pieceCid, records, isCompleteIndex)
+	if respch == nil {
+		// The RPC method has no error return, so a failed call returns a
+		// nil channel
+		return fmt.Errorf("add index with piece cid %s: request failed", pieceCid)
+	}
 	for resp := range respch {
 		if resp.Err != "" {
 			return fmt.Errorf("add index with piece cid %s: %s", pieceCid, resp.Err)
@@ -140,65 +270,235 @@ func (s *Store) AddIndex(ctx context.Context, pieceCid cid.Cid, records []model.
 }
 
 func (s *Store) IsIndexed(ctx context.Context, pieceCid cid.Cid) (bool, error) {
-	return s.client.IsIndexed(ctx, pieceCid)
+	return withPieceShard(s, pieceCid, func(cl *rpcClient) (bool, error) {
+		return cl.IsIndexed(ctx, pieceCid)
+	})
 }
 
 func (s *Store) IsCompleteIndex(ctx context.Context, pieceCid cid.Cid) (bool, error) {
-	return s.client.IsCompleteIndex(ctx, pieceCid)
+	return withPieceShard(s, pieceCid, func(cl *rpcClient) (bool, error) {
+		return cl.IsCompleteIndex(ctx, pieceCid)
+	})
+}
+
+// SetIndexCheckpoint records the offset in the piece up to which the index
+// has been added
+func (s *Store) SetIndexCheckpoint(ctx context.Context, pieceCid cid.Cid, checkpoint uint64) error {
+	return s.shardFor(pieceCid).client.SetIndexCheckpoint(ctx, pieceCid, checkpoint)
 }
 
 func (s *Store) IndexedAt(ctx context.Context, pieceCid cid.Cid) (time.Time, error) {
-	return s.client.IndexedAt(ctx, pieceCid)
+	return withPieceShard(s, pieceCid, func(cl *rpcClient) (time.Time, error) {
+		return cl.IndexedAt(ctx, pieceCid)
+	})
 }
 
 func (s *Store) GetOffsetSize(ctx context.Context, pieceCid cid.Cid, hash mh.Multihash) (*model.OffsetSize, error) {
-	return s.client.GetOffsetSize(ctx, pieceCid, hash)
+	return withPieceShard(s, pieceCid, func(cl *rpcClient) (*model.OffsetSize, error) {
+		return cl.GetOffsetSize(ctx, pieceCid, hash)
+	})
 }
 
 func (s *Store) RemoveDealForPiece(ctx context.Context, pieceCid cid.Cid, dealId string) error {
-	return s.client.RemoveDealForPiece(ctx, pieceCid, dealId)
+	_, err := withPieceShard(s, pieceCid, func(cl *rpcClient) (struct{}, error) {
+		return struct{}{}, cl.RemoveDealForPiece(ctx, pieceCid, dealId)
+	})
+	return err
 }
 
 func (s *Store) RemovePieceMetadata(ctx context.Context, pieceCid cid.Cid) error {
-	return s.client.RemovePieceMetadata(ctx, pieceCid)
+	_, err := withPieceShard(s, pieceCid, func(cl *rpcClient) (struct{}, error) {
+		return struct{}{}, cl.RemovePieceMetadata(ctx, pieceCid)
+	})
+	return err
 }
 
 func (s *Store) RemoveIndexes(ctx context.Context, pieceCid cid.Cid) error {
-	return s.client.RemoveIndexes(ctx, pieceCid)
+	_, err := withPieceShard(s, pieceCid, func(cl *rpcClient) (struct{}, error) {
+		return struct{}{}, cl.RemoveIndexes(ctx, pieceCid)
+	})
+	return err
 }
 
 func (s *Store) ListPieces(ctx context.Context) ([]cid.Cid, error) {
-	return s.client.ListPieces(ctx)
+	if len(s.shards) == 1 {
+		return s.shards[0].client.ListPieces(ctx)
+	}
+
+	results := make([][]cid.Cid, len(s.shards))
+	err := s.forEachShard(ctx, func(ctx context.Context, i int, cl *rpcClient) error {
+		pcids, err := cl.ListPieces(ctx)
+		results[i] = pcids
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mergeCids(results), nil
 }
 
 func (s *Store) PiecesCount(ctx context.Context, maddr address.Address) (int, error) {
-	return s.client.PiecesCount(ctx, maddr)
+	counts, err := s.piecesCounts(ctx, maddr)
+	if err != nil {
+		return 0, err
+	}
+
+	var total int
+	for _, c := range counts {
+		total += c
+	}
+	return total, nil
+}
+
+func (s *Store) piecesCounts(ctx context.Context, maddr address.Address) ([]int, error) {
+	counts := make([]int, len(s.shards))
+	err := s.forEachShard(ctx, func(ctx context.Context, i int, cl *rpcClient) error {
+		count, err := cl.PiecesCount(ctx, maddr)
+		counts[i] = count
+		return err
+	})
+	return counts, err
 }
 
+// ScanProgress returns the progress of the piece doctor scan across all
+// shards, weighted by the number of pieces on each shard. The last scan time
+// is the time of the least recent scan.
 func (s *Store) ScanProgress(ctx context.Context, maddr address.Address) (*types.ScanProgress, error) {
-	return s.client.ScanProgress(ctx, maddr)
+	if len(s.shards) == 1 {
+		return s.shards[0].client.ScanProgress(ctx, maddr)
+	}
+
+	counts, err := s.piecesCounts(ctx, maddr)
+	if err != nil {
+		return nil, err
+	}
+
+	progs := make([]*types.ScanProgress, len(s.shards))
+	err = s.forEachShard(ctx, func(ctx context.Context, i int, cl *rpcClient) error {
+		prog, err := cl.ScanProgress(ctx, maddr)
+		progs[i] = prog
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var total int
+	var scanned float64
+	res := &types.ScanProgress{}
+	for i, prog := range progs {
+		total += counts[i]
+		scanned += prog.Progress * float64(counts[i])
+		if res.LastScan.IsZero() || prog.LastScan.Before(res.LastScan) {
+			res.LastScan = prog.LastScan
+		}
+	}
+	if total > 0 {
+		res.Progress = scanned / float64(total)
+	}
+	return res, nil
 }
 
 func (s *Store) NextPiecesToCheck(ctx context.Context, maddr address.Address) ([]cid.Cid, error) {
-	return s.client.NextPiecesToCheck(ctx, maddr)
+	if len(s.shards) == 1 {
+		return s.shards[0].client.NextPiecesToCheck(ctx, maddr)
+	}
+
+	results := make([][]cid.Cid, len(s.shards))
+	err := s.forEachShard(ctx, func(ctx context.Context, i int, cl *rpcClient) error {
+		pcids, err := cl.NextPiecesToCheck(ctx, maddr)
+		results[i] = pcids
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mergeCids(results), nil
 }
 
-func (s *Store) FlagPiece(ctx context.Context, pieceCid cid.Cid, hasUnsealedDeal bool, maddr address.Address) error {
-	return s.client.FlagPiece(ctx, pieceCid, hasUnsealedDeal, maddr)
+func (s *Store) FlagPiece(ctx context.Context, pieceCid cid.Cid, hasUnsealedDeal bool, maddr address.Address, reason model.FlagReason, details string) error {
+	return s.shardFor(pieceCid).client.FlagPiece(ctx, pieceCid, hasUnsealedDeal, maddr, reason, details)
 }
 
 func (s *Store) UnflagPiece(ctx context.Context, pieceCid cid.Cid, maddr address.Address) error {
-	return s.client.UnflagPiece(ctx, pieceCid, maddr)
+	return s.shardFor(pieceCid).client.UnflagPiece(ctx, pieceCid, maddr)
 }
 
+// FlaggedPiecesList gets the flagged pieces from all shards, and returns the
+// page of pieces at the given offset, ordered by most recently flagged first
 func (s *Store) FlaggedPiecesList(ctx context.Context, filter *types.FlaggedPiecesListFilter, cursor *time.Time, offset int, limit int) ([]model.FlaggedPiece, error) {
-	return s.client.FlaggedPiecesList(ctx, filter, cursor, offset, limit)
+	if len(s.shards) == 1 {
+		return s.shards[0].client.FlaggedPiecesList(ctx, filter, cursor, offset, limit)
+	}
+
+	// The page could be made up of pieces from any of the shards, so get
+	// all pieces up to the end of the page from each shard
+	shardLimit := 0
+	if limit > 0 {
+		shardLimit = offset + limit
+	}
+	results := make([][]model.FlaggedPiece, len(s.shards))
+	err := s.forEachShard(ctx, func(ctx context.Context, i int, cl *rpcClient) error {
+		pieces, err := cl.FlaggedPiecesList(ctx, filter, cursor, 0, shardLimit)
+		results[i] = pieces
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var pieces []model.FlaggedPiece
+	for _, res := range results {
+		pieces = append(pieces, res...)
+	}
+	sort.Slice(pieces, func(i, j int) bool {
+		return pieces[i].CreatedAt.After(pieces[j].CreatedAt)
+	})
+
+	if offset >= len(pieces) {
+		return []model.FlaggedPiece{}, nil
+	}
+	pieces = pieces[offset:]
+	if limit > 0 && len(pieces) > limit {
+		pieces = pieces[:limit]
+	}
+	return pieces, nil
 }
 
 func (s *Store) FlaggedPiecesCount(ctx context.Context, filter *types.FlaggedPiecesListFilter) (int, error) {
-	return s.client.FlaggedPiecesCount(ctx, filter)
+	counts := make([]int, len(s.shards))
+	err := s.forEachShard(ctx, func(ctx context.Context, i int, cl *rpcClient) error {
+		count, err := cl.FlaggedPiecesCount(ctx, filter)
+		counts[i] = count
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var total int
+	for _, c := range counts {
+		total += c
+	}
+	return total, nil
 }
 
 func (s *Store) UntrackPiece(ctx context.Context, pieceCid cid.Cid, maddr address.Address) error {
-	return s.client.UntrackPiece(ctx, pieceCid, maddr)
+	return s.shardFor(pieceCid).client.UntrackPiece(ctx, pieceCid, maddr)
+}
+
+// mergeCids returns the distinct cids in the given lists
+func mergeCids(lists [][]cid.Cid) []cid.Cid {
+	seen := make(map[cid.Cid]struct{})
+	var merged []cid.Cid
+	for _, l := range lists {
+		for _, c := range l {
+			if _, ok := seen[c]; ok {
+				continue
+			}
+			seen[c] = struct{}{}
+			merged = append(merged, c)
+		}
+	}
+	return merged
 }
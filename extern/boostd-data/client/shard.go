@@ -0,0 +1,223 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+
+	"github.com/filecoin-project/boost/extern/boostd-data/model"
+	"github.com/ipfs/go-cid"
+)
+
+// The number of points on the hash ring for each shard. More points give a
+// more even distribution of pieces across shards.
+const ringPointsPerShard = 128
+
+// hashRing maps keys to shards by consistent hashing, so that when a shard
+// is added only the keys that map to the new shard move
+type hashRing struct {
+	points []uint64
+	shards map[uint64]int
+}
+
+func newHashRing(addrs []string) *hashRing {
+	r := &hashRing{shards: make(map[uint64]int, len(addrs)*ringPointsPerShard)}
+	for i, addr := range addrs {
+		for p := 0; p < ringPointsPerShard; p++ {
+			h := ringHash([]byte(addr + "-" + strconv.Itoa(p)))
+			r.points = append(r.points, h)
+			r.shards[h] = i
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r
+}
+
+// locate returns the index of the shard that the key maps to
+func (r *hashRing) locate(key []byte) int {
+	h := ringHash(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.shards[r.points[i]]
+}
+
+func ringHash(b []byte) uint64 {
+	sum := sha256.Sum256(b)
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// rebalanceState is persisted when a rebalance completes with no errors
+type rebalanceState struct {
+	// The addresses of the shards that the pieces were balanced across
+	Shards []string
+}
+
+// Rebalance moves each piece that is not on the shard it maps to on the hash
+// ring to that shard. It should be run after a shard is added.
+// Until a rebalance completes with no errors, lookups for a piece that is
+// not found on the shard it maps to fall back to the other shards.
+// If statePath is not empty, the shards that the pieces are balanced across
+// are saved to the file when the rebalance completes, and the next rebalance
+// with the same shards doesn't need to move any pieces.
+// Errors moving individual pieces don't stop the rebalance; they are all
+// returned together once the other pieces have been moved.
+// Returns the number of pieces that were moved.
+func (s *Store) Rebalance(ctx context.Context, statePath string) (int, error) {
+	if len(s.shards) < 2 {
+		return 0, nil
+	}
+
+	addrs := s.shardAddrs()
+	if statePath != "" {
+		balanced, err := readRebalanceState(statePath)
+		if err != nil {
+			return 0, err
+		}
+		if slices.Equal(balanced, addrs) {
+			s.rebalancing.Store(false)
+			return 0, nil
+		}
+	}
+
+	var moved int
+	var errs []error
+	for _, src := range s.shards {
+		pieceCids, err := src.client.ListPieces(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return moved, ctx.Err()
+			}
+			errs = append(errs, fmt.Errorf("listing pieces on local index directory shard %s: %w", src.addr, err))
+			continue
+		}
+
+		for _, pieceCid := range pieceCids {
+			dst := s.shardFor(pieceCid)
+			if dst == src {
+				continue
+			}
+
+			if err := movePiece(ctx, pieceCid, src, dst); err != nil {
+				if ctx.Err() != nil {
+					return moved, ctx.Err()
+				}
+				log.Warnw("moving piece between local index directory shards", "piece-cid", pieceCid, "err", err)
+				errs = append(errs, err)
+				continue
+			}
+			moved++
+		}
+	}
+
+	if moved > 0 {
+		log.Infow("rebalanced local index directory shards", "moved", moved, "failed", len(errs), "shards", len(s.shards))
+	}
+	if len(errs) > 0 {
+		return moved, fmt.Errorf("rebalancing local index directory shards: %d errors: %w", len(errs), errors.Join(errs...))
+	}
+
+	if statePath != "" {
+		if err := writeRebalanceState(statePath, addrs); err != nil {
+			return moved, err
+		}
+	}
+	s.rebalancing.Store(false)
+	return moved, nil
+}
+
+// shardAddrs returns the sorted addresses of the shards
+func (s *Store) shardAddrs() []string {
+	addrs := make([]string, 0, len(s.shards))
+	for _, sh := range s.shards {
+		addrs = append(addrs, sh.addr)
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+// readRebalanceState returns the shards that the pieces were balanced across
+// by the last rebalance that completed, or nil if there hasn't been one
+func readRebalanceState(path string) ([]string, error) {
+	bz, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading local index directory rebalance state: %w", err)
+	}
+
+	var st rebalanceState
+	if err := json.Unmarshal(bz, &st); err != nil {
+		return nil, fmt.Errorf("parsing local index directory rebalance state %s: %w", path, err)
+	}
+	sort.Strings(st.Shards)
+	return st.Shards, nil
+}
+
+func writeRebalanceState(path string, addrs []string) error {
+	bz, err := json.Marshal(rebalanceState{Shards: addrs})
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file and rename it, so that the state is never
+	// partially written
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, bz, 0644); err != nil {
+		return fmt.Errorf("writing local index directory rebalance state: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("writing local index directory rebalance state: %w", err)
+	}
+	return nil
+}
+
+// movePiece copies the index and deals for a piece from one shard to another,
+// and then removes the piece from the source shard
+func movePiece(ctx context.Context, pieceCid cid.Cid, src *shard, dst *shard) error {
+	log.Debugw("moving piece between local index directory shards", "piece-cid", pieceCid, "from", src.addr, "to", dst.addr)
+
+	md, err := src.client.GetPieceMetadata(ctx, pieceCid)
+	if err != nil {
+		return fmt.Errorf("getting metadata for piece %s from shard %s: %w", pieceCid, src.addr, err)
+	}
+
+	if !md.IndexedAt.IsZero() {
+		respch, err := src.client.GetIndex(ctx, pieceCid)
+		if err != nil {
+			return fmt.Errorf("getting index for piece %s from shard %s: %w", pieceCid, src.addr, err)
+		}
+
+		var records []model.Record
+		for r := range respch {
+			if r.Error != nil {
+				return fmt.Errorf("getting index for piece %s from shard %s: %w", pieceCid, src.addr, r.Error)
+			}
+			records = append(records, r.Record)
+		}
+
+		if err := addIndex(ctx, &dst.client, pieceCid, records, md.CompleteIndex); err != nil {
+			return fmt.Errorf("adding index to shard %s: %w", dst.addr, err)
+		}
+	}
+
+	for _, dl := range md.Deals {
+		if err := dst.client.AddDealForPiece(ctx, pieceCid, dl); err != nil {
+			return fmt.Errorf("adding deal %s for piece %s to shard %s: %w", dl.DealUuid, pieceCid, dst.addr, err)
+		}
+	}
+
+	if err := src.client.RemovePieceMetadata(ctx, pieceCid); err != nil {
+		return fmt.Errorf("removing piece %s from shard %s: %w", pieceCid, src.addr, err)
+	}
+	return nil
+}
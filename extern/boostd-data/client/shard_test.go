@@ -0,0 +1,32 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/filecoin-project/boost/extern/boostd-data/testutils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashRing(t *testing.T) {
+	addrs := []string{"ws://a:8042", "ws://b:8042", "ws://c:8042"}
+	ring := newHashRing(addrs[:2])
+	grown := newHashRing(addrs)
+
+	keys := testutils.GenerateCids(3000)
+	counts := make([]int, len(addrs))
+	for _, k := range keys {
+		before := ring.locate(k.Bytes())
+		after := grown.locate(k.Bytes())
+		counts[after]++
+
+		// Adding a shard should only move keys to the new shard
+		if after != before {
+			require.Equal(t, 2, after)
+		}
+	}
+
+	// Each shard should get a reasonable share of the keys
+	for _, c := range counts {
+		require.Greater(t, c, len(keys)/len(addrs)/2)
+	}
+}
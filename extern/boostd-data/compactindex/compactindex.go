@@ -0,0 +1,293 @@
+// Package compactindex implements a compact encoding of the index of a
+// piece, for pieces with a large number of blocks.
+//
+// The records are sorted by multihash and split into blocks of a fixed
+// number of records. Within a block, each multihash is stored as the length
+// of the prefix it shares with the previous multihash followed by the
+// remaining suffix, and each offset is stored as the (zig-zag encoded)
+// difference from the previous offset:
+//
+//	block:  uvarint record count, then for each record:
+//	        uvarint shared prefix length, uvarint suffix length, suffix,
+//	        varint offset delta, uvarint size
+//
+// A directory holds the first multihash of each block, so that the offset
+// and size of a single multihash can be found by decoding just one block:
+//
+//	directory: uvarint version, uvarint record count, uvarint block count,
+//	           then for each block: uvarint multihash length, multihash
+package compactindex
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/filecoin-project/boost/extern/boostd-data/model"
+	"github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// DefaultBlockSize is the default number of records in each block
+const DefaultBlockSize = 1024
+
+const directoryVersion = 1
+
+var ErrNotFound = errors.New("multihash not found in index")
+
+// Directory holds the first multihash of each block of an encoded index
+type Directory struct {
+	// The total number of records in the index
+	Count uint64
+	// The first multihash in each block
+	FirstMultihashes []mh.Multihash
+}
+
+// Encode sorts the records by multihash and encodes them into blocks of
+// at most blockSize records. If a multihash appears more than once, the
+// last record with that multihash is kept.
+func Encode(records []model.Record, blockSize int) (*Directory, [][]byte) {
+	if blockSize <= 0 {
+		blockSize = DefaultBlockSize
+	}
+
+	type entry struct {
+		mh mh.Multihash
+		model.OffsetSize
+	}
+	entries := make([]entry, 0, len(records))
+	for _, r := range records {
+		entries = append(entries, entry{mh: r.Cid.Hash(), OffsetSize: r.OffsetSize})
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].mh, entries[j].mh) < 0
+	})
+
+	// Remove duplicates, keeping the last record for each multihash
+	deduped := entries[:0]
+	for i, e := range entries {
+		if i+1 < len(entries) && bytes.Equal(e.mh, entries[i+1].mh) {
+			continue
+		}
+		deduped = append(deduped, e)
+	}
+	entries = deduped
+
+	dir := &Directory{Count: uint64(len(entries))}
+	var blocks [][]byte
+	buf := make([]byte, binary.MaxVarintLen64)
+	for start := 0; start < len(entries); start += blockSize {
+		end := start + blockSize
+		if end > len(entries) {
+			end = len(entries)
+		}
+
+		var block bytes.Buffer
+		putUvarint(&block, buf, uint64(end-start))
+		var prevMh mh.Multihash
+		var prevOffset uint64
+		for _, e := range entries[start:end] {
+			shared := sharedPrefixLen(prevMh, e.mh)
+			putUvarint(&block, buf, uint64(shared))
+			putUvarint(&block, buf, uint64(len(e.mh)-shared))
+			block.Write(e.mh[shared:])
+			n := binary.PutVarint(buf, int64(e.Offset-prevOffset))
+			block.Write(buf[:n])
+			putUvarint(&block, buf, e.Size)
+
+			prevMh = e.mh
+			prevOffset = e.Offset
+		}
+
+		dir.FirstMultihashes = append(dir.FirstMultihashes, entries[start].mh)
+		blocks = append(blocks, block.Bytes())
+	}
+
+	return dir, blocks
+}
+
+// Block returns the index of the block that contains the multihash if it
+// is in the index, or -1 if the multihash is definitely not in the index
+func (d *Directory) Block(m mh.Multihash) int {
+	// Find the first block whose first multihash is greater than m
+	i := sort.Search(len(d.FirstMultihashes), func(i int) bool {
+		return bytes.Compare(d.FirstMultihashes[i], m) > 0
+	})
+	// The multihash can only be in the block before it
+	return i - 1
+}
+
+// Marshal encodes the directory
+func (d *Directory) Marshal() []byte {
+	var out bytes.Buffer
+	buf := make([]byte, binary.MaxVarintLen64)
+	putUvarint(&out, buf, directoryVersion)
+	putUvarint(&out, buf, d.Count)
+	putUvarint(&out, buf, uint64(len(d.FirstMultihashes)))
+	for _, m := range d.FirstMultihashes {
+		putUvarint(&out, buf, uint64(len(m)))
+		out.Write(m)
+	}
+	return out.Bytes()
+}
+
+// UnmarshalDirectory decodes a directory encoded with Marshal
+func UnmarshalDirectory(b []byte) (*Directory, error) {
+	r := bytes.NewReader(b)
+	version, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading directory version: %w", err)
+	}
+	if version != directoryVersion {
+		return nil, fmt.Errorf("unsupported compact index directory version %d", version)
+	}
+
+	d := &Directory{}
+	if d.Count, err = binary.ReadUvarint(r); err != nil {
+		return nil, fmt.Errorf("reading record count: %w", err)
+	}
+	blockCount, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading block count: %w", err)
+	}
+	if blockCount > uint64(r.Len()) {
+		return nil, fmt.Errorf("block count %d exceeds directory size", blockCount)
+	}
+
+	d.FirstMultihashes = make([]mh.Multihash, 0, blockCount)
+	for i := uint64(0); i < blockCount; i++ {
+		m, err := readBytes(r)
+		if err != nil {
+			return nil, fmt.Errorf("reading first multihash of block %d: %w", i, err)
+		}
+		d.FirstMultihashes = append(d.FirstMultihashes, m)
+	}
+	return d, nil
+}
+
+// DecodeBlock decodes all the records in a block
+func DecodeBlock(b []byte) ([]model.Record, error) {
+	var records []model.Record
+	err := forEachInBlock(b, func(m []byte, ofsz model.OffsetSize) (bool, error) {
+		// Copy the multihash because the buffer is reused
+		mhcp := make([]byte, len(m))
+		copy(mhcp, m)
+		c, err := castMultihash(mhcp)
+		if err != nil {
+			return false, err
+		}
+		records = append(records, model.Record{Cid: c, OffsetSize: ofsz})
+		return true, nil
+	})
+	return records, err
+}
+
+// FindInBlock returns the offset and size of the multihash in the block, or
+// ErrNotFound if the multihash is not in the block
+func FindInBlock(b []byte, m mh.Multihash) (*model.OffsetSize, error) {
+	var found *model.OffsetSize
+	err := forEachInBlock(b, func(cur []byte, ofsz model.OffsetSize) (bool, error) {
+		switch bytes.Compare(cur, m) {
+		case 0:
+			found = &ofsz
+			return false, nil
+		case 1:
+			// The multihashes are sorted, so we've gone past the multihash
+			return false, nil
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, ErrNotFound
+	}
+	return found, nil
+}
+
+// forEachInBlock calls cb with each multihash and offset / size in the
+// block, until cb returns false. The multihash buffer is reused between
+// calls.
+func forEachInBlock(b []byte, cb func([]byte, model.OffsetSize) (bool, error)) error {
+	r := bytes.NewReader(b)
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return fmt.Errorf("reading block record count: %w", err)
+	}
+
+	var cur []byte
+	var offset uint64
+	for i := uint64(0); i < count; i++ {
+		shared, err := binary.ReadUvarint(r)
+		if err != nil {
+			return fmt.Errorf("reading shared prefix length: %w", err)
+		}
+		if shared > uint64(len(cur)) {
+			return fmt.Errorf("shared prefix length %d exceeds previous multihash length %d", shared, len(cur))
+		}
+		suffix, err := readBytes(r)
+		if err != nil {
+			return fmt.Errorf("reading multihash suffix: %w", err)
+		}
+		cur = append(cur[:shared], suffix...)
+
+		delta, err := binary.ReadVarint(r)
+		if err != nil {
+			return fmt.Errorf("reading offset: %w", err)
+		}
+		offset += uint64(delta)
+		size, err := binary.ReadUvarint(r)
+		if err != nil {
+			return fmt.Errorf("reading size: %w", err)
+		}
+
+		more, err := cb(cur, model.OffsetSize{Offset: offset, Size: size})
+		if err != nil || !more {
+			return err
+		}
+	}
+	return nil
+}
+
+func castMultihash(b []byte) (cid.Cid, error) {
+	m, err := mh.Cast(b)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("parsing multihash: %w", err)
+	}
+	return cid.NewCidV1(cid.Raw, m), nil
+}
+
+func readBytes(r *bytes.Reader) ([]byte, error) {
+	l, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if l > uint64(r.Len()) {
+		return nil, fmt.Errorf("length %d exceeds remaining %d bytes", l, r.Len())
+	}
+	b := make([]byte, l)
+	_, err = io.ReadFull(r, b)
+	return b, err
+}
+
+func putUvarint(out *bytes.Buffer, buf []byte, v uint64) {
+	n := binary.PutUvarint(buf, v)
+	out.Write(buf[:n])
+}
+
+func sharedPrefixLen(a, b []byte) int {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return n
+}
@@ -0,0 +1,83 @@
+package compactindex
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/filecoin-project/boost/extern/boostd-data/model"
+	"github.com/filecoin-project/boost/extern/boostd-data/testutils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	const count = 2500
+	const blockSize = 100
+
+	var records []model.Record
+	for i, c := range testutils.GenerateCids(count) {
+		records = append(records, model.Record{
+			Cid:        c,
+			OffsetSize: model.OffsetSize{Offset: uint64(i) * 1000, Size: uint64(rand.Intn(1 << 20))},
+		})
+	}
+	// Add a duplicate multihash: the last record should be kept
+	dup := records[10]
+	dup.Offset = 123
+	records = append(records, dup)
+
+	dir, blocks := Encode(records, blockSize)
+	require.EqualValues(t, count, dir.Count)
+	require.Len(t, blocks, count/blockSize)
+	require.Len(t, dir.FirstMultihashes, len(blocks))
+
+	// The directory should round-trip
+	dir, err := UnmarshalDirectory(dir.Marshal())
+	require.NoError(t, err)
+	require.EqualValues(t, count, dir.Count)
+
+	expected := make(map[string]model.OffsetSize)
+	for _, r := range records {
+		expected[string(r.Cid.Hash())] = r.OffsetSize
+	}
+
+	// Decoding all the blocks should give back every record
+	var decoded []model.Record
+	for _, b := range blocks {
+		recs, err := DecodeBlock(b)
+		require.NoError(t, err)
+		decoded = append(decoded, recs...)
+	}
+	require.Len(t, decoded, count)
+	for _, r := range decoded {
+		require.Equal(t, expected[string(r.Cid.Hash())], r.OffsetSize)
+	}
+
+	// Each multihash should be found in the block given by the directory
+	for _, r := range records {
+		i := dir.Block(r.Cid.Hash())
+		require.GreaterOrEqual(t, i, 0)
+		ofsz, err := FindInBlock(blocks[i], r.Cid.Hash())
+		require.NoError(t, err)
+		require.Equal(t, expected[string(r.Cid.Hash())], *ofsz)
+	}
+
+	// Multihashes that are not in the index should not be found
+	for _, c := range testutils.GenerateCids(100) {
+		i := dir.Block(c.Hash())
+		if i < 0 {
+			continue
+		}
+		_, err := FindInBlock(blocks[i], c.Hash())
+		require.ErrorIs(t, err, ErrNotFound)
+	}
+}
+
+func TestEncodeEmpty(t *testing.T) {
+	dir, blocks := Encode(nil, 0)
+	require.Empty(t, blocks)
+	require.EqualValues(t, 0, dir.Count)
+
+	dir, err := UnmarshalDirectory(dir.Marshal())
+	require.NoError(t, err)
+	require.Equal(t, -1, dir.Block(testutils.GenerateCid().Hash()))
+}
@@ -11,6 +11,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/filecoin-project/boost/extern/boostd-data/compactindex"
 	"github.com/filecoin-project/boost/extern/boostd-data/model"
 	"github.com/filecoin-project/boost/extern/boostd-data/shared/tracing"
 	"github.com/filecoin-project/boost/extern/boostd-data/svc/types"
@@ -46,6 +47,18 @@ var (
 	prefixPieceCidToFlagged  uint64 = 3
 	sprefixPieceCidToFlagged string
 
+	// LevelDB key prefix for the compact index table.
+	// LevelDB keys will be built by concatenating the cursor to this prefix.
+	prefixCompactIndex  uint64 = 4
+	sprefixCompactIndex string
+
+	// LevelDB key prefix for the pieces that the piece doctor should no
+	// longer check.
+	// LevelDB keys will be built by concatenating miner address and PieceCid
+	// to this prefix.
+	prefixPieceUntracked  uint64 = 5
+	sprefixPieceUntracked string
+
 	/////////////////////////////////////////
 	// Prefixes up to 100 are system prefixes
 )
@@ -66,6 +79,14 @@ func init() {
 	buf = make([]byte, binary.MaxVarintLen64)
 	binary.PutUvarint(buf, prefixPieceCidToFlagged)
 	sprefixPieceCidToFlagged = string(buf)
+
+	buf = make([]byte, binary.MaxVarintLen64)
+	binary.PutUvarint(buf, prefixCompactIndex)
+	sprefixCompactIndex = string(buf)
+
+	buf = make([]byte, binary.MaxVarintLen64)
+	binary.PutUvarint(buf, prefixPieceUntracked)
+	sprefixPieceUntracked = string(buf)
 }
 
 type DB struct {
@@ -264,11 +285,46 @@ func (db *DB) GetPieceCidToFlagged(ctx context.Context, pieceCid cid.Cid, maddr
 	return metadata, nil
 }
 
+func pieceUntrackedKey(maddr address.Address, pieceCid cid.Cid) ds.Key {
+	return datastore.NewKey(fmt.Sprintf("%s/%s/%s", sprefixPieceUntracked, maddr.String(), pieceCid.String()))
+}
+
+// SetPieceUntracked stops the piece doctor from checking the piece on the miner
+func (db *DB) SetPieceUntracked(ctx context.Context, pieceCid cid.Cid, maddr address.Address) error {
+	ctx, span := tracing.Tracer.Start(ctx, "db.set_piece_untracked")
+	defer span.End()
+
+	return db.Put(ctx, pieceUntrackedKey(maddr, pieceCid), []byte{})
+}
+
+// RemovePieceUntracked resumes checking the piece on the miner
+func (db *DB) RemovePieceUntracked(ctx context.Context, pieceCid cid.Cid, maddr address.Address) error {
+	ctx, span := tracing.Tracer.Start(ctx, "db.remove_piece_untracked")
+	defer span.End()
+
+	return db.Delete(ctx, pieceUntrackedKey(maddr, pieceCid))
+}
+
+// IsPieceUntracked
+func (db *DB) IsPieceUntracked(ctx context.Context, pieceCid cid.Cid, maddr address.Address) (bool, error) {
+	return db.Has(ctx, pieceUntrackedKey(maddr, pieceCid))
+}
+
 // SetPieceCidToMetadata
 func (db *DB) SetPieceCidToMetadata(ctx context.Context, pieceCid cid.Cid, md LeveldbMetadata) error {
 	ctx, span := tracing.Tracer.Start(ctx, "db.set_piece_cid_to_metadata")
 	defer span.End()
 
+	return putPieceCidToMetadata(ctx, db, pieceCid, md)
+}
+
+// SetPieceCidToMetadataBatch adds the write for the piece metadata to the
+// batch, so that it is committed together with the index it describes
+func (db *DB) SetPieceCidToMetadataBatch(ctx context.Context, batch ds.Batch, pieceCid cid.Cid, md LeveldbMetadata) error {
+	return putPieceCidToMetadata(ctx, batch, pieceCid, md)
+}
+
+func putPieceCidToMetadata(ctx context.Context, w ds.Write, pieceCid cid.Cid, md LeveldbMetadata) error {
 	b, err := json.Marshal(md)
 	if err != nil {
 		return err
@@ -276,7 +332,7 @@ func (db *DB) SetPieceCidToMetadata(ctx context.Context, pieceCid cid.Cid, md Le
 
 	key := datastore.NewKey(fmt.Sprintf("%s/%s", sprefixPieceCidToCursor, pieceCid.String()))
 
-	return db.Put(ctx, key, b)
+	return w.Put(ctx, key, b)
 }
 
 // GetPieceCidToMetadata
@@ -453,6 +509,14 @@ func (db *DB) NextPiecesToCheck(ctx context.Context, maddr address.Address) ([]c
 			return nil, fmt.Errorf("getting piece metadata: %w", err)
 		}
 
+		untracked, err := db.IsPieceUntracked(ctx, pieceCid, maddr)
+		if err != nil {
+			return nil, fmt.Errorf("getting untracked state of piece: %w", err)
+		}
+		if untracked {
+			continue
+		}
+
 		for _, dl := range md.Deals {
 			if dl.MinerAddr == maddr {
 				checkedLk.Lock()
@@ -652,67 +716,281 @@ func (db *DB) RemoveIndexes(ctx context.Context, cursor uint64, pieceCid cid.Cid
 
 		m := r.Key[len(q.Prefix)+1:]
 
-		err = func() error {
-			key := datastore.NewKey(fmt.Sprintf("%s%s", sprefixMhtoPieceCids, m))
+		err = db.removePieceCidFromMultihash(ctx, batch, m, pieceCid)
+		if err != nil {
+			return err
+		}
 
-			val, err := db.Get(ctx, key)
-			if err != nil && err != ds.ErrNotFound {
-				return fmt.Errorf("failed to get value for multihash %s, err: %w", m, err)
-			}
+		// Remove (cursor+multihash) -> Offset
+		if err := batch.Delete(ctx, ds.NewKey(r.Key)); err != nil {
+			return fmt.Errorf("failed to batch delete mh=%s, err%w", r.Key, err)
+		}
+	}
 
-			if errors.Is(err, ds.ErrNotFound) {
-				return nil
+	// Remove the compact index and any segments that haven't been merged
+	// into it
+	for segment := 0; ; segment++ {
+		dir, err := db.GetCompactIndexDirectory(ctx, cursor, segment)
+		if err != nil && !errors.Is(err, ds.ErrNotFound) {
+			return err
+		}
+		if err != nil {
+			if segment == 0 {
+				// The segments may exist without the main index
+				continue
 			}
-
-			var pcids []cid.Cid
-			if err := json.Unmarshal(val, &pcids); err != nil {
-				return fmt.Errorf("failed to unmarshal pieceCids slice: %w", err)
+			break
+		}
+		for i := range dir.FirstMultihashes {
+			b, err := db.Get(ctx, compactIndexBlockKey(cursor, segment, i))
+			if err != nil {
+				return fmt.Errorf("getting compact index block %d: %w", i, err)
 			}
-
-			if !has(pcids, pieceCid) {
-				return nil
+			recs, err := compactindex.DecodeBlock(b)
+			if err != nil {
+				return fmt.Errorf("decoding compact index block %d: %w", i, err)
 			}
-
-			if len(pcids) <= 1 {
-				// Remove multihash -> pieceCId (key+value)
-				if err := batch.Delete(ctx, key); err != nil {
-					return fmt.Errorf("failed to batch delete multihash to pieceCid mh=%s, pieceCid=%s err%w", key, pcids[0], err)
+			for _, rec := range recs {
+				err = db.removePieceCidFromMultihash(ctx, batch, rec.Cid.Hash().String(), pieceCid)
+				if err != nil {
+					return err
 				}
-				return nil
 			}
-
-			// Remove multihash -> pieceCId (value only)
-			for i, v := range pcids {
-				if v == pieceCid {
-					pcids[i] = pcids[len(pcids)-1]
-					pcids = pcids[:len(pcids)-1]
-				}
+			if err := batch.Delete(ctx, compactIndexBlockKey(cursor, segment, i)); err != nil {
+				return fmt.Errorf("failed to batch delete compact index block %d: %w", i, err)
 			}
+		}
+		if err := batch.Delete(ctx, compactIndexDirectoryKey(cursor, segment)); err != nil {
+			return fmt.Errorf("failed to batch delete compact index directory: %w", err)
+		}
+	}
 
-			b, err := json.Marshal(pcids)
-			if err != nil {
-				return fmt.Errorf("failed to marshal pieceCids slice: %w", err)
-			}
-			if err := batch.Put(ctx, key, b); err != nil {
-				return fmt.Errorf("failed to batch put mh=%s, err%w", m, err)
+	if err := batch.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit batch: %w", err)
+	}
+
+	return nil
+}
+
+// removePieceCidFromMultihash removes the piece cid from the multihash ->
+// pieceCids table. If there are no piece cids left for the multihash, the
+// multihash entry is removed.
+func (db *DB) removePieceCidFromMultihash(ctx context.Context, batch ds.Batch, m string, pieceCid cid.Cid) error {
+	key := datastore.NewKey(fmt.Sprintf("%s%s", sprefixMhtoPieceCids, m))
+
+	val, err := db.Get(ctx, key)
+	if err != nil && err != ds.ErrNotFound {
+		return fmt.Errorf("failed to get value for multihash %s, err: %w", m, err)
+	}
+
+	if errors.Is(err, ds.ErrNotFound) {
+		return nil
+	}
+
+	var pcids []cid.Cid
+	if err := json.Unmarshal(val, &pcids); err != nil {
+		return fmt.Errorf("failed to unmarshal pieceCids slice: %w", err)
+	}
+
+	if !has(pcids, pieceCid) {
+		return nil
+	}
+
+	if len(pcids) <= 1 {
+		// Remove multihash -> pieceCId (key+value)
+		if err := batch.Delete(ctx, key); err != nil {
+			return fmt.Errorf("failed to batch delete multihash to pieceCid mh=%s, pieceCid=%s err%w", key, pcids[0], err)
+		}
+		return nil
+	}
+
+	// Remove multihash -> pieceCId (value only)
+	for i, v := range pcids {
+		if v == pieceCid {
+			pcids[i] = pcids[len(pcids)-1]
+			pcids = pcids[:len(pcids)-1]
+		}
+	}
+
+	b, err := json.Marshal(pcids)
+	if err != nil {
+		return fmt.Errorf("failed to marshal pieceCids slice: %w", err)
+	}
+	if err := batch.Put(ctx, key, b); err != nil {
+		return fmt.Errorf("failed to batch put mh=%s, err%w", m, err)
+	}
+
+	return nil
+}
+
+// compactIndexPrefix returns the key prefix of a compact index segment.
+// Segment 0 is the piece's main compact index. While an index is being
+// built in batches, each batch is added as a separate segment numbered
+// from 1, and the segments are later merged into the main index.
+func compactIndexPrefix(cursor uint64, segment int) string {
+	if segment == 0 {
+		return fmt.Sprintf("%s/%d", sprefixCompactIndex, cursor)
+	}
+	return fmt.Sprintf("%s/%d/s/%d", sprefixCompactIndex, cursor, segment)
+}
+
+func compactIndexDirectoryKey(cursor uint64, segment int) ds.Key {
+	return datastore.NewKey(compactIndexPrefix(cursor, segment) + "/d")
+}
+
+func compactIndexBlockKey(cursor uint64, segment int, block int) ds.Key {
+	return datastore.NewKey(fmt.Sprintf("%s/b/%d", compactIndexPrefix(cursor, segment), block))
+}
+
+// SetCompactIndex adds the writes for the compact index segment for the
+// cursor to the batch, replacing any existing segment with the same number
+func (db *DB) SetCompactIndex(ctx context.Context, batch ds.Batch, cursor uint64, segment int, dir *compactindex.Directory, blocks [][]byte) error {
+	ctx, span := tracing.Tracer.Start(ctx, "db.set_compact_index")
+	defer span.End()
+
+	// Remove any blocks of the existing index that won't be overwritten
+	prev, err := db.GetCompactIndexDirectory(ctx, cursor, segment)
+	if err != nil && !errors.Is(err, ds.ErrNotFound) {
+		return err
+	}
+	if err == nil {
+		for i := len(blocks); i < len(prev.FirstMultihashes); i++ {
+			if err := batch.Delete(ctx, compactIndexBlockKey(cursor, segment, i)); err != nil {
+				return fmt.Errorf("failed to batch delete compact index block %d: %w", i, err)
 			}
+		}
+	}
 
-			return nil
-		}()
+	for i, b := range blocks {
+		if err := batch.Put(ctx, compactIndexBlockKey(cursor, segment, i), b); err != nil {
+			return fmt.Errorf("failed to batch put compact index block %d: %w", i, err)
+		}
+	}
+	if err := batch.Put(ctx, compactIndexDirectoryKey(cursor, segment), dir.Marshal()); err != nil {
+		return fmt.Errorf("failed to batch put compact index directory: %w", err)
+	}
+	return nil
+}
+
+// RemoveCompactIndexSegments adds the deletes for the compact index segments
+// numbered from 1 for the cursor to the batch, leaving the main compact
+// index in place
+func (db *DB) RemoveCompactIndexSegments(ctx context.Context, batch ds.Batch, cursor uint64) error {
+	ctx, span := tracing.Tracer.Start(ctx, "db.remove_compact_index_segments")
+	defer span.End()
+
+	for segment := 1; ; segment++ {
+		dir, err := db.GetCompactIndexDirectory(ctx, cursor, segment)
+		if errors.Is(err, ds.ErrNotFound) {
+			break
+		}
 		if err != nil {
 			return err
 		}
+		for i := range dir.FirstMultihashes {
+			if err := batch.Delete(ctx, compactIndexBlockKey(cursor, segment, i)); err != nil {
+				return fmt.Errorf("failed to batch delete compact index segment %d block %d: %w", segment, i, err)
+			}
+		}
+		if err := batch.Delete(ctx, compactIndexDirectoryKey(cursor, segment)); err != nil {
+			return fmt.Errorf("failed to batch delete compact index segment %d directory: %w", segment, err)
+		}
+	}
+	return nil
+}
 
-		// Remove (cursor+multihash) -> Offset
-		if err := batch.Delete(ctx, ds.NewKey(r.Key)); err != nil {
-			return fmt.Errorf("failed to batch delete mh=%s, err%w", r.Key, err)
+// GetCompactIndexDirectory
+func (db *DB) GetCompactIndexDirectory(ctx context.Context, cursor uint64, segment int) (*compactindex.Directory, error) {
+	b, err := db.Get(ctx, compactIndexDirectoryKey(cursor, segment))
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := compactindex.UnmarshalDirectory(b)
+	if err != nil {
+		return nil, fmt.Errorf("decoding compact index directory for cursor %d segment %d: %w", cursor, segment, err)
+	}
+	return dir, nil
+}
+
+// AllCompactIndexRecords
+func (db *DB) AllCompactIndexRecords(ctx context.Context, cursor uint64, segment int) ([]model.Record, error) {
+	ctx, span := tracing.Tracer.Start(ctx, "db.all_compact_index_records")
+	defer span.End()
+
+	dir, err := db.GetCompactIndexDirectory(ctx, cursor, segment)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]model.Record, 0, dir.Count)
+	for i := range dir.FirstMultihashes {
+		b, err := db.Get(ctx, compactIndexBlockKey(cursor, segment, i))
+		if err != nil {
+			return nil, fmt.Errorf("getting compact index block %d: %w", i, err)
 		}
+		recs, err := compactindex.DecodeBlock(b)
+		if err != nil {
+			return nil, fmt.Errorf("decoding compact index block %d: %w", i, err)
+		}
+		records = append(records, recs...)
 	}
 
-	if err := batch.Commit(ctx); err != nil {
-		return fmt.Errorf("failed to commit batch: %w", err)
+	return records, nil
+}
+
+// GetCompactIndexOffsetSize
+func (db *DB) GetCompactIndexOffsetSize(ctx context.Context, cursor uint64, segment int, m multihash.Multihash) (*model.OffsetSize, error) {
+	ctx, span := tracing.Tracer.Start(ctx, "db.get_compact_index_offset")
+	defer span.End()
+
+	dir, err := db.GetCompactIndexDirectory(ctx, cursor, segment)
+	if err != nil {
+		return nil, err
+	}
+
+	i := dir.Block(m)
+	if i < 0 {
+		return nil, ds.ErrNotFound
 	}
 
+	b, err := db.Get(ctx, compactIndexBlockKey(cursor, segment, i))
+	if err != nil {
+		return nil, fmt.Errorf("getting compact index block %d: %w", i, err)
+	}
+
+	ofsz, err := compactindex.FindInBlock(b, m)
+	if errors.Is(err, compactindex.ErrNotFound) {
+		return nil, ds.ErrNotFound
+	}
+	return ofsz, err
+}
+
+// RemoveIndexRecords adds the deletes for the cursor+multihash -> offset
+// entries for the cursor to the batch, without removing the multihash ->
+// pieceCids entries. It is used when the index has been rewritten in the
+// compact encoding.
+func (db *DB) RemoveIndexRecords(ctx context.Context, batch ds.Batch, cursor uint64) error {
+	ctx, span := tracing.Tracer.Start(ctx, "db.remove_index_records")
+	defer span.End()
+
+	var q query.Query
+	q.Prefix = fmt.Sprintf("%d/", cursor)
+	q.KeysOnly = true
+	results, err := db.Query(ctx, q)
+	if err != nil {
+		return fmt.Errorf("error querying the database:  %w", err)
+	}
+
+	for {
+		r, ok := results.NextSync()
+		if !ok {
+			break
+		}
+		if err := batch.Delete(ctx, ds.NewKey(r.Key)); err != nil {
+			return fmt.Errorf("failed to batch delete mh=%s, err%w", r.Key, err)
+		}
+	}
 	return nil
 }
 
@@ -753,11 +1031,7 @@ func (db *DB) ListFlaggedPieces(ctx context.Context, filter *types.FlaggedPieces
 			return nil, fmt.Errorf("failed to unmarshal LeveldbFlaggedMetadata: %w; %v", err, r.Value)
 		}
 
-		if filter != nil && filter.HasUnsealedCopy != v.HasUnsealedCopy {
-			continue
-		}
-
-		if filter != nil && !filter.MinerAddr.Empty() && filter.MinerAddr != v.MinerAddr {
+		if !filter.Matches(v.MinerAddr, v.HasUnsealedCopy, v.Reason) {
 			continue
 		}
 
@@ -770,6 +1044,8 @@ func (db *DB) ListFlaggedPieces(ctx context.Context, filter *types.FlaggedPieces
 			UpdatedAt:       v.UpdatedAt,
 			PieceCid:        pieceCid,
 			HasUnsealedCopy: v.HasUnsealedCopy,
+			Reason:          v.Reason,
+			Details:         v.Details,
 		})
 	}
 
@@ -822,11 +1098,7 @@ func (db *DB) FlaggedPiecesCount(ctx context.Context, filter *types.FlaggedPiece
 				return 0, fmt.Errorf("failed to unmarshal LeveldbFlaggedMetadata: %w; %v", err, r.Value)
 			}
 
-			if filter.HasUnsealedCopy != v.HasUnsealedCopy {
-				continue
-			}
-
-			if !filter.MinerAddr.Empty() && filter.MinerAddr != v.MinerAddr {
+			if !filter.Matches(v.MinerAddr, v.HasUnsealedCopy, v.Reason) {
 				continue
 			}
 		}
@@ -2,12 +2,14 @@ package ldb
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
 	"sync"
 	"time"
 
+	"github.com/filecoin-project/boost/extern/boostd-data/compactindex"
 	"github.com/filecoin-project/boost/extern/boostd-data/metrics"
 	"github.com/filecoin-project/boost/extern/boostd-data/model"
 	"github.com/filecoin-project/boost/extern/boostd-data/shared/tracing"
@@ -22,22 +24,62 @@ import (
 	"go.opencensus.io/tag"
 )
 
-// The current piece metadata version. This version will be used when doing
-// data migrations (migrations are not yet implemented in version 1).
-const pieceMetadataVersion = "1"
+// The current piece metadata version.
+// In version 1 each index record is stored under a separate key.
+// In version 2 the index is stored in the compact index encoding. Pieces
+// with version 1 metadata are migrated to version 2 in the background.
+const pieceMetadataVersion = "2"
+
+const pieceMetadataVersionRecords = "1"
 
 var log = logging.Logger("boostd-data-ldb")
 
 type LeveldbFlaggedMetadata struct {
-	CreatedAt       time.Time       `json:"c"`
-	UpdatedAt       time.Time       `json:"u"`
-	HasUnsealedCopy bool            `json:"huc"`
-	MinerAddr       address.Address `json:"m"`
+	CreatedAt       time.Time        `json:"c"`
+	UpdatedAt       time.Time        `json:"u"`
+	HasUnsealedCopy bool             `json:"huc"`
+	MinerAddr       address.Address  `json:"m"`
+	Reason          model.FlagReason `json:"r"`
+	Details         string           `json:"d"`
 }
 
 type LeveldbMetadata struct {
 	model.Metadata
 	Cursor uint64 `json:"c"`
+	// The number of compact index segments that have not yet been merged
+	// into the piece's main compact index
+	Segments int `json:"sg,omitempty"`
+}
+
+// leveldbMetadataJson is the stored form of LeveldbMetadata. The cursor's
+// key "c" shadows the key of model.Metadata.CompleteIndex, so the complete
+// index flag is stored under a separate key.
+type leveldbMetadataJson struct {
+	model.Metadata
+	Cursor        uint64 `json:"c"`
+	Segments      int    `json:"sg,omitempty"`
+	CompleteIndex bool   `json:"ci,omitempty"`
+}
+
+func (md LeveldbMetadata) MarshalJSON() ([]byte, error) {
+	return json.Marshal(leveldbMetadataJson{
+		Metadata:      md.Metadata,
+		Cursor:        md.Cursor,
+		Segments:      md.Segments,
+		CompleteIndex: md.Metadata.CompleteIndex,
+	})
+}
+
+func (md *LeveldbMetadata) UnmarshalJSON(b []byte) error {
+	var v leveldbMetadataJson
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	md.Metadata = v.Metadata
+	md.Metadata.CompleteIndex = v.CompleteIndex
+	md.Cursor = v.Cursor
+	md.Segments = v.Segments
+	return nil
 }
 
 func newLeveldbMetadata() LeveldbMetadata {
@@ -46,6 +88,27 @@ func newLeveldbMetadata() LeveldbMetadata {
 	}
 }
 
+// newPieceMetadata creates metadata for a new piece, and allocates a cursor
+// under which the piece's index is stored. The caller must hold the lock.
+func (s *Store) newPieceMetadata(ctx context.Context) (LeveldbMetadata, error) {
+	md := newLeveldbMetadata()
+
+	// get and set next cursor (handle synchronization, maybe with CAS)
+	cursor, _, err := s.db.NextCursor(ctx)
+	if err != nil {
+		return md, err
+	}
+
+	// allocate metadata for pieceCid
+	err = s.db.SetNextCursor(ctx, cursor+1)
+	if err != nil {
+		return md, err
+	}
+
+	md.Cursor = cursor
+	return md, nil
+}
+
 type Store struct {
 	sync.Mutex
 	db       *DB
@@ -84,6 +147,8 @@ func (s *Store) Start(ctx context.Context) error {
 
 	s.ctx = ctx
 
+	go s.migrateIndexes(ctx)
+
 	log.Debugw("new leveldb local index directory service", "repo path", repopath)
 	return nil
 }
@@ -113,7 +178,11 @@ func (s *Store) AddDealForPiece(ctx context.Context, pieceCid cid.Cid, dealInfo
 			return fmt.Errorf("getting piece cid metadata for piece %s: %w", pieceCid, err)
 		}
 		// there isn't yet any metadata, so create new metadata
-		md = newLeveldbMetadata()
+		md, err = s.newPieceMetadata(ctx)
+		if err != nil {
+			stats.Record(s.ctx, metrics.FailureAddDealForPieceCount.M(1))
+			return err
+		}
 	}
 
 	// Check if the deal has already been added
@@ -134,6 +203,13 @@ func (s *Store) AddDealForPiece(ctx context.Context, pieceCid cid.Cid, dealInfo
 		return err
 	}
 
+	// A new deal on the miner means the piece should be checked again
+	err = s.db.RemovePieceUntracked(ctx, pieceCid, dealInfo.MinerAddr)
+	if err != nil {
+		stats.Record(s.ctx, metrics.FailureAddDealForPieceCount.M(1))
+		return fmt.Errorf("tracking piece %s: %w", pieceCid, err)
+	}
+
 	stats.Record(s.ctx, metrics.SuccessAddDealForPieceCount.M(1))
 	return nil
 }
@@ -161,7 +237,12 @@ func (s *Store) GetOffsetSize(ctx context.Context, pieceCid cid.Cid, hash mh.Mul
 		return nil, normalizePieceCidError(pieceCid, err)
 	}
 
-	out, err := s.db.GetOffsetSize(ctx, fmt.Sprintf("%d", md.Cursor)+"/", hash)
+	var out *model.OffsetSize
+	if hasCompactIndex(md) {
+		out, err = s.compactIndexOffsetSize(ctx, md, hash)
+	} else {
+		out, err = s.db.GetOffsetSize(ctx, fmt.Sprintf("%d", md.Cursor)+"/", hash)
+	}
 	if err != nil {
 		stats.Record(s.ctx, metrics.FailureGetOffsetSizeCount.M(1))
 	} else {
@@ -273,7 +354,7 @@ func (s *Store) GetIndex(ctx context.Context, pieceCid cid.Cid) (<-chan types.In
 		return nil, normalizePieceCidError(pieceCid, err)
 	}
 
-	records, err := s.db.AllRecords(ctx, md.Cursor)
+	records, err := s.allRecords(ctx, md)
 	if err != nil {
 		err = normalizePieceCidError(pieceCid, err)
 		stats.Record(s.ctx, metrics.FailureGetIndexCount.M(1))
@@ -292,17 +373,208 @@ func (s *Store) GetIndex(ctx context.Context, pieceCid cid.Cid) (<-chan types.In
 	return recs, nil
 }
 
+// hasCompactIndex returns true if the index for the piece is stored in the
+// compact index encoding
+func hasCompactIndex(md LeveldbMetadata) bool {
+	return md.Version != "" && md.Version != pieceMetadataVersionRecords
+}
+
+// allRecords returns all the index records for the piece, in either encoding
+func (s *Store) allRecords(ctx context.Context, md LeveldbMetadata) ([]model.Record, error) {
+	if !hasCompactIndex(md) {
+		return s.db.AllRecords(ctx, md.Cursor)
+	}
+
+	var records []model.Record
+	for segment := 0; segment <= md.Segments; segment++ {
+		recs, err := s.db.AllCompactIndexRecords(ctx, md.Cursor, segment)
+		if err != nil {
+			if errors.Is(err, ds.ErrNotFound) {
+				// The piece has metadata but no index, or the segments
+				// have not yet been merged into the main index
+				continue
+			}
+			return nil, err
+		}
+		records = append(records, recs...)
+	}
+	return records, nil
+}
+
+// compactIndexOffsetSize looks up the multihash in the piece's compact
+// index segments, starting with the most recently added segment
+func (s *Store) compactIndexOffsetSize(ctx context.Context, md LeveldbMetadata, hash mh.Multihash) (*model.OffsetSize, error) {
+	for segment := md.Segments; segment >= 0; segment-- {
+		out, err := s.db.GetCompactIndexOffsetSize(ctx, md.Cursor, segment, hash)
+		if errors.Is(err, ds.ErrNotFound) {
+			continue
+		}
+		return out, err
+	}
+	return nil, ds.ErrNotFound
+}
+
+// addCompactIndex adds the records to the index for the piece, and writes
+// the updated metadata in the same batch as the index, so that the metadata
+// always describes the index that is stored. The caller must hold the lock.
+// While the index is being built in batches (there is an index checkpoint)
+// the records are written as a new segment, so that each batch only
+// writes its own records. Otherwise the records are merged with the
+// existing index.
+func (s *Store) addCompactIndex(ctx context.Context, pieceCid cid.Cid, md *LeveldbMetadata, records []model.Record) error {
+	if !hasCompactIndex(*md) || md.IndexCheckpoint == 0 {
+		return s.mergeCompactIndex(ctx, pieceCid, md, records)
+	}
+
+	batch, err := s.db.Batch(ctx)
+	if err != nil {
+		return fmt.Errorf("error in creating batching:  %w", err)
+	}
+
+	segment := md.Segments + 1
+	dir, blocks := compactindex.Encode(records, compactindex.DefaultBlockSize)
+	if err := s.db.SetCompactIndex(ctx, batch, md.Cursor, segment, dir, blocks); err != nil {
+		return fmt.Errorf("writing compact index segment %d: %w", segment, err)
+	}
+
+	updated := *md
+	updated.Segments = segment
+	if err := s.db.SetPieceCidToMetadataBatch(ctx, batch, pieceCid, updated); err != nil {
+		return err
+	}
+	if err := batch.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit batch: %w", err)
+	}
+
+	*md = updated
+	return nil
+}
+
+// mergeCompactIndex merges the records with any existing index for the
+// piece, including any segments, and writes the merged index in the compact
+// index encoding. The merged index, the removal of the old records and
+// segments, and the updated metadata are committed in a single batch, so
+// that the index is never lost if the process stops part way through.
+// The caller must hold the lock.
+func (s *Store) mergeCompactIndex(ctx context.Context, pieceCid cid.Cid, md *LeveldbMetadata, records []model.Record) error {
+	existing, err := s.allRecords(ctx, *md)
+	if err != nil {
+		return fmt.Errorf("getting existing index records: %w", err)
+	}
+	if len(existing) > 0 {
+		// When a multihash appears more than once the last record is kept,
+		// so the new records take precedence over the existing records
+		records = append(existing, records...)
+	}
+
+	batch, err := s.db.Batch(ctx)
+	if err != nil {
+		return fmt.Errorf("error in creating batching:  %w", err)
+	}
+
+	dir, blocks := compactindex.Encode(records, compactindex.DefaultBlockSize)
+	if err := s.db.SetCompactIndex(ctx, batch, md.Cursor, 0, dir, blocks); err != nil {
+		return fmt.Errorf("writing compact index: %w", err)
+	}
+
+	if !hasCompactIndex(*md) {
+		// Remove the records that were stored under separate keys
+		if err := s.db.RemoveIndexRecords(ctx, batch, md.Cursor); err != nil {
+			return fmt.Errorf("removing index records: %w", err)
+		}
+	}
+	if md.Segments > 0 {
+		if err := s.db.RemoveCompactIndexSegments(ctx, batch, md.Cursor); err != nil {
+			return fmt.Errorf("removing compact index segments: %w", err)
+		}
+	}
+
+	updated := *md
+	updated.Version = pieceMetadataVersion
+	updated.Segments = 0
+	if err := s.db.SetPieceCidToMetadataBatch(ctx, batch, pieceCid, updated); err != nil {
+		return err
+	}
+	if err := batch.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit batch: %w", err)
+	}
+
+	*md = updated
+	return nil
+}
+
+// migrateIndexes rewrites the indexes of pieces with version 1 metadata in
+// the compact index encoding
+func (s *Store) migrateIndexes(ctx context.Context) {
+	pieceCids, err := s.db.ListPieces(ctx)
+	if err != nil {
+		log.Errorw("listing pieces for compact index migration", "err", err)
+		return
+	}
+
+	var migrated int
+	start := time.Now()
+	for _, pieceCid := range pieceCids {
+		if ctx.Err() != nil {
+			return
+		}
+
+		ok, err := s.migratePieceIndex(ctx, pieceCid)
+		if err != nil {
+			log.Errorw("migrating piece index to compact index", "pieceCid", pieceCid, "err", err)
+			continue
+		}
+		if ok {
+			migrated++
+		}
+	}
+
+	if migrated > 0 {
+		log.Infow("migrated piece indexes to compact index", "count", migrated, "took", time.Since(start).String())
+	}
+}
+
+// migratePieceIndex rewrites the index of a piece with version 1 metadata
+// in the compact index encoding. It returns false if the piece did not
+// need to be migrated.
+func (s *Store) migratePieceIndex(ctx context.Context, pieceCid cid.Cid) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	md, err := s.db.GetPieceCidToMetadata(ctx, pieceCid)
+	if err != nil {
+		if errors.Is(err, ds.ErrNotFound) {
+			// The piece was removed after the pieces were listed
+			return false, nil
+		}
+		return false, err
+	}
+	if hasCompactIndex(md) {
+		return false, nil
+	}
+
+	if err := s.mergeCompactIndex(ctx, pieceCid, &md, nil); err != nil {
+		return false, err
+	}
+	return true, s.db.Sync(ctx, ds.NewKey(fmt.Sprintf("%d", md.Cursor)))
+}
+
 func (s *Store) IsIndexed(ctx context.Context, pieceCid cid.Cid) (bool, error) {
 	ctx, _ = tag.New(ctx, tag.Upsert(metrics.Endpoint, "ldb.is_indexed"))
 	stop := metrics.Timer(ctx, metrics.APIRequestDuration)
 	defer stop()
-	t, err := s.IndexedAt(ctx, pieceCid)
-	if err != nil {
+
+	s.Lock()
+	defer s.Unlock()
+
+	md, err := s.db.GetPieceCidToMetadata(ctx, pieceCid)
+	if err != nil && !errors.Is(err, ds.ErrNotFound) {
 		stats.Record(s.ctx, metrics.FailureIsIndexedCount.M(1))
 		return false, err
 	}
 	stats.Record(s.ctx, metrics.SuccessIsIndexedCount.M(1))
-	return !t.IsZero(), nil
+	// A piece with a checkpoint is only partially indexed
+	return !md.IndexedAt.IsZero() && md.IndexCheckpoint == 0, nil
 }
 
 func (s *Store) IsCompleteIndex(ctx context.Context, pieceCid cid.Cid) (bool, error) {
@@ -329,6 +601,59 @@ func (s *Store) IsCompleteIndex(ctx context.Context, pieceCid cid.Cid) (bool, er
 	return md.CompleteIndex, nil
 }
 
+func (s *Store) SetIndexCheckpoint(ctx context.Context, pieceCid cid.Cid, checkpoint uint64) error {
+	log.Debugw("handle.set-index-checkpoint", "pieceCid", pieceCid, "checkpoint", checkpoint)
+
+	ctx, span := tracing.Tracer.Start(ctx, "store.set_index_checkpoint")
+	defer span.End()
+
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.Endpoint, "ldb.set_index_checkpoint"))
+	stop := metrics.Timer(ctx, metrics.APIRequestDuration)
+	defer stop()
+
+	defer func(now time.Time) {
+		log.Debugw("handled.set-index-checkpoint", "took", time.Since(now).String())
+	}(time.Now())
+
+	s.Lock()
+	defer s.Unlock()
+
+	md, err := s.db.GetPieceCidToMetadata(ctx, pieceCid)
+	if err != nil {
+		if !errors.Is(err, ds.ErrNotFound) {
+			stats.Record(s.ctx, metrics.FailureSetIndexCheckpointCount.M(1))
+			return fmt.Errorf("getting piece cid metadata for piece %s: %w", pieceCid, err)
+		}
+		// the checkpoint may be set before the first records are added,
+		// so create new metadata with its own cursor
+		md, err = s.newPieceMetadata(ctx)
+		if err != nil {
+			stats.Record(s.ctx, metrics.FailureSetIndexCheckpointCount.M(1))
+			return err
+		}
+	}
+
+	md.IndexCheckpoint = checkpoint
+	if checkpoint == 0 && md.Segments > 0 {
+		// The index build is complete, so merge the segments that were
+		// added for each batch into a single index (this also saves the
+		// metadata)
+		if err := s.mergeCompactIndex(ctx, pieceCid, &md, nil); err != nil {
+			stats.Record(s.ctx, metrics.FailureSetIndexCheckpointCount.M(1))
+			return fmt.Errorf("merging compact index segments for piece %s: %w", pieceCid, err)
+		}
+	} else {
+		err = s.db.SetPieceCidToMetadata(ctx, pieceCid, md)
+		if err != nil {
+			stats.Record(s.ctx, metrics.FailureSetIndexCheckpointCount.M(1))
+			return err
+		}
+	}
+
+	stats.Record(s.ctx, metrics.SuccessSetIndexCheckpointCount.M(1))
+	return nil
+}
+
 func (s *Store) AddIndex(ctx context.Context, pieceCid cid.Cid, records []model.Record, isCompleteIndex bool) <-chan types.AddIndexProgress {
 	log.Debugw("handle.add-index", "records", len(records))
 
@@ -373,9 +698,6 @@ func (s *Store) AddIndex(ctx context.Context, pieceCid cid.Cid, records []model.
 		}
 		progress <- types.AddIndexProgress{Progress: 0.45}
 
-		var cursor uint64
-		var keyCursorPrefix string
-
 		// get the metadata for the piece
 		// allocate a new cursor only if metadata doesn't exist. This is required to be able
 		// to handle multiple AddIndex calls for the same pieceCid
@@ -386,43 +708,25 @@ func (s *Store) AddIndex(ctx context.Context, pieceCid cid.Cid, records []model.
 				return
 			}
 			// there isn't yet any metadata, so create new metadata
-			md = newLeveldbMetadata()
-
-			// get and set next cursor (handle synchronization, maybe with CAS)
-			cursor, keyCursorPrefix, err = s.db.NextCursor(ctx)
-			if err != nil {
-				progress <- types.AddIndexProgress{Err: err.Error()}
-				return
-			}
-
-			// allocate metadata for pieceCid
-			err = s.db.SetNextCursor(ctx, cursor+1)
+			md, err = s.newPieceMetadata(ctx)
 			if err != nil {
 				progress <- types.AddIndexProgress{Err: err.Error()}
 				return
 			}
-
-			md.Cursor = cursor
-			md.CompleteIndex = isCompleteIndex
-		} else {
-			cursor = md.Cursor
-			keyCursorPrefix = KeyCursorPrefix(cursor)
 		}
-
-		// process index and store entries
-		for _, rec := range records {
-			err := s.db.AddIndexRecord(ctx, keyCursorPrefix, rec)
-			if err != nil {
-				progress <- types.AddIndexProgress{Err: err.Error()}
-				return
-			}
+		if md.IndexedAt.IsZero() {
+			// this is the first AddIndex call for the piece (the metadata
+			// may have been created by SetIndexCheckpoint)
+			md.CompleteIndex = isCompleteIndex
 		}
-		progress <- types.AddIndexProgress{Progress: 0.9}
+		cursor := md.Cursor
 
-		// mark indexing as complete
+		// mark indexing as complete: the metadata is saved together with
+		// the index entries
 		md.IndexedAt = time.Now()
 
-		err = s.db.SetPieceCidToMetadata(ctx, pieceCid, md)
+		// process index and store entries
+		err = s.addCompactIndex(ctx, pieceCid, &md, records)
 		if err != nil {
 			progress <- types.AddIndexProgress{Err: err.Error()}
 			return
@@ -558,8 +862,8 @@ func (s *Store) NextPiecesToCheck(ctx context.Context, maddr address.Address) ([
 	return out, nil
 }
 
-func (s *Store) FlagPiece(ctx context.Context, pieceCid cid.Cid, hasUnsealedCopy bool, maddr address.Address) error {
-	log.Debugw("handle.flag-piece", "piece-cid", pieceCid, "hasUnsealedCopy", hasUnsealedCopy)
+func (s *Store) FlagPiece(ctx context.Context, pieceCid cid.Cid, hasUnsealedCopy bool, maddr address.Address, reason model.FlagReason, details string) error {
+	log.Debugw("handle.flag-piece", "piece-cid", pieceCid, "hasUnsealedCopy", hasUnsealedCopy, "reason", reason)
 
 	ctx, span := tracing.Tracer.Start(ctx, "store.flag_piece")
 	defer span.End()
@@ -590,6 +894,8 @@ func (s *Store) FlagPiece(ctx context.Context, pieceCid cid.Cid, hasUnsealedCopy
 
 	fm.UpdatedAt = now
 	fm.HasUnsealedCopy = hasUnsealedCopy
+	fm.Reason = reason
+	fm.Details = details
 
 	// Write the piece metadata back to the db
 	err = s.db.SetPieceCidToFlagged(ctx, pieceCid, maddr, fm)
@@ -822,6 +1128,7 @@ func (s *Store) RemoveIndexes(ctx context.Context, pieceCid cid.Cid) error {
 	}
 
 	md.IndexedAt = time.Time{}
+	md.IndexCheckpoint = 0
 
 	if err = s.db.SetPieceCidToMetadata(ctx, pieceCid, md); err == nil {
 		failureMetrics = false
@@ -844,11 +1151,16 @@ func (s *Store) UntrackPiece(ctx context.Context, pieceCid cid.Cid, maddr addres
 		log.Debugw("handled.untack-piece", "took", time.Since(now).String())
 	}(time.Now())
 
-	stats.Record(s.ctx, metrics.FailureUntrackPieceCount.M(1))
+	// LEVELDB does not have a separate PieceTracker table: all pieces to be
+	// checked are picked from the main table, so mark the piece as untracked
+	// for the miner instead. The mark is cleared if a new deal for the piece
+	// is added on the miner.
+	err := s.db.SetPieceUntracked(ctx, pieceCid, maddr)
+	if err != nil {
+		stats.Record(s.ctx, metrics.FailureUntrackPieceCount.M(1))
+		return fmt.Errorf("untracking piece %s: %w", pieceCid, err)
+	}
 
-	// LEVELDB does not have a separate PieceTracker table
-	// All pieces to be checked are picked from the main table
-	// so there is no need to delete anything
+	stats.Record(s.ctx, metrics.SuccessUntrackPieceCount.M(1))
 	return nil
-
 }
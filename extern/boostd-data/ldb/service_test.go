@@ -0,0 +1,262 @@
+package ldb
+
+import (
+	"context"
+	"testing"
+
+	"github.com/filecoin-project/boost/extern/boostd-data/model"
+	"github.com/filecoin-project/boost/extern/boostd-data/svc/types"
+	"github.com/filecoin-project/boost/extern/boostd-data/testutils"
+	"github.com/filecoin-project/go-address"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car/v2/index"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateToCompactIndex(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := NewStore("")
+	require.NoError(t, s.Start(ctx))
+
+	// Write an index in the version 1 format, with each record stored under
+	// a separate key
+	pieceCid := testutils.GenerateCid()
+	var recs []model.Record
+	var carRecs []index.Record
+	for i, c := range testutils.GenerateCids(3000) {
+		rec := model.Record{Cid: c, OffsetSize: model.OffsetSize{Offset: uint64(i * 100), Size: 100}}
+		recs = append(recs, rec)
+		carRecs = append(carRecs, index.Record{Cid: c, Offset: rec.Offset})
+	}
+
+	cursor, cursorPrefix, err := s.db.NextCursor(ctx)
+	require.NoError(t, err)
+	require.NoError(t, s.db.SetNextCursor(ctx, cursor+1))
+	require.NoError(t, s.db.SetMultihashesToPieceCid(ctx, carRecs, pieceCid))
+	for _, rec := range recs {
+		require.NoError(t, s.db.AddIndexRecord(ctx, cursorPrefix, rec))
+	}
+	md := newLeveldbMetadata()
+	md.Version = pieceMetadataVersionRecords
+	md.Cursor = cursor
+	md.CompleteIndex = true
+	require.NoError(t, s.db.SetPieceCidToMetadata(ctx, pieceCid, md))
+
+	// Migrate the index to the compact index format
+	s.migrateIndexes(ctx)
+
+	md, err = s.db.GetPieceCidToMetadata(ctx, pieceCid)
+	require.NoError(t, err)
+	require.Equal(t, pieceMetadataVersion, md.Version)
+
+	// The records stored under separate keys should have been removed
+	oldRecs, err := s.db.AllRecords(ctx, cursor)
+	require.NoError(t, err)
+	require.Empty(t, oldRecs)
+
+	// The index should be readable through the service
+	idx, err := s.GetIndex(ctx, pieceCid)
+	require.NoError(t, err)
+	var count int
+	for r := range idx {
+		require.Empty(t, r.Error)
+		count++
+	}
+	require.Equal(t, len(recs), count)
+
+	for _, rec := range recs {
+		ofsz, err := s.GetOffsetSize(ctx, pieceCid, rec.Cid.Hash())
+		require.NoError(t, err)
+		require.Equal(t, rec.OffsetSize, *ofsz)
+	}
+
+	// Adding more records should merge them with the existing index
+	more := []model.Record{{Cid: testutils.GenerateCid(), OffsetSize: model.OffsetSize{Offset: 1, Size: 2}}}
+	for p := range s.AddIndex(ctx, pieceCid, more, true) {
+		require.Empty(t, p.Err)
+	}
+	ofsz, err := s.GetOffsetSize(ctx, pieceCid, more[0].Cid.Hash())
+	require.NoError(t, err)
+	require.Equal(t, more[0].OffsetSize, *ofsz)
+	ofsz, err = s.GetOffsetSize(ctx, pieceCid, recs[0].Cid.Hash())
+	require.NoError(t, err)
+	require.Equal(t, recs[0].OffsetSize, *ofsz)
+
+	// A multihash that is not in the index should not be found
+	_, err = s.GetOffsetSize(ctx, pieceCid, testutils.GenerateCid().Hash())
+	require.True(t, types.IsNotFound(err))
+
+	// Removing the piece should remove the compact index and the
+	// multihash to piece cid mappings
+	require.NoError(t, s.RemovePieceMetadata(ctx, pieceCid))
+	_, err = s.db.GetCompactIndexDirectory(ctx, cursor, 0)
+	require.Error(t, err)
+	_, err = s.PiecesContainingMultihash(ctx, recs[0].Cid.Hash())
+	require.True(t, types.IsNotFound(err))
+}
+
+func TestFlaggedPiecesReason(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := NewStore("")
+	require.NoError(t, s.Start(ctx))
+
+	maddr := address.TestAddress
+	missingIndex := testutils.GenerateCid()
+	require.NoError(t, s.FlagPiece(ctx, missingIndex, true, maddr, model.FlagReasonMissingIndex, "no index"))
+	mismatch := testutils.GenerateCid()
+	require.NoError(t, s.FlagPiece(ctx, mismatch, true, maddr, model.FlagReasonBlockMismatch, "2 of 10 blocks do not match"))
+
+	// Filter on the reason
+	hasUnsealedCopy := true
+	filter := &types.FlaggedPiecesListFilter{HasUnsealedCopy: &hasUnsealedCopy, Reason: model.FlagReasonBlockMismatch}
+	pieces, err := s.FlaggedPiecesList(ctx, filter, nil, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, pieces, 1)
+	require.Equal(t, mismatch, pieces[0].PieceCid)
+	require.Equal(t, model.FlagReasonBlockMismatch, pieces[0].Reason)
+	require.Equal(t, "2 of 10 blocks do not match", pieces[0].Details)
+
+	count, err := s.FlaggedPiecesCount(ctx, filter)
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+
+	// Without a reason all pieces should match
+	count, err = s.FlaggedPiecesCount(ctx, &types.FlaggedPiecesListFilter{HasUnsealedCopy: &hasUnsealedCopy})
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+
+	// Without the unsealed copy filter, pieces with and without an unsealed
+	// copy should match
+	expired := testutils.GenerateCid()
+	require.NoError(t, s.FlagPiece(ctx, expired, false, maddr, model.FlagReasonExpiredDeal, "no active deals"))
+	count, err = s.FlaggedPiecesCount(ctx, &types.FlaggedPiecesListFilter{MinerAddr: maddr})
+	require.NoError(t, err)
+	require.Equal(t, 3, count)
+	noUnsealedCopy := false
+	count, err = s.FlaggedPiecesCount(ctx, &types.FlaggedPiecesListFilter{HasUnsealedCopy: &noUnsealedCopy})
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+
+	// Flagging the piece again should update the reason
+	require.NoError(t, s.FlagPiece(ctx, missingIndex, true, maddr, model.FlagReasonBlockMismatch, "1 of 10 blocks do not match"))
+	count, err = s.FlaggedPiecesCount(ctx, filter)
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+}
+
+func TestCheckpointBeforeFirstIndex(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := NewStore("")
+	require.NoError(t, s.Start(ctx))
+
+	// Set a checkpoint for two new pieces before their first records are
+	// added, as an index build does
+	pieces := []struct {
+		pieceCid cid.Cid
+		recs     []model.Record
+	}{{pieceCid: testutils.GenerateCid()}, {pieceCid: testutils.GenerateCid()}}
+	for i := range pieces {
+		for _, c := range testutils.GenerateCids(10) {
+			pieces[i].recs = append(pieces[i].recs, model.Record{Cid: c, OffsetSize: model.OffsetSize{Offset: 1, Size: 1}})
+		}
+		require.NoError(t, s.SetIndexCheckpoint(ctx, pieces[i].pieceCid, 1))
+	}
+	for _, p := range pieces {
+		for prog := range s.AddIndex(ctx, p.pieceCid, p.recs, true) {
+			require.Empty(t, prog.Err)
+		}
+	}
+
+	// Each piece should have its own cursor and a complete index that only
+	// contains its own records
+	cursors := make(map[uint64]struct{})
+	for _, p := range pieces {
+		md, err := s.db.GetPieceCidToMetadata(ctx, p.pieceCid)
+		require.NoError(t, err)
+		require.True(t, md.CompleteIndex)
+		cursors[md.Cursor] = struct{}{}
+
+		idx, err := s.GetIndex(ctx, p.pieceCid)
+		require.NoError(t, err)
+		var count int
+		for r := range idx {
+			require.Empty(t, r.Error)
+			count++
+		}
+		require.Equal(t, len(p.recs), count)
+	}
+	require.Len(t, cursors, len(pieces))
+}
+
+func TestAddIndexSegments(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := NewStore("")
+	require.NoError(t, s.Start(ctx))
+
+	// Add the index in batches, as an index build does
+	pieceCid := testutils.GenerateCid()
+	var recs []model.Record
+	for i, c := range testutils.GenerateCids(300) {
+		recs = append(recs, model.Record{Cid: c, OffsetSize: model.OffsetSize{Offset: uint64(i*100 + 1), Size: 100}})
+	}
+	require.NoError(t, s.SetIndexCheckpoint(ctx, pieceCid, recs[0].Offset))
+	for i := 0; i < len(recs); i += 100 {
+		for p := range s.AddIndex(ctx, pieceCid, recs[i:i+100], true) {
+			require.Empty(t, p.Err)
+		}
+		require.NoError(t, s.SetIndexCheckpoint(ctx, pieceCid, recs[i+99].Offset+1))
+	}
+
+	// Each batch should have been added as a separate segment
+	md, err := s.db.GetPieceCidToMetadata(ctx, pieceCid)
+	require.NoError(t, err)
+	require.Equal(t, 3, md.Segments)
+	_, err = s.db.GetCompactIndexDirectory(ctx, md.Cursor, 0)
+	require.Error(t, err)
+
+	checkIndex := func() {
+		idx, err := s.GetIndex(ctx, pieceCid)
+		require.NoError(t, err)
+		var count int
+		for r := range idx {
+			require.Empty(t, r.Error)
+			count++
+		}
+		require.Equal(t, len(recs), count)
+
+		for _, rec := range recs {
+			ofsz, err := s.GetOffsetSize(ctx, pieceCid, rec.Cid.Hash())
+			require.NoError(t, err)
+			require.Equal(t, rec.OffsetSize, *ofsz)
+		}
+	}
+	checkIndex()
+
+	// The piece should not be reported as indexed while the checkpoint is set
+	indexed, err := s.IsIndexed(ctx, pieceCid)
+	require.NoError(t, err)
+	require.False(t, indexed)
+
+	// Clearing the checkpoint at the end of the build should merge the
+	// segments into the main index
+	require.NoError(t, s.SetIndexCheckpoint(ctx, pieceCid, 0))
+	indexed, err = s.IsIndexed(ctx, pieceCid)
+	require.NoError(t, err)
+	require.True(t, indexed)
+	md, err = s.db.GetPieceCidToMetadata(ctx, pieceCid)
+	require.NoError(t, err)
+	require.Zero(t, md.Segments)
+	for segment := 1; segment <= 3; segment++ {
+		_, err = s.db.GetCompactIndexDirectory(ctx, md.Cursor, segment)
+		require.Error(t, err)
+	}
+	checkIndex()
+}
@@ -52,6 +52,7 @@ var (
 	SuccessFlaggedPiecesListCount         = stats.Int64("success_flagged_pieces_list_count", "Counter of flagged pieces list success", stats.UnitDimensionless)
 	SuccessFlaggedPiecesCountCount        = stats.Int64("success_flagged_pieces_count_count", "Counter of flagged pieces count success", stats.UnitDimensionless)
 	SuccessUntrackPieceCount              = stats.Int64("success_untrack_piece", "Counter of untrack piece success", stats.UnitDimensionless)
+	SuccessSetIndexCheckpointCount        = stats.Int64("success_set_index_checkpoint_count", "Counter of set index checkpoint success", stats.UnitDimensionless)
 	FailureAddDealForPieceCount           = stats.Int64("failure_add_deal_for_piece_count", "Counter of add deal failure", stats.UnitDimensionless)
 	FailureAddIndexCount                  = stats.Int64("failure_add_index_count", "Counter of add index failure", stats.UnitDimensionless)
 	FailureIsIndexedCount                 = stats.Int64("failure_is_indexed_count", "Counter of is indexed failure", stats.UnitDimensionless)
@@ -74,6 +75,13 @@ var (
 	FailureFlaggedPiecesListCount         = stats.Int64("failure_flagged_pieces_list_count", "Counter of flagged pieces list failure", stats.UnitDimensionless)
 	FailureFlaggedPiecesCountCount        = stats.Int64("failure_flagged_pieces_count_count", "Counter of flagged pieces count failure", stats.UnitDimensionless)
 	FailureUntrackPieceCount              = stats.Int64("failure_untrack_piece", "Counter of untrack piece failure", stats.UnitDimensionless)
+	FailureSetIndexCheckpointCount        = stats.Int64("failure_set_index_checkpoint_count", "Counter of set index checkpoint failure", stats.UnitDimensionless)
+
+	// multihash filter
+	MultihashFilterFalsePositiveRate  = stats.Float64("multihash_filter_false_positive_rate", "Estimated false positive rate of the multihash filter", stats.UnitDimensionless)
+	MultihashFilterDefiniteMissCount  = stats.Int64("multihash_filter_definite_miss_count", "Counter of multihash lookups answered by the multihash filter", stats.UnitDimensionless)
+	MultihashFilterFalsePositiveCount = stats.Int64("multihash_filter_false_positive_count", "Counter of multihash lookups that passed the multihash filter but were not found", stats.UnitDimensionless)
+	MultihashFilterRebuildCount       = stats.Int64("multihash_filter_rebuild_count", "Counter of multihash filter rebuilds", stats.UnitDimensionless)
 )
 
 var (
@@ -113,6 +121,7 @@ var (
 	SuccessFlaggedPiecesListCountView         = &view.View{Measure: SuccessFlaggedPiecesListCount, Aggregation: view.Count()}
 	SuccessFlaggedPiecesCountCountView        = &view.View{Measure: SuccessFlaggedPiecesCountCount, Aggregation: view.Count()}
 	SuccessUntrackPieceCountView              = &view.View{Measure: SuccessUntrackPieceCount, Aggregation: view.Count()}
+	SuccessSetIndexCheckpointCountView        = &view.View{Measure: SuccessSetIndexCheckpointCount, Aggregation: view.Count()}
 	FailureAddDealForPieceCountView           = &view.View{Measure: FailureAddDealForPieceCount, Aggregation: view.Count()}
 	FailureAddIndexCountView                  = &view.View{Measure: FailureAddIndexCount, Aggregation: view.Count()}
 	FailureIsIndexedCountView                 = &view.View{Measure: FailureIsIndexedCount, Aggregation: view.Count()}
@@ -135,6 +144,13 @@ var (
 	FailureFlaggedPiecesListCountView         = &view.View{Measure: FailureFlaggedPiecesListCount, Aggregation: view.Count()}
 	FailureFlaggedPiecesCountCountView        = &view.View{Measure: FailureFlaggedPiecesCountCount, Aggregation: view.Count()}
 	FailureUntrackPieceCountView              = &view.View{Measure: FailureUntrackPieceCount, Aggregation: view.Count()}
+	FailureSetIndexCheckpointCountView        = &view.View{Measure: FailureSetIndexCheckpointCount, Aggregation: view.Count()}
+
+	// multihash filter
+	MultihashFilterFalsePositiveRateView  = &view.View{Measure: MultihashFilterFalsePositiveRate, Aggregation: view.LastValue()}
+	MultihashFilterDefiniteMissCountView  = &view.View{Measure: MultihashFilterDefiniteMissCount, Aggregation: view.Count()}
+	MultihashFilterFalsePositiveCountView = &view.View{Measure: MultihashFilterFalsePositiveCount, Aggregation: view.Count()}
+	MultihashFilterRebuildCountView       = &view.View{Measure: MultihashFilterRebuildCount, Aggregation: view.Count()}
 )
 
 // DefaultViews is an array of OpenCensus views for metric gathering purposes
@@ -164,6 +180,7 @@ var DefaultViews = func() []*view.View {
 		SuccessFlaggedPiecesListCountView,
 		SuccessFlaggedPiecesCountCountView,
 		SuccessUntrackPieceCountView,
+		SuccessSetIndexCheckpointCountView,
 		FailureAddDealForPieceCountView,
 		FailureAddIndexCountView,
 		FailureIsIndexedCountView,
@@ -186,6 +203,11 @@ var DefaultViews = func() []*view.View {
 		FailureFlaggedPiecesListCountView,
 		FailureFlaggedPiecesCountCountView,
 		FailureUntrackPieceCountView,
+		FailureSetIndexCheckpointCountView,
+		MultihashFilterFalsePositiveRateView,
+		MultihashFilterDefiniteMissCountView,
+		MultihashFilterFalsePositiveCountView,
+		MultihashFilterRebuildCountView,
 	}
 	//views = append(views, blockstore.DefaultViews...)
 	views = append(views, rpcmetrics.DefaultViews...)
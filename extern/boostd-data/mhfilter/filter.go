@@ -0,0 +1,201 @@
+package mhfilter
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"math/bits"
+
+	"github.com/cespare/xxhash/v2"
+	mh "github.com/multiformats/go-multihash"
+)
+
+var fileMagic = [4]byte{'m', 'h', 'b', 'f'}
+
+const fileVersion = 1
+
+// magic, version, pieces digest, m, k, set bits, count
+const headerSize = 4 + 4 + 32 + 8 + 4 + 8 + 8
+
+// the size of the checksum at the end of the file
+const checksumSize = 4
+
+// The largest filter parameters accepted when reading a filter file, so that
+// a corrupt header can't cause a huge allocation. A filter with 2^40 bits
+// (128GiB) holds about 10^11 multihashes at a 1% false positive rate.
+const (
+	maxFilterBits    = 1 << 40
+	maxHashFunctions = 64
+)
+
+// bloomFilter is a bloom filter over multihashes. It is not thread-safe.
+type bloomFilter struct {
+	bits []uint64
+	// the number of bits in the filter
+	m uint64
+	// the number of hash functions
+	k uint32
+	// the number of bits that are set
+	setBits uint64
+	// the number of multihashes that have been added to the filter
+	count uint64
+}
+
+// newBloomFilter creates a bloom filter sized to hold n items with the
+// given false positive rate
+func newBloomFilter(n uint64, fpRate float64) *bloomFilter {
+	if n < 1 {
+		n = 1
+	}
+	m := uint64(math.Ceil(-float64(n) * math.Log(fpRate) / (math.Ln2 * math.Ln2)))
+	// Round up to a whole number of words
+	m = (m + 63) / 64 * 64
+	k := uint32(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &bloomFilter{bits: make([]uint64, m/64), m: m, k: k}
+}
+
+// hashes returns the two base hashes of the multihash that are combined
+// to create the k hash functions (Kirsch-Mitzenmacher)
+func hashes(m mh.Multihash) (uint64, uint64) {
+	h1 := xxhash.Sum64(m)
+	// Derive a second independent hash by mixing the first (splitmix64)
+	h2 := h1 + 0x9e3779b97f4a7c15
+	h2 = (h2 ^ (h2 >> 30)) * 0xbf58476d1ce4e5b9
+	h2 = (h2 ^ (h2 >> 27)) * 0x94d049bb133111eb
+	h2 = h2 ^ (h2 >> 31)
+	// Make sure the second hash is odd so that it cycles through all bits
+	return h1, h2 | 1
+}
+
+func (f *bloomFilter) add(m mh.Multihash) {
+	h1, h2 := hashes(m)
+	for i := uint32(0); i < f.k; i++ {
+		bit := (h1 + uint64(i)*h2) % f.m
+		word, mask := bit/64, uint64(1)<<(bit%64)
+		if f.bits[word]&mask == 0 {
+			f.bits[word] |= mask
+			f.setBits++
+		}
+	}
+	f.count++
+}
+
+// has returns false if the multihash is definitely not in the filter
+func (f *bloomFilter) has(m mh.Multihash) bool {
+	h1, h2 := hashes(m)
+	for i := uint32(0); i < f.k; i++ {
+		bit := (h1 + uint64(i)*h2) % f.m
+		if f.bits[bit/64]&(uint64(1)<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// falsePositiveRate estimates the current false positive rate of the filter
+// from the proportion of bits that are set
+func (f *bloomFilter) falsePositiveRate() float64 {
+	return math.Pow(float64(f.setBits)/float64(f.m), float64(f.k))
+}
+
+// writeTo writes the filter in a binary format followed by a checksum.
+// The pieces digest identifies the set of pieces that were in the store
+// when the filter was written.
+func (f *bloomFilter) writeTo(w io.Writer, piecesDigest [32]byte) error {
+	crc := crc32.NewIEEE()
+	bw := bufio.NewWriter(io.MultiWriter(w, crc))
+
+	hdr := make([]byte, 0, headerSize)
+	hdr = append(hdr, fileMagic[:]...)
+	hdr = binary.LittleEndian.AppendUint32(hdr, fileVersion)
+	hdr = append(hdr, piecesDigest[:]...)
+	hdr = binary.LittleEndian.AppendUint64(hdr, f.m)
+	hdr = binary.LittleEndian.AppendUint32(hdr, f.k)
+	hdr = binary.LittleEndian.AppendUint64(hdr, f.setBits)
+	hdr = binary.LittleEndian.AppendUint64(hdr, f.count)
+	if _, err := bw.Write(hdr); err != nil {
+		return err
+	}
+
+	word := make([]byte, 8)
+	for _, b := range f.bits {
+		binary.LittleEndian.PutUint64(word, b)
+		if _, err := bw.Write(word); err != nil {
+			return err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	_, err := w.Write(binary.LittleEndian.AppendUint32(nil, crc.Sum32()))
+	return err
+}
+
+var errBadChecksum = errors.New("bad checksum")
+
+// readBloomFilter reads a filter of the given size in bytes written by
+// writeTo and verifies its checksum. It returns the filter and the pieces
+// digest.
+func readBloomFilter(r io.Reader, size int64) (*bloomFilter, [32]byte, error) {
+	var piecesDigest [32]byte
+
+	crc := crc32.NewIEEE()
+	br := io.TeeReader(bufio.NewReader(r), crc)
+
+	hdr := make([]byte, headerSize)
+	if _, err := io.ReadFull(br, hdr); err != nil {
+		return nil, piecesDigest, fmt.Errorf("reading header: %w", err)
+	}
+	if [4]byte(hdr[:4]) != fileMagic {
+		return nil, piecesDigest, fmt.Errorf("not a multihash filter file")
+	}
+	if v := binary.LittleEndian.Uint32(hdr[4:]); v != fileVersion {
+		return nil, piecesDigest, fmt.Errorf("unsupported multihash filter file version %d", v)
+	}
+	copy(piecesDigest[:], hdr[8:40])
+
+	f := &bloomFilter{
+		m:       binary.LittleEndian.Uint64(hdr[40:]),
+		k:       binary.LittleEndian.Uint32(hdr[48:]),
+		setBits: binary.LittleEndian.Uint64(hdr[52:]),
+		count:   binary.LittleEndian.Uint64(hdr[60:]),
+	}
+	if f.m == 0 || f.m%64 != 0 || f.m > maxFilterBits || f.k == 0 || f.k > maxHashFunctions {
+		return nil, piecesDigest, fmt.Errorf("invalid multihash filter parameters m=%d k=%d", f.m, f.k)
+	}
+	// Check that the file holds exactly the number of bits in the header
+	// before allocating them
+	if int64(f.m/8) != size-headerSize-checksumSize {
+		return nil, piecesDigest, fmt.Errorf("multihash filter with %d bits does not match file size %d", f.m, size)
+	}
+
+	f.bits = make([]uint64, f.m/64)
+	word := make([]byte, 8)
+	var setBits uint64
+	for i := range f.bits {
+		if _, err := io.ReadFull(br, word); err != nil {
+			return nil, piecesDigest, fmt.Errorf("reading filter bits: %w", err)
+		}
+		f.bits[i] = binary.LittleEndian.Uint64(word)
+		setBits += uint64(bits.OnesCount64(f.bits[i]))
+	}
+
+	expected := crc.Sum32()
+	sum := make([]byte, checksumSize)
+	if _, err := io.ReadFull(br, sum); err != nil {
+		return nil, piecesDigest, fmt.Errorf("reading checksum: %w", err)
+	}
+	if binary.LittleEndian.Uint32(sum) != expected || setBits != f.setBits {
+		return nil, piecesDigest, errBadChecksum
+	}
+
+	return f, piecesDigest, nil
+}
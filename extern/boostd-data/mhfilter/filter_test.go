@@ -0,0 +1,83 @@
+package mhfilter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/filecoin-project/boost/extern/boostd-data/testutils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBloomFilter(t *testing.T) {
+	const count = 10_000
+	const fpRate = 0.01
+
+	f := newBloomFilter(count, fpRate)
+	cids := testutils.GenerateCids(count * 2)
+	for _, c := range cids[:count] {
+		f.add(c.Hash())
+	}
+
+	// There should never be false negatives
+	for _, c := range cids[:count] {
+		require.True(t, f.has(c.Hash()))
+	}
+
+	// The false positive rate should be close to the target rate
+	var falsePositives int
+	for _, c := range cids[count:] {
+		if f.has(c.Hash()) {
+			falsePositives++
+		}
+	}
+	measured := float64(falsePositives) / count
+	require.Less(t, measured, fpRate*2)
+	require.InDelta(t, fpRate, f.falsePositiveRate(), fpRate/2)
+}
+
+func TestBloomFilterPersistence(t *testing.T) {
+	f := newBloomFilter(1000, 0.01)
+	cids := testutils.GenerateCids(1000)
+	for _, c := range cids {
+		f.add(c.Hash())
+	}
+
+	digest := digestPieces(testutils.GenerateCids(3))
+
+	var buf bytes.Buffer
+	require.NoError(t, f.writeTo(&buf, digest))
+
+	read, readDigest, err := readBloomFilter(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	require.Equal(t, digest, readDigest)
+	require.Equal(t, f.m, read.m)
+	require.Equal(t, f.k, read.k)
+	require.Equal(t, f.count, read.count)
+	require.Equal(t, f.setBits, read.setBits)
+	for _, c := range cids {
+		require.True(t, read.has(c.Hash()))
+	}
+
+	// Corrupt a bit in the filter
+	corrupt := bytes.Clone(buf.Bytes())
+	corrupt[headerSize+10] ^= 0x01
+	_, _, err = readBloomFilter(bytes.NewReader(corrupt), int64(len(corrupt)))
+	require.ErrorIs(t, err, errBadChecksum)
+
+	// Truncate the filter
+	_, _, err = readBloomFilter(bytes.NewReader(buf.Bytes()[:buf.Len()-10]), int64(buf.Len()-10))
+	require.Error(t, err)
+
+	// A header with a number of bits that doesn't match the file size should
+	// be rejected before the bits are allocated
+	huge := bytes.Clone(buf.Bytes())
+	binary.LittleEndian.PutUint64(huge[40:], 1<<62)
+	_, _, err = readBloomFilter(bytes.NewReader(huge), int64(len(huge)))
+	require.ErrorContains(t, err, "invalid multihash filter parameters")
+
+	larger := bytes.Clone(buf.Bytes())
+	binary.LittleEndian.PutUint64(larger[40:], f.m*2)
+	_, _, err = readBloomFilter(bytes.NewReader(larger), int64(len(larger)))
+	require.ErrorContains(t, err, "does not match file size")
+}
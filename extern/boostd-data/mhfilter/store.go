@@ -0,0 +1,516 @@
+// Package mhfilter wraps a local index directory store with an in-memory
+// bloom filter of all indexed multihashes, so that lookups for multihashes
+// that are not in any piece can be answered without querying the store.
+//
+// The filter is updated incrementally as indexes are added. Because a bloom
+// filter does not support removal, the filter is rebuilt from the store in
+// the background when enough pieces have been removed, or when the filter
+// has grown beyond its capacity. While the filter is being built every
+// lookup is passed through to the store.
+//
+// The filter is persisted to disk periodically, together with a digest of
+// the set of pieces in the store. On startup the persisted filter is only
+// used if the set of pieces in the store has not changed, so that changes
+// made while the service was stopped do not cause false negatives.
+//
+// The filter only sees indexes that are added through this service, so it
+// must not be enabled when several local index directory services write to
+// the same database.
+package mhfilter
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/boost/extern/boostd-data/metrics"
+	"github.com/filecoin-project/boost/extern/boostd-data/model"
+	"github.com/filecoin-project/boost/extern/boostd-data/svc/types"
+	"github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log/v2"
+	mh "github.com/multiformats/go-multihash"
+	"go.opencensus.io/stats"
+)
+
+var log = logging.Logger("mhfilter")
+
+type Config struct {
+	// The path of the file that the filter is persisted to.
+	// If empty the filter is not persisted.
+	Path string
+	// The target false positive rate of the filter
+	FalsePositiveRate float64
+	// The minimum number of multihashes the filter is sized for
+	MinCapacity uint64
+	// How often to check if the filter needs to be rebuilt, and to record
+	// the false positive rate metric
+	CheckInterval time.Duration
+	// How often to persist the filter to disk, if it has changed
+	SaveInterval time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		FalsePositiveRate: 0.01,
+		MinCapacity:       1 << 20,
+		CheckInterval:     time.Minute,
+		SaveInterval:      10 * time.Minute,
+	}
+}
+
+// The filter is rebuilt when its estimated false positive rate exceeds the
+// target rate by this factor
+const rebuildFalsePositiveFactor = 2
+
+// The filter is rebuilt when this proportion of the pieces that it was
+// built with have been removed
+const rebuildRemovedRatio = 0.1
+
+type Store struct {
+	types.ServiceImpl
+
+	cfg Config
+	ctx context.Context
+
+	lk sync.RWMutex
+	// the filter used to answer lookups, or nil if it has not been built yet
+	filter *bloomFilter
+	// the filter that is being rebuilt, or nil if there is no rebuild
+	// in progress
+	building *bloomFilter
+	// whether the filter has changed since it was last saved
+	dirty bool
+	// the number of pieces in the store when the filter was built
+	piecesAtBuild int
+	// the number of pieces removed since the filter was built
+	removedSinceBuild int
+
+	// held while the filter is being saved or rebuilt
+	bgLk sync.Mutex
+	// held for reading by each add index operation until it completes, and
+	// for writing when a rebuild starts, so that a rebuild only starts
+	// once all indexes that were not added to the new filter are in the store
+	addLk sync.RWMutex
+}
+
+var _ types.ServiceImpl = (*Store)(nil)
+
+func NewStore(impl types.ServiceImpl, cfg Config) *Store {
+	return &Store{ServiceImpl: impl, cfg: cfg}
+}
+
+func (s *Store) Start(ctx context.Context) error {
+	s.ctx = ctx
+
+	if err := s.ServiceImpl.Start(ctx); err != nil {
+		return err
+	}
+
+	loaded, err := s.load(ctx)
+	if err != nil {
+		log.Warnw("failed to load multihash filter: rebuilding filter", "path", s.cfg.Path, "err", err)
+	}
+	if !loaded {
+		// Start building the filter before returning, so that any indexes
+		// added from now on are added to the new filter
+		s.startBuilding()
+	}
+
+	go s.run(ctx, !loaded)
+
+	return nil
+}
+
+func (s *Store) run(ctx context.Context, rebuild bool) {
+	if rebuild {
+		s.rebuild(ctx)
+	}
+
+	checkTicker := time.NewTicker(s.cfg.CheckInterval)
+	defer checkTicker.Stop()
+	lastSave := time.Now()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-checkTicker.C:
+		}
+
+		if s.needsRebuild() {
+			s.rebuild(ctx)
+			lastSave = time.Now()
+			continue
+		}
+
+		if time.Since(lastSave) >= s.cfg.SaveInterval {
+			if err := s.save(ctx); err != nil {
+				log.Errorw("saving multihash filter", "path", s.cfg.Path, "err", err)
+			}
+			lastSave = time.Now()
+		}
+	}
+}
+
+func (s *Store) AddIndex(ctx context.Context, pieceCid cid.Cid, records []model.Record, isCompleteIndex bool) <-chan types.AddIndexProgress {
+	s.addLk.RLock()
+
+	// Add the multihashes to the filter before they are added to the store,
+	// so that there is never a moment when a multihash is in the store but
+	// not in the filter
+	s.lk.Lock()
+	for _, f := range []*bloomFilter{s.filter, s.building} {
+		if f == nil {
+			continue
+		}
+		for _, r := range records {
+			f.add(r.Cid.Hash())
+		}
+	}
+	s.dirty = true
+	s.lk.Unlock()
+
+	progress := s.ServiceImpl.AddIndex(ctx, pieceCid, records, isCompleteIndex)
+	out := make(chan types.AddIndexProgress, 1)
+	go func() {
+		defer close(out)
+		defer s.addLk.RUnlock()
+
+		for p := range progress {
+			out <- p
+		}
+
+		// The pieces in the store may have changed, so make sure the
+		// filter is saved with the new pieces digest
+		s.lk.Lock()
+		s.dirty = true
+		s.lk.Unlock()
+	}()
+	return out
+}
+
+func (s *Store) PiecesContainingMultihash(ctx context.Context, m mh.Multihash) ([]cid.Cid, error) {
+	s.lk.RLock()
+	useFilter := s.filter != nil
+	maybe := !useFilter || s.filter.has(m)
+	s.lk.RUnlock()
+
+	if !maybe {
+		stats.Record(s.ctx, metrics.MultihashFilterDefiniteMissCount.M(1))
+		return nil, fmt.Errorf("multihash %s: %s", m, types.ErrNotFound)
+	}
+
+	pcids, err := s.ServiceImpl.PiecesContainingMultihash(ctx, m)
+	if useFilter && types.IsNotFound(err) {
+		stats.Record(s.ctx, metrics.MultihashFilterFalsePositiveCount.M(1))
+	}
+	return pcids, err
+}
+
+func (s *Store) RemovePieceMetadata(ctx context.Context, pieceCid cid.Cid) error {
+	err := s.ServiceImpl.RemovePieceMetadata(ctx, pieceCid)
+	if err == nil {
+		s.pieceRemoved()
+	}
+	return err
+}
+
+func (s *Store) RemoveIndexes(ctx context.Context, pieceCid cid.Cid) error {
+	err := s.ServiceImpl.RemoveIndexes(ctx, pieceCid)
+	if err == nil {
+		s.pieceRemoved()
+	}
+	return err
+}
+
+func (s *Store) pieceRemoved() {
+	s.lk.Lock()
+	s.removedSinceBuild++
+	s.lk.Unlock()
+}
+
+func (s *Store) needsRebuild() bool {
+	s.lk.RLock()
+	defer s.lk.RUnlock()
+
+	if s.building != nil {
+		return false
+	}
+	if s.filter == nil {
+		// The initial build of the filter failed, so try again
+		return true
+	}
+
+	fpRate := s.filter.falsePositiveRate()
+	stats.Record(s.ctx, metrics.MultihashFilterFalsePositiveRate.M(fpRate))
+	if fpRate > s.cfg.FalsePositiveRate*rebuildFalsePositiveFactor {
+		log.Infow("multihash filter false positive rate is too high: rebuilding filter",
+			"rate", fpRate, "target", s.cfg.FalsePositiveRate)
+		return true
+	}
+
+	if s.removedSinceBuild > 0 && float64(s.removedSinceBuild) >= float64(s.piecesAtBuild)*rebuildRemovedRatio {
+		log.Infow("pieces have been removed since multihash filter was built: rebuilding filter",
+			"removed", s.removedSinceBuild, "pieces", s.piecesAtBuild)
+		return true
+	}
+
+	return false
+}
+
+// rebuild builds a new filter from all the indexes in the store, and then
+// replaces the current filter with the new filter
+func (s *Store) rebuild(ctx context.Context) {
+	s.bgLk.Lock()
+	defer s.bgLk.Unlock()
+
+	start := time.Now()
+
+	s.lk.RLock()
+	started := s.building != nil
+	s.lk.RUnlock()
+	if !started {
+		s.startBuilding()
+	}
+
+	pieces, mhCount, err := s.addAllIndexes(ctx)
+	if err != nil {
+		s.lk.Lock()
+		s.building = nil
+		s.lk.Unlock()
+		if ctx.Err() == nil {
+			log.Errorw("rebuilding multihash filter", "err", err)
+		}
+		return
+	}
+
+	s.lk.Lock()
+	s.filter = s.building
+	s.building = nil
+	s.piecesAtBuild = pieces
+	s.removedSinceBuild = 0
+	s.dirty = true
+	fpRate := s.filter.falsePositiveRate()
+	s.lk.Unlock()
+
+	stats.Record(s.ctx, metrics.MultihashFilterRebuildCount.M(1))
+	stats.Record(s.ctx, metrics.MultihashFilterFalsePositiveRate.M(fpRate))
+	log.Infow("rebuilt multihash filter", "pieces", pieces, "multihashes", mhCount,
+		"false-positive-rate", fpRate, "took", time.Since(start).String())
+
+	if err := s.saveLocked(ctx); err != nil {
+		log.Errorw("saving multihash filter", "path", s.cfg.Path, "err", err)
+	}
+}
+
+// startBuilding creates the new filter that indexes are added to while the
+// filter is rebuilt
+func (s *Store) startBuilding() {
+	// Wait for in-progress add index operations to complete
+	s.addLk.Lock()
+	defer s.addLk.Unlock()
+
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	// Size the new filter to have room to grow
+	capacity := s.cfg.MinCapacity
+	if s.filter != nil && s.filter.count*2 > capacity {
+		capacity = s.filter.count * 2
+	}
+	s.building = newBloomFilter(capacity, s.cfg.FalsePositiveRate)
+}
+
+// addAllIndexes adds the multihashes of every piece in the store to the
+// filter that is being built
+func (s *Store) addAllIndexes(ctx context.Context) (int, int, error) {
+	pieceCids, err := s.ServiceImpl.ListPieces(ctx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("listing pieces: %w", err)
+	}
+
+	var mhCount int
+	for _, pieceCid := range pieceCids {
+		if ctx.Err() != nil {
+			return 0, 0, ctx.Err()
+		}
+
+		recs, err := s.ServiceImpl.GetIndex(ctx, pieceCid)
+		if err != nil {
+			if types.IsNotFound(err) {
+				// The piece has no index, or it was removed after the
+				// pieces were listed
+				continue
+			}
+			return 0, 0, fmt.Errorf("getting index for piece %s: %w", pieceCid, err)
+		}
+
+		var mhs []mh.Multihash
+		for r := range recs {
+			if r.Error != nil {
+				return 0, 0, fmt.Errorf("reading index for piece %s: %w", pieceCid, r.Error)
+			}
+			mhs = append(mhs, r.Cid.Hash())
+		}
+
+		s.lk.Lock()
+		for _, m := range mhs {
+			s.building.add(m)
+		}
+		s.lk.Unlock()
+		mhCount += len(mhs)
+	}
+
+	return len(pieceCids), mhCount, nil
+}
+
+// save persists the filter to disk if it has changed since it was last saved
+func (s *Store) save(ctx context.Context) error {
+	s.bgLk.Lock()
+	defer s.bgLk.Unlock()
+
+	return s.saveLocked(ctx)
+}
+
+func (s *Store) saveLocked(ctx context.Context) error {
+	if s.cfg.Path == "" {
+		return nil
+	}
+
+	s.lk.RLock()
+	skip := s.filter == nil || !s.dirty
+	s.lk.RUnlock()
+	if skip {
+		return nil
+	}
+
+	// Get the digest of the pieces in the store before taking a copy of the
+	// filter. Multihashes are added to the filter before they are added to
+	// the store, so the copy will contain all of the indexes of these pieces.
+	digest, err := s.piecesDigest(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.lk.Lock()
+	f := &bloomFilter{
+		bits:    make([]uint64, len(s.filter.bits)),
+		m:       s.filter.m,
+		k:       s.filter.k,
+		setBits: s.filter.setBits,
+		count:   s.filter.count,
+	}
+	copy(f.bits, s.filter.bits)
+	s.dirty = false
+	s.lk.Unlock()
+
+	err = writeFile(s.cfg.Path, f, digest)
+	if err != nil {
+		s.lk.Lock()
+		s.dirty = true
+		s.lk.Unlock()
+		return err
+	}
+
+	log.Debugw("saved multihash filter", "path", s.cfg.Path, "multihashes", f.count)
+	return nil
+}
+
+// load loads the persisted filter from disk. It returns false if there is
+// no persisted filter, or the persisted filter is out of date.
+func (s *Store) load(ctx context.Context) (bool, error) {
+	if s.cfg.Path == "" {
+		return false, nil
+	}
+
+	file, err := os.Open(s.cfg.Path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, fmt.Errorf("opening multihash filter file: %w", err)
+	}
+	defer file.Close() //nolint:errcheck
+
+	fi, err := file.Stat()
+	if err != nil {
+		return false, fmt.Errorf("getting multihash filter file size: %w", err)
+	}
+
+	f, savedDigest, err := readBloomFilter(file, fi.Size())
+	if err != nil {
+		return false, fmt.Errorf("reading multihash filter file: %w", err)
+	}
+
+	pieceCids, err := s.ServiceImpl.ListPieces(ctx)
+	if err != nil {
+		return false, fmt.Errorf("listing pieces: %w", err)
+	}
+	if digestPieces(pieceCids) != savedDigest {
+		log.Infow("pieces have changed since the multihash filter was saved: rebuilding filter", "path", s.cfg.Path)
+		return false, nil
+	}
+
+	s.lk.Lock()
+	s.filter = f
+	s.piecesAtBuild = len(pieceCids)
+	s.lk.Unlock()
+
+	log.Infow("loaded multihash filter", "path", s.cfg.Path, "multihashes", f.count,
+		"false-positive-rate", f.falsePositiveRate())
+	return true, nil
+}
+
+func (s *Store) piecesDigest(ctx context.Context) ([32]byte, error) {
+	pieceCids, err := s.ServiceImpl.ListPieces(ctx)
+	if err != nil {
+		return [32]byte{}, fmt.Errorf("listing pieces: %w", err)
+	}
+	return digestPieces(pieceCids), nil
+}
+
+// digestPieces returns a digest that identifies a set of pieces
+func digestPieces(pieceCids []cid.Cid) [32]byte {
+	keys := make([]string, 0, len(pieceCids))
+	for _, c := range pieceCids {
+		keys = append(keys, c.KeyString())
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	for _, k := range keys {
+		h.Write([]byte(k))
+	}
+	var digest [32]byte
+	copy(digest[:], h.Sum(nil))
+	return digest
+}
+
+// writeFile writes the filter to a temporary file and then renames it, so
+// that the persisted filter is never partially written
+func writeFile(path string, f *bloomFilter, digest [32]byte) error {
+	tmpPath := path + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("creating multihash filter file: %w", err)
+	}
+
+	err = f.writeTo(file, digest)
+	if err == nil {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("writing multihash filter file: %w", err)
+	}
+
+	return os.Rename(tmpPath, path)
+}
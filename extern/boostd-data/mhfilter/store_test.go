@@ -0,0 +1,119 @@
+package mhfilter
+
+import (
+	"context"
+	"path"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/boost/extern/boostd-data/ldb"
+	"github.com/filecoin-project/boost/extern/boostd-data/model"
+	"github.com/filecoin-project/boost/extern/boostd-data/svc/types"
+	"github.com/filecoin-project/boost/extern/boostd-data/testutils"
+	"github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+// countingStore counts the number of multihash lookups that reach the
+// underlying store
+type countingStore struct {
+	types.ServiceImpl
+	lookups atomic.Int32
+}
+
+// Start does nothing, as the underlying store is started by the test
+func (s *countingStore) Start(ctx context.Context) error {
+	return nil
+}
+
+func (s *countingStore) PiecesContainingMultihash(ctx context.Context, m mh.Multihash) ([]cid.Cid, error) {
+	s.lookups.Add(1)
+	return s.ServiceImpl.PiecesContainingMultihash(ctx, m)
+}
+
+func TestStore(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	inner := &countingStore{ServiceImpl: ldb.NewStore("")}
+	require.NoError(t, inner.ServiceImpl.Start(ctx))
+
+	// Add a piece directly to the underlying store before the filter
+	// is started
+	existing, existingRecs := generatePiece()
+	addIndex(ctx, t, inner, existing, existingRecs)
+
+	cfg := DefaultConfig()
+	cfg.MinCapacity = 1000
+	cfg.Path = path.Join(t.TempDir(), "multihash-filter")
+	s := NewStore(inner, cfg)
+	require.NoError(t, s.Start(ctx))
+
+	// Add a piece through the filter while it is being built
+	added, addedRecs := generatePiece()
+	addIndex(ctx, t, s, added, addedRecs)
+
+	waitForFilter(t, s)
+
+	// Multihashes in the store should be found
+	for _, recs := range [][]model.Record{existingRecs, addedRecs} {
+		pcids, err := s.PiecesContainingMultihash(ctx, recs[0].Cid.Hash())
+		require.NoError(t, err)
+		require.Len(t, pcids, 1)
+	}
+	require.EqualValues(t, 2, inner.lookups.Load())
+
+	// Lookups for multihashes that are not in the store should be answered
+	// by the filter (with the occasional false positive)
+	inner.lookups.Store(0)
+	for _, c := range testutils.GenerateCids(100) {
+		_, err := s.PiecesContainingMultihash(ctx, c.Hash())
+		require.True(t, types.IsNotFound(err))
+	}
+	require.Less(t, inner.lookups.Load(), int32(10))
+
+	// The saved filter should be loaded if the pieces have not changed
+	require.NoError(t, s.save(ctx))
+	loaded := NewStore(inner, cfg)
+	ok, err := loaded.load(ctx)
+	require.NoError(t, err)
+	require.True(t, ok)
+	pcids, err := loaded.PiecesContainingMultihash(ctx, addedRecs[0].Cid.Hash())
+	require.NoError(t, err)
+	require.Equal(t, []cid.Cid{added}, pcids)
+
+	// If a piece is added while the filter is not running, the saved filter
+	// should not be loaded
+	offline, offlineRecs := generatePiece()
+	addIndex(ctx, t, inner, offline, offlineRecs)
+	ok, err = NewStore(inner, cfg).load(ctx)
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func generatePiece() (cid.Cid, []model.Record) {
+	var recs []model.Record
+	for i, c := range testutils.GenerateCids(10) {
+		recs = append(recs, model.Record{
+			Cid:        c,
+			OffsetSize: model.OffsetSize{Offset: uint64(i * 100), Size: 100},
+		})
+	}
+	return testutils.GenerateCid(), recs
+}
+
+func addIndex(ctx context.Context, t *testing.T, s types.Service, pieceCid cid.Cid, recs []model.Record) {
+	for p := range s.AddIndex(ctx, pieceCid, recs, true) {
+		require.Empty(t, p.Err)
+	}
+}
+
+func waitForFilter(t *testing.T, s *Store) {
+	require.Eventually(t, func() bool {
+		s.lk.RLock()
+		defer s.lk.RUnlock()
+		return s.filter != nil
+	}, 5*time.Second, 10*time.Millisecond)
+}
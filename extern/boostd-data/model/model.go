@@ -45,6 +45,11 @@ type Metadata struct {
 	// offsets).
 	CompleteIndex bool       `json:"c"`
 	Deals         []DealInfo `json:"d"`
+	// IndexCheckpoint is set while the index for a piece is being added in
+	// batches. All records for blocks at an offset before the checkpoint have
+	// been added, so an interrupted index build can resume at the checkpoint.
+	// It is zero once the whole index has been added.
+	IndexCheckpoint uint64 `json:"ck,omitempty"`
 }
 
 // Record is the information stored in the index for each block in a piece
@@ -83,6 +88,27 @@ func (ofsz *OffsetSize) UnmarshallBase64(str string) error {
 	return nil
 }
 
+// FlagReason is the reason that a piece was flagged
+type FlagReason string
+
+const (
+	// FlagReasonUnknown is the reason for pieces that were flagged before
+	// flag reasons were recorded
+	FlagReasonUnknown FlagReason = ""
+	// FlagReasonMissingIndex indicates that the piece has not been indexed
+	FlagReasonMissingIndex FlagReason = "missing-index"
+	// FlagReasonNoUnsealedCopy indicates that there is no unsealed copy of
+	// the piece
+	FlagReasonNoUnsealedCopy FlagReason = "no-unsealed-copy"
+	// FlagReasonBlockMismatch indicates that the data at the offsets in the
+	// index does not match the blocks in the index
+	FlagReasonBlockMismatch FlagReason = "block-mismatch"
+	// FlagReasonExpiredDeal indicates that the piece is in an active sector
+	// but none of its deals are active on chain, eg because they have
+	// expired or been terminated
+	FlagReasonExpiredDeal FlagReason = "expired-deal"
+)
+
 // FlaggedPiece is a piece that has been flagged for the user's attention
 // (eg because the index is missing)
 type FlaggedPiece struct {
@@ -91,4 +117,7 @@ type FlaggedPiece struct {
 	CreatedAt       time.Time
 	UpdatedAt       time.Time
 	HasUnsealedCopy bool
+	Reason          FlagReason
+	// Details is a human readable description of the problem
+	Details string
 }
@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS PieceFlagged;
+DROP TABLE IF EXISTS PieceTracker;
+DROP TABLE IF EXISTS PieceBlockOffsetSize;
+DROP TABLE IF EXISTS PayloadToPieces;
+DROP TABLE IF EXISTS PieceDeal;
+DROP TABLE IF EXISTS PieceMetadata;
+DROP TABLE IF EXISTS goose_db_version;
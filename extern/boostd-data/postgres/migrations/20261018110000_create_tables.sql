@@ -0,0 +1,75 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS PieceMetadata (
+    PieceCid TEXT PRIMARY KEY,
+    Version TEXT,
+    CreatedAt TIMESTAMPTZ NOT NULL,
+    IndexedAt TIMESTAMPTZ,
+    CompleteIndex BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE INDEX IF NOT EXISTS PieceMetadataCreatedAt ON PieceMetadata (CreatedAt);
+
+CREATE TABLE IF NOT EXISTS PieceDeal (
+    DealUuid TEXT NOT NULL,
+    PieceCid TEXT NOT NULL,
+    IsLegacy BOOLEAN,
+    ChainDealID BIGINT,
+    MinerAddr TEXT NOT NULL,
+    SectorID BIGINT,
+    PieceOffset BIGINT,
+    PieceLength BIGINT,
+    CarLength BIGINT,
+    IsDirectDeal BOOLEAN,
+    PRIMARY KEY (PieceCid, DealUuid)
+);
+
+CREATE INDEX IF NOT EXISTS PieceDealDealUuid ON PieceDeal (DealUuid);
+
+CREATE TABLE IF NOT EXISTS PayloadToPieces (
+    PayloadMultihash BYTEA NOT NULL,
+    PieceCid BYTEA NOT NULL,
+    PRIMARY KEY (PayloadMultihash, PieceCid)
+);
+
+CREATE TABLE IF NOT EXISTS PieceBlockOffsetSize (
+    PieceCid BYTEA NOT NULL,
+    PayloadMultihash BYTEA NOT NULL,
+    BlockOffset BIGINT,
+    BlockSize BIGINT,
+    PRIMARY KEY (PieceCid, PayloadMultihash)
+);
+
+CREATE TABLE IF NOT EXISTS PieceTracker (
+    MinerAddr TEXT NOT NULL,
+    PieceCid TEXT NOT NULL,
+    CreatedAt TIMESTAMPTZ,
+    UpdatedAt TIMESTAMPTZ,
+    PRIMARY KEY (MinerAddr, PieceCid)
+);
+
+CREATE INDEX IF NOT EXISTS PieceTrackerCreatedAt ON PieceTracker (CreatedAt);
+CREATE INDEX IF NOT EXISTS PieceTrackerUpdatedAt ON PieceTracker (UpdatedAt);
+
+CREATE TABLE IF NOT EXISTS PieceFlagged (
+    MinerAddr TEXT NOT NULL,
+    PieceCid TEXT NOT NULL,
+    CreatedAt TIMESTAMPTZ,
+    UpdatedAt TIMESTAMPTZ,
+    HasUnsealedCopy BOOLEAN,
+    PRIMARY KEY (MinerAddr, PieceCid)
+);
+
+CREATE INDEX IF NOT EXISTS PieceFlaggedCreatedAt ON PieceFlagged (CreatedAt);
+CREATE INDEX IF NOT EXISTS PieceFlaggedUpdatedAt ON PieceFlagged (UpdatedAt);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS PieceFlagged;
+DROP TABLE IF EXISTS PieceTracker;
+DROP TABLE IF EXISTS PieceBlockOffsetSize;
+DROP TABLE IF EXISTS PayloadToPieces;
+DROP TABLE IF EXISTS PieceDeal;
+DROP TABLE IF EXISTS PieceMetadata;
+-- +goose StatementEnd
@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+-- A human readable description of the reason that the piece was flagged
+ALTER TABLE PieceFlagged ADD COLUMN Details TEXT NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS PieceFlaggedReason ON PieceFlagged (Reason);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS PieceFlaggedReason;
+ALTER TABLE PieceFlagged DROP COLUMN Details;
+-- +goose StatementEnd
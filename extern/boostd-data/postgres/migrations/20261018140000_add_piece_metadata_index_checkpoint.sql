@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+-- The offset in the piece up to which the index has been added, while the
+-- index is being added in batches
+ALTER TABLE PieceMetadata ADD COLUMN IndexCheckpoint BIGINT NOT NULL DEFAULT 0;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE PieceMetadata DROP COLUMN IndexCheckpoint;
+-- +goose StatementEnd
@@ -0,0 +1,43 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/pressly/goose/v3"
+)
+
+var log = logging.Logger("migrations")
+
+//go:embed *.sql
+var EmbedMigrations embed.FS
+
+func Migrate(ctx context.Context, sqldb *sql.DB) error {
+	goose.SetBaseFS(EmbedMigrations)
+
+	if err := goose.SetDialect("postgres"); err != nil {
+		return err
+	}
+
+	beforeVer, err := goose.GetDBVersionContext(ctx, sqldb)
+	if err != nil {
+		return err
+	}
+
+	if err := goose.UpContext(ctx, sqldb, "."); err != nil {
+		return err
+	}
+
+	afterVer, err := goose.GetDBVersionContext(ctx, sqldb)
+	if err != nil {
+		return err
+	}
+
+	if beforeVer != afterVer {
+		log.Warnw("boostd-data postgres migrated", "previous", beforeVer, "current", afterVer)
+	}
+
+	return nil
+}
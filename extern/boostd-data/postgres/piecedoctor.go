@@ -0,0 +1,405 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/filecoin-project/boost/extern/boostd-data/metrics"
+	"github.com/filecoin-project/boost/extern/boostd-data/model"
+	"github.com/filecoin-project/boost/extern/boostd-data/shared/tracing"
+	"github.com/filecoin-project/boost/extern/boostd-data/svc/types"
+	"github.com/filecoin-project/go-address"
+	"github.com/ipfs/go-cid"
+	"github.com/jackc/pgtype"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var TrackerCheckBatchSize = 1024
+
+// NextPiecesToCheck is periodically called by the piece doctor.
+// It returns a selection of piece cids so the piece doctor can check the
+// status of each piece.
+// For each piece it saves the time at which the piece was checked, so that
+// the piece won't be checked again for a while.
+// Rows are added to the PieceTracker table when a deal is added for a piece,
+// so unlike the yugabyte implementation there is no need to copy new pieces
+// into the tracker table first.
+func (s *Store) NextPiecesToCheck(ctx context.Context, maddr address.Address) ([]cid.Cid, error) {
+	ctx, span := tracing.Tracer.Start(ctx, "store.next_pieces_to_check")
+	defer span.End()
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.Endpoint, "pg.next_piece_to_check"))
+	stop := metrics.Timer(ctx, metrics.APIRequestDuration)
+	defer stop()
+
+	failureMetrics := true
+	defer func() {
+		if failureMetrics {
+			stats.Record(s.ctx, metrics.FailureNextPiecesToCheckCount.M(1))
+		} else {
+			stats.Record(s.ctx, metrics.SuccessNextPiecesToCheckCount.M(1))
+		}
+	}()
+
+	// Work out how frequently to check each piece, based on how many pieces
+	// there are.
+	pieceCheckPeriod, err := s.getPieceCheckPeriod(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting piece check period: %w", err)
+	}
+
+	// Get all pieces from the piece tracker table that have not been updated
+	// since the last piece check period.
+	// At the same time set the UpdatedAt field so that these pieces are marked
+	// as checked (and will not be returned until the next piece check period
+	// elapses again).
+	// Note that we limit the number of rows to fetch so as not to overload the
+	// system. Any rows beyond the limit will be fetched the next time
+	// NextPiecesToCheck is called.
+	now := time.Now()
+	qry := `WITH cte AS (` +
+		`SELECT MinerAddr, PieceCid FROM PieceTracker WHERE MinerAddr = $1 AND UpdatedAt < $2 ` +
+		`ORDER BY UpdatedAt LIMIT $3 FOR UPDATE SKIP LOCKED` +
+		`)` +
+		`UPDATE PieceTracker pt SET UpdatedAt = $4 ` +
+		`FROM cte WHERE pt.MinerAddr = cte.MinerAddr AND pt.PieceCid = cte.PieceCid ` +
+		`RETURNING pt.PieceCid`
+	rows, err := s.db.Query(ctx, qry, maddr.String(), now.Add(-pieceCheckPeriod), TrackerCheckBatchSize, now)
+	if err != nil {
+		return nil, fmt.Errorf("getting pieces from piece tracker: %w", err)
+	}
+	defer rows.Close()
+
+	pcids := make([]cid.Cid, 0, TrackerCheckBatchSize)
+	var pcid string
+	for rows.Next() {
+		err := rows.Scan(&pcid)
+		if err != nil {
+			return nil, fmt.Errorf("scanning piece tracker row: %w", err)
+		}
+
+		c, err := cid.Parse(pcid)
+		if err != nil {
+			return nil, fmt.Errorf("parsing tracker piece cid %s as cid: %w", pcid, err)
+		}
+
+		pcids = append(pcids, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("getting pieces to check: %w", err)
+	}
+
+	log.Debugw("got tracker pieces", "count", len(pcids), "now", now.String(),
+		"piece-check-period", pieceCheckPeriod.String())
+	failureMetrics = false
+	return pcids, nil
+}
+
+// The minimum frequency with which to check pieces for errors (eg bad index)
+var MinPieceCheckPeriod = 5 * time.Minute
+
+// Work out how frequently to check each piece, based on how many pieces
+// there are: if there are many pieces, each piece will be checked
+// less frequently
+func (s *Store) getPieceCheckPeriod(ctx context.Context) (time.Duration, error) {
+	var count int
+	err := s.db.QueryRow(ctx, `SELECT Count(*) FROM PieceTracker`).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("getting count of rows in piece tracker: %w", err)
+	}
+
+	// Check period:
+	// - 1k pieces;   every 100s (5 minutes because of MinPieceCheckPeriod)
+	// - 100k pieces; every 150m
+	// - 1m pieces;   every 20 hours
+	period := time.Duration(count*100) * time.Millisecond
+	if period < MinPieceCheckPeriod {
+		period = MinPieceCheckPeriod
+	}
+
+	return period, nil
+}
+
+// PiecesCount returns the number of pieces with deals on the given miner
+func (s *Store) PiecesCount(ctx context.Context, maddr address.Address) (int, error) {
+	ctx, span := tracing.Tracer.Start(ctx, "store.pieces_count")
+	defer span.End()
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.Endpoint, "pg.piece_count"))
+	stop := metrics.Timer(ctx, metrics.APIRequestDuration)
+	defer stop()
+	failureMetrics := true
+	defer func() {
+		if failureMetrics {
+			stats.Record(s.ctx, metrics.FailurePiecesCountCount.M(1))
+		} else {
+			stats.Record(s.ctx, metrics.SuccessPiecesCountCount.M(1))
+		}
+	}()
+
+	var count int
+	qry := `SELECT COUNT(*) FROM PieceTracker WHERE MinerAddr = $1`
+	err := s.db.QueryRow(ctx, qry, maddr.String()).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("getting pieces count: %w", err)
+	}
+
+	failureMetrics = false
+	return count, nil
+}
+
+// Calculate the progress of the initial scan
+func (s *Store) ScanProgress(ctx context.Context, maddr address.Address) (*types.ScanProgress, error) {
+	ctx, span := tracing.Tracer.Start(ctx, "store.scan_progress")
+	defer span.End()
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.Endpoint, "pg.scan_progress"))
+	stop := metrics.Timer(ctx, metrics.APIRequestDuration)
+	defer stop()
+	failureMetrics := true
+	defer func() {
+		if failureMetrics {
+			stats.Record(s.ctx, metrics.FailureScanProgressCount.M(1))
+		} else {
+			stats.Record(s.ctx, metrics.SuccessScanProgressCount.M(1))
+		}
+	}()
+
+	// Pieces that have not yet been scanned have an UpdatedAt of unix zero time
+	var scanned, total int
+	var lastScanRes pgtype.Timestamptz
+	qry := `SELECT COUNT(*) FILTER (WHERE UpdatedAt > 'epoch'), COUNT(*), MAX(UpdatedAt) ` +
+		`FROM PieceTracker WHERE MinerAddr = $1`
+	err := s.db.QueryRow(ctx, qry, maddr.String()).Scan(&scanned, &total, &lastScanRes)
+	if err != nil {
+		return nil, fmt.Errorf("getting scan progress: %w", err)
+	}
+
+	progress := float64(1)
+	if total != 0 {
+		progress = float64(scanned) / float64(total)
+	}
+
+	failureMetrics = false
+	return &types.ScanProgress{Progress: progress, LastScan: lastScanRes.Time}, nil
+}
+
+func (s *Store) FlagPiece(ctx context.Context, pieceCid cid.Cid, hasUnsealedCopy bool, maddr address.Address, reason model.FlagReason, details string) error {
+	ctx, span := tracing.Tracer.Start(ctx, "store.flag_piece")
+	span.SetAttributes(attribute.String("pieceCid", pieceCid.String()))
+	defer span.End()
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.Endpoint, "pg.flag_piece"))
+	stop := metrics.Timer(ctx, metrics.APIRequestDuration)
+	defer stop()
+	failureMetrics := true
+	defer func() {
+		if failureMetrics {
+			stats.Record(s.ctx, metrics.FailureFlagPieceCount.M(1))
+		} else {
+			stats.Record(s.ctx, metrics.SuccessFlagPieceCount.M(1))
+		}
+	}()
+
+	now := time.Now()
+	qry := `INSERT INTO PieceFlagged (MinerAddr, PieceCid, CreatedAt, UpdatedAt, HasUnsealedCopy, Reason, Details) ` +
+		`VALUES ($1, $2, $3, $4, $5, $6, $7) ` +
+		`ON CONFLICT (MinerAddr, PieceCid) DO UPDATE SET ` +
+		`UpdatedAt = excluded.UpdatedAt, HasUnsealedCopy = excluded.HasUnsealedCopy, Reason = excluded.Reason, Details = excluded.Details`
+	_, err := s.db.Exec(ctx, qry, maddr.String(), pieceCid.String(), now, now, hasUnsealedCopy, string(reason), details)
+	if err != nil {
+		return fmt.Errorf("flagging piece %s: %w", pieceCid, err)
+	}
+	failureMetrics = false
+	return nil
+}
+
+func (s *Store) UnflagPiece(ctx context.Context, pieceCid cid.Cid, maddr address.Address) error {
+	ctx, span := tracing.Tracer.Start(ctx, "store.unflag_piece")
+	span.SetAttributes(attribute.String("pieceCid", pieceCid.String()))
+	defer span.End()
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.Endpoint, "pg.unflag_piece"))
+	stop := metrics.Timer(ctx, metrics.APIRequestDuration)
+	defer stop()
+	failureMetrics := true
+	defer func() {
+		if failureMetrics {
+			stats.Record(s.ctx, metrics.FailureUnflagPieceCount.M(1))
+		} else {
+			stats.Record(s.ctx, metrics.SuccessUnflagPieceCount.M(1))
+		}
+	}()
+
+	qry := `DELETE FROM PieceFlagged WHERE MinerAddr = $1 AND PieceCid = $2`
+	_, err := s.db.Exec(ctx, qry, maddr.String(), pieceCid.String())
+	if err != nil {
+		return fmt.Errorf("unflagging piece %s %s: %w", maddr, pieceCid, err)
+	}
+
+	failureMetrics = false
+	return nil
+}
+
+func (s *Store) UntrackPiece(ctx context.Context, pieceCid cid.Cid, maddr address.Address) error {
+	ctx, span := tracing.Tracer.Start(ctx, "store.untrack_piece")
+	span.SetAttributes(attribute.String("pieceCid", pieceCid.String()))
+	defer span.End()
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.Endpoint, "pg.untrack_piece"))
+	stop := metrics.Timer(ctx, metrics.APIRequestDuration)
+	defer stop()
+	failureMetrics := true
+	defer func() {
+		if failureMetrics {
+			stats.Record(s.ctx, metrics.FailureUntrackPieceCount.M(1))
+		} else {
+			stats.Record(s.ctx, metrics.SuccessUntrackPieceCount.M(1))
+		}
+	}()
+
+	qry := `DELETE FROM PieceTracker WHERE MinerAddr = $1 AND PieceCid = $2`
+	_, err := s.db.Exec(ctx, qry, maddr.String(), pieceCid.String())
+	if err != nil {
+		return fmt.Errorf("untracking piece %s %s: %w", maddr, pieceCid, err)
+	}
+
+	failureMetrics = false
+	return nil
+}
+
+// flaggedPiecesWhere builds the WHERE clause and arguments for a flagged
+// pieces query
+func flaggedPiecesWhere(filter *types.FlaggedPiecesListFilter, cursor *time.Time) (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	addCond := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+
+	if cursor != nil {
+		addCond(`CreatedAt < $%d`, *cursor)
+	}
+	if filter != nil {
+		if !filter.MinerAddr.Empty() {
+			addCond(`MinerAddr = $%d`, filter.MinerAddr.String())
+		}
+		if filter.HasUnsealedCopy != nil {
+			addCond(`HasUnsealedCopy = $%d`, *filter.HasUnsealedCopy)
+		}
+		if filter.Reason != "" {
+			addCond(`Reason = $%d`, string(filter.Reason))
+		}
+	}
+
+	where := ""
+	for i, cond := range conds {
+		if i == 0 {
+			where += ` WHERE `
+		} else {
+			where += ` AND `
+		}
+		where += cond
+	}
+	return where, args
+}
+
+func (s *Store) FlaggedPiecesList(ctx context.Context, filter *types.FlaggedPiecesListFilter, cursor *time.Time, offset int, limit int) ([]model.FlaggedPiece, error) {
+	ctx, span := tracing.Tracer.Start(ctx, "store.flagged_pieces")
+	var spanCursor int
+	if cursor != nil {
+		spanCursor = int(cursor.UnixMilli())
+	}
+	span.SetAttributes(attribute.Int("cursor", spanCursor))
+	span.SetAttributes(attribute.Int("offset", offset))
+	span.SetAttributes(attribute.Int("limit", limit))
+	defer span.End()
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.Endpoint, "pg.flagged_pieces_list"))
+	stop := metrics.Timer(ctx, metrics.APIRequestDuration)
+	defer stop()
+	failureMetrics := true
+	defer func() {
+		if failureMetrics {
+			stats.Record(s.ctx, metrics.FailureFlaggedPiecesListCount.M(1))
+		} else {
+			stats.Record(s.ctx, metrics.SuccessFlaggedPiecesListCount.M(1))
+		}
+	}()
+
+	where, args := flaggedPiecesWhere(filter, cursor)
+	qry := `SELECT MinerAddr, PieceCid, CreatedAt, UpdatedAt, HasUnsealedCopy, Reason, Details FROM PieceFlagged` + where +
+		fmt.Sprintf(` ORDER BY CreatedAt DESC LIMIT $%d OFFSET $%d`, len(args)+1, len(args)+2)
+	args = append(args, limit, offset)
+
+	rows, err := s.db.Query(ctx, qry, args...)
+	if err != nil {
+		return nil, fmt.Errorf("getting flagged pieces: %w", err)
+	}
+	defer rows.Close()
+
+	var pieces []model.FlaggedPiece
+	var maddr string
+	var pcid string
+	var createdAt time.Time
+	var updatedAt time.Time
+	var hasUnsealedCopy bool
+	var reason string
+	var details string
+	for rows.Next() {
+		err := rows.Scan(&maddr, &pcid, &createdAt, &updatedAt, &hasUnsealedCopy, &reason, &details)
+		if err != nil {
+			return nil, fmt.Errorf("scanning flagged piece: %w", err)
+		}
+
+		ma, err := address.NewFromString(maddr)
+		if err != nil {
+			return nil, fmt.Errorf("parsing flagged piece miner address '%s': %w", maddr, err)
+		}
+
+		c, err := cid.Parse(pcid)
+		if err != nil {
+			return nil, fmt.Errorf("parsing flagged piece cid %s: %w", pcid, err)
+		}
+
+		pieces = append(pieces, model.FlaggedPiece{
+			MinerAddr:       ma,
+			PieceCid:        c,
+			CreatedAt:       createdAt,
+			UpdatedAt:       updatedAt,
+			HasUnsealedCopy: hasUnsealedCopy,
+			Reason:          model.FlagReason(reason),
+			Details:         details,
+		})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("getting flagged pieces: %w", err)
+	}
+
+	failureMetrics = false
+	return pieces, nil
+}
+
+func (s *Store) FlaggedPiecesCount(ctx context.Context, filter *types.FlaggedPiecesListFilter) (int, error) {
+	ctx, span := tracing.Tracer.Start(ctx, "store.flagged_pieces_count")
+	defer span.End()
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.Endpoint, "pg.flagged_pieces_count"))
+	stop := metrics.Timer(ctx, metrics.APIRequestDuration)
+	defer stop()
+	failureMetrics := true
+	defer func() {
+		if failureMetrics {
+			stats.Record(s.ctx, metrics.FailureFlaggedPiecesCountCount.M(1))
+		} else {
+			stats.Record(s.ctx, metrics.SuccessFlaggedPiecesCountCount.M(1))
+		}
+	}()
+
+	where, args := flaggedPiecesWhere(filter, nil)
+	var count int
+	err := s.db.QueryRow(ctx, `SELECT COUNT(*) FROM PieceFlagged`+where, args...).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("getting flagged pieces count: %w", err)
+	}
+
+	failureMetrics = false
+	return count, nil
+}
@@ -0,0 +1,40 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"fmt"
+
+	"github.com/filecoin-project/boost/extern/boostd-data/postgres/migrations"
+	_ "github.com/lib/pq"
+)
+
+// Create creates the tables by running all migrations against the database
+func (s *Store) Create(ctx context.Context) error {
+	// The migration library requires a *sql.DB, so open a separate connection
+	// that is only used for running migrations
+	sqldb, err := sql.Open("postgres", s.settings.ConnectString)
+	if err != nil {
+		return fmt.Errorf("opening postgres connection: %w", err)
+	}
+	defer sqldb.Close()
+
+	log.Infow("running postgres migrations")
+	err = migrations.Migrate(ctx, sqldb)
+	if err != nil {
+		return fmt.Errorf("running postgres migrations: %w", err)
+	}
+	log.Infow("postgres migrations complete")
+
+	return nil
+}
+
+//go:embed drop.sql
+var dropSQL string
+
+// Drop drops all tables, including the migrations version table
+func (s *Store) Drop(ctx context.Context) error {
+	_, err := s.db.Exec(ctx, dropSQL)
+	return err
+}
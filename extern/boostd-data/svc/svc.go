@@ -9,8 +9,11 @@ import (
 	"path"
 	"time"
 
+	"github.com/filecoin-project/boost/extern/boostd-data/badger"
 	"github.com/filecoin-project/boost/extern/boostd-data/ldb"
 	"github.com/filecoin-project/boost/extern/boostd-data/metrics"
+	"github.com/filecoin-project/boost/extern/boostd-data/mhfilter"
+	"github.com/filecoin-project/boost/extern/boostd-data/postgres"
 	"github.com/filecoin-project/boost/extern/boostd-data/svc/types"
 	"github.com/filecoin-project/boost/extern/boostd-data/yugabyte"
 	"github.com/filecoin-project/go-jsonrpc"
@@ -30,6 +33,10 @@ func NewYugabyte(settings yugabyte.DBSettings, migrator *yugabyte.Migrator, stor
 	return &Service{Impl: yugabyte.NewStore(settings, migrator, storeOpts...)}
 }
 
+func NewPostgres(settings postgres.DBSettings) *Service {
+	return &Service{Impl: postgres.NewStore(settings)}
+}
+
 func NewLevelDB(repoPath string) (*Service, error) {
 	if repoPath != "" { // an empty repo path is used for testing
 		var err error
@@ -50,6 +57,41 @@ func MakeLevelDBDir(repoPath string) (string, error) {
 	return repoPath, nil
 }
 
+func NewBadger(repoPath string) (*Service, error) {
+	if repoPath != "" { // an empty repo path is used for testing
+		var err error
+		repoPath, err = MakeBadgerDir(repoPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &Service{Impl: badger.NewStore(repoPath)}, nil
+}
+
+func MakeBadgerDir(repoPath string) (string, error) {
+	repoPath = path.Join(repoPath, "lid", "badger")
+	if err := os.MkdirAll(repoPath, os.ModePerm); err != nil {
+		return "", fmt.Errorf("creating badger repo directory %s: %w", repoPath, err)
+	}
+	return repoPath, nil
+}
+
+// WithMultihashFilter wraps the service's store with an in-memory filter of
+// all indexed multihashes, so that lookups for multihashes that are not in
+// any piece are answered without querying the store
+func WithMultihashFilter(s *Service, cfg mhfilter.Config) *Service {
+	return &Service{Impl: mhfilter.NewStore(s.Impl, cfg)}
+}
+
+func MakeMultihashFilterPath(repoPath string) (string, error) {
+	dir := path.Join(repoPath, "lid")
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", fmt.Errorf("creating lid repo directory %s: %w", dir, err)
+	}
+	return path.Join(dir, "multihash-filter"), nil
+}
+
 func (s *Service) Start(ctx context.Context, addr string) (net.Addr, error) {
 	ln, err := net.Listen("tcp", addr)
 	if err != nil {
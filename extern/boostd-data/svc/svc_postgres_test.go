@@ -0,0 +1,33 @@
+//go:build test_lid
+// +build test_lid
+
+package svc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+func TestServicePostgres(t *testing.T) {
+	_ = logging.SetLogLevel("cbtest", "debug")
+	_ = logging.SetLogLevel("boostd-data-pg", "debug")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	bdsvc := SetupPostgres(t)
+	testService(ctx, t, bdsvc, "localhost:0")
+}
+
+func TestCleanupPostgres(t *testing.T) {
+	_ = logging.SetLogLevel("*", "debug")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	bdsvc := SetupPostgres(t)
+	testCleanup(ctx, t, bdsvc, "localhost:0")
+}
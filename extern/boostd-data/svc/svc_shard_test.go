@@ -0,0 +1,131 @@
+package svc
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/boost/extern/boostd-data/client"
+	"github.com/filecoin-project/boost/extern/boostd-data/model"
+	"github.com/filecoin-project/boost/extern/boostd-data/testutils"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/google/uuid"
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShardedLevelDB(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var addrs []string
+	for i := 0; i < 3; i++ {
+		bdsvc, err := NewLevelDB("")
+		require.NoError(t, err)
+		ln, err := bdsvc.Start(ctx, "localhost:0")
+		require.NoError(t, err)
+		addrs = append(addrs, fmt.Sprintf("ws://%s", ln))
+	}
+
+	// Add pieces to a store with two shards
+	cl := client.NewStore()
+	require.NoError(t, cl.DialShards(ctx, addrs[:2]))
+
+	pieces := make(map[cid.Cid][]model.Record)
+	shared := testutils.GenerateCid()
+	for i := 0; i < 20; i++ {
+		pieceCid := testutils.GenerateCid()
+		recs := []model.Record{{Cid: shared, OffsetSize: model.OffsetSize{Offset: 0, Size: 10}}}
+		for j, c := range testutils.GenerateCids(10) {
+			recs = append(recs, model.Record{Cid: c, OffsetSize: model.OffsetSize{Offset: uint64(j+1) * 10, Size: 10}})
+		}
+		require.NoError(t, cl.AddIndex(ctx, pieceCid, recs, true))
+		require.NoError(t, cl.AddDealForPiece(ctx, pieceCid, model.DealInfo{
+			DealUuid: uuid.NewString(),
+			SectorID: abi.SectorNumber(i),
+		}))
+		pieces[pieceCid] = recs
+	}
+
+	// Both shards should have some of the pieces
+	for _, addr := range addrs[:2] {
+		require.NotEmpty(t, shardPieces(ctx, t, addr))
+	}
+
+	checkPieces := func(cl *client.Store) {
+		all, err := cl.ListPieces(ctx)
+		require.NoError(t, err)
+		require.Len(t, all, len(pieces))
+
+		// The multihash that is in every piece should be found in all pieces
+		pcids, err := cl.PiecesContainingMultihash(ctx, shared.Hash())
+		require.NoError(t, err)
+		require.Len(t, pcids, len(pieces))
+
+		for pieceCid, recs := range pieces {
+			pcids, err := cl.PiecesContainingMultihash(ctx, recs[1].Cid.Hash())
+			require.NoError(t, err)
+			require.Equal(t, []cid.Cid{pieceCid}, pcids)
+
+			ofsz, err := cl.GetOffsetSize(ctx, pieceCid, recs[1].Cid.Hash())
+			require.NoError(t, err)
+			require.Equal(t, recs[1].OffsetSize, *ofsz)
+
+			dls, err := cl.GetPieceDeals(ctx, pieceCid)
+			require.NoError(t, err)
+			require.Len(t, dls, 1)
+		}
+	}
+	checkPieces(cl)
+	cl.Close(ctx)
+
+	// Add a shard that fails every request, and a working shard. The pieces
+	// that map to the failed shard can't be moved, but the other pieces
+	// should still be moved and the errors reported.
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	statePath := filepath.Join(t.TempDir(), "rebalance.json")
+	cl = client.NewStore()
+	require.NoError(t, cl.DialShards(ctx, append(addrs[:3:3], failing.URL)))
+	moved, err := cl.Rebalance(ctx, statePath)
+	require.Error(t, err)
+	require.Greater(t, moved, 0)
+	require.Len(t, shardPieces(ctx, t, addrs[2]), moved)
+	require.NoFileExists(t, statePath)
+	cl.Close(ctx)
+
+	// Rebalance with the working shards
+	cl = client.NewStore()
+	require.NoError(t, cl.DialShards(ctx, addrs))
+	defer cl.Close(ctx)
+
+	movedAgain, err := cl.Rebalance(ctx, statePath)
+	require.NoError(t, err)
+	require.FileExists(t, statePath)
+
+	// Only pieces that map to the new shard should have moved
+	require.Len(t, shardPieces(ctx, t, addrs[2]), moved+movedAgain)
+	checkPieces(cl)
+
+	// The shards are balanced, so rebalancing again should not move any pieces
+	moved, err = cl.Rebalance(ctx, "")
+	require.NoError(t, err)
+	require.Equal(t, 0, moved)
+}
+
+func shardPieces(ctx context.Context, t *testing.T, addr string) []cid.Cid {
+	cl := client.NewStore()
+	require.NoError(t, cl.Dial(ctx, addr))
+	defer cl.Close(ctx)
+
+	pcids, err := cl.ListPieces(ctx)
+	require.NoError(t, err)
+	return pcids
+}
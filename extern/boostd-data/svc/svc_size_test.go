@@ -44,6 +44,13 @@ func TestSizeLimit(t *testing.T) {
 		addr := "localhost:0"
 		testSizeLimit(ctx, t, bdsvc, addr)
 	})
+
+	t.Run("postgres", func(t *testing.T) {
+		_ = logging.SetLogLevel("boostd-data-pg", "debug")
+
+		bdsvc := SetupPostgres(t)
+		testSizeLimit(ctx, t, bdsvc, "localhost:0")
+	})
 }
 
 func testSizeLimit(ctx context.Context, t *testing.T, bdsvc *Service, addr string) {
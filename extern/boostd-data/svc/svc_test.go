@@ -44,3 +44,44 @@ func TestCleanupLevelDB(t *testing.T) {
 	require.NoError(t, err)
 	testCleanup(ctx, t, bdsvc, "localhost:0")
 }
+
+func TestUntrackLevelDB(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	bdsvc, err := NewLevelDB("")
+	require.NoError(t, err)
+	testUntrack(ctx, t, bdsvc, "localhost:0")
+}
+
+func TestServiceBadger(t *testing.T) {
+	_ = logging.SetLogLevel("cbtest", "debug")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	bdsvc, err := NewBadger("")
+	require.NoError(t, err)
+
+	testService(ctx, t, bdsvc, "localhost:0")
+}
+
+func TestCleanupBadger(t *testing.T) {
+	_ = logging.SetLogLevel("*", "debug")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	bdsvc, err := NewBadger("")
+	require.NoError(t, err)
+	testCleanup(ctx, t, bdsvc, "localhost:0")
+}
+
+func TestUntrackBadger(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	bdsvc, err := NewBadger("")
+	require.NoError(t, err)
+	testUntrack(ctx, t, bdsvc, "localhost:0")
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/filecoin-project/boost/extern/boostd-data/model"
 	"github.com/filecoin-project/boost/extern/boostd-data/testutils"
 	"github.com/filecoin-project/boost/testutil"
+	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/google/uuid"
 	"github.com/ipfs/go-cid"
@@ -458,3 +459,50 @@ func toEntries(idx index.Index) (map[string]uint64, bool) {
 	}
 	return entries, true
 }
+
+func testUntrack(ctx context.Context, t *testing.T, bdsvc *Service, addr string) {
+	ln, err := bdsvc.Start(ctx, addr)
+	require.NoError(t, err)
+
+	cl := client.NewStore()
+	err = cl.Dial(context.Background(), fmt.Sprintf("ws://%s", ln))
+	require.NoError(t, err)
+	defer cl.Close(ctx)
+
+	maddr, err := address.NewIDAddress(1234)
+	require.NoError(t, err)
+
+	newDeal := func() model.DealInfo {
+		return model.DealInfo{
+			DealUuid:    uuid.NewString(),
+			MinerAddr:   maddr,
+			SectorID:    abi.SectorNumber(1),
+			PieceOffset: 1,
+			PieceLength: 2,
+			CarLength:   3,
+		}
+	}
+
+	// Add a deal for two pieces
+	pieceCids := testutils.GenerateCids(2)
+	for _, pieceCid := range pieceCids {
+		err = cl.AddDealForPiece(ctx, pieceCid, newDeal())
+		require.NoError(t, err)
+	}
+
+	// Untrack the first piece: only the second piece should be checked
+	err = cl.UntrackPiece(ctx, pieceCids[0], maddr)
+	require.NoError(t, err)
+
+	toCheck, err := cl.NextPiecesToCheck(ctx, maddr)
+	require.NoError(t, err)
+	require.Equal(t, []cid.Cid{pieceCids[1]}, toCheck)
+
+	// Adding a new deal for the first piece should track it again
+	err = cl.AddDealForPiece(ctx, pieceCids[0], newDeal())
+	require.NoError(t, err)
+
+	toCheck, err = cl.NextPiecesToCheck(ctx, maddr)
+	require.NoError(t, err)
+	require.Equal(t, []cid.Cid{pieceCids[0]}, toCheck)
+}
@@ -41,8 +41,26 @@ type ScanProgress struct {
 }
 
 type FlaggedPiecesListFilter struct {
-	MinerAddr       address.Address
-	HasUnsealedCopy bool
+	MinerAddr address.Address
+	// Only match pieces that do or don't have an unsealed copy (if set)
+	HasUnsealedCopy *bool
+	// Only match pieces flagged with this reason (if set)
+	Reason model.FlagReason
+}
+
+// Matches returns true if a flagged piece with the given fields matches the
+// filter
+func (f *FlaggedPiecesListFilter) Matches(maddr address.Address, hasUnsealedCopy bool, reason model.FlagReason) bool {
+	if f == nil {
+		return true
+	}
+	if f.HasUnsealedCopy != nil && *f.HasUnsealedCopy != hasUnsealedCopy {
+		return false
+	}
+	if !f.MinerAddr.Empty() && f.MinerAddr != maddr {
+		return false
+	}
+	return f.Reason == "" || f.Reason == reason
 }
 
 type Service interface {
@@ -51,6 +69,7 @@ type Service interface {
 	GetIndex(context.Context, cid.Cid) (<-chan IndexRecord, error)
 	IsIndexed(ctx context.Context, pieceCid cid.Cid) (bool, error)
 	IsCompleteIndex(ctx context.Context, pieceCid cid.Cid) (bool, error)
+	SetIndexCheckpoint(ctx context.Context, pieceCid cid.Cid, checkpoint uint64) error
 	GetOffsetSize(context.Context, cid.Cid, mh.Multihash) (*model.OffsetSize, error)
 	ListPieces(ctx context.Context) ([]cid.Cid, error)
 	GetPieceMetadata(ctx context.Context, pieceCid cid.Cid) (model.Metadata, error)
@@ -60,11 +79,14 @@ type Service interface {
 	RemoveDealForPiece(context.Context, cid.Cid, string) error
 	RemovePieceMetadata(context.Context, cid.Cid) error
 	RemoveIndexes(context.Context, cid.Cid) error
+	PiecesCount(ctx context.Context, maddr address.Address) (int, error)
+	ScanProgress(ctx context.Context, maddr address.Address) (*ScanProgress, error)
 	NextPiecesToCheck(ctx context.Context, maddr address.Address) ([]cid.Cid, error)
-	FlagPiece(ctx context.Context, pieceCid cid.Cid, hasUnsealedCopy bool, maddr address.Address) error
+	FlagPiece(ctx context.Context, pieceCid cid.Cid, hasUnsealedCopy bool, maddr address.Address, reason model.FlagReason, details string) error
 	UnflagPiece(ctx context.Context, pieceCid cid.Cid, maddr address.Address) error
 	FlaggedPiecesList(ctx context.Context, filter *FlaggedPiecesListFilter, cursor *time.Time, offset int, limit int) ([]model.FlaggedPiece, error)
 	FlaggedPiecesCount(ctx context.Context, filter *FlaggedPiecesListFilter) (int, error)
+	UntrackPiece(ctx context.Context, pieceCid cid.Cid, maddr address.Address) error
 }
 
 type ServiceImpl interface {
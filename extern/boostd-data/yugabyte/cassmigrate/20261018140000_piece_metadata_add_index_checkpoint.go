@@ -0,0 +1,23 @@
+package cassmigrate
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/yugabyte/gocql"
+)
+
+// ts20261018140000_pieceMetadataAddIndexCheckpoint adds a new column IndexCheckpoint to the PieceMetadata table
+func ts20261018140000_pieceMetadataAddIndexCheckpoint(ctx context.Context, session *gocql.Session) error {
+	qry := `ALTER TABLE PieceMetadata ADD IndexCheckpoint BIGINT`
+	err := session.Query(qry).WithContext(ctx).Exec()
+	if err != nil {
+		if strings.Contains(err.Error(), "code=2200") {
+			log.Warn("column IndexCheckpoint already exists")
+			return nil
+		}
+		return fmt.Errorf("creating new column IndexCheckpoint: %w", err)
+	}
+	return nil
+}
@@ -19,6 +19,7 @@ type migrationFn func(ctx context.Context, session *gocql.Session) error
 var migrations = []migrationFn{
 	ts20230824154306_dealsFixMinerAddr,
 	ts20230913144459_dealsAddIsDirectDealColumn,
+	ts20261018140000_pieceMetadataAddIndexCheckpoint,
 }
 
 // Migrate migrates the cassandra database
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+-- The reason that the piece was flagged. Pieces that were flagged before
+-- the reason was recorded have an empty reason.
+ALTER TABLE PieceFlagged ADD COLUMN Reason TEXT NOT NULL DEFAULT '';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE PieceFlagged DROP COLUMN Reason;
+-- +goose StatementEnd
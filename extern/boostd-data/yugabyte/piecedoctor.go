@@ -3,6 +3,7 @@ package yugabyte
 import (
 	"context"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
@@ -340,7 +341,7 @@ func (s *Store) ScanProgress(ctx context.Context, maddr address.Address) (*types
 	return &types.ScanProgress{Progress: progress, LastScan: lastScanRes.Time}, nil
 }
 
-func (s *Store) FlagPiece(ctx context.Context, pieceCid cid.Cid, hasUnsealedCopy bool, maddr address.Address) error {
+func (s *Store) FlagPiece(ctx context.Context, pieceCid cid.Cid, hasUnsealedCopy bool, maddr address.Address, reason model.FlagReason, details string) error {
 	ctx, span := tracing.Tracer.Start(ctx, "store.flag_piece")
 	span.SetAttributes(attribute.String("pieceCid", pieceCid.String()))
 	defer span.End()
@@ -357,10 +358,10 @@ func (s *Store) FlagPiece(ctx context.Context, pieceCid cid.Cid, hasUnsealedCopy
 	}()
 
 	now := time.Now()
-	qry := `INSERT INTO PieceFlagged (MinerAddr, PieceCid, CreatedAt, UpdatedAt, HasUnsealedCopy) ` +
-		`VALUES ($1, $2, $3, $4, $5) ` +
-		`ON CONFLICT (MinerAddr, PieceCid) DO UPDATE SET UpdatedAt = excluded.UpdatedAt`
-	_, err := s.db.Exec(ctx, qry, maddr.String(), pieceCid.String(), now, now, hasUnsealedCopy)
+	qry := `INSERT INTO PieceFlagged (MinerAddr, PieceCid, CreatedAt, UpdatedAt, HasUnsealedCopy, Reason, Details) ` +
+		`VALUES ($1, $2, $3, $4, $5, $6, $7) ` +
+		`ON CONFLICT (MinerAddr, PieceCid) DO UPDATE SET UpdatedAt = excluded.UpdatedAt, Reason = excluded.Reason, Details = excluded.Details`
+	_, err := s.db.Exec(ctx, qry, maddr.String(), pieceCid.String(), now, now, hasUnsealedCopy, string(reason), details)
 	if err != nil {
 		return fmt.Errorf("flagging piece %s: %w", pieceCid, err)
 	}
@@ -416,35 +417,11 @@ func (s *Store) FlaggedPiecesList(ctx context.Context, filter *types.FlaggedPiec
 		}
 	}()
 
-	var args []interface{}
-	idx := 0
-	qry := `SELECT MinerAddr, PieceCid, CreatedAt, UpdatedAt, HasUnsealedCopy from PieceFlagged `
-	where := ""
-	if cursor != nil {
-		where += `WHERE CreatedAt < $1 `
-		args = append(args, cursor)
-		idx++
-	}
-	if filter != nil {
-		if where == "" {
-			where += `WHERE `
-		} else {
-			where += `AND `
-		}
-		if !filter.MinerAddr.Empty() {
-			where += fmt.Sprintf(`MinerAddr = $%d AND `, idx+1)
-			args = append(args, filter.MinerAddr.String())
-			idx++
-		}
+	where, args := flaggedPiecesWhere(filter, cursor)
+	qry := `SELECT MinerAddr, PieceCid, CreatedAt, UpdatedAt, HasUnsealedCopy, Reason, Details from PieceFlagged` + where
+	qry += ` ORDER BY CreatedAt desc`
 
-		where += fmt.Sprintf(`HasUnsealedCopy = $%d `, idx+1)
-		args = append(args, filter.HasUnsealedCopy)
-		idx++
-	}
-	qry += where
-	qry += `ORDER BY CreatedAt desc `
-
-	qry += fmt.Sprintf(`LIMIT $%d OFFSET $%d`, idx+1, idx+2)
+	qry += fmt.Sprintf(` LIMIT $%d OFFSET $%d`, len(args)+1, len(args)+2)
 	args = append(args, limit, offset)
 
 	rows, err := s.db.Query(ctx, qry, args...)
@@ -459,8 +436,10 @@ func (s *Store) FlaggedPiecesList(ctx context.Context, filter *types.FlaggedPiec
 	var createdAt time.Time
 	var updatedAt time.Time
 	var hasUnsealedCopy bool
+	var reason string
+	var details string
 	for rows.Next() {
-		err := rows.Scan(&maddr, &pcid, &createdAt, &updatedAt, &hasUnsealedCopy)
+		err := rows.Scan(&maddr, &pcid, &createdAt, &updatedAt, &hasUnsealedCopy, &reason, &details)
 		if err != nil {
 			return nil, fmt.Errorf("scanning flagged piece: %w", err)
 		}
@@ -481,6 +460,8 @@ func (s *Store) FlaggedPiecesList(ctx context.Context, filter *types.FlaggedPiec
 			CreatedAt:       createdAt,
 			UpdatedAt:       updatedAt,
 			HasUnsealedCopy: hasUnsealedCopy,
+			Reason:          model.FlagReason(reason),
+			Details:         details,
 		})
 	}
 
@@ -507,18 +488,9 @@ func (s *Store) FlaggedPiecesCount(ctx context.Context, filter *types.FlaggedPie
 		}
 	}()
 
-	var args []interface{}
 	var count int
-	qry := `SELECT COUNT(*) FROM PieceFlagged`
-	if filter != nil {
-		qry += ` WHERE HasUnsealedCopy = $1`
-		args = append(args, filter.HasUnsealedCopy)
-		if !filter.MinerAddr.Empty() {
-			qry += ` AND MinerAddr = $2`
-			args = append(args, filter.MinerAddr)
-		}
-	}
-
+	where, args := flaggedPiecesWhere(filter, nil)
+	qry := `SELECT COUNT(*) FROM PieceFlagged` + where
 	err := s.db.QueryRow(ctx, qry, args...).Scan(&count)
 	if err != nil {
 		return 0, fmt.Errorf("getting flagged pieces count: %w", err)
@@ -528,6 +500,37 @@ func (s *Store) FlaggedPiecesCount(ctx context.Context, filter *types.FlaggedPie
 	return count, nil
 }
 
+// flaggedPiecesWhere builds the WHERE clause and arguments for a flagged
+// pieces query
+func flaggedPiecesWhere(filter *types.FlaggedPiecesListFilter, cursor *time.Time) (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	addCond := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+
+	if cursor != nil {
+		addCond(`CreatedAt < $%d`, *cursor)
+	}
+	if filter != nil {
+		if !filter.MinerAddr.Empty() {
+			addCond(`MinerAddr = $%d`, filter.MinerAddr.String())
+		}
+		if filter.HasUnsealedCopy != nil {
+			addCond(`HasUnsealedCopy = $%d`, *filter.HasUnsealedCopy)
+		}
+		if filter.Reason != "" {
+			addCond(`Reason = $%d`, string(filter.Reason))
+		}
+	}
+
+	if len(conds) == 0 {
+		return "", args
+	}
+	return ` WHERE ` + strings.Join(conds, ` AND `), args
+}
+
 func (s *Store) UntrackPiece(ctx context.Context, pieceCid cid.Cid, maddr address.Address) error {
 	ctx, span := tracing.Tracer.Start(ctx, "store.untrack_piece")
 	span.SetAttributes(attribute.String("pieceCid", pieceCid.String()))
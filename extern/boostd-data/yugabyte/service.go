@@ -248,13 +248,15 @@ func (s *Store) getPieceMetadata(ctx context.Context, pieceCid cid.Cid) (model.M
 
 	// Get piece metadata
 	var md model.Metadata
-	qry := `SELECT Version, IndexedAt, CompleteIndex FROM PieceMetadata WHERE PieceCid = ?`
+	var checkpoint int64
+	qry := `SELECT Version, IndexedAt, CompleteIndex, IndexCheckpoint FROM PieceMetadata WHERE PieceCid = ?`
 	err := s.session.Query(qry, pieceCid.String()).WithContext(ctx).
-		Scan(&md.Version, &md.IndexedAt, &md.CompleteIndex)
+		Scan(&md.Version, &md.IndexedAt, &md.CompleteIndex, &checkpoint)
 	if err != nil {
 		err = normalizePieceCidError(pieceCid, err)
 		return md, fmt.Errorf("getting piece metadata: %w", err)
 	}
+	md.IndexCheckpoint = uint64(checkpoint)
 
 	return md, nil
 }
@@ -715,6 +717,37 @@ func (s *Store) IsCompleteIndex(ctx context.Context, pieceCid cid.Cid) (bool, er
 	return md.CompleteIndex, nil
 }
 
+func (s *Store) SetIndexCheckpoint(ctx context.Context, pieceCid cid.Cid, checkpoint uint64) error {
+	ctx, span := tracing.Tracer.Start(ctx, "store.set_index_checkpoint")
+	defer span.End()
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.Endpoint, "yb.set_index_checkpoint"))
+	stop := metrics.Timer(ctx, metrics.APIRequestDuration)
+	defer stop()
+	failureMetrics := true
+	defer func() {
+		if failureMetrics {
+			stats.Record(s.ctx, metrics.FailureSetIndexCheckpointCount.M(1))
+		} else {
+			stats.Record(s.ctx, metrics.SuccessSetIndexCheckpointCount.M(1))
+		}
+	}()
+
+	// The checkpoint may be set before the first records are added, so
+	// create the piece metadata if it doesn't exist yet
+	if err := s.createPieceMetadata(ctx, pieceCid); err != nil {
+		return err
+	}
+
+	qry := `UPDATE PieceMetadata SET IndexCheckpoint = ? WHERE PieceCid = ?`
+	err := s.session.Query(qry, int64(checkpoint), pieceCid.String()).WithContext(ctx).Exec()
+	if err != nil {
+		return fmt.Errorf("setting index checkpoint for piece %s: %w", pieceCid, err)
+	}
+
+	failureMetrics = false
+	return nil
+}
+
 func (s *Store) IsIndexed(ctx context.Context, pieceCid cid.Cid) (bool, error) {
 	ctx, _ = tag.New(ctx, tag.Upsert(metrics.Endpoint, "yb.is_indexed"))
 	stop := metrics.Timer(ctx, metrics.APIRequestDuration)
@@ -728,7 +761,7 @@ func (s *Store) IsIndexed(ctx context.Context, pieceCid cid.Cid) (bool, error) {
 		}
 	}()
 
-	t, err := s.IndexedAt(ctx, pieceCid)
+	md, err := s.getPieceMetadata(ctx, pieceCid)
 	if err != nil {
 		if isNotFoundErr(err) {
 			return false, nil
@@ -736,7 +769,8 @@ func (s *Store) IsIndexed(ctx context.Context, pieceCid cid.Cid) (bool, error) {
 		return false, err
 	}
 	failureMetrics = false
-	return !t.IsZero(), nil
+	// A piece with a checkpoint is only partially indexed
+	return !md.IndexedAt.IsZero() && md.IndexCheckpoint == 0, nil
 }
 
 func (s *Store) IndexedAt(ctx context.Context, pieceCid cid.Cid) (time.Time, error) {
@@ -930,6 +964,13 @@ func (s *Store) RemoveIndexes(ctx context.Context, pieceCid cid.Cid) error {
 		return err
 	}
 
+	// Clear any checkpoint left by a partial index build
+	qry := `UPDATE PieceMetadata SET IndexCheckpoint = 0 WHERE PieceCid = ? IF EXISTS`
+	err = s.session.Query(qry, pieceCid.String()).WithContext(ctx).Exec()
+	if err != nil {
+		return fmt.Errorf("clearing index checkpoint for piece %s: %w", pieceCid, err)
+	}
+
 	failureMetrics = false
 	return nil
 }
@@ -17,6 +17,7 @@ import (
 	"github.com/filecoin-project/boost/indexprovider"
 	"github.com/filecoin-project/boost/lib/legacy"
 	"github.com/filecoin-project/boost/lib/mpoolmonitor"
+	"github.com/filecoin-project/boost/lib/unsealsched"
 	"github.com/filecoin-project/boost/markets/storageadapter"
 	"github.com/filecoin-project/boost/node/config"
 	"github.com/filecoin-project/boost/piecedirectory"
@@ -76,10 +77,11 @@ type resolver struct {
 	mpool          *mpoolmonitor.MpoolMonitor
 	mma            *lib.MultiMinerAccessor
 	askProv        storedask.StoredAsk
+	unsealSched    *unsealsched.UnsealScheduler
 	curio          bool
 }
 
-func NewResolver(ctx context.Context, cfg *config.Boost, r lotus_repo.LockedRepo, h host.Host, dealsDB *db.DealsDB, directDealsDB *db.DirectDealsDB, logsDB *db.LogsDB, retDB *rtvllog.RetrievalLogDB, plDB *db.ProposalLogsDB, fundsDB *db.FundsDB, fundMgr *fundmanager.FundManager, storageMgr *storagemanager.StorageManager, spApi sealingpipeline.API, provider *storagemarket.Provider, ddProvider *storagemarket.DirectDealsProvider, legacyDeals legacy.LegacyDealManager, piecedirectory *piecedirectory.PieceDirectory, publisher *storageadapter.DealPublisher, indexProv provider.Interface, idxProvWrapper *indexprovider.Wrapper, fullNode v1api.FullNode, ssm *sectorstatemgr.SectorStateMgr, mpool *mpoolmonitor.MpoolMonitor, mma *lib.MultiMinerAccessor, assk storedask.StoredAsk) (*resolver, error) {
+func NewResolver(ctx context.Context, cfg *config.Boost, r lotus_repo.LockedRepo, h host.Host, dealsDB *db.DealsDB, directDealsDB *db.DirectDealsDB, logsDB *db.LogsDB, retDB *rtvllog.RetrievalLogDB, plDB *db.ProposalLogsDB, fundsDB *db.FundsDB, fundMgr *fundmanager.FundManager, storageMgr *storagemanager.StorageManager, spApi sealingpipeline.API, provider *storagemarket.Provider, ddProvider *storagemarket.DirectDealsProvider, legacyDeals legacy.LegacyDealManager, piecedirectory *piecedirectory.PieceDirectory, publisher *storageadapter.DealPublisher, indexProv provider.Interface, idxProvWrapper *indexprovider.Wrapper, fullNode v1api.FullNode, ssm *sectorstatemgr.SectorStateMgr, mpool *mpoolmonitor.MpoolMonitor, mma *lib.MultiMinerAccessor, assk storedask.StoredAsk, unsealSched *unsealsched.UnsealScheduler) (*resolver, error) {
 
 	ret := &resolver{
 		ctx:            ctx,
@@ -107,6 +109,7 @@ func NewResolver(ctx context.Context, cfg *config.Boost, r lotus_repo.LockedRepo
 		mpool:          mpool,
 		mma:            mma,
 		askProv:        assk,
+		unsealSched:    unsealSched,
 	}
 
 	v, err := spApi.Version(context.Background())
@@ -712,6 +715,35 @@ func (dr *dealResolver) Logs(ctx context.Context) ([]*logsResolver, error) {
 	return logResolvers, nil
 }
 
+func (dr *dealResolver) SubPieces(ctx context.Context) ([]*subPieceResolver, error) {
+	subPieces, err := dr.provider.DealSubPieces(ctx, dr.ProviderDealState.DealUuid)
+	if err != nil {
+		return nil, err
+	}
+
+	subPieceResolvers := make([]*subPieceResolver, 0, len(subPieces))
+	for _, sp := range subPieces {
+		subPieceResolvers = append(subPieceResolvers, &subPieceResolver{sp})
+	}
+	return subPieceResolvers, nil
+}
+
+type subPieceResolver struct {
+	types.DealSubPiece
+}
+
+func (sr *subPieceResolver) SubPieceCid() string {
+	return sr.DealSubPiece.SubPieceCID.String()
+}
+
+func (sr *subPieceResolver) Offset() gqltypes.Uint64 {
+	return gqltypes.Uint64(sr.DealSubPiece.Offset)
+}
+
+func (sr *subPieceResolver) Size() gqltypes.Uint64 {
+	return gqltypes.Uint64(sr.DealSubPiece.Size)
+}
+
 type logsResolver struct {
 	db.DealLog
 }
@@ -0,0 +1,127 @@
+package gql
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/filecoin-project/boost/cmd/booster-http/accesslog"
+	gqltypes "github.com/filecoin-project/boost/gql/types"
+	"github.com/graph-gophers/graphql-go"
+)
+
+type httpRetrievalCountResolver struct {
+	c accesslog.Count
+}
+
+func (r *httpRetrievalCountResolver) Name() string {
+	return r.c.Name
+}
+
+func (r *httpRetrievalCountResolver) Requests() gqltypes.Uint64 {
+	return gqltypes.Uint64(r.c.Requests)
+}
+
+func (r *httpRetrievalCountResolver) Bytes() gqltypes.Uint64 {
+	return gqltypes.Uint64(r.c.Bytes)
+}
+
+func (r *httpRetrievalCountResolver) Errors() gqltypes.Uint64 {
+	return gqltypes.Uint64(r.c.Errors)
+}
+
+type httpRetrievalStatsResolver struct {
+	enabled bool
+	s       accesslog.Summary
+}
+
+func (r *httpRetrievalStatsResolver) Enabled() bool {
+	return r.enabled
+}
+
+func (r *httpRetrievalStatsResolver) From() graphql.Time {
+	return graphql.Time{Time: r.s.From}
+}
+
+func (r *httpRetrievalStatsResolver) To() graphql.Time {
+	return graphql.Time{Time: r.s.To}
+}
+
+func (r *httpRetrievalStatsResolver) Requests() gqltypes.Uint64 {
+	return gqltypes.Uint64(r.s.Requests)
+}
+
+func (r *httpRetrievalStatsResolver) Bytes() gqltypes.Uint64 {
+	return gqltypes.Uint64(r.s.Bytes)
+}
+
+func (r *httpRetrievalStatsResolver) ClientErrors() gqltypes.Uint64 {
+	return gqltypes.Uint64(r.s.ClientErrors)
+}
+
+func (r *httpRetrievalStatsResolver) ServerErrors() gqltypes.Uint64 {
+	return gqltypes.Uint64(r.s.ServerErrors)
+}
+
+func (r *httpRetrievalStatsResolver) ErrorRate() float64 {
+	return r.s.ErrorRate()
+}
+
+func (r *httpRetrievalStatsResolver) CacheHits() gqltypes.Uint64 {
+	return gqltypes.Uint64(r.s.CacheHits)
+}
+
+func (r *httpRetrievalStatsResolver) LatencyP50Ms() float64 {
+	return float64(r.s.LatencyP50) / float64(time.Millisecond)
+}
+
+func (r *httpRetrievalStatsResolver) LatencyP99Ms() float64 {
+	return float64(r.s.LatencyP99) / float64(time.Millisecond)
+}
+
+func (r *httpRetrievalStatsResolver) TopPieces() []*httpRetrievalCountResolver {
+	return toHttpRetrievalCountResolvers(r.s.TopPieces)
+}
+
+func (r *httpRetrievalStatsResolver) TopClients() []*httpRetrievalCountResolver {
+	return toHttpRetrievalCountResolvers(r.s.TopClients)
+}
+
+func toHttpRetrievalCountResolvers(counts []accesslog.Count) []*httpRetrievalCountResolver {
+	res := make([]*httpRetrievalCountResolver, 0, len(counts))
+	for _, c := range counts {
+		res = append(res, &httpRetrievalCountResolver{c: c})
+	}
+	return res
+}
+
+type httpRetrievalStatsArgs struct {
+	SinceHours graphql.NullInt
+	Top        graphql.NullInt
+}
+
+// query: httpRetrievalStats(sinceHours, top): HttpRetrievalStats
+func (r *resolver) HttpRetrievalStats(ctx context.Context, args httpRetrievalStatsArgs) (*httpRetrievalStatsResolver, error) {
+	// The stats are read from booster-http's access log, so they are only
+	// available if the access log directory is configured
+	dir := r.cfg.Retrievals.HTTP.HTTPAccessLogDir
+	if dir == "" {
+		return &httpRetrievalStatsResolver{}, nil
+	}
+
+	sinceHours := 24
+	if args.SinceHours.Set && args.SinceHours.Value != nil && *args.SinceHours.Value > 0 {
+		sinceHours = int(*args.SinceHours.Value)
+	}
+	top := 10
+	if args.Top.Set && args.Top.Value != nil && *args.Top.Value >= 0 {
+		top = int(*args.Top.Value)
+	}
+
+	since := time.Now().Add(-time.Duration(sinceHours) * time.Hour)
+	s, err := accesslog.Summarise(dir, since, top)
+	if err != nil {
+		return nil, fmt.Errorf("summarising booster-http access log: %w", err)
+	}
+	return &httpRetrievalStatsResolver{enabled: true, s: *s}, nil
+}
@@ -68,16 +68,18 @@ func (r *resolver) LID(ctx context.Context) (*lidState, error) {
 	}
 
 	maddr := r.provider.Address
+	hasUnsealedCopy := true
 	fpHasUnsealed, err := r.piecedirectory.FlaggedPiecesCount(ctx, &bdtypes.FlaggedPiecesListFilter{
-		HasUnsealedCopy: true,
+		HasUnsealedCopy: &hasUnsealedCopy,
 		MinerAddr:       maddr,
 	})
 	if err != nil {
 		return nil, err
 	}
 
+	noUnsealedCopy := false
 	fpNoUnsealed, err := r.piecedirectory.FlaggedPiecesCount(ctx, &bdtypes.FlaggedPiecesListFilter{
-		HasUnsealedCopy: false,
+		HasUnsealedCopy: &noUnsealedCopy,
 		MinerAddr:       maddr,
 	})
 	if err != nil {
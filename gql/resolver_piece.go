@@ -8,8 +8,10 @@ import (
 
 	"github.com/filecoin-project/boost/cmd/lib"
 	"github.com/filecoin-project/boost/db"
+	"github.com/filecoin-project/boost/extern/boostd-data/model"
 	"github.com/filecoin-project/boost/extern/boostd-data/svc/types"
 	gqltypes "github.com/filecoin-project/boost/gql/types"
+	"github.com/filecoin-project/boost/piecedirectory"
 	pdtypes "github.com/filecoin-project/boost/piecedirectory/types"
 	"github.com/filecoin-project/boost/sectorstatemgr"
 	"github.com/filecoin-project/go-address"
@@ -84,16 +86,20 @@ type pieceResolver struct {
 }
 
 type flaggedPieceResolver struct {
-	MinerAddr   string
-	PieceCid    string
-	IndexStatus *indexStatus
-	DealCount   int32
-	CreatedAt   graphql.Time
+	MinerAddr          string
+	PieceCid           string
+	IndexStatus        *indexStatus
+	DealCount          int32
+	CreatedAt          graphql.Time
+	Reason             string
+	Details            string
+	RemediationActions []string
 }
 
 type piecesFlaggedArgs struct {
 	MinerAddr       graphql.NullString
 	HasUnsealedCopy graphql.NullBool
+	Reason          graphql.NullString
 	Cursor          *gqltypes.BigInt // CreatedAt in milli-seconds
 	Offset          graphql.NullInt
 	Limit           graphql.NullInt
@@ -128,7 +134,13 @@ func (r *resolver) PiecesFlagged(ctx context.Context, args piecesFlaggedArgs) (*
 		if filter == nil {
 			filter = &types.FlaggedPiecesListFilter{}
 		}
-		filter.HasUnsealedCopy = *args.HasUnsealedCopy.Value
+		filter.HasUnsealedCopy = args.HasUnsealedCopy.Value
+	}
+	if args.Reason.Set && args.Reason.Value != nil && *args.Reason.Value != "" {
+		if filter == nil {
+			filter = &types.FlaggedPiecesListFilter{}
+		}
+		filter.Reason = model.FlagReason(*args.Reason.Value)
 	}
 
 	// Fetch one extra row so that we can check if there are more rows
@@ -167,12 +179,20 @@ func (r *resolver) PiecesFlagged(ctx context.Context, args piecesFlaggedArgs) (*
 				return err
 			}
 
+			var actions []string
+			for _, a := range piecedirectory.RemediationActions(flaggedPiece.Reason) {
+				actions = append(actions, string(a))
+			}
+
 			flaggedPieceResolvers = append(flaggedPieceResolvers, &flaggedPieceResolver{
-				MinerAddr:   flaggedPiece.MinerAddr.String(),
-				PieceCid:    flaggedPiece.PieceCid.String(),
-				IndexStatus: idxStatus,
-				DealCount:   int32(len(pieceInfo.Deals)),
-				CreatedAt:   graphql.Time{Time: flaggedPiece.CreatedAt},
+				MinerAddr:          flaggedPiece.MinerAddr.String(),
+				PieceCid:           flaggedPiece.PieceCid.String(),
+				IndexStatus:        idxStatus,
+				DealCount:          int32(len(pieceInfo.Deals)),
+				CreatedAt:          graphql.Time{Time: flaggedPiece.CreatedAt},
+				Reason:             string(flaggedPiece.Reason),
+				Details:            flaggedPiece.Details,
+				RemediationActions: actions,
 			})
 			return nil
 		})
@@ -195,12 +215,19 @@ func (r *resolver) PiecesFlagged(ctx context.Context, args piecesFlaggedArgs) (*
 
 type piecesFlaggedCountArgs struct {
 	HasUnsealedCopy graphql.NullBool
+	Reason          graphql.NullString
 }
 
 func (r *resolver) PiecesFlaggedCount(ctx context.Context, args piecesFlaggedCountArgs) (int32, error) {
 	var filter *types.FlaggedPiecesListFilter
 	if args.HasUnsealedCopy.Set && args.HasUnsealedCopy.Value != nil {
-		filter = &types.FlaggedPiecesListFilter{HasUnsealedCopy: *args.HasUnsealedCopy.Value}
+		filter = &types.FlaggedPiecesListFilter{HasUnsealedCopy: args.HasUnsealedCopy.Value}
+	}
+	if args.Reason.Set && args.Reason.Value != nil && *args.Reason.Value != "" {
+		if filter == nil {
+			filter = &types.FlaggedPiecesListFilter{}
+		}
+		filter.Reason = model.FlagReason(*args.Reason.Value)
 	}
 
 	count, err := r.piecedirectory.FlaggedPiecesCount(ctx, filter)
@@ -268,6 +295,31 @@ func (r *resolver) PieceBuildIndex(args struct{ PieceCid string }) (bool, error)
 	return true, nil
 }
 
+type pieceRemediateArgs struct {
+	PieceCid  string
+	MinerAddr string
+	Action    string
+}
+
+func (r *resolver) PieceRemediate(args pieceRemediateArgs) (bool, error) {
+	pieceCid, err := cid.Parse(args.PieceCid)
+	if err != nil {
+		return false, fmt.Errorf("%s is not a valid piece cid", args.PieceCid)
+	}
+	maddr, err := address.NewFromString(args.MinerAddr)
+	if err != nil {
+		return false, fmt.Errorf("parsing miner address '%s': %w", args.MinerAddr, err)
+	}
+
+	// Use the global boost context, because if the user navigates away from
+	// the page we don't want to cancel the remediation operation
+	err = r.piecedirectory.RemediateFlaggedPiece(r.ctx, pieceCid, maddr, piecedirectory.RemediationAction(args.Action))
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 func (r *resolver) PieceStatus(ctx context.Context, args struct{ PieceCid string }) (*pieceResolver, error) {
 	pieceCid, err := cid.Parse(args.PieceCid)
 	if err != nil {
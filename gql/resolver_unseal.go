@@ -0,0 +1,64 @@
+package gql
+
+import (
+	"context"
+
+	gqltypes "github.com/filecoin-project/boost/gql/types"
+	"github.com/filecoin-project/boost/lib/unsealsched"
+	"github.com/graph-gophers/graphql-go"
+)
+
+type unsealJobResolver struct {
+	unsealsched.Job
+}
+
+func (r *unsealJobResolver) Miner() string {
+	return r.Job.Miner.String()
+}
+
+func (r *unsealJobResolver) Sector() gqltypes.Uint64 {
+	return gqltypes.Uint64(r.Job.Sector)
+}
+
+func (r *unsealJobResolver) PieceCid() string {
+	return r.Job.PieceCid.String()
+}
+
+func (r *unsealJobResolver) Policy() string {
+	return string(r.Job.Policy)
+}
+
+func (r *unsealJobResolver) Status() string {
+	return string(r.Job.Status)
+}
+
+func (r *unsealJobResolver) CreatedAt() graphql.Time {
+	return graphql.Time{Time: r.Job.CreatedAt}
+}
+
+func (r *unsealJobResolver) UpdatedAt() graphql.Time {
+	return graphql.Time{Time: r.Job.UpdatedAt}
+}
+
+type unsealSchedulerResolver struct {
+	Enabled bool
+	Jobs    []*unsealJobResolver
+}
+
+// query: unsealScheduler: UnsealScheduler
+func (r *resolver) UnsealScheduler(ctx context.Context) (*unsealSchedulerResolver, error) {
+	// The unseal scheduler is nil if it is disabled in config
+	if r.unsealSched == nil {
+		return &unsealSchedulerResolver{}, nil
+	}
+
+	jobs := r.unsealSched.Jobs()
+	res := &unsealSchedulerResolver{
+		Enabled: true,
+		Jobs:    make([]*unsealJobResolver, 0, len(jobs)),
+	}
+	for _, j := range jobs {
+		res.Jobs = append(res.Jobs, &unsealJobResolver{Job: j})
+	}
+	return res, nil
+}
@@ -438,7 +438,7 @@ func (w *Wrapper) appendExtendedProviders(ctx context.Context, adBuilder *xprovi
 		}
 		var ep = xproviders.Info{
 			ID:       w.h.ID().String(),
-			Addrs:    []string{w.cfg.Retrievals.HTTP.HTTPRetrievalMultiaddr},
+			Addrs:    w.cfg.Retrievals.HTTP.Multiaddrs(),
 			Metadata: mbytes,
 			Priv:     key,
 		}
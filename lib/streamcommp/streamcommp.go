@@ -0,0 +1,241 @@
+package streamcommp
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"math/bits"
+
+	commcid "github.com/filecoin-project/go-fil-commcid"
+	"github.com/filecoin-project/go-state-types/abi"
+)
+
+const (
+	// The number of bytes of unpadded data in an fr32 quad
+	quadPayloadSize = 127
+	// The number of bytes in a node of the piece merkle tree
+	nodeSize = 32
+	// The maximum height of the piece merkle tree
+	maxLayers = 64
+	// The minimum amount of data that commp can be computed over
+	MinPayloadSize = 65
+)
+
+type node [nodeSize]byte
+
+// Calc computes the piece commitment (commp) over a stream of data.
+//
+// It produces the same result as the calculators in go-fil-commp-hashhash and
+// go-commp-utils, but its intermediate state can be saved and restored, so
+// that a calculation over a large file can be resumed after a restart without
+// re-reading the data that has already been processed.
+type Calc struct {
+	// The number of bytes of unpadded data written so far
+	size uint64
+	// Unpadded data waiting for a complete fr32 quad
+	quad    [quadPayloadSize]byte
+	quadLen int
+	// layers[h] holds the left node at height h of the merkle tree that is
+	// waiting for its right sibling (if bit h of filled is set)
+	layers [maxLayers]node
+	filled uint64
+
+	hashBuf [2 * nodeSize]byte
+}
+
+// State is the serializable intermediate state of a Calc
+type State struct {
+	// The number of bytes of unpadded data written so far
+	Size uint64
+	// Unpadded data waiting for a complete fr32 quad
+	Quad []byte
+	// Layers[h] is the left node at height h of the merkle tree that is
+	// waiting for its right sibling, or nil if there is no such node
+	Layers [][]byte
+}
+
+func New() *Calc {
+	return &Calc{}
+}
+
+// NewFromState creates a Calc that resumes the calculation from the given
+// intermediate state
+func NewFromState(st State) (*Calc, error) {
+	if len(st.Quad) != int(st.Size%quadPayloadSize) {
+		return nil, fmt.Errorf("commp state quad length %d does not match size %d", len(st.Quad), st.Size)
+	}
+	if len(st.Layers) > maxLayers {
+		return nil, fmt.Errorf("commp state has %d layers: maximum is %d", len(st.Layers), maxLayers)
+	}
+
+	c := &Calc{size: st.Size, quadLen: len(st.Quad)}
+	copy(c.quad[:], st.Quad)
+	for h, n := range st.Layers {
+		if n == nil {
+			continue
+		}
+		if len(n) != nodeSize {
+			return nil, fmt.Errorf("commp state node at layer %d has length %d: expected %d", h, len(n), nodeSize)
+		}
+		copy(c.layers[h][:], n)
+		c.filled |= 1 << h
+	}
+	return c, nil
+}
+
+// State returns a copy of the intermediate state of the calculation
+func (c *Calc) State() State {
+	st := State{
+		Size: c.size,
+		Quad: append([]byte{}, c.quad[:c.quadLen]...),
+	}
+	for h := 0; h < maxLayers; h++ {
+		if c.filled>>h == 0 {
+			break
+		}
+		var n []byte
+		if c.filled&(1<<h) != 0 {
+			n = append([]byte{}, c.layers[h][:]...)
+		}
+		st.Layers = append(st.Layers, n)
+	}
+	return st
+}
+
+// Size is the number of bytes of unpadded data written so far
+func (c *Calc) Size() uint64 {
+	return c.size
+}
+
+// Write adds data to the calculation. It never returns an error.
+func (c *Calc) Write(p []byte) (int, error) {
+	n := len(p)
+	c.size += uint64(n)
+
+	// Complete a partial quad left over from the last write
+	if c.quadLen > 0 {
+		copied := copy(c.quad[c.quadLen:], p)
+		c.quadLen += copied
+		p = p[copied:]
+		if c.quadLen < quadPayloadSize {
+			return n, nil
+		}
+		c.addQuad(c.quad[:])
+		c.quadLen = 0
+	}
+
+	// Process complete quads directly from the input
+	for len(p) >= quadPayloadSize {
+		c.addQuad(p[:quadPayloadSize])
+		p = p[quadPayloadSize:]
+	}
+
+	// Keep the remainder until there's enough data for a quad
+	c.quadLen = copy(c.quad[:], p)
+	return n, nil
+}
+
+// Sum returns the piece commitment over the data written so far. It does not
+// change the state of the calculation.
+func (c *Calc) Sum() (abi.PieceInfo, error) {
+	if c.size < MinPayloadSize {
+		return abi.PieceInfo{}, fmt.Errorf("insufficient data for commp: %d bytes is less than the minimum %d bytes", c.size, MinPayloadSize)
+	}
+
+	// Work on a copy so that more data can be written after Sum
+	cc := *c
+	if cc.quadLen > 0 {
+		// Pad the last quad with zeros
+		for i := cc.quadLen; i < quadPayloadSize; i++ {
+			cc.quad[i] = 0
+		}
+		cc.addQuad(cc.quad[:])
+	}
+
+	// The piece is padded with zeros up to the next power of two
+	quads := (c.size + quadPayloadSize - 1) / quadPayloadSize
+	paddedSize := uint64(1) << bits.Len64(quads*128-1)
+	height := bits.TrailingZeros64(paddedSize / nodeSize)
+
+	// Fold the pending nodes into the root, using the commitment to zero data
+	// for any missing right siblings
+	var carry node
+	var hasCarry bool
+	zero := node{}
+	for h := 0; h < height; h++ {
+		if cc.filled&(1<<h) != 0 {
+			if hasCarry {
+				carry = cc.hash(&cc.layers[h], &carry)
+			} else {
+				carry = cc.hash(&cc.layers[h], &zero)
+			}
+			hasCarry = true
+		} else if hasCarry {
+			carry = cc.hash(&carry, &zero)
+		}
+		zero = cc.hash(&zero, &zero)
+	}
+
+	root := carry
+	if !hasCarry {
+		// The data exactly filled the tree
+		root = cc.layers[height]
+	}
+
+	pieceCid, err := commcid.DataCommitmentV1ToCID(root[:])
+	if err != nil {
+		return abi.PieceInfo{}, fmt.Errorf("converting commp to cid: %w", err)
+	}
+	return abi.PieceInfo{Size: abi.PaddedPieceSize(paddedSize), PieceCID: pieceCid}, nil
+}
+
+// addQuad fr32 pads a quad of 127 bytes into four 32 byte leaf nodes and adds
+// them to the tree
+func (c *Calc) addQuad(in []byte) {
+	var out [4 * nodeSize]byte
+
+	// Each 254 bit field element is stored in 32 bytes, with the two most
+	// significant bits set to zero
+	copy(out[:31], in[:31])
+	out[31] = in[31] & 0x3f
+	for i := 32; i < 64; i++ {
+		out[i] = in[i]<<2 | in[i-1]>>6
+	}
+	out[63] &= 0x3f
+	for i := 64; i < 96; i++ {
+		out[i] = in[i]<<4 | in[i-1]>>4
+	}
+	out[95] &= 0x3f
+	for i := 96; i < 127; i++ {
+		out[i] = in[i]<<6 | in[i-1]>>2
+	}
+	out[127] = in[126] >> 2
+
+	for i := 0; i < 4; i++ {
+		var leaf node
+		copy(leaf[:], out[i*nodeSize:(i+1)*nodeSize])
+		c.addNode(leaf)
+	}
+}
+
+// addNode adds a leaf node to the tree, combining it with any pending left
+// nodes
+func (c *Calc) addNode(n node) {
+	for h := 0; h < maxLayers; h++ {
+		if c.filled&(1<<h) == 0 {
+			c.layers[h] = n
+			c.filled |= 1 << h
+			return
+		}
+		n = c.hash(&c.layers[h], &n)
+		c.filled &^= 1 << h
+	}
+}
+
+// hash computes the parent of two nodes: the sha256 hash truncated to 254 bits
+func (c *Calc) hash(left, right *node) node {
+	copy(c.hashBuf[:nodeSize], left[:])
+	copy(c.hashBuf[nodeSize:], right[:])
+	out := node(sha256.Sum256(c.hashBuf[:]))
+	out[nodeSize-1] &= 0x3f
+	return out
+}
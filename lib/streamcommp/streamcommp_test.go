@@ -0,0 +1,83 @@
+package streamcommp
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/filecoin-project/go-commp-utils/writer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCalcMatchesCommpWriter(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	sizes := []int{127, 128, 254, 1016, 1017, 4064, 100_000, 2 << 20, 3<<20 + 11}
+	for _, size := range sizes {
+		data := make([]byte, size)
+		_, err := rnd.Read(data)
+		require.NoError(t, err)
+
+		w := &writer.Writer{}
+		_, err = io.Copy(w, bytes.NewReader(data))
+		require.NoError(t, err)
+		expected, err := w.Sum()
+		require.NoError(t, err)
+
+		c := New()
+		_, err = io.Copy(c, bytes.NewReader(data))
+		require.NoError(t, err)
+		pi, err := c.Sum()
+		require.NoError(t, err)
+
+		require.Equal(t, expected.PieceCID, pi.PieceCID, "size %d", size)
+		require.Equal(t, expected.PieceSize, pi.Size, "size %d", size)
+	}
+}
+
+func TestCalcResumeFromState(t *testing.T) {
+	rnd := rand.New(rand.NewSource(2))
+	data := make([]byte, 1<<20+333)
+	_, err := rnd.Read(data)
+	require.NoError(t, err)
+
+	c := New()
+	_, err = c.Write(data)
+	require.NoError(t, err)
+	expected, err := c.Sum()
+	require.NoError(t, err)
+
+	// Write the data in randomly sized pieces, saving and restoring the state
+	// in between writes
+	c = New()
+	for rest := data; len(rest) > 0; {
+		n := rnd.Intn(10_000) + 1
+		if n > len(rest) {
+			n = len(rest)
+		}
+		_, err = c.Write(rest[:n])
+		require.NoError(t, err)
+		rest = rest[n:]
+
+		bz, err := json.Marshal(c.State())
+		require.NoError(t, err)
+		var st State
+		require.NoError(t, json.Unmarshal(bz, &st))
+		c, err = NewFromState(st)
+		require.NoError(t, err)
+	}
+
+	pi, err := c.Sum()
+	require.NoError(t, err)
+	require.Equal(t, expected, pi)
+	require.EqualValues(t, len(data), c.Size())
+}
+
+func TestCalcInsufficientData(t *testing.T) {
+	c := New()
+	_, err := c.Write(make([]byte, MinPayloadSize-1))
+	require.NoError(t, err)
+	_, err = c.Sum()
+	require.Error(t, err)
+}
@@ -0,0 +1,406 @@
+package unsealsched
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/boost/cmd/lib"
+	"github.com/filecoin-project/boost/db"
+	"github.com/filecoin-project/boost/node/config"
+	"github.com/filecoin-project/boost/piecedirectory"
+	"github.com/filecoin-project/boost/retrievalmarket/rtvllog"
+	"github.com/filecoin-project/boost/sectorstatemgr"
+	"github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log/v2"
+	"go.uber.org/fx"
+)
+
+var log = logging.Logger("unsealsched")
+
+// Finished jobs are kept for this long so that their result can be displayed
+const finishedJobRetention = 24 * time.Hour
+
+// Policy is the reason that a piece should have an unsealed copy
+type Policy string
+
+const (
+	// PolicyFastRetrieval matches pieces in deals made with the fast retrieval
+	// flag (or direct deals with keep unsealed copy)
+	PolicyFastRetrieval Policy = "fast-retrieval"
+	// PolicyClientAllowList matches pieces in deals made by an allowlisted client
+	PolicyClientAllowList Policy = "client-allowlist"
+	// PolicyRecentRetrieval matches pieces that were retrieved recently
+	PolicyRecentRetrieval Policy = "recent-retrieval"
+)
+
+// JobStatus is the status of an unseal job
+type JobStatus string
+
+const (
+	JobStatusQueued    JobStatus = "queued"
+	JobStatusUnsealing JobStatus = "unsealing"
+	JobStatusComplete  JobStatus = "complete"
+	JobStatusFailed    JobStatus = "failed"
+)
+
+// Job is a request to the sealer to unseal a sector
+type Job struct {
+	Miner     address.Address
+	Sector    abi.SectorNumber
+	PieceCid  cid.Cid
+	Policy    Policy
+	Status    JobStatus
+	Error     string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (j *Job) finished() bool {
+	return j.Status == JobStatusComplete || j.Status == JobStatusFailed
+}
+
+type DealsDB interface {
+	ListCompleted(ctx context.Context) ([]*types.ProviderDealState, error)
+}
+
+type DirectDealsDB interface {
+	ListCompleted(ctx context.Context) ([]*types.DirectDeal, error)
+}
+
+type RetrievalLog interface {
+	PiecesRetrievedSince(ctx context.Context, since time.Time) ([]cid.Cid, error)
+}
+
+// candidate is a piece in a sector that may need an unsealed copy
+type candidate struct {
+	pieceCid cid.Cid
+	client   address.Address
+	sector   abi.SectorNumber
+	offset   abi.PaddedPieceSize
+	length   abi.PaddedPieceSize
+	// fast retrieval or keep unsealed copy was set on the deal
+	keepUnsealed bool
+}
+
+// UnsealScheduler keeps an unsealed copy of pieces that match a policy.
+// It periodically checks the sealing state of the sectors containing
+// matching pieces, and asks the sealer to unseal sectors that only have a
+// sealed copy.
+type UnsealScheduler struct {
+	cfg           config.UnsealSchedulerConfig
+	maddr         address.Address
+	clients       map[address.Address]struct{}
+	dealsDB       DealsDB
+	directDealsDB DirectDealsDB
+	retrievalLog  RetrievalLog
+	ssm           *sectorstatemgr.SectorStateMgr
+	unsealer      piecedirectory.Unsealer
+	throttle      chan struct{}
+
+	lk   sync.Mutex
+	jobs map[abi.SectorNumber]*Job
+}
+
+func NewUnsealScheduler(cfg *config.Boost) func(lc fx.Lifecycle, dealsDB *db.DealsDB, directDealsDB *db.DirectDealsDB, retDB *rtvllog.RetrievalLogDB, ssm *sectorstatemgr.SectorStateMgr, mma *lib.MultiMinerAccessor) (*UnsealScheduler, error) {
+	return func(lc fx.Lifecycle, dealsDB *db.DealsDB, directDealsDB *db.DirectDealsDB, retDB *rtvllog.RetrievalLogDB, ssm *sectorstatemgr.SectorStateMgr, mma *lib.MultiMinerAccessor) (*UnsealScheduler, error) {
+		schedCfg := cfg.Retrievals.UnsealScheduler
+		if !schedCfg.Enabled {
+			return nil, nil
+		}
+
+		s, err := newUnsealScheduler(schedCfg, dealsDB, directDealsDB, retDB, ssm, mma)
+		if err != nil {
+			return nil, err
+		}
+
+		cctx, cancel := context.WithCancel(context.Background())
+		lc.Append(fx.Hook{
+			OnStart: func(ctx context.Context) error {
+				go s.run(cctx)
+				return nil
+			},
+			OnStop: func(ctx context.Context) error {
+				cancel()
+				return nil
+			},
+		})
+
+		return s, nil
+	}
+}
+
+func newUnsealScheduler(cfg config.UnsealSchedulerConfig, dealsDB DealsDB, directDealsDB DirectDealsDB, retrievalLog RetrievalLog, ssm *sectorstatemgr.SectorStateMgr, unsealer piecedirectory.Unsealer) (*UnsealScheduler, error) {
+	clients := make(map[address.Address]struct{}, len(cfg.ClientAllowList))
+	for _, c := range cfg.ClientAllowList {
+		addr, err := address.NewFromString(c)
+		if err != nil {
+			return nil, fmt.Errorf("parsing unseal scheduler client allow list address '%s': %w", c, err)
+		}
+		clients[addr] = struct{}{}
+	}
+
+	maxConcurrent := cfg.MaxConcurrentUnseals
+	if maxConcurrent <= 0 {
+		maxConcurrent = 1
+	}
+
+	return &UnsealScheduler{
+		cfg:           cfg,
+		maddr:         ssm.Maddr,
+		clients:       clients,
+		dealsDB:       dealsDB,
+		directDealsDB: directDealsDB,
+		retrievalLog:  retrievalLog,
+		ssm:           ssm,
+		unsealer:      unsealer,
+		throttle:      make(chan struct{}, maxConcurrent),
+		jobs:          make(map[abi.SectorNumber]*Job),
+	}, nil
+}
+
+func (s *UnsealScheduler) run(ctx context.Context) {
+	interval := time.Duration(s.cfg.CheckInterval)
+	if interval <= 0 {
+		interval = time.Hour
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.ScheduleOnce(ctx); err != nil {
+			log.Errorw("scheduling unseals", "err", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Jobs returns the current and recently finished unseal jobs, most recently
+// created first
+func (s *UnsealScheduler) Jobs() []Job {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	jobs := make([]Job, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		jobs = append(jobs, *j)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.After(jobs[j].CreatedAt)
+	})
+	return jobs
+}
+
+// ScheduleOnce finds pieces that match the policy and are in sectors with
+// only a sealed copy, and queues a job to unseal each of those sectors
+func (s *UnsealScheduler) ScheduleOnce(ctx context.Context) error {
+	// Wait for the sector state manager to get the state of each sector
+	s.ssm.LatestUpdateMu.Lock()
+	lu := s.ssm.LatestUpdate
+	s.ssm.LatestUpdateMu.Unlock()
+	if lu == nil {
+		log.Debugw("sector state not available yet, skipping unseal scheduling")
+		return nil
+	}
+
+	mid, err := address.IDFromAddress(s.maddr)
+	if err != nil {
+		return fmt.Errorf("getting miner id from address %s: %w", s.maddr, err)
+	}
+
+	cands, err := s.candidates(ctx)
+	if err != nil {
+		return err
+	}
+
+	recent, err := s.recentlyRetrieved(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	s.pruneFinished()
+
+	var queued int
+	for _, c := range cands {
+		policy, ok := s.match(c, recent)
+		if !ok {
+			continue
+		}
+
+		// Only unseal active sectors that have a sealed copy but no
+		// unsealed copy
+		sectorID := abi.SectorID{Miner: abi.ActorID(mid), Number: c.sector}
+		if _, ok := lu.ActiveSectors[sectorID]; !ok {
+			continue
+		}
+		if lu.SectorStates[sectorID] != db.SealStateSealed {
+			continue
+		}
+
+		if j, ok := s.jobs[c.sector]; ok {
+			// Skip sectors that are already being unsealed
+			if !j.finished() {
+				continue
+			}
+			// If the job finished after the last sector state update, the
+			// sector state doesn't reflect the result of the job yet
+			if j.UpdatedAt.After(lu.UpdatedAt) {
+				continue
+			}
+		}
+
+		now := time.Now()
+		j := &Job{
+			Miner:     s.maddr,
+			Sector:    c.sector,
+			PieceCid:  c.pieceCid,
+			Policy:    policy,
+			Status:    JobStatusQueued,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		s.jobs[c.sector] = j
+		queued++
+
+		go s.unseal(ctx, j, c.offset.Unpadded(), c.length.Unpadded())
+	}
+
+	if queued > 0 {
+		log.Infow("queued sector unseals", "count", queued)
+	}
+	return nil
+}
+
+// candidates returns the pieces in completed deals that are in a sector
+func (s *UnsealScheduler) candidates(ctx context.Context) ([]candidate, error) {
+	deals, err := s.dealsDB.ListCompleted(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting completed boost deals: %w", err)
+	}
+	directDeals, err := s.directDealsDB.ListCompleted(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting completed direct deals: %w", err)
+	}
+
+	cands := make([]candidate, 0, len(deals)+len(directDeals))
+	for _, d := range deals {
+		if d.Err != "" || d.SectorID == 0 {
+			continue
+		}
+		cands = append(cands, candidate{
+			pieceCid:     d.ClientDealProposal.Proposal.PieceCID,
+			client:       d.ClientDealProposal.Proposal.Client,
+			sector:       d.SectorID,
+			offset:       d.Offset,
+			length:       d.Length,
+			keepUnsealed: d.FastRetrieval,
+		})
+	}
+	for _, d := range directDeals {
+		if d.Err != "" || d.SectorID == 0 {
+			continue
+		}
+		cands = append(cands, candidate{
+			pieceCid:     d.PieceCID,
+			client:       d.Client,
+			sector:       d.SectorID,
+			offset:       d.Offset,
+			length:       d.Length,
+			keepUnsealed: d.KeepUnsealedCopy,
+		})
+	}
+	return cands, nil
+}
+
+func (s *UnsealScheduler) recentlyRetrieved(ctx context.Context) (map[cid.Cid]struct{}, error) {
+	window := time.Duration(s.cfg.RecentRetrievalWindow)
+	if window <= 0 || s.retrievalLog == nil {
+		return nil, nil
+	}
+
+	pieceCids, err := s.retrievalLog.PiecesRetrievedSince(ctx, time.Now().Add(-window))
+	if err != nil {
+		return nil, fmt.Errorf("getting recently retrieved pieces: %w", err)
+	}
+
+	recent := make(map[cid.Cid]struct{}, len(pieceCids))
+	for _, c := range pieceCids {
+		recent[c] = struct{}{}
+	}
+	return recent, nil
+}
+
+// match returns the policy that requires the piece to have an unsealed copy
+func (s *UnsealScheduler) match(c candidate, recent map[cid.Cid]struct{}) (Policy, bool) {
+	if s.cfg.FastRetrievalDeals && c.keepUnsealed {
+		return PolicyFastRetrieval, true
+	}
+	if _, ok := s.clients[c.client]; ok {
+		return PolicyClientAllowList, true
+	}
+	if _, ok := recent[c.pieceCid]; ok {
+		return PolicyRecentRetrieval, true
+	}
+	return "", false
+}
+
+// pruneFinished removes jobs that finished more than the retention period ago.
+// Must be called with the lock held.
+func (s *UnsealScheduler) pruneFinished() {
+	for sector, j := range s.jobs {
+		if j.finished() && time.Since(j.UpdatedAt) > finishedJobRetention {
+			delete(s.jobs, sector)
+		}
+	}
+}
+
+func (s *UnsealScheduler) setStatus(j *Job, status JobStatus, err error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	j.Status = status
+	j.UpdatedAt = time.Now()
+	if err != nil {
+		j.Error = err.Error()
+	}
+}
+
+func (s *UnsealScheduler) unseal(ctx context.Context, j *Job, offset abi.UnpaddedPieceSize, length abi.UnpaddedPieceSize) {
+	// Limit the number of concurrent unseal requests to the sealer
+	select {
+	case s.throttle <- struct{}{}:
+	case <-ctx.Done():
+		s.setStatus(j, JobStatusFailed, ctx.Err())
+		return
+	}
+	defer func() { <-s.throttle }()
+
+	s.setStatus(j, JobStatusUnsealing, nil)
+
+	start := time.Now()
+	log.Infow("unsealing sector", "miner", j.Miner, "sector", j.Sector, "pieceCid", j.PieceCid, "policy", j.Policy)
+	r, err := s.unsealer.UnsealSectorAt(ctx, j.Miner, j.Sector, offset, length)
+	if err != nil {
+		log.Errorw("unsealing sector", "miner", j.Miner, "sector", j.Sector, "pieceCid", j.PieceCid, "err", err)
+		s.setStatus(j, JobStatusFailed, err)
+		return
+	}
+	_ = r.Close()
+
+	log.Infow("unsealed sector", "miner", j.Miner, "sector", j.Sector, "pieceCid", j.PieceCid, "took", time.Since(start).String())
+	s.setStatus(j, JobStatusComplete, nil)
+}
@@ -0,0 +1,158 @@
+package unsealsched
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/boost/db"
+	"github.com/filecoin-project/boost/node/config"
+	"github.com/filecoin-project/boost/sectorstatemgr"
+	"github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/boost/testutil"
+	"github.com/filecoin-project/dagstore/mount"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/builtin/v9/market"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduleOnce(t *testing.T) {
+	ctx := context.Background()
+
+	maddr, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	client, err := address.NewIDAddress(2000)
+	require.NoError(t, err)
+
+	// Sector 1: fast retrieval deal, sealed copy only
+	// Sector 2: deal from an allowlisted client, sealed copy only
+	// Sector 3: fast retrieval deal, already unsealed
+	// Sector 4: deal that doesn't match any policy
+	deals := &mockDealsDB{deals: []*types.ProviderDealState{
+		newDeal(1, address.TestAddress, true),
+		newDeal(2, client, false),
+		newDeal(3, address.TestAddress, true),
+		newDeal(4, address.TestAddress, false),
+	}}
+
+	states := map[abi.SectorNumber]db.SealState{
+		1: db.SealStateSealed,
+		2: db.SealStateSealed,
+		3: db.SealStateUnsealed,
+		4: db.SealStateSealed,
+	}
+	ssm := &sectorstatemgr.SectorStateMgr{Maddr: maddr}
+	ssm.LatestUpdate = newSectorStateUpdates(maddr, states)
+
+	cfg := config.UnsealSchedulerConfig{
+		Enabled:              true,
+		FastRetrievalDeals:   true,
+		ClientAllowList:      []string{client.String()},
+		MaxConcurrentUnseals: 1,
+	}
+	unsealer := &mockUnsealer{}
+	s, err := newUnsealScheduler(cfg, deals, &mockDirectDealsDB{}, nil, ssm, unsealer)
+	require.NoError(t, err)
+
+	require.NoError(t, s.ScheduleOnce(ctx))
+	require.Eventually(t, func() bool {
+		for _, j := range s.Jobs() {
+			if j.Status != JobStatusComplete {
+				return false
+			}
+		}
+		return true
+	}, time.Second, 10*time.Millisecond)
+
+	jobs := s.Jobs()
+	require.Len(t, jobs, 2)
+	policies := make(map[abi.SectorNumber]Policy)
+	for _, j := range jobs {
+		policies[j.Sector] = j.Policy
+	}
+	require.Equal(t, PolicyFastRetrieval, policies[1])
+	require.Equal(t, PolicyClientAllowList, policies[2])
+	require.ElementsMatch(t, []abi.SectorNumber{1, 2}, unsealer.unsealed())
+
+	// The sector state has not been refreshed since the jobs completed, so
+	// the sectors should not be unsealed again
+	require.NoError(t, s.ScheduleOnce(ctx))
+	require.Len(t, unsealer.unsealed(), 2)
+}
+
+func newDeal(sector abi.SectorNumber, client address.Address, fastRetrieval bool) *types.ProviderDealState {
+	return &types.ProviderDealState{
+		ClientDealProposal: market.ClientDealProposal{
+			Proposal: market.DealProposal{
+				PieceCID: testutil.GenerateCid(),
+				Client:   client,
+			},
+		},
+		SectorID:      sector,
+		Offset:        0,
+		Length:        2048,
+		FastRetrieval: fastRetrieval,
+	}
+}
+
+func newSectorStateUpdates(maddr address.Address, states map[abi.SectorNumber]db.SealState) *sectorstatemgr.SectorStateUpdates {
+	mid, _ := address.IDFromAddress(maddr)
+	u := &sectorstatemgr.SectorStateUpdates{
+		ActiveSectors: make(map[abi.SectorID]struct{}),
+		SectorStates:  make(map[abi.SectorID]db.SealState),
+		UpdatedAt:     time.Now().Add(-time.Minute),
+	}
+	for sector, state := range states {
+		sectorID := abi.SectorID{Miner: abi.ActorID(mid), Number: sector}
+		u.ActiveSectors[sectorID] = struct{}{}
+		u.SectorStates[sectorID] = state
+	}
+	return u
+}
+
+type mockDealsDB struct {
+	deals []*types.ProviderDealState
+}
+
+func (m *mockDealsDB) ListCompleted(ctx context.Context) ([]*types.ProviderDealState, error) {
+	return m.deals, nil
+}
+
+type mockDirectDealsDB struct{}
+
+func (m *mockDirectDealsDB) ListCompleted(ctx context.Context) ([]*types.DirectDeal, error) {
+	return nil, nil
+}
+
+type mockUnsealer struct {
+	lk      sync.Mutex
+	sectors []abi.SectorNumber
+}
+
+func (m *mockUnsealer) IsUnsealed(ctx context.Context, minerAddr address.Address, sectorID abi.SectorNumber, offset abi.UnpaddedPieceSize, length abi.UnpaddedPieceSize) (bool, error) {
+	return false, nil
+}
+
+func (m *mockUnsealer) UnsealSectorAt(ctx context.Context, minerAddr address.Address, sectorID abi.SectorNumber, pieceOffset abi.UnpaddedPieceSize, length abi.UnpaddedPieceSize) (mount.Reader, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	m.sectors = append(m.sectors, sectorID)
+	return &nopReader{Reader: bytes.NewReader(nil)}, nil
+}
+
+func (m *mockUnsealer) unsealed() []abi.SectorNumber {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	return append([]abi.SectorNumber{}, m.sectors...)
+}
+
+type nopReader struct {
+	*bytes.Reader
+}
+
+func (r *nopReader) Close() error {
+	return nil
+}
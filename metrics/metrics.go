@@ -24,6 +24,12 @@ var (
 
 	Endpoint, _     = tag.NewKey("endpoint")
 	APIInterface, _ = tag.NewKey("api")
+
+	// http
+	APIKey, _ = tag.NewKey("api_key")
+
+	// http and bitswap
+	GeoRule, _ = tag.NewKey("geo_rule")
 )
 
 // Measures
@@ -38,6 +44,16 @@ var (
 	HttpPieceByCid400ResponseCount = stats.Int64("http/piece_by_cid_400_response_count", "Counter of /piece/<piece-cid> 400 responses", stats.UnitDimensionless)
 	HttpPieceByCid404ResponseCount = stats.Int64("http/piece_by_cid_404_response_count", "Counter of /piece/<piece-cid> 404 responses", stats.UnitDimensionless)
 	HttpPieceByCid500ResponseCount = stats.Int64("http/piece_by_cid_500_response_count", "Counter of /piece/<piece-cid> 500 responses", stats.UnitDimensionless)
+	HttpAuthRequestCount           = stats.Int64("http/auth_request_count", "Counter of authenticated requests", stats.UnitDimensionless)
+	HttpAuthRejectedCount          = stats.Int64("http/auth_rejected_count", "Counter of authenticated requests rejected because the quota was exceeded", stats.UnitDimensionless)
+	HttpAuthBytesSentCount         = stats.Int64("http/auth_bytes_sent_count", "Counter of the number of bytes sent to authenticated requests", stats.UnitBytes)
+	HttpRateLimitedCount           = stats.Int64("http/rate_limited_count", "Counter of requests rejected because a rate limit was exceeded", stats.UnitDimensionless)
+	HttpPaymentRequiredCount       = stats.Int64("http/payment_required_count", "Counter of requests rejected because the client's balance was exhausted", stats.UnitDimensionless)
+	HttpGeoDeniedCount             = stats.Int64("http/geo_denied_count", "Counter of requests rejected by the geo policy", stats.UnitDimensionless)
+	HttpPieceCacheHitCount         = stats.Int64("http/piece_cache_hit_count", "Counter of piece chunks read from the piece cache", stats.UnitDimensionless)
+	HttpPieceCacheMissCount        = stats.Int64("http/piece_cache_miss_count", "Counter of piece chunks not found in the piece cache", stats.UnitDimensionless)
+	HttpBlockCacheHitCount         = stats.Int64("http/block_cache_hit_count", "Counter of blocks read from the block cache", stats.UnitDimensionless)
+	HttpBlockCacheMissCount        = stats.Int64("http/block_cache_miss_count", "Counter of blocks not found in the block cache", stats.UnitDimensionless)
 
 	// http remote blockstore
 	HttpRblsGetRequestCount             = stats.Int64("http/rbls_get_request_count", "Counter of RemoteBlockstore Get requests", stats.UnitDimensionless)
@@ -62,6 +78,7 @@ var (
 	BitswapRblsHasSuccessResponseCount     = stats.Int64("bitswap/rbls_has_success_response_count", "Counter of successful RemoteBlockstore Has responses", stats.UnitDimensionless)
 	BitswapRblsHasFailResponseCount        = stats.Int64("bitswap/rbls_has_fail_response_count", "Counter of failed RemoteBlockstore Has responses", stats.UnitDimensionless)
 	BitswapRblsBytesSentCount              = stats.Int64("bitswap/rbls_bytes_sent_count", "Counter of the number of bytes sent by bitswap since startup", stats.UnitBytes)
+	BitswapGeoDeniedCount                  = stats.Int64("bitswap/geo_denied_count", "Counter of block requests rejected by the geo policy", stats.UnitDimensionless)
 
 	// graphsync
 	GraphsyncRequestQueuedCount                 = stats.Int64("graphsync/request_queued_count", "Counter of Graphsync requests queued", stats.UnitDimensionless)
@@ -100,6 +117,10 @@ var (
 	GraphsyncSendingTotalMemoryAllocated    = stats.Int64("graphsync/sending_total_allocated", "amount of block memory allocated for sending graphsync data", stats.UnitBytes)
 	GraphsyncSendingTotalPendingAllocations = stats.Int64("graphsync/sending_pending_allocations", "amount of block memory on hold from sending pending allocation", stats.UnitBytes)
 	GraphsyncSendingPeersPending            = stats.Int64("graphsync/sending_peers_pending", "number of peers we can't send more data to cause of pending allocations", stats.UnitDimensionless)
+
+	// sector packing
+	SectorPackingFillRatio = stats.Float64("storagemarket/sector_packing_fill_ratio", "Ratio of the size of the pieces in a released bin to the sector size", stats.UnitDimensionless)
+	SectorPackingBinPieces = stats.Int64("storagemarket/sector_packing_bin_pieces", "Number of pieces in a released bin", stats.UnitDimensionless)
 )
 
 var (
@@ -140,6 +161,50 @@ var (
 		Measure:     HttpPieceByCid500ResponseCount,
 		Aggregation: view.Count(),
 	}
+	HttpAuthRequestCountView = &view.View{
+		Measure:     HttpAuthRequestCount,
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{APIKey},
+	}
+	HttpAuthRejectedCountView = &view.View{
+		Measure:     HttpAuthRejectedCount,
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{APIKey},
+	}
+	HttpAuthBytesSentCountView = &view.View{
+		Measure:     HttpAuthBytesSentCount,
+		Aggregation: view.Sum(),
+		TagKeys:     []tag.Key{APIKey},
+	}
+	HttpRateLimitedCountView = &view.View{
+		Measure:     HttpRateLimitedCount,
+		Aggregation: view.Count(),
+	}
+	HttpPaymentRequiredCountView = &view.View{
+		Measure:     HttpPaymentRequiredCount,
+		Aggregation: view.Count(),
+	}
+	HttpGeoDeniedCountView = &view.View{
+		Measure:     HttpGeoDeniedCount,
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{GeoRule},
+	}
+	HttpPieceCacheHitCountView = &view.View{
+		Measure:     HttpPieceCacheHitCount,
+		Aggregation: view.Count(),
+	}
+	HttpPieceCacheMissCountView = &view.View{
+		Measure:     HttpPieceCacheMissCount,
+		Aggregation: view.Count(),
+	}
+	HttpBlockCacheHitCountView = &view.View{
+		Measure:     HttpBlockCacheHitCount,
+		Aggregation: view.Count(),
+	}
+	HttpBlockCacheMissCountView = &view.View{
+		Measure:     HttpBlockCacheMissCount,
+		Aggregation: view.Count(),
+	}
 
 	HttpRblsGetRequestCountView = &view.View{
 		Measure:     HttpRblsGetRequestCount,
@@ -223,6 +288,11 @@ var (
 		Measure:     BitswapRblsBytesSentCount,
 		Aggregation: view.Sum(),
 	}
+	BitswapGeoDeniedCountView = &view.View{
+		Measure:     BitswapGeoDeniedCount,
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{GeoRule},
+	}
 
 	// graphsync
 	GraphsyncRequestQueuedCountView = &view.View{
@@ -365,6 +435,16 @@ var (
 		Measure:     GraphsyncSendingPeersPending,
 		Aggregation: view.LastValue(),
 	}
+
+	// sector packing
+	SectorPackingFillRatioView = &view.View{
+		Measure:     SectorPackingFillRatio,
+		Aggregation: view.Distribution(0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9, 1),
+	}
+	SectorPackingBinPiecesView = &view.View{
+		Measure:     SectorPackingBinPieces,
+		Aggregation: view.Distribution(1, 2, 4, 8, 16, 32, 64, 128, 256),
+	}
 )
 
 // DefaultViews is an array of OpenCensus views for metric gathering purposes
@@ -378,6 +458,16 @@ var DefaultViews = func() []*view.View {
 		HttpPieceByCid400ResponseCountView,
 		HttpPieceByCid404ResponseCountView,
 		HttpPieceByCid500ResponseCountView,
+		HttpAuthRequestCountView,
+		HttpAuthRejectedCountView,
+		HttpAuthBytesSentCountView,
+		HttpRateLimitedCountView,
+		HttpPaymentRequiredCountView,
+		HttpGeoDeniedCountView,
+		HttpPieceCacheHitCountView,
+		HttpPieceCacheMissCountView,
+		HttpBlockCacheHitCountView,
+		HttpBlockCacheMissCountView,
 		HttpRblsGetRequestCountView,
 		HttpRblsGetSuccessResponseCountView,
 		HttpRblsGetFailResponseCountView,
@@ -398,6 +488,7 @@ var DefaultViews = func() []*view.View {
 		BitswapRblsHasSuccessResponseCountView,
 		BitswapRblsHasFailResponseCountView,
 		BitswapRblsBytesSentCountView,
+		BitswapGeoDeniedCountView,
 		GraphsyncRequestQueuedCountView,
 		GraphsyncRequestQueuedPaidCountView,
 		GraphsyncRequestQueuedUnpaidCountView,
@@ -433,6 +524,8 @@ var DefaultViews = func() []*view.View {
 		GraphsyncSendingTotalMemoryAllocatedView,
 		GraphsyncSendingTotalPendingAllocationsView,
 		GraphsyncSendingPeersPendingView,
+		SectorPackingFillRatioView,
+		SectorPackingBinPiecesView,
 		lotusmetrics.DagStorePRBytesDiscardedView,
 		lotusmetrics.DagStorePRBytesRequestedView,
 		lotusmetrics.DagStorePRDiscardCountView,
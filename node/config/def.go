@@ -78,13 +78,24 @@ func DefaultBoost() *Boost {
 			Yugabyte: LocalIndexDirectoryYugabyteConfig{
 				Enabled: false,
 			},
+			Postgres: LocalIndexDirectoryPostgresConfig{
+				Enabled: false,
+			},
 			Leveldb: LocalIndexDirectoryLeveldbConfig{
 				Enabled: false,
 			},
+			Badger: LocalIndexDirectoryBadgerConfig{
+				Enabled: false,
+			},
+			MultihashFilter: LocalIndexDirectoryMultihashFilterConfig{
+				Enabled:           false,
+				FalsePositiveRate: 0.01,
+			},
 			ParallelAddIndexLimit: 4,
 			AddIndexConcurrency:   DefaultAddIndexConcurrency,
 			EmbeddedServicePort:   8042,
 			ServiceApiInfo:        "",
+			ServiceApiInfoShards:  []string{},
 			ServiceRPCTimeout:     Duration(15 * time.Minute),
 			EnablePieceDoctor:     true,
 			LidCleanupInterval:    Duration(6 * time.Hour),
@@ -113,9 +124,20 @@ func DefaultBoost() *Boost {
 			MaxTransferDuration:             Duration(24 * 3600 * time.Second),
 			RemoteCommp:                     false,
 			MaxConcurrentLocalCommp:         1,
+			CommpWorkerPool:                 false,
+			CommpWorkerMaxAttempts:          3,
 			DealLogDurationDays:             30,
 			SealingPipelineCacheTimeout:     Duration(30 * time.Second),
 			FundsTaggingEnabled:             true,
+			Backpressure: BackpressureConfig{
+				Mode:       "pause",
+				RetryAfter: Duration(10 * time.Minute),
+			},
+			SectorPacking: SectorPackingConfig{
+				Enabled:           false,
+				MaxWait:           Duration(time.Hour),
+				EndEpochTolerance: 86400, // 86400 epochs == 30 days
+			},
 		},
 
 		IndexProvider: IndexProviderConfig{
@@ -149,6 +171,7 @@ func DefaultBoost() *Boost {
 			HttpTransferStallCheckPeriod:       Duration(30 * time.Second),
 			NChunks:                            5,
 			AllowPrivateIPs:                    false,
+			StreamingCommp:                     false,
 		},
 		Retrievals: RetrievalConfig{
 			Graphsync: GraphsyncRetrievalConfig{
@@ -161,7 +184,16 @@ func DefaultBoost() *Boost {
 				BitswapPublicAddresses: []string{},
 			},
 			HTTP: HTTPRetrievalConfig{
-				HTTPRetrievalMultiaddr: "",
+				HTTPRetrievalMultiaddr:            "",
+				HTTPRetrievalAdditionalMultiaddrs: []string{},
+			},
+			UnsealScheduler: UnsealSchedulerConfig{
+				Enabled:               false,
+				FastRetrievalDeals:    true,
+				ClientAllowList:       []string{},
+				RecentRetrievalWindow: Duration(0),
+				MaxConcurrentUnseals:  2,
+				CheckInterval:         Duration(time.Hour),
 			},
 		},
 		Dealpublish: DealPublishConfig{
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"io"
 	"net/http"
+	"strings"
 
 	"github.com/filecoin-project/boost/lib/legacy"
 	"github.com/filecoin-project/boost/lib/pdcleaner"
@@ -22,9 +23,11 @@ import (
 	"github.com/filecoin-project/boost/markets/storageadapter"
 	retmarket "github.com/filecoin-project/boost/retrievalmarket/server"
 	"github.com/filecoin-project/boost/storagemarket"
+	"github.com/filecoin-project/boost/storagemarket/commppool"
 	"github.com/filecoin-project/boost/storagemarket/sealingpipeline"
 	"github.com/filecoin-project/boost/storagemarket/types"
 
+	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-jsonrpc/auth"
 	lapi "github.com/filecoin-project/lotus/api"
 	"github.com/filecoin-project/lotus/gateway"
@@ -56,6 +59,9 @@ type BoostAPI struct {
 	// Boost - Direct Data onboarding
 	DirectDealsProvider *storagemarket.DirectDealsProvider
 
+	// Commp worker pool
+	CommpPool *commppool.Pool
+
 	// Lotus Markets
 	DealPublisher *storageadapter.DealPublisher
 
@@ -111,6 +117,12 @@ func (sm *BoostAPI) ServeRemote(perm bool) func(w http.ResponseWriter, r *http.R
 			}
 		}
 
+		// Serve staged deal data to commp workers
+		if strings.HasPrefix(r.URL.Path, commppool.JobDataPath) && sm.CommpPool.Enabled() {
+			sm.CommpPool.ServeJobData(w, r)
+			return
+		}
+
 		//sm.StorageMgr.ServeHTTP(w, r)
 	}
 }
@@ -119,6 +131,10 @@ func (sm *BoostAPI) BoostDummyDeal(ctx context.Context, params types.DealParams)
 	return sm.StorageProvider.ExecuteDeal(ctx, &params, "dummy")
 }
 
+func (sm *BoostAPI) BoostCommpWorkerRegister(ctx context.Context, url string, maxJobs int) error {
+	return sm.CommpPool.Register(ctx, url, maxJobs)
+}
+
 func (sm *BoostAPI) BoostDeal(ctx context.Context, dealUuid uuid.UUID) (*types.ProviderDealState, error) {
 	// TODO: Use a middleware function that wraps the entire api implementation for all RPC calls
 	// Testing for now until a middleware function is created
@@ -133,6 +149,10 @@ func (sm *BoostAPI) BoostDealBySignedProposalCid(ctx context.Context, proposalCi
 	return sm.StorageProvider.DealBySignedProposalCid(ctx, proposalCid)
 }
 
+func (sm *BoostAPI) BoostSubPieceDeals(ctx context.Context, subPieceCid cid.Cid) ([]types.DealSubPiece, error) {
+	return sm.StorageProvider.SubPieceDeals(ctx, subPieceCid)
+}
+
 func (sm *BoostAPI) BoostIndexerAnnounceAllDeals(ctx context.Context) error {
 	return sm.IndexProvider.IndexerAnnounceAllDeals(ctx)
 }
@@ -228,6 +248,14 @@ func (sm *BoostAPI) PdCleanup(ctx context.Context) error {
 	return sm.Pdc.CleanOnce(ctx)
 }
 
+func (sm *BoostAPI) PdRemediateFlaggedPiece(ctx context.Context, piececid cid.Cid, maddr address.Address, action string) error {
+	ctx, span := tracing.Tracer.Start(ctx, "Boost.PdRemediateFlaggedPiece")
+	span.SetAttributes(attribute.String("piececid", piececid.String()))
+	defer span.End()
+
+	return sm.Pd.RemediateFlaggedPiece(ctx, piececid, maddr, piecedirectory.RemediationAction(action))
+}
+
 func (sm *BoostAPI) MarketGetAsk(ctx context.Context) (*legacytypes.SignedStorageAsk, error) {
 	return sm.StorageProvider.GetAsk(), nil
 }
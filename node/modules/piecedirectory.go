@@ -3,10 +3,13 @@ package modules
 import (
 	"context"
 	"fmt"
+	"path/filepath"
 	"time"
 
 	"github.com/filecoin-project/boost/cmd/lib"
 	bdclient "github.com/filecoin-project/boost/extern/boostd-data/client"
+	"github.com/filecoin-project/boost/extern/boostd-data/mhfilter"
+	"github.com/filecoin-project/boost/extern/boostd-data/postgres"
 	"github.com/filecoin-project/boost/extern/boostd-data/svc"
 	"github.com/filecoin-project/boost/extern/boostd-data/yugabyte"
 	"github.com/filecoin-project/boost/node/config"
@@ -33,6 +36,25 @@ func NewPieceDirectoryStore(cfg *config.Boost) func(lc fx.Lifecycle, r lotus_rep
 		var svcCtx context.Context
 		lc.Append(fx.Hook{
 			OnStart: func(ctx context.Context) error {
+				if len(cfg.LocalIndexDirectory.ServiceApiInfoShards) > 0 {
+					shards := cfg.LocalIndexDirectory.ServiceApiInfoShards
+					log.Infow("local index directory: dialing the sharded service apis", "service-api-info-shards", shards)
+					if err := client.DialShards(ctx, shards); err != nil {
+						return err
+					}
+
+					// Move pieces to the shard they map to, in case a shard
+					// has been added
+					svcCtx, cancel = context.WithCancel(context.Background())
+					go func() {
+						moved, err := client.Rebalance(svcCtx, filepath.Join(r.Path(), "lid-shards-rebalance.json"))
+						if err != nil {
+							log.Errorw("local index directory: rebalancing shards", "moved", moved, "err", err)
+						}
+					}()
+					return nil
+				}
+
 				if cfg.LocalIndexDirectory.ServiceApiInfo != "" {
 					log.Infow("local index directory: dialing the service api", "service-api-info", cfg.LocalIndexDirectory.ServiceApiInfo)
 					return client.Dial(ctx, cfg.LocalIndexDirectory.ServiceApiInfo)
@@ -61,6 +83,15 @@ func NewPieceDirectoryStore(cfg *config.Boost) func(lc fx.Lifecycle, r lotus_rep
 					migrator := yugabyte.NewMigrator(settings, address.Address(maddr))
 					bdsvc = svc.NewYugabyte(settings, migrator)
 
+				case cfg.LocalIndexDirectory.Postgres.Enabled:
+					log.Infow("local index directory: connecting to postgres server")
+
+					// Set up a local index directory service that connects to the postgres db
+					bdsvc = svc.NewPostgres(postgres.DBSettings{
+						ConnectString:     cfg.LocalIndexDirectory.Postgres.ConnectString,
+						InsertConcurrency: cfg.LocalIndexDirectory.AddIndexConcurrency,
+					})
+
 				case cfg.LocalIndexDirectory.Leveldb.Enabled:
 					log.Infow("local index directory: connecting to leveldb instance")
 
@@ -71,11 +102,34 @@ func NewPieceDirectoryStore(cfg *config.Boost) func(lc fx.Lifecycle, r lotus_rep
 						return fmt.Errorf("creating leveldb local index directory: %w", err)
 					}
 
+				case cfg.LocalIndexDirectory.Badger.Enabled:
+					log.Infow("local index directory: connecting to badger instance")
+
+					// Setup a local index directory service that connects to the badger db
+					var err error
+					bdsvc, err = svc.NewBadger(r.Path())
+					if err != nil {
+						return fmt.Errorf("creating badger local index directory: %w", err)
+					}
+
 				default:
 					return fmt.Errorf("starting local index directory client: " +
-						"neither yugabyte nor leveldb is enabled in config - " +
-						"you must explicitly configure either LocalIndexDirectory.Yugabyte " +
-						"or LocalIndexDirectory.Leveldb as the local index directory implementation")
+						"none of yugabyte, postgres, leveldb or badger is enabled in config - " +
+						"you must explicitly configure one of LocalIndexDirectory.Yugabyte, " +
+						"LocalIndexDirectory.Postgres, LocalIndexDirectory.Leveldb or LocalIndexDirectory.Badger " +
+						"as the local index directory implementation")
+				}
+
+				if cfg.LocalIndexDirectory.MultihashFilter.Enabled {
+					filterCfg := mhfilter.DefaultConfig()
+					filterCfg.FalsePositiveRate = cfg.LocalIndexDirectory.MultihashFilter.FalsePositiveRate
+					var err error
+					filterCfg.Path, err = svc.MakeMultihashFilterPath(r.Path())
+					if err != nil {
+						return err
+					}
+					log.Infow("local index directory: enabling multihash filter", "path", filterCfg.Path)
+					bdsvc = svc.WithMultihashFilter(bdsvc, filterCfg)
 				}
 
 				// Start the embedded local index directory service
@@ -89,7 +143,7 @@ func NewPieceDirectoryStore(cfg *config.Boost) func(lc fx.Lifecycle, r lotus_rep
 				return client.Dial(ctx, fmt.Sprintf("ws://%s", addr))
 			},
 			OnStop: func(ctx context.Context) error {
-				// cancel is nil if we use the service api (boostd-data process)
+				// cancel is nil if we use a single service api (boostd-data process)
 				if cancel != nil {
 					cancel()
 				}
@@ -126,7 +180,8 @@ func NewPieceDirectory(cfg *config.Boost) func(lc fx.Lifecycle, maddr dtypes.Min
 		pdctx, cancel := context.WithCancel(context.Background())
 		pd := piecedirectory.NewPieceDirectory(store, sa,
 			cfg.LocalIndexDirectory.ParallelAddIndexLimit,
-			piecedirectory.WithAddIndexConcurrency(cfg.LocalIndexDirectory.AddIndexConcurrency))
+			piecedirectory.WithAddIndexConcurrency(cfg.LocalIndexDirectory.AddIndexConcurrency),
+			piecedirectory.WithUnsealer(sa))
 		lc.Append(fx.Hook{
 			OnStart: func(ctx context.Context) error {
 				err := sa.Start(ctx, log)
@@ -147,12 +202,17 @@ func NewPieceDirectory(cfg *config.Boost) func(lc fx.Lifecycle, maddr dtypes.Min
 	}
 }
 
-func NewPieceDoctor(cfg *config.Boost) func(lc fx.Lifecycle, maddr lotus_dtypes.MinerAddress, store *bdclient.Store, ssm *sectorstatemgr.SectorStateMgr, fullnodeApi api.FullNode) *piecedirectory.Doctor {
-	return func(lc fx.Lifecycle, maddr lotus_dtypes.MinerAddress, store *bdclient.Store, ssm *sectorstatemgr.SectorStateMgr, fullnodeApi api.FullNode) *piecedirectory.Doctor {
+func NewPieceDoctor(cfg *config.Boost) func(lc fx.Lifecycle, maddr lotus_dtypes.MinerAddress, store *bdclient.Store, pd *piecedirectory.PieceDirectory, ssm *sectorstatemgr.SectorStateMgr, fullnodeApi api.FullNode) *piecedirectory.Doctor {
+	return func(lc fx.Lifecycle, maddr lotus_dtypes.MinerAddress, store *bdclient.Store, pd *piecedirectory.PieceDirectory, ssm *sectorstatemgr.SectorStateMgr, fullnodeApi api.FullNode) *piecedirectory.Doctor {
 		if !cfg.LocalIndexDirectory.EnablePieceDoctor {
 			return &piecedirectory.Doctor{}
 		}
-		doc := piecedirectory.NewDoctor(address.Address(maddr), store, ssm, fullnodeApi)
+
+		var opts []piecedirectory.DoctorOption
+		if cfg.LocalIndexDirectory.PieceDoctorDeepCheckFraction > 0 {
+			opts = append(opts, piecedirectory.WithDeepCheck(pd, cfg.LocalIndexDirectory.PieceDoctorDeepCheckFraction))
+		}
+		doc := piecedirectory.NewDoctor(address.Address(maddr), store, ssm, fullnodeApi, opts...)
 		docctx, cancel := context.WithCancel(context.Background())
 		lc.Append(fx.Hook{
 			OnStart: func(ctx context.Context) error {
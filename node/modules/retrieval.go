@@ -15,6 +15,7 @@ import (
 	"github.com/filecoin-project/boost/retrievalmarket/rtvllog"
 	"github.com/filecoin-project/boost/retrievalmarket/server"
 	"github.com/filecoin-project/boost/retrievalmarket/types"
+	transporttypes "github.com/filecoin-project/boost/transport/types"
 	"github.com/filecoin-project/lotus/node/repo"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/peer"
@@ -83,16 +84,20 @@ func NewTransportsListener(cfg *config.Boost) func(h host.Host) (*lp2pimpl.Trans
 
 		// If there's an http retrieval address specified, add HTTP to the list
 		// of supported protocols
-		if cfg.Retrievals.HTTP.HTTPRetrievalMultiaddr != "" {
-			maddr, err := multiaddr.NewMultiaddr(cfg.Retrievals.HTTP.HTTPRetrievalMultiaddr)
-			if err != nil {
-				msg := "HTTPRetrievalURL must be in multi-address format. "
-				msg += "Could not parse '%s' as multiaddr: %w"
-				return nil, fmt.Errorf(msg, cfg.Retrievals.HTTP.HTTPRetrievalMultiaddr, err)
+		if httpAddrs := cfg.Retrievals.HTTP.Multiaddrs(); len(httpAddrs) > 0 {
+			maddrs := make([]multiaddr.Multiaddr, 0, len(httpAddrs))
+			for _, addr := range httpAddrs {
+				maddr, err := multiaddr.NewMultiaddr(addr)
+				if err != nil {
+					msg := "HTTPRetrievalURL must be in multi-address format. "
+					msg += "Could not parse '%s' as multiaddr: %w"
+					return nil, fmt.Errorf(msg, addr, err)
+				}
+				maddrs = append(maddrs, maddr)
 			}
 			protos = append(protos, types.Protocol{
 				Name:      "http",
-				Addresses: []multiaddr.Multiaddr{maddr},
+				Addresses: maddrs,
 			})
 		}
 
@@ -109,6 +114,23 @@ func NewTransportsListener(cfg *config.Boost) func(h host.Host) (*lp2pimpl.Trans
 			})
 		}
 
+		// If there's a booster-http libp2p peer ID specified, add HTTP over
+		// libp2p to the list of supported protocols. Requests are made to
+		// boostd, which proxies them to booster-http.
+		if cfg.Retrievals.HTTP.HTTPRetrievalLibp2pPeerID != "" && len(h.Addrs()) > 0 {
+			maddrs, err := peer.AddrInfoToP2pAddrs(&peer.AddrInfo{
+				ID:    h.ID(),
+				Addrs: h.Addrs(),
+			})
+			if err != nil {
+				return nil, fmt.Errorf("could not parse libp2p addresses: %w", err)
+			}
+			protos = append(protos, types.Protocol{
+				Name:      "libp2p-http",
+				Addresses: maddrs,
+			})
+		}
+
 		return lp2pimpl.NewTransportsListener(h, protos), nil
 	}
 }
@@ -190,6 +212,14 @@ func NewProtocolProxy(cfg *config.Boost) func(h host.Host) (*protocolproxy.Proto
 			}
 			peerConfig[bsPeerID] = bitswap.Protocols
 		}
+		// add booster-http's libp2p HTTP protocol if a peer id is set
+		if cfg.Retrievals.HTTP.HTTPRetrievalLibp2pPeerID != "" {
+			httpPeerID, err := peer.Decode(cfg.Retrievals.HTTP.HTTPRetrievalLibp2pPeerID)
+			if err != nil {
+				return nil, fmt.Errorf("parsing HTTPRetrievalLibp2pPeerID %s: %w", cfg.Retrievals.HTTP.HTTPRetrievalLibp2pPeerID, err)
+			}
+			peerConfig[httpPeerID] = append(peerConfig[httpPeerID], transporttypes.Libp2pHttpRetrievalProtocol)
+		}
 		return protocolproxy.NewProtocolProxy(h, peerConfig)
 	}
 }
@@ -27,6 +27,7 @@ import (
 	"github.com/filecoin-project/boost/retrievalmarket/server"
 	"github.com/filecoin-project/boost/storagemanager"
 	"github.com/filecoin-project/boost/storagemarket"
+	"github.com/filecoin-project/boost/storagemarket/commppool"
 	"github.com/filecoin-project/boost/storagemarket/logs"
 	"github.com/filecoin-project/boost/storagemarket/lp2pimpl"
 	"github.com/filecoin-project/boost/storagemarket/sealingpipeline"
@@ -36,6 +37,7 @@ import (
 	"github.com/filecoin-project/boost/transport/httptransport"
 	"github.com/filecoin-project/go-address"
 	vfsm "github.com/filecoin-project/go-ds-versioning/pkg/fsm"
+	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/go-state-types/builtin"
 	"github.com/filecoin-project/go-state-types/builtin/v9/account"
 	"github.com/filecoin-project/go-state-types/crypto"
@@ -469,10 +471,10 @@ func NewLegacyDealsManager(lc fx.Lifecycle, legacyFSM fsm.Group) legacy.LegacyDe
 	return mgr
 }
 
-func NewStorageMarketProvider(provAddr address.Address, cfg *config.Boost) func(lc fx.Lifecycle, h host.Host, a v1api.FullNode, sqldb *sql.DB, dealsDB *db.DealsDB, fundMgr *fundmanager.FundManager, storageMgr *storagemanager.StorageManager, sask storedask.StoredAsk, dp *storageadapter.DealPublisher, secb *sectorblocks.SectorBlocks, commpc types.CommpCalculator, commpt storagemarket.CommpThrottle, sps sealingpipeline.API, df dtypes.StorageDealFilter, logsSqlDB *LogSqlDB, logsDB *db.LogsDB, piecedirectory *piecedirectory.PieceDirectory, ip *indexprovider.Wrapper, cdm *storagemarket.ChainDealManager) (*storagemarket.Provider, error) {
+func NewStorageMarketProvider(provAddr address.Address, cfg *config.Boost) func(lc fx.Lifecycle, h host.Host, a v1api.FullNode, sqldb *sql.DB, dealsDB *db.DealsDB, fundMgr *fundmanager.FundManager, storageMgr *storagemanager.StorageManager, sask storedask.StoredAsk, dp *storageadapter.DealPublisher, secb *sectorblocks.SectorBlocks, commpc types.CommpCalculator, commpt storagemarket.CommpThrottle, cwp *commppool.Pool, sps sealingpipeline.API, df dtypes.StorageDealFilter, logsSqlDB *LogSqlDB, logsDB *db.LogsDB, piecedirectory *piecedirectory.PieceDirectory, ip *indexprovider.Wrapper, cdm *storagemarket.ChainDealManager) (*storagemarket.Provider, error) {
 	return func(lc fx.Lifecycle, h host.Host, a v1api.FullNode, sqldb *sql.DB, dealsDB *db.DealsDB,
 		fundMgr *fundmanager.FundManager, storageMgr *storagemanager.StorageManager, sask storedask.StoredAsk, dp *storageadapter.DealPublisher, secb *sectorblocks.SectorBlocks,
-		commpc types.CommpCalculator, commpt storagemarket.CommpThrottle, sps sealingpipeline.API,
+		commpc types.CommpCalculator, commpt storagemarket.CommpThrottle, cwp *commppool.Pool, sps sealingpipeline.API,
 		df dtypes.StorageDealFilter, logsSqlDB *LogSqlDB, logsDB *db.LogsDB,
 		piecedirectory *piecedirectory.PieceDirectory, ip *indexprovider.Wrapper, cdm *storagemarket.ChainDealManager) (*storagemarket.Provider, error) {
 
@@ -487,11 +489,27 @@ func NewStorageMarketProvider(provAddr address.Address, cfg *config.Boost) func(
 			DealLogDurationDays:         cfg.Dealmaking.DealLogDurationDays,
 			StorageFilter:               cfg.Dealmaking.Filter,
 			SealingPipelineCacheTimeout: time.Duration(cfg.Dealmaking.SealingPipelineCacheTimeout),
+			Backpressure: storagemarket.BackpressureConfig{
+				Mode:                       cfg.Dealmaking.Backpressure.Mode,
+				MaxSectorsInAddPiece:       cfg.Dealmaking.Backpressure.MaxSectorsInAddPiece,
+				MaxSectorsInPreCommit1:     cfg.Dealmaking.Backpressure.MaxSectorsInPreCommit1,
+				MaxSectorsInPreCommit2:     cfg.Dealmaking.Backpressure.MaxSectorsInPreCommit2,
+				MaxSectorsInWaitDeals:      cfg.Dealmaking.Backpressure.MaxSectorsInWaitDeals,
+				MaxDealsWaitingForAddPiece: cfg.Dealmaking.Backpressure.MaxDealsWaitingForAddPiece,
+				RetryAfter:                 time.Duration(cfg.Dealmaking.Backpressure.RetryAfter),
+			},
+			SectorPacking: storagemarket.SectorPackingConfig{
+				Enabled:              cfg.Dealmaking.SectorPacking.Enabled,
+				MaxWait:              time.Duration(cfg.Dealmaking.SectorPacking.MaxWait),
+				EndEpochTolerance:    abi.ChainEpoch(cfg.Dealmaking.SectorPacking.EndEpochTolerance),
+				ExpectedSealDuration: time.Duration(cfg.Dealmaking.ExpectedSealDuration),
+			},
 		}
 		dl := logs.NewDealLogger(logsDB)
-		tspt := httptransport.New(h, dl, httptransport.NChunksOpt(cfg.HttpDownload.NChunks), httptransport.AllowPrivateIPsOpt(cfg.HttpDownload.AllowPrivateIPs))
+		tspt := httptransport.New(h, dl, httptransport.NChunksOpt(cfg.HttpDownload.NChunks), httptransport.AllowPrivateIPsOpt(cfg.HttpDownload.AllowPrivateIPs),
+			httptransport.StreamingCommpOpt(cfg.HttpDownload.StreamingCommp))
 		prov, err := storagemarket.NewProvider(prvCfg, sqldb, dealsDB, fundMgr, storageMgr, a, dp, provAddr, secb, commpc, commpt,
-			sps, cdm, df, logsSqlDB.db, logsDB, piecedirectory, ip, sask, &signatureVerifier{a}, dl, tspt)
+			commpWorkerPool(cwp), sps, cdm, df, logsSqlDB.db, logsDB, piecedirectory, ip, sask, &signatureVerifier{a}, dl, tspt)
 		if err != nil {
 			return nil, err
 		}
@@ -510,6 +528,39 @@ func NewCommpThrottle(cfg *config.Boost) func() storagemarket.CommpThrottle {
 	}
 }
 
+func NewCommpWorkerPool(cfg *config.Boost) func(lc fx.Lifecycle) *commppool.Pool {
+	return func(lc fx.Lifecycle) *commppool.Pool {
+		pool := commppool.NewPool(commppool.Config{
+			Enabled:     cfg.Dealmaking.CommpWorkerPool,
+			MaxAttempts: cfg.Dealmaking.CommpWorkerMaxAttempts,
+		}, nil)
+
+		lc.Append(fx.Hook{
+			OnStart: func(ctx context.Context) error {
+				if pool.Enabled() {
+					pool.Start()
+				}
+				return nil
+			},
+			OnStop: func(ctx context.Context) error {
+				pool.Stop()
+				return nil
+			},
+		})
+
+		return pool
+	}
+}
+
+// commpWorkerPool returns nil if the worker pool is not enabled, so that the
+// provider falls back to remote or local commp
+func commpWorkerPool(cwp *commppool.Pool) types.CommpWorkerPool {
+	if !cwp.Enabled() {
+		return nil
+	}
+	return cwp
+}
+
 // Use a caching sector accessor
 func NewSectorAccessor(cfg *config.Boost) sectoraccessor.SectorAccessorConstructor {
 	// The cache just holds booleans, so there's no harm in using a big number
@@ -1,9 +1,12 @@
 package piecedirectory
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"math"
 	"math/rand"
 	"strings"
 	"time"
@@ -11,14 +14,17 @@ import (
 	"github.com/filecoin-project/boost/db"
 	bdclient "github.com/filecoin-project/boost/extern/boostd-data/client"
 	"github.com/filecoin-project/boost/extern/boostd-data/model"
+	pdtypes "github.com/filecoin-project/boost/piecedirectory/types"
 	"github.com/filecoin-project/boost/sectorstatemgr"
 	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-state-types/abi"
 	verifregtypes "github.com/filecoin-project/go-state-types/builtin/v9/verifreg"
 	"github.com/filecoin-project/lotus/api"
 	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/lib/readerutil"
 	"github.com/ipfs/go-cid"
 	logging "github.com/ipfs/go-log/v2"
+	"github.com/ipld/go-car/util"
 )
 
 var doclog = logging.Logger("piecedoc")
@@ -33,10 +39,37 @@ type Doctor struct {
 	store       *bdclient.Store
 	ssm         *sectorstatemgr.SectorStateMgr
 	fullnodeApi api.FullNode
+
+	// The fraction of index records to verify against the piece data when
+	// checking a piece (deep check). Deep checks are disabled if zero.
+	deepCheckFraction float64
+	pieceReader       PieceReaderGetter
 }
 
-func NewDoctor(maddr address.Address, store *bdclient.Store, ssm *sectorstatemgr.SectorStateMgr, fullnodeApi api.FullNode) *Doctor {
-	return &Doctor{maddr: maddr, store: store, ssm: ssm, fullnodeApi: fullnodeApi}
+// PieceReaderGetter gets a reader over the data for a piece
+type PieceReaderGetter interface {
+	GetPieceReader(ctx context.Context, pieceCid cid.Cid) (pdtypes.SectionReader, error)
+}
+
+type DoctorOption func(*Doctor)
+
+// WithDeepCheck enables the deep check: when a piece is checked, the doctor
+// reads a random sample of the blocks in the piece index from the piece data,
+// and verifies that the data for each block matches the block's multihash.
+// fraction is the fraction of index records to sample for each piece.
+func WithDeepCheck(pr PieceReaderGetter, fraction float64) DoctorOption {
+	return func(d *Doctor) {
+		d.pieceReader = pr
+		d.deepCheckFraction = fraction
+	}
+}
+
+func NewDoctor(maddr address.Address, store *bdclient.Store, ssm *sectorstatemgr.SectorStateMgr, fullnodeApi api.FullNode, opts ...DoctorOption) *Doctor {
+	d := &Doctor{maddr: maddr, store: store, ssm: ssm, fullnodeApi: fullnodeApi}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
 }
 
 // The average interval between calls to NextPiecesToCheck
@@ -180,6 +213,30 @@ func (d *Doctor) checkPiece(ctx context.Context, pieceCid cid.Cid, lu *sectorsta
 		return nil
 	}
 
+	var hasUnsealedCopy bool
+
+	for _, dl := range md.Deals {
+		// Ignore deals that were not made on this node's miner
+		if d.maddr != dl.MinerAddr {
+			continue
+		}
+
+		mid, err := address.IDFromAddress(dl.MinerAddr)
+		if err != nil {
+			return err
+		}
+
+		sectorID := abi.SectorID{
+			Miner:  abi.ActorID(mid),
+			Number: dl.SectorID,
+		}
+
+		if lu.SectorStates[sectorID] == db.SealStateUnsealed {
+			hasUnsealedCopy = true
+			break
+		}
+	}
+
 	// Check that Deal is actually on-chain for the active sectors
 	if d.fullnodeApi != nil { // nil in tests
 		found := false
@@ -202,40 +259,17 @@ func (d *Doctor) checkPiece(ctx context.Context, pieceCid cid.Cid, lu *sectorsta
 		}
 
 		if !found {
-			doclog.Debugw("ignoring and unflagging piece as no deal id found on chain", "piece", pieceCid)
+			doclog.Debugw("flagging piece as no deal id found on chain", "piece", pieceCid)
 
-			err = d.store.UnflagPiece(ctx, pieceCid, d.maddr)
+			details := "the piece is in an active sector but none of its deals are active on chain"
+			err = d.store.FlagPiece(ctx, pieceCid, hasUnsealedCopy, d.maddr, model.FlagReasonExpiredDeal, details)
 			if err != nil {
-				return fmt.Errorf("failed to unflag piece %s: %w", pieceCid, err)
+				return fmt.Errorf("failed to flag piece %s: %w", pieceCid, err)
 			}
 			return nil
 		}
 	}
 
-	var hasUnsealedCopy bool
-
-	for _, dl := range md.Deals {
-		// Ignore deals that were not made on this node's miner
-		if d.maddr != dl.MinerAddr {
-			continue
-		}
-
-		mid, err := address.IDFromAddress(dl.MinerAddr)
-		if err != nil {
-			return err
-		}
-
-		sectorID := abi.SectorID{
-			Miner:  abi.ActorID(mid),
-			Number: dl.SectorID,
-		}
-
-		if lu.SectorStates[sectorID] == db.SealStateUnsealed {
-			hasUnsealedCopy = true
-			break
-		}
-	}
-
 	// Check if piece has been indexed
 	isIndexed, err := d.store.IsIndexed(ctx, pieceCid)
 	if err != nil {
@@ -244,7 +278,13 @@ func (d *Doctor) checkPiece(ctx context.Context, pieceCid cid.Cid, lu *sectorsta
 
 	// If piece is not indexed or has no unsealed copy, flag it
 	if !isIndexed || !hasUnsealedCopy {
-		err = d.store.FlagPiece(ctx, pieceCid, hasUnsealedCopy, d.maddr)
+		reason := model.FlagReasonNoUnsealedCopy
+		details := "there is no unsealed copy of the piece"
+		if !isIndexed {
+			reason = model.FlagReasonMissingIndex
+			details = "the piece has not been indexed"
+		}
+		err = d.store.FlagPiece(ctx, pieceCid, hasUnsealedCopy, d.maddr, reason, details)
 		if err != nil {
 			return fmt.Errorf("failed to flag piece %s: %w", pieceCid, err)
 		}
@@ -252,6 +292,23 @@ func (d *Doctor) checkPiece(ctx context.Context, pieceCid cid.Cid, lu *sectorsta
 		return nil
 	}
 
+	// Verify that the piece data matches a sample of the blocks in the index
+	if d.deepCheckFraction > 0 && d.pieceReader != nil {
+		mismatches, sampled, err := d.deepCheck(ctx, pieceCid)
+		if err != nil {
+			return fmt.Errorf("failed to deep check piece %s: %w", pieceCid, err)
+		}
+		if mismatches > 0 {
+			details := fmt.Sprintf("%d of %d sampled blocks do not match the index", mismatches, sampled)
+			err = d.store.FlagPiece(ctx, pieceCid, hasUnsealedCopy, d.maddr, model.FlagReasonBlockMismatch, details)
+			if err != nil {
+				return fmt.Errorf("failed to flag piece %s: %w", pieceCid, err)
+			}
+			doclog.Debugw("flagging piece", "piece", pieceCid, "blockMismatches", mismatches)
+			return nil
+		}
+	}
+
 	// There are no known issues with the piece, so unflag it
 	doclog.Debugw("unflagging piece", "piece", pieceCid)
 	err = d.store.UnflagPiece(ctx, pieceCid, d.maddr)
@@ -261,3 +318,88 @@ func (d *Doctor) checkPiece(ctx context.Context, pieceCid cid.Cid, lu *sectorsta
 
 	return nil
 }
+
+// deepCheck reads a random sample of the blocks in the piece index from the
+// piece data, and verifies that the data for each block matches the block's
+// multihash. It returns the number of blocks that do not match, and the
+// number of blocks that were sampled.
+func (d *Doctor) deepCheck(ctx context.Context, pieceCid cid.Cid) (int, int, error) {
+	defer func(start time.Time) { doclog.Debugw("deep check", "piece", pieceCid, "took", time.Since(start)) }(time.Now())
+
+	recs, err := d.store.GetRecords(ctx, pieceCid)
+	if err != nil {
+		return 0, 0, fmt.Errorf("getting index records: %w", err)
+	}
+	sample := sampleRecords(recs, d.deepCheckFraction)
+	if len(sample) == 0 {
+		return 0, 0, nil
+	}
+
+	reader, err := d.pieceReader.GetPieceReader(ctx, pieceCid)
+	if err != nil {
+		return 0, 0, fmt.Errorf("getting piece reader: %w", err)
+	}
+	defer reader.Close()
+
+	var mismatches int
+	for _, rec := range sample {
+		if ctx.Err() != nil {
+			return 0, 0, ctx.Err()
+		}
+
+		err := verifyBlock(reader, rec)
+		if err != nil {
+			doclog.Warnw("block in piece does not match index", "piece", pieceCid, "cid", rec.Cid, "offset", rec.Offset, "err", err)
+			mismatches++
+		}
+	}
+
+	doclog.Debugw("deep checked piece", "piece", pieceCid, "sampled", len(sample), "records", len(recs), "mismatches", mismatches)
+	return mismatches, len(sample), nil
+}
+
+// sampleRecords returns a random sample of the records, of size
+// len(recs) * fraction (and at least one record)
+func sampleRecords(recs []model.Record, fraction float64) []model.Record {
+	if len(recs) == 0 {
+		return nil
+	}
+
+	count := int(math.Ceil(float64(len(recs)) * fraction))
+	if count >= len(recs) {
+		return recs
+	}
+
+	sample := make([]model.Record, 0, count)
+	for _, i := range rand.Perm(len(recs))[:count] {
+		sample = append(sample, recs[i])
+	}
+	return sample
+}
+
+// verifyBlock reads the block at the record's offset and verifies that the
+// block's cid matches the record, and that the block's data matches its
+// multihash
+func verifyBlock(reader pdtypes.SectionReader, rec model.Record) error {
+	readerAt := readerutil.NewReadSeekerFromReaderAt(reader, int64(rec.Offset))
+	bufferSize := 4096
+	if rec.Size > 0 && rec.Size < 4096 {
+		bufferSize = int(rec.Size)
+	}
+	readCid, data, err := util.ReadNode(bufio.NewReaderSize(readerAt, bufferSize))
+	if err != nil {
+		return fmt.Errorf("reading block: %w", err)
+	}
+	if !bytes.Equal(readCid.Hash(), rec.Cid.Hash()) {
+		return fmt.Errorf("expected block %s but read block %s", rec.Cid, readCid)
+	}
+
+	sum, err := readCid.Prefix().Sum(data)
+	if err != nil {
+		return fmt.Errorf("hashing block data: %w", err)
+	}
+	if !bytes.Equal(sum.Hash(), readCid.Hash()) {
+		return fmt.Errorf("block data hashes to %s but expected %s", sum, readCid)
+	}
+	return nil
+}
@@ -4,16 +4,20 @@
 package piecedirectory
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"testing"
 	"time"
 
 	"github.com/filecoin-project/boost/db"
+	"github.com/filecoin-project/boost/extern/boostd-data/badger"
 	"github.com/filecoin-project/boost/extern/boostd-data/client"
 	"github.com/filecoin-project/boost/extern/boostd-data/ldb"
 	"github.com/filecoin-project/boost/extern/boostd-data/model"
+	"github.com/filecoin-project/boost/extern/boostd-data/postgres"
 	"github.com/filecoin-project/boost/extern/boostd-data/svc"
 	"github.com/filecoin-project/boost/extern/boostd-data/yugabyte"
 	"github.com/filecoin-project/boost/sectorstatemgr"
@@ -65,6 +69,40 @@ func TestPieceDoctor(t *testing.T) {
 		ldb.MinPieceCheckPeriod = prev
 	})
 
+	t.Run("badger", func(t *testing.T) {
+		prev := badger.MinPieceCheckPeriod
+		badger.MinPieceCheckPeriod = 1 * time.Second
+
+		bdsvc, err := svc.NewBadger("")
+		require.NoError(t, err)
+
+		ln, err := bdsvc.Start(ctx, "localhost:0")
+		require.NoError(t, err)
+
+		cl := client.NewStore()
+		err = cl.Dial(ctx, fmt.Sprintf("ws://%s", ln))
+		require.NoError(t, err)
+		defer cl.Close(ctx)
+
+		t.Run("next pieces pagination", func(t *testing.T) {
+			prevp := badger.PiecesToTrackerBatchSize
+			testNextPiecesPagination(ctx, t, cl, func(pageSize int) {
+				badger.PiecesToTrackerBatchSize = pageSize
+			})
+			badger.PiecesToTrackerBatchSize = prevp
+		})
+
+		t.Run("check pieces", func(t *testing.T) {
+			testCheckPieces(ctx, t, cl)
+		})
+
+		t.Run("pieces count", func(t *testing.T) {
+			testPiecesCount(ctx, t, cl)
+		})
+
+		badger.MinPieceCheckPeriod = prev
+	})
+
 	t.Run("yugabyte", func(t *testing.T) {
 		prev := yugabyte.MinPieceCheckPeriod
 		yugabyte.MinPieceCheckPeriod = 1 * time.Second
@@ -106,6 +144,48 @@ func TestPieceDoctor(t *testing.T) {
 
 		yugabyte.MinPieceCheckPeriod = prev
 	})
+
+	t.Run("postgres", func(t *testing.T) {
+		prev := postgres.MinPieceCheckPeriod
+		postgres.MinPieceCheckPeriod = 1 * time.Second
+
+		bdsvc := svc.SetupPostgres(t)
+		ln, err := bdsvc.Start(ctx, "localhost:0")
+		require.NoError(t, err)
+
+		cl := client.NewStore()
+		err = cl.Dial(ctx, fmt.Sprintf("ws://%s", ln))
+		require.NoError(t, err)
+		defer cl.Close(ctx)
+
+		pgstore := bdsvc.Impl.(*postgres.Store)
+
+		t.Run("next pieces", func(t *testing.T) {
+			svc.RecreatePostgresTables(ctx, t, pgstore)
+			testNextPieces(ctx, t, cl, postgres.MinPieceCheckPeriod)
+		})
+
+		t.Run("next pieces pagination", func(t *testing.T) {
+			svc.RecreatePostgresTables(ctx, t, pgstore)
+			prevp := postgres.TrackerCheckBatchSize
+			testNextPiecesPagination(ctx, t, cl, func(pageSize int) {
+				postgres.TrackerCheckBatchSize = pageSize
+			})
+			postgres.TrackerCheckBatchSize = prevp
+		})
+
+		t.Run("check pieces", func(t *testing.T) {
+			svc.RecreatePostgresTables(ctx, t, pgstore)
+			testCheckPieces(ctx, t, cl)
+		})
+
+		t.Run("pieces count", func(t *testing.T) {
+			svc.RecreatePostgresTables(ctx, t, pgstore)
+			testPiecesCount(ctx, t, cl)
+		})
+
+		postgres.MinPieceCheckPeriod = prev
+	})
 }
 
 // Verify that after a new piece is added
@@ -441,3 +521,44 @@ func testPiecesCount(ctx context.Context, t *testing.T, cl *client.Store) {
 	require.NoError(t, err)
 	require.Equal(t, 0, count)
 }
+
+func TestDeepCheckVerifyBlock(t *testing.T) {
+	// Create a random CAR file
+	_, carFilePath := CreateCarFile(t)
+	carReader, err := car.OpenReader(carFilePath)
+	require.NoError(t, err)
+	defer carReader.Close()
+	carv1Reader, err := carReader.DataReader()
+	require.NoError(t, err)
+
+	recs := GetRecords(t, carv1Reader)
+	require.NotEmpty(t, recs)
+
+	_, err = carv1Reader.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	data, err := io.ReadAll(carv1Reader)
+	require.NoError(t, err)
+
+	// All the blocks should match the index
+	reader := MockSectionReader{bytes.NewReader(data)}
+	for _, rec := range recs {
+		require.NoError(t, verifyBlock(reader, rec))
+	}
+
+	// Corrupt the last byte of the data of one of the blocks (the byte
+	// before the start of the next block)
+	rec := recs[len(recs)/2]
+	corrupt := bytes.Clone(data)
+	corrupt[recs[len(recs)/2+1].Offset-1] ^= 0xff
+	require.Error(t, verifyBlock(MockSectionReader{bytes.NewReader(corrupt)}, rec))
+
+	// An index record with the wrong offset should not match
+	wrongOffset := recs[1]
+	wrongOffset.Offset = recs[2].Offset
+	require.Error(t, verifyBlock(reader, wrongOffset))
+
+	// The sample should contain the requested fraction of the records
+	require.Len(t, sampleRecords(recs, 1), len(recs))
+	require.Len(t, sampleRecords(recs, 0.0001), 1)
+	require.Len(t, sampleRecords(recs, 0.5), (len(recs)+1)/2)
+}
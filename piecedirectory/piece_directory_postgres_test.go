@@ -0,0 +1,20 @@
+//go:build test_lid
+// +build test_lid
+
+package piecedirectory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/boost/extern/boostd-data/svc"
+)
+
+func TestPieceDirectoryPostgres(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Second)
+	defer cancel()
+
+	bdsvc := svc.SetupPostgres(t)
+	testPieceDirectory(ctx, t, bdsvc)
+}
@@ -1,12 +1,17 @@
 package piecedirectory
 
 import (
+	"bytes"
 	"context"
+	"io"
 	"os"
 	"testing"
 
 	"github.com/filecoin-project/boost/extern/boostd-data/svc"
+	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car"
+	"github.com/ipld/go-car/util"
 	"github.com/stretchr/testify/require"
 )
 
@@ -16,6 +21,12 @@ func TestPieceDirectoryLevelDB(t *testing.T) {
 	testPieceDirectory(context.Background(), t, bdsvc)
 }
 
+func TestPieceDirectoryBadger(t *testing.T) {
+	bdsvc, err := svc.NewBadger("")
+	require.NoError(t, err)
+	testPieceDirectory(context.Background(), t, bdsvc)
+}
+
 func TestSegmentParsing(t *testing.T) {
 	carSize := int64(8323072)
 	pieceCid, err := cid.Parse(string("baga6ea4seaqly4jqbnjbw5dz4gpcu5uuu3o3t7ohzjpjx7x6z3v53tkfutogwga"))
@@ -33,6 +44,40 @@ func TestSegmentParsing(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestCarPayload(t *testing.T) {
+	// Write a CARv1 followed by zero padding, as it would be in a data segment
+	blk1 := blocks.NewBlock([]byte("block one"))
+	blk2 := blocks.NewBlock([]byte("block two"))
+	var buf bytes.Buffer
+	err := car.WriteHeader(&car.CarHeader{Roots: []cid.Cid{blk1.Cid()}, Version: 1}, &buf)
+	require.NoError(t, err)
+	for _, blk := range []blocks.Block{blk1, blk2} {
+		err = util.LdWrite(&buf, blk.Cid().Bytes(), blk.RawData())
+		require.NoError(t, err)
+	}
+	carLen := buf.Len()
+	buf.Write(make([]byte, 1024))
+
+	sr := io.NewSectionReader(bytes.NewReader(buf.Bytes()), 0, int64(buf.Len()))
+	offset, length, roots, err := carPayload(sr)
+	require.NoError(t, err)
+	require.EqualValues(t, 0, offset)
+	require.EqualValues(t, carLen, length)
+	require.Equal(t, []cid.Cid{blk1.Cid()}, roots)
+
+	// Only the header is read to get the roots, so the roots are returned
+	// even if the blocks after the header are corrupt
+	var hdr bytes.Buffer
+	err = car.WriteHeader(&car.CarHeader{Roots: []cid.Cid{blk1.Cid()}, Version: 1}, &hdr)
+	require.NoError(t, err)
+	hdr.Write([]byte{0xff, 0xff, 0xff, 0xff})
+	roots, err = carRoots(io.NewSectionReader(bytes.NewReader(hdr.Bytes()), 0, int64(hdr.Len())))
+	require.NoError(t, err)
+	require.Equal(t, []cid.Cid{blk1.Cid()}, roots)
+	_, _, _, err = carPayload(io.NewSectionReader(bytes.NewReader(hdr.Bytes()), 0, int64(hdr.Len())))
+	require.Error(t, err)
+}
+
 func TestPieceDirectoryLevelDBFuzz(t *testing.T) {
 	//_ = logging.SetLogLevel("piecedirectory", "debug")
 	bdsvc, err := svc.NewLevelDB("")
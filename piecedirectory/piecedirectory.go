@@ -8,6 +8,8 @@ import (
 	"fmt"
 	"io"
 	"runtime"
+	"slices"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -37,6 +39,7 @@ import (
 	"github.com/jellydator/ttlcache/v2"
 	"github.com/multiformats/go-multihash"
 	mh "github.com/multiformats/go-multihash"
+	"github.com/multiformats/go-varint"
 	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/sync/errgroup"
 	"golang.org/x/xerrors"
@@ -52,10 +55,15 @@ const (
 	// to support multiple concurrent AddIndex operations
 	PodsiMaxConcurrency = 32
 	PodsiMinConcurrency = 4
+	// The number of records sent to the local index directory in each
+	// AddIndex call while adding the index for a piece
+	AddIndexBatchSize = 250_000
 )
 
 type settings struct {
 	addIndexConcurrency int
+	addIndexBatchSize   int
+	unsealer            Unsealer
 }
 
 type Option func(*settings)
@@ -66,6 +74,12 @@ func WithAddIndexConcurrency(c int) Option {
 	}
 }
 
+func WithAddIndexBatchSize(n int) Option {
+	return func(s *settings) {
+		s.addIndexBatchSize = n
+	}
+}
+
 type PieceDirectory struct {
 	settings    *settings
 	store       *bdclient.Store
@@ -94,6 +108,7 @@ func NewPieceDirectory(store *bdclient.Store, pr types.PieceReader, addIndexThro
 		addIdxThrottle:     make(chan struct{}, addIndexThrottleSize),
 		settings: &settings{
 			addIndexConcurrency: config.DefaultAddIndexConcurrency,
+			addIndexBatchSize:   AddIndexBatchSize,
 		},
 	}
 
@@ -104,6 +119,9 @@ func NewPieceDirectory(store *bdclient.Store, pr types.PieceReader, addIndexThro
 	if pd.settings.addIndexConcurrency == 0 {
 		pd.settings.addIndexConcurrency = config.DefaultAddIndexConcurrency
 	}
+	if pd.settings.addIndexBatchSize == 0 {
+		pd.settings.addIndexBatchSize = AddIndexBatchSize
+	}
 
 	log.Infow("new piece directory", "add-index-concurrency", pd.settings.addIndexConcurrency, "add-idx-throttle-size", pd.addIdxThrottleSize)
 
@@ -238,6 +256,18 @@ func (ps *PieceDirectory) AddDealForPiece(ctx context.Context, pieceCid cid.Cid,
 		return err
 	}
 
+	// If a previous index build was interrupted, resume it
+	if isIndexed {
+		md, err := ps.store.GetPieceMetadata(ctx, pieceCid)
+		if err != nil {
+			return err
+		}
+		if md.IndexCheckpoint > 0 {
+			log.Infow("add deal for piece: resuming interrupted index build", "pieceCid", pieceCid, "checkpoint", md.IndexCheckpoint)
+			isIndexed = false
+		}
+	}
+
 	if !isIndexed {
 		// Perform indexing of piece
 		if err := ps.addIndexForPieceThrottled(ctx, pieceCid, dealInfo); err != nil {
@@ -320,84 +350,295 @@ func (ps *PieceDirectory) addIndexForPiece(ctx context.Context, pieceCid cid.Cid
 
 	defer reader.Close() //nolint:errcheck
 
+	// If a previous attempt to add the index was interrupted, the records
+	// before the checkpoint have already been added
+	var checkpoint uint64
+	md, err := ps.store.GetPieceMetadata(ctx, pieceCid)
+	if err != nil && !bdtypes.IsNotFound(err) {
+		return fmt.Errorf("getting metadata for piece %s: %w", pieceCid, err)
+	}
+	if err == nil && md.IndexCheckpoint > 0 {
+		checkpoint = md.IndexCheckpoint
+		log.Infow("add index: resuming from checkpoint", "pieceCid", pieceCid, "checkpoint", checkpoint)
+	}
+
 	// Try to parse data as containing a data segment index
 	log.Debugw("add index: read index", "pieceCid", pieceCid)
-	recs, err := parsePieceWithDataSegmentIndex(pieceCid, int64(dealInfo.PieceLength.Unpadded()), reader)
-	if err != nil {
-		log.Infow("add index: data segment check failed. falling back to car", "pieceCid", pieceCid, "err", err)
-		// Iterate over all the blocks in the piece to extract the index records
-		if _, err := reader.Seek(0, io.SeekStart); err != nil {
-			return fmt.Errorf("seek to start for piece %s: %w", pieceCid, err)
+	segments, err := parseDataSegments(pieceCid, int64(dealInfo.PieceLength.Unpadded()), reader)
+	if err == nil {
+		w := ps.newIndexWriter(ctx, pieceCid, checkpoint)
+		err = w.finish(streamRecordsFromDataSegments(reader, segments, w.add))
+		if err == nil {
+			w.warnIfEmpty()
+			return nil
 		}
-		recs, err = parseRecordsFromCar(reader)
-		if err != nil {
-			return fmt.Errorf("parse car for piece %s: %w", pieceCid, err)
+		if w.storeErr != nil {
+			return err
+		}
+
+		// Remove any records that were added from the data segments before
+		// falling back to parsing the piece as a CAR file
+		if w.added > 0 {
+			if rerr := ps.store.RemoveIndexes(ctx, pieceCid); rerr != nil {
+				return fmt.Errorf("removing partial index for piece %s: %w", pieceCid, rerr)
+			}
+			checkpoint = 0
 		}
 	}
 
-	if len(recs) == 0 {
-		log.Warnw("add index: generated index with 0 recs", "pieceCid", pieceCid)
-		return nil
+	log.Infow("add index: data segment check failed. falling back to car", "pieceCid", pieceCid, "err", err)
+	// Iterate over all the blocks in the piece to extract the index records
+	if _, err := reader.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("seek to start for piece %s: %w", pieceCid, err)
+	}
+	w := ps.newIndexWriter(ctx, pieceCid, checkpoint)
+	if err := w.finish(streamRecordsFromCar(reader, w.add)); err != nil {
+		return fmt.Errorf("parse car for piece %s: %w", pieceCid, err)
+	}
+	w.warnIfEmpty()
+	return nil
+}
+
+// indexWriter adds the records for a piece to the local index directory in
+// batches as they are parsed from the piece data.
+// Batches are added concurrently. When all workers are busy, adding a record
+// blocks until a worker is free, so that only a bounded number of records are
+// held in memory.
+// As batches are added, the offset below which all records have been added
+// is saved in the piece metadata as a checkpoint, so that an interrupted
+// index build can be resumed. Records must be parsed in order of offset.
+type indexWriter struct {
+	ctx      context.Context
+	egctx    context.Context
+	eg       *errgroup.Group
+	store    *bdclient.Store
+	pieceCid cid.Cid
+
+	// records before the checkpoint were added by a previous attempt
+	checkpoint uint64
+	batchSize  int
+	batch      []model.Record
+	batches    chan indexBatch
+	nextSeq    int
+	// the number of records parsed, and the number sent to the store
+	parsed int
+	added  int
+
+	// the error returned by the store when adding a batch
+	storeErr error
+
+	lk sync.Mutex
+	// the end offset of each batch that has been added, by sequence number
+	done       map[int]uint64
+	nextCommit int
+}
+
+type indexBatch struct {
+	seq  int
+	recs []model.Record
+}
+
+func (ps *PieceDirectory) newIndexWriter(ctx context.Context, pieceCid cid.Cid, checkpoint uint64) *indexWriter {
+	eg, egctx := errgroup.WithContext(ctx)
+	w := &indexWriter{
+		ctx:        ctx,
+		egctx:      egctx,
+		eg:         eg,
+		store:      ps.store,
+		pieceCid:   pieceCid,
+		checkpoint: checkpoint,
+		batchSize:  ps.settings.addIndexBatchSize,
+		batches:    make(chan indexBatch),
+		done:       make(map[int]uint64),
 	}
 
 	// Transferring a large number of records over the wire can take a significant amount of time.
 	// Split the transfer into multiple concurrent parts to speed it up. Note that the index can't be generated by boost-data itself
 	// as it doesn't possess the actual file.
-	concurrency := ps.settings.addIndexConcurrency
-	// in an unlikely case if there are less than 8 records in the index
-	if concurrency > len(recs) {
-		concurrency = len(recs)
+	for i := 0; i < ps.settings.addIndexConcurrency; i++ {
+		eg.Go(w.worker)
 	}
+	return w
+}
 
-	rangeLen := len(recs) / concurrency
-	eg, ctx := errgroup.WithContext(ctx)
+// add is called for each record parsed from the piece
+func (w *indexWriter) add(rec model.Record) error {
+	w.parsed++
+	if rec.Offset < w.checkpoint {
+		return nil
+	}
 
-	for i := 0; i < concurrency; i++ {
-		i := i
-		eg.Go(func() error {
-			start := i * rangeLen
-			end := start + rangeLen
-			if i == concurrency-1 {
-				end = len(recs)
-			}
-			// Add mh => piece index to store: "which piece contains the multihash?"
-			// Add mh => offset index to store: "what is the offset of the multihash within the piece?"
-			log.Debugw("add index: store index in local index directory", "pieceCid", pieceCid, "chunk", i, "chunksTotal", concurrency, "len(recs)", len(recs[start:end]), "start", start, "end", end)
-			if err := ps.store.AddIndex(ctx, pieceCid, recs[start:end], true); err != nil {
-				return fmt.Errorf("adding CAR index for piece %s: %w", pieceCid, err)
-			}
-			return nil
-		})
+	w.batch = append(w.batch, rec)
+	if len(w.batch) < w.batchSize {
+		return nil
+	}
+	return w.flush()
+}
+
+func (w *indexWriter) flush() error {
+	if len(w.batch) == 0 {
+		return nil
+	}
+
+	// Before the first records are added, save a checkpoint so that the
+	// index is not treated as complete if the build is interrupted
+	if w.added == 0 && w.checkpoint == 0 {
+		if err := w.store.SetIndexCheckpoint(w.ctx, w.pieceCid, w.batch[0].Offset); err != nil {
+			return w.fail(fmt.Errorf("setting index checkpoint for piece %s: %w", w.pieceCid, err))
+		}
 	}
 
-	return eg.Wait()
+	b := indexBatch{seq: w.nextSeq, recs: w.batch}
+	w.nextSeq++
+	w.added += len(w.batch)
+	w.batch = make([]model.Record, 0, w.batchSize)
+
+	select {
+	case w.batches <- b:
+		return nil
+	case <-w.egctx.Done():
+		// A worker failed to add a batch: return the error from the store
+		// rather than the cancellation of the errgroup context
+		return w.err()
+	}
+}
+
+// err returns the first error returned by the store, or the context error
+// if the context was cancelled before the store returned an error
+func (w *indexWriter) err() error {
+	w.lk.Lock()
+	defer w.lk.Unlock()
+	if w.storeErr != nil {
+		return w.storeErr
+	}
+	return w.egctx.Err()
+}
+
+func (w *indexWriter) worker() error {
+	for b := range w.batches {
+		// Add mh => piece index to store: "which piece contains the multihash?"
+		// Add mh => offset index to store: "what is the offset of the multihash within the piece?"
+		log.Debugw("add index: store index in local index directory", "pieceCid", w.pieceCid, "batch", b.seq, "len(recs)", len(b.recs))
+		if err := w.store.AddIndex(w.egctx, w.pieceCid, b.recs, true); err != nil {
+			return w.fail(fmt.Errorf("adding CAR index for piece %s: %w", w.pieceCid, err))
+		}
+		if err := w.commit(b); err != nil {
+			return w.fail(err)
+		}
+	}
+	return nil
+}
+
+func (w *indexWriter) fail(err error) error {
+	w.lk.Lock()
+	defer w.lk.Unlock()
+	if w.storeErr == nil {
+		w.storeErr = err
+	}
+	return err
+}
+
+// commit records that a batch has been added, and advances the checkpoint
+// past all batches that have been added without a gap before them
+func (w *indexWriter) commit(b indexBatch) error {
+	w.lk.Lock()
+	defer w.lk.Unlock()
+
+	w.done[b.seq] = b.recs[len(b.recs)-1].Offset + 1
+
+	var checkpoint uint64
+	for {
+		end, ok := w.done[w.nextCommit]
+		if !ok {
+			break
+		}
+		delete(w.done, w.nextCommit)
+		w.nextCommit++
+		checkpoint = end
+	}
+	if checkpoint == 0 {
+		return nil
+	}
+
+	// The checkpoint is set while holding the lock so that it only moves forwards
+	if err := w.store.SetIndexCheckpoint(w.egctx, w.pieceCid, checkpoint); err != nil {
+		return fmt.Errorf("setting index checkpoint for piece %s: %w", w.pieceCid, err)
+	}
+	return nil
+}
+
+// finish is called once parsing is complete, with the parsing error if any.
+// It adds any remaining records and waits for all batches to be added.
+// If the whole index was added, the checkpoint is cleared.
+func (w *indexWriter) finish(parseErr error) error {
+	if parseErr == nil {
+		parseErr = w.flush()
+	}
+	close(w.batches)
+	err := w.eg.Wait()
+	if parseErr != nil {
+		return parseErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if w.added == 0 && w.checkpoint == 0 {
+		return nil
+	}
+	if err := w.store.SetIndexCheckpoint(w.ctx, w.pieceCid, 0); err != nil {
+		return w.fail(fmt.Errorf("clearing index checkpoint for piece %s: %w", w.pieceCid, err))
+	}
+	return nil
+}
+
+func (w *indexWriter) warnIfEmpty() {
+	if w.parsed == 0 {
+		log.Warnw("add index: generated index with 0 recs", "pieceCid", w.pieceCid)
+	}
 }
 
 func parseRecordsFromCar(reader io.Reader) ([]model.Record, error) {
-	// Iterate over all the blocks in the piece to extract the index records
 	recs := make([]model.Record, 0)
+	err := streamRecordsFromCar(reader, func(rec model.Record) error {
+		recs = append(recs, rec)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return recs, nil
+}
+
+// streamRecordsFromCar calls onRecord with the index record for each block
+// in the CAR, in order of offset
+func streamRecordsFromCar(reader io.Reader, onRecord func(model.Record) error) error {
+	// Iterate over all the blocks in the piece to extract the index records
 	opts := []carv2.Option{carv2.ZeroLengthSectionAsEOF(true)}
 	blockReader, err := carv2.NewBlockReader(reader, opts...)
 	if err != nil {
-		return nil, fmt.Errorf("getting block reader over piece: %w", err)
+		return fmt.Errorf("getting block reader over piece: %w", err)
 	}
 
 	blockMetadata, err := blockReader.SkipNext()
 	for err == nil {
-		recs = append(recs, model.Record{
+		err = onRecord(model.Record{
 			Cid: blockMetadata.Cid,
 			OffsetSize: model.OffsetSize{
 				Offset: blockMetadata.SourceOffset,
 				Size:   blockMetadata.Size,
 			},
 		})
+		if err != nil {
+			return err
+		}
 
 		blockMetadata, err = blockReader.SkipNext()
 	}
 	if !errors.Is(err, io.EOF) {
-		return nil, fmt.Errorf("generating index for piece: %w", err)
+		return fmt.Errorf("generating index for piece: %w", err)
 	}
-	return recs, nil
+	return nil
 }
 
 type countingReader struct {
@@ -412,6 +653,25 @@ func (cr *countingReader) Read(p []byte) (n int, err error) {
 }
 
 func parsePieceWithDataSegmentIndex(pieceCid cid.Cid, unpaddedSize int64, r types.SectionReader) ([]model.Record, error) {
+	segments, err := parseDataSegments(pieceCid, unpaddedSize, r)
+	if err != nil {
+		return nil, err
+	}
+
+	recs := make([]model.Record, 0)
+	err = streamRecordsFromDataSegments(r, segments, func(rec model.Record) error {
+		recs = append(recs, rec)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return recs, nil
+}
+
+// parseDataSegments reads the data segment index from the piece and returns
+// the valid segments, in order of offset
+func parseDataSegments(pieceCid cid.Cid, unpaddedSize int64, r types.SectionReader) ([]datasegment.SegmentDesc, error) {
 	concurrency := runtime.NumCPU()
 	if concurrency < PodsiMinConcurrency {
 		concurrency = PodsiMinConcurrency
@@ -509,35 +769,143 @@ func parsePieceWithDataSegmentIndex(pieceCid cid.Cid, unpaddedSize int64, r type
 	}
 
 	log.Debugw("podsi: validated data segment index", "validSegments", len(validSegments), "time", time.Since(start).String())
-	start = time.Now()
-	readsCnt = 0
 
-	recs := make([]model.Record, 0)
-	for _, s := range validSegments {
+	// Records are streamed in order of offset, so that the offset can be
+	// used as a checkpoint while adding the index
+	sort.Slice(validSegments, func(i, j int) bool {
+		return validSegments[i].Offset < validSegments[j].Offset
+	})
+
+	return validSegments, nil
+}
+
+// streamRecordsFromDataSegments calls onRecord with the index record for each
+// block in each of the data segments
+func streamRecordsFromDataSegments(r types.SectionReader, segments []datasegment.SegmentDesc, onRecord func(model.Record) error) error {
+	start := time.Now()
+	var readsCnt int32
+	var recsCnt int
+
+	for i, s := range segments {
 		segOffset := s.UnpaddedOffest()
 		segSize := s.UnpaddedLength()
 
 		lr := io.NewSectionReader(r, int64(segOffset), int64(segSize))
 
-		cr = &countingReader{
+		cr := &countingReader{
 			Reader: lr,
 			cnt:    &readsCnt,
 		}
 
-		subRecs, err := parseRecordsFromCar(bufio.NewReaderSize(cr, PodsiBuffesrSize))
+		err := streamRecordsFromCar(bufio.NewReaderSize(cr, PodsiBuffesrSize), func(rec model.Record) error {
+			recsCnt++
+			rec.Offset += segOffset
+			return onRecord(rec)
+		})
 		if err != nil {
 			log.Debugw("Failed to parse data segment", "error", err)
-			return nil, fmt.Errorf("could not parse data segment #%d at offset %d: %w", len(recs), segOffset, err)
+			return fmt.Errorf("could not parse data segment #%d at offset %d: %w", i, segOffset, err)
 		}
-		for i := range subRecs {
-			subRecs[i].Offset += segOffset
+	}
+
+	log.Debugw("podsi: parsed records from data segments", "recs", recsCnt, "reads", readsCnt, "time", time.Since(start).String())
+
+	return nil
+}
+
+// FindDataSegmentByRoot reads the data segment index of an aggregate piece
+// to find the data segment with a CAR file that has the given root. It
+// returns the offset and length of the CAR file within the unpadded piece.
+func FindDataSegmentByRoot(pieceCid cid.Cid, unpaddedSize int64, r types.SectionReader, root cid.Cid) (uint64, uint64, error) {
+	segments, err := parseDataSegments(pieceCid, unpaddedSize, r)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for i, s := range segments {
+		segOffset := s.UnpaddedOffest()
+		sr := io.NewSectionReader(r, int64(segOffset), int64(s.UnpaddedLength()))
+		// Only read the CAR header of each segment, and only find the
+		// length of the CAR data for the segment with the root
+		roots, err := carRoots(sr)
+		if err != nil {
+			log.Debugw("podsi: skipping data segment", "piece", pieceCid, "segment", i, "offset", segOffset, "error", err)
+			continue
+		}
+		if !slices.ContainsFunc(roots, root.Equals) {
+			continue
+		}
+
+		offset, length, _, err := carPayload(io.NewSectionReader(r, int64(segOffset), int64(s.UnpaddedLength())))
+		if err != nil {
+			return 0, 0, fmt.Errorf("reading CAR file in data segment #%d with root %s: %w", i, root, err)
 		}
-		recs = append(recs, subRecs...)
+		return segOffset + offset, length, nil
 	}
 
-	log.Debugw("podsi: parsed records from data segments", "recs", len(recs), "reads", readsCnt, "time", time.Since(start).String())
+	return 0, 0, fmt.Errorf("data segment with root %s not found in piece %s", root, pieceCid)
+}
 
-	return recs, nil
+// carRoots returns the roots of the CAR file at the start of the reader.
+// The block reader only reads the CAR header (and for a CARv2, the header
+// of the inner CARv1) until blocks are read.
+func carRoots(sr *io.SectionReader) ([]cid.Cid, error) {
+	blockReader, err := carv2.NewBlockReader(sr)
+	if err != nil {
+		return nil, fmt.Errorf("reading CAR header: %w", err)
+	}
+	return blockReader.Roots, nil
+}
+
+// carPayload returns the offset and length of the CARv1 data in the CAR file
+// at the start of the reader, and the roots of the CAR file.
+// The data segment may be padded with zeros after the CAR file, so for a
+// CARv1 the length is found by skipping over each block.
+func carPayload(sr *io.SectionReader) (uint64, uint64, []cid.Cid, error) {
+	version, err := carv2.ReadVersion(sr)
+	if err != nil {
+		return 0, 0, nil, fmt.Errorf("reading CAR version: %w", err)
+	}
+	if _, err := sr.Seek(0, io.SeekStart); err != nil {
+		return 0, 0, nil, err
+	}
+
+	switch version {
+	case 1:
+		blockReader, err := carv2.NewBlockReader(sr, carv2.ZeroLengthSectionAsEOF(true))
+		if err != nil {
+			return 0, 0, nil, fmt.Errorf("getting block reader: %w", err)
+		}
+		// The source offset is the offset of the start of the section, and
+		// the size is the size of the block data, so the end of the section
+		// is after the section length varint, the cid and the block data
+		var end uint64
+		blockMetadata, err := blockReader.SkipNext()
+		for err == nil {
+			sectionLen := uint64(len(blockMetadata.Cid.Bytes())) + blockMetadata.Size
+			end = blockMetadata.SourceOffset + uint64(varint.UvarintSize(sectionLen)) + sectionLen
+			blockMetadata, err = blockReader.SkipNext()
+		}
+		if !errors.Is(err, io.EOF) {
+			return 0, 0, nil, fmt.Errorf("reading CAR blocks: %w", err)
+		}
+		if end == 0 {
+			return 0, 0, nil, errors.New("CAR file has no blocks")
+		}
+		return 0, end, blockReader.Roots, nil
+	case 2:
+		cr, err := carv2.NewReader(sr)
+		if err != nil {
+			return 0, 0, nil, fmt.Errorf("reading CARv2 header: %w", err)
+		}
+		roots, err := cr.Roots()
+		if err != nil {
+			return 0, 0, nil, fmt.Errorf("reading CARv2 roots: %w", err)
+		}
+		return cr.Header.DataOffset, cr.Header.DataSize, roots, nil
+	default:
+		return 0, 0, nil, fmt.Errorf("unsupported CAR version %d", version)
+	}
 }
 
 // parseDataSegmentIndex is a temporary wrapper around datasegment.ParseDataSegmentIndex that exists only as a workaround
@@ -50,6 +50,10 @@ func testPieceDirectory(ctx context.Context, t *testing.T, bdsvc *svc.Service) {
 		testDataSegmentIndex(ctx, t, cl)
 	})
 
+	t.Run("resume interrupted index", func(t *testing.T) {
+		testResumeIndex(ctx, t, cl)
+	})
+
 	t.Run("flagging pieces", func(t *testing.T) {
 		testFlaggingPieces(ctx, t, cl)
 	})
@@ -295,6 +299,89 @@ func testDataSegmentIndex(ctx context.Context, t *testing.T, cl *client.Store) {
 	require.Equal(t, 188193, bss)
 }
 
+// Verify that an index build that was interrupted is resumed from the
+// checkpoint when a deal is added for the piece
+func testResumeIndex(ctx context.Context, t *testing.T, cl *client.Store) {
+	type testPiece struct {
+		pieceCid cid.Cid
+		recs     []model.Record
+		pr       pdTypes.PieceReader
+	}
+
+	// Simulate index builds of two pieces that were both interrupted half
+	// way through, so that each piece's index is created by
+	// SetIndexCheckpoint before any records are added
+	var pieces []testPiece
+	for i := 0; i < 2; i++ {
+		// Create a random CAR file
+		_, carFilePath := CreateCarFile(t)
+		carReader, err := car.OpenReader(carFilePath)
+		require.NoError(t, err)
+		defer carReader.Close()
+		carv1Reader, err := carReader.DataReader()
+		require.NoError(t, err)
+
+		// Any calls to get a reader over data should return a reader over the random CAR file
+		pr := CreateMockPieceReader(t, carv1Reader)
+
+		recs := GetRecords(t, carv1Reader)
+		require.Greater(t, len(recs), 4)
+		pieceCid := CalculateCommp(t, carv1Reader).PieceCID
+
+		half := len(recs) / 2
+		err = cl.SetIndexCheckpoint(ctx, pieceCid, recs[half].Offset)
+		require.NoError(t, err)
+		err = cl.AddIndex(ctx, pieceCid, recs[:half], true)
+		require.NoError(t, err)
+
+		// A partially added index should not be reported as indexed
+		indexed, err := cl.IsIndexed(ctx, pieceCid)
+		require.NoError(t, err)
+		require.False(t, indexed)
+
+		pieces = append(pieces, testPiece{pieceCid: pieceCid, recs: recs, pr: pr})
+	}
+
+	for i, p := range pieces {
+		// Adding a deal for the piece should resume the index build, adding
+		// the remaining records in several batches
+		pm := NewPieceDirectory(cl, p.pr, 1, WithAddIndexBatchSize(len(p.recs)/4))
+		pm.Start(ctx)
+		di := model.DealInfo{
+			DealUuid:    uuid.New().String(),
+			ChainDealID: abi.DealID(i + 1),
+			SectorID:    2,
+			PieceOffset: 0,
+			PieceLength: 0,
+		}
+		err := pm.AddDealForPiece(ctx, p.pieceCid, di)
+		require.NoError(t, err)
+
+		// The checkpoint should be cleared once the index is complete
+		md, err := cl.GetPieceMetadata(ctx, p.pieceCid)
+		require.NoError(t, err)
+		require.Zero(t, md.IndexCheckpoint)
+		require.True(t, md.CompleteIndex)
+		indexed, err := cl.IsIndexed(ctx, p.pieceCid)
+		require.NoError(t, err)
+		require.True(t, indexed)
+
+		// All the records should be in the index
+		for _, rec := range p.recs {
+			offsetSize, err := pm.GetOffsetSize(ctx, p.pieceCid, rec.Cid.Hash())
+			require.NoError(t, err)
+			require.Equal(t, rec.OffsetSize, *offsetSize)
+		}
+	}
+
+	// The index of each piece should only contain its own records
+	for _, p := range pieces {
+		recs, err := cl.GetRecords(ctx, p.pieceCid)
+		require.NoError(t, err)
+		require.Len(t, recs, len(p.recs))
+	}
+}
+
 func testFlaggingPieces(ctx context.Context, t *testing.T, cl *client.Store) {
 	// Create a random CAR file
 	_, carFilePath := CreateCarFile(t)
@@ -335,7 +422,7 @@ func testFlaggingPieces(ctx context.Context, t *testing.T, cl *client.Store) {
 
 	// Flag a piece
 	maddr := address.TestAddress
-	err = cl.FlagPiece(ctx, commpCalc.PieceCID, false, maddr)
+	err = cl.FlagPiece(ctx, commpCalc.PieceCID, false, maddr, model.FlagReasonNoUnsealedCopy, "there is no unsealed copy of the piece")
 	require.NoError(t, err)
 
 	// Count and list of pieces should contain one piece
@@ -346,18 +433,26 @@ func testFlaggingPieces(ctx context.Context, t *testing.T, cl *client.Store) {
 	pcids, err = cl.FlaggedPiecesList(ctx, nil, nil, 0, 10)
 	require.NoError(t, err)
 	require.Equal(t, 1, len(pcids))
+	require.Equal(t, model.FlagReasonNoUnsealedCopy, pcids[0].Reason)
 
 	// Test that setting the filter returns the correct results
-	filterMatchUnsealed := &types.FlaggedPiecesListFilter{HasUnsealedCopy: false}
-	filterDifferentUnsealed := &types.FlaggedPiecesListFilter{HasUnsealedCopy: true}
-	filterMatchUnsealedMatchingMiner := &types.FlaggedPiecesListFilter{HasUnsealedCopy: false, MinerAddr: maddr}
-	filterMatchUnsealedDifferentMiner := &types.FlaggedPiecesListFilter{HasUnsealedCopy: false, MinerAddr: address.TestAddress2}
-	filterDifferentUnsealedMatchingMiner := &types.FlaggedPiecesListFilter{HasUnsealedCopy: true, MinerAddr: maddr}
+	noUnsealedCopy := false
+	hasUnsealedCopy := true
+	filterMatchUnsealed := &types.FlaggedPiecesListFilter{HasUnsealedCopy: &noUnsealedCopy}
+	filterDifferentUnsealed := &types.FlaggedPiecesListFilter{HasUnsealedCopy: &hasUnsealedCopy}
+	filterMatchUnsealedMatchingMiner := &types.FlaggedPiecesListFilter{HasUnsealedCopy: &noUnsealedCopy, MinerAddr: maddr}
+	filterMatchUnsealedDifferentMiner := &types.FlaggedPiecesListFilter{HasUnsealedCopy: &noUnsealedCopy, MinerAddr: address.TestAddress2}
+	filterDifferentUnsealedMatchingMiner := &types.FlaggedPiecesListFilter{HasUnsealedCopy: &hasUnsealedCopy, MinerAddr: maddr}
+	filterMatchingMiner := &types.FlaggedPiecesListFilter{MinerAddr: maddr}
 
 	count, err = cl.FlaggedPiecesCount(ctx, filterMatchUnsealed)
 	require.NoError(t, err)
 	require.Equal(t, 1, count)
 
+	count, err = cl.FlaggedPiecesCount(ctx, filterMatchingMiner)
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+
 	count, err = cl.FlaggedPiecesCount(ctx, filterDifferentUnsealed)
 	require.NoError(t, err)
 	require.Equal(t, 0, count)
@@ -0,0 +1,207 @@
+package piecedirectory
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/filecoin-project/boost/extern/boostd-data/model"
+	"github.com/filecoin-project/boost/extern/boostd-data/shared/tracing"
+	"github.com/filecoin-project/dagstore/mount"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/hashicorp/go-multierror"
+	"github.com/ipfs/go-cid"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// RemediationAction is an action that can be taken to fix a flagged piece
+type RemediationAction string
+
+const (
+	// RemediationRebuildIndex builds a new index from an unsealed copy of the
+	// piece and replaces the existing index with it
+	RemediationRebuildIndex RemediationAction = "rebuild-index"
+	// RemediationUnseal requests the sealer to unseal a sector containing the
+	// piece
+	RemediationUnseal RemediationAction = "unseal"
+	// RemediationUntrack removes the piece from the set of pieces that are
+	// checked by the piece doctor
+	RemediationUntrack RemediationAction = "untrack"
+)
+
+// RemediationActions returns the actions that can be taken to fix a piece
+// flagged with the given reason
+func RemediationActions(reason model.FlagReason) []RemediationAction {
+	switch reason {
+	case model.FlagReasonMissingIndex, model.FlagReasonBlockMismatch:
+		return []RemediationAction{RemediationRebuildIndex, RemediationUntrack}
+	case model.FlagReasonNoUnsealedCopy:
+		return []RemediationAction{RemediationUnseal, RemediationUntrack}
+	case model.FlagReasonExpiredDeal:
+		return []RemediationAction{RemediationUntrack}
+	default:
+		return []RemediationAction{RemediationRebuildIndex, RemediationUnseal, RemediationUntrack}
+	}
+}
+
+// Unsealer unseals sectors on a miner
+type Unsealer interface {
+	IsUnsealed(ctx context.Context, minerAddr address.Address, sectorID abi.SectorNumber, offset abi.UnpaddedPieceSize, length abi.UnpaddedPieceSize) (bool, error)
+	UnsealSectorAt(ctx context.Context, minerAddr address.Address, sectorID abi.SectorNumber, pieceOffset abi.UnpaddedPieceSize, length abi.UnpaddedPieceSize) (mount.Reader, error)
+}
+
+func WithUnsealer(u Unsealer) Option {
+	return func(s *settings) {
+		s.unsealer = u
+	}
+}
+
+// RemediateFlaggedPiece takes the remediation action for a piece flagged on
+// the given miner.
+// Unsealing a sector can take several hours, so the unseal action returns as
+// soon as the unseal request has been made. The piece is unflagged by the
+// piece doctor once there is an unsealed copy.
+func (ps *PieceDirectory) RemediateFlaggedPiece(ctx context.Context, pieceCid cid.Cid, maddr address.Address, action RemediationAction) error {
+	ctx, span := tracing.Tracer.Start(ctx, "pm.remediate_flagged_piece")
+	defer span.End()
+	span.SetAttributes(attribute.String("piececid", pieceCid.String()))
+	span.SetAttributes(attribute.String("action", string(action)))
+
+	log.Infow("remediate flagged piece", "pieceCid", pieceCid, "miner", maddr, "action", action)
+
+	switch action {
+	case RemediationRebuildIndex:
+		return ps.rebuildIndex(ctx, pieceCid, maddr)
+	case RemediationUnseal:
+		return ps.unsealPiece(ctx, pieceCid, maddr)
+	case RemediationUntrack:
+		if err := ps.store.UnflagPiece(ctx, pieceCid, maddr); err != nil {
+			return fmt.Errorf("unflagging piece %s: %w", pieceCid, err)
+		}
+		if err := ps.store.UntrackPiece(ctx, pieceCid, maddr); err != nil {
+			return fmt.Errorf("untracking piece %s: %w", pieceCid, err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unrecognized remediation action '%s'", action)
+	}
+}
+
+func (ps *PieceDirectory) rebuildIndex(ctx context.Context, pieceCid cid.Cid, maddr address.Address) error {
+	// Build the new index before touching the existing one, so that if the
+	// piece data can't be read the piece keeps its current index
+	recs, err := ps.buildIndexRecords(ctx, pieceCid)
+	if err != nil {
+		return fmt.Errorf("building index for piece %s: %w", pieceCid, err)
+	}
+
+	// Remove the existing index, in case it has records that don't match the
+	// piece data
+	err = ps.store.RemoveIndexes(ctx, pieceCid)
+	if err != nil {
+		return fmt.Errorf("removing existing index for piece %s: %w", pieceCid, err)
+	}
+
+	err = ps.store.AddIndex(ctx, pieceCid, recs, true)
+	if err != nil {
+		return fmt.Errorf("adding rebuilt index for piece %s: %w", pieceCid, err)
+	}
+
+	if err := ps.store.UnflagPiece(ctx, pieceCid, maddr); err != nil {
+		return fmt.Errorf("unflagging piece %s: %w", pieceCid, err)
+	}
+	return nil
+}
+
+// buildIndexRecords reads the index records for the piece from the first
+// deal with readable piece data
+func (ps *PieceDirectory) buildIndexRecords(ctx context.Context, pieceCid cid.Cid) ([]model.Record, error) {
+	dls, err := ps.GetPieceDeals(ctx, pieceCid)
+	if err != nil {
+		return nil, fmt.Errorf("getting piece deals: %w", err)
+	}
+	if len(dls) == 0 {
+		return nil, fmt.Errorf("getting piece deals: no deals found for piece")
+	}
+
+	var merr error
+	for _, dl := range dls {
+		recs, err := ps.parsePieceRecords(ctx, pieceCid, dl)
+		if err == nil {
+			return recs, nil
+		}
+		merr = multierror.Append(merr, fmt.Errorf("reading piece for deal %d: %w", dl.ChainDealID, err))
+	}
+	return nil, merr
+}
+
+func (ps *PieceDirectory) parsePieceRecords(ctx context.Context, pieceCid cid.Cid, dl model.DealInfo) ([]model.Record, error) {
+	reader, err := ps.pieceReader.GetReader(ctx, dl.MinerAddr, dl.SectorID, dl.PieceOffset, dl.PieceLength)
+	if err != nil {
+		return nil, fmt.Errorf("getting reader over piece %s: %w", pieceCid, err)
+	}
+	defer reader.Close() //nolint:errcheck
+
+	recs, err := parsePieceWithDataSegmentIndex(pieceCid, int64(dl.PieceLength.Unpadded()), reader)
+	if err == nil {
+		return recs, nil
+	}
+
+	log.Infow("rebuild index: data segment check failed. falling back to car", "pieceCid", pieceCid, "err", err)
+	if _, err := reader.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("seek to start for piece %s: %w", pieceCid, err)
+	}
+	recs, err = parseRecordsFromCar(reader)
+	if err != nil {
+		return nil, fmt.Errorf("parse car for piece %s: %w", pieceCid, err)
+	}
+	return recs, nil
+}
+
+func (ps *PieceDirectory) unsealPiece(ctx context.Context, pieceCid cid.Cid, maddr address.Address) error {
+	if ps.settings.unsealer == nil {
+		return fmt.Errorf("unsealing is not supported")
+	}
+
+	dls, err := ps.GetPieceDeals(ctx, pieceCid)
+	if err != nil {
+		return fmt.Errorf("getting piece deals: %w", err)
+	}
+
+	// Find a sector on the miner that contains the piece
+	for _, dl := range dls {
+		if dl.MinerAddr != maddr {
+			continue
+		}
+
+		offset := dl.PieceOffset.Unpadded()
+		length := dl.PieceLength.Unpadded()
+		isUnsealed, err := ps.settings.unsealer.IsUnsealed(ctx, maddr, dl.SectorID, offset, length)
+		if err != nil {
+			log.Warnw("getting unsealed state of sector", "pieceCid", pieceCid, "sector", dl.SectorID, "err", err)
+			continue
+		}
+		if isUnsealed {
+			log.Infow("piece already has an unsealed copy", "pieceCid", pieceCid, "sector", dl.SectorID)
+			return nil
+		}
+
+		// Unseal the sector in the background, as it can take several hours
+		go func(sectorID abi.SectorNumber) {
+			start := time.Now()
+			log.Infow("unsealing sector", "pieceCid", pieceCid, "miner", maddr, "sector", sectorID)
+			r, err := ps.settings.unsealer.UnsealSectorAt(ps.ctx, maddr, sectorID, offset, length)
+			if err != nil {
+				log.Errorw("unsealing sector", "pieceCid", pieceCid, "miner", maddr, "sector", sectorID, "err", err)
+				return
+			}
+			_ = r.Close()
+			log.Infow("unsealed sector", "pieceCid", pieceCid, "miner", maddr, "sector", sectorID, "took", time.Since(start).String())
+		}(dl.SectorID)
+		return nil
+	}
+
+	return fmt.Errorf("no sector found on miner %s for piece %s", maddr, pieceCid)
+}
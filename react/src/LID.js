@@ -1,7 +1,7 @@
 import {useMutation, useQuery} from "@apollo/react-hooks";
 import {
     LIDQuery,
-    FlaggedPiecesQuery, PieceBuildIndexMutation,
+    FlaggedPiecesQuery, PieceBuildIndexMutation, PieceRemediateMutation,
     PieceStatusQuery, PiecesWithPayloadCidQuery, FlaggedPiecesCountQuery, PiecePayloadCidsQuery,
 } from "./gql";
 import moment from "moment";
@@ -270,6 +270,8 @@ function FlaggedPieces({setSearchQuery}) {
                     <th>Piece CID</th>
                     <th>Index</th>
                     <th>Deals</th>
+                    <th>Reason</th>
+                    <th>Actions</th>
                 </tr>
 
                 {rows.map(piece => (
@@ -311,7 +313,22 @@ function NoUnsealedSectorLink() {
     </div>
 }
 
+const flagReasonLabels = {
+    'missing-index': 'Missing index',
+    'no-unsealed-copy': 'No unsealed copy',
+    'block-mismatch': 'Index does not match data',
+    'expired-deal': 'Deal expired',
+}
+
+const remediationActionLabels = {
+    'rebuild-index': 'Re-index',
+    'unseal': 'Unseal',
+    'untrack': 'Untrack',
+}
+
 function FlaggedPieceRow({piece}) {
+    const [remediate, remediateResp] = useMutation(PieceRemediateMutation)
+
     return <tr>
         <td>
             <Link to={"/piece-doctor/piece/"+piece.PieceCid}>
@@ -320,6 +337,24 @@ function FlaggedPieceRow({piece}) {
         </td>
         <td>{piece.IndexStatus.Status}</td>
         <td>{piece.DealCount}</td>
+        <td title={piece.Details}>{flagReasonLabels[piece.Reason] || 'Unknown'}</td>
+        <td>
+            {piece.RemediationActions.map(action => (
+                <div
+                    key={action}
+                    className="button remediate"
+                    title={remediationActionLabels[action] || action}
+                    onClick={() => remediate({variables: {
+                        pieceCid: piece.PieceCid,
+                        minerAddr: piece.MinerAddr,
+                        action: action,
+                    }})}
+                >
+                    {remediationActionLabels[action] || action}
+                </div>
+            ))}
+            {remediateResp.error ? <div>{remediateResp.error + ''}</div> : null}
+        </td>
     </tr>
 }
 
@@ -403,6 +438,8 @@ function NoUnsealedSectorPieces() {
                     <th>Piece CID</th>
                     <th>Index</th>
                     <th>Deals</th>
+                    <th>Reason</th>
+                    <th>Actions</th>
                 </tr>
 
                 {rows.map(piece => (
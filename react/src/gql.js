@@ -311,6 +311,36 @@ const RetrievalLogQuery = gql`
     }
 `;
 
+const HttpRetrievalStatsQuery = gql`
+    query AppHttpRetrievalStatsQuery($sinceHours: Int, $top: Int) {
+        httpRetrievalStats(sinceHours: $sinceHours, top: $top) {
+            Enabled
+            From
+            To
+            Requests
+            Bytes
+            ClientErrors
+            ServerErrors
+            ErrorRate
+            CacheHits
+            LatencyP50Ms
+            LatencyP99Ms
+            TopPieces {
+                Name
+                Requests
+                Bytes
+                Errors
+            }
+            TopClients {
+                Name
+                Requests
+                Bytes
+                Errors
+            }
+        }
+    }
+`;
+
 const RetrievalLogsListQuery = gql`
     query AppRetrievalLogsListQuery($isIndexer: Boolean, $cursor: Uint64, $offset: Int, $limit: Int) {
         retrievalLogs(isIndexer: $isIndexer, cursor: $cursor, offset: $offset, limit: $limit) {
@@ -536,16 +566,20 @@ const PiecesWithPayloadCidQuery = gql`
 `;
 
 const FlaggedPiecesQuery = gql`
-    query AppFlaggedPiecesQuery($hasUnsealedCopy: Boolean, $cursor: BigInt, $offset: Int, $limit: Int) {
-        piecesFlagged(hasUnsealedCopy: $hasUnsealedCopy, cursor: $cursor, offset: $offset, limit: $limit) {
+    query AppFlaggedPiecesQuery($hasUnsealedCopy: Boolean, $reason: String, $cursor: BigInt, $offset: Int, $limit: Int) {
+        piecesFlagged(hasUnsealedCopy: $hasUnsealedCopy, reason: $reason, cursor: $cursor, offset: $offset, limit: $limit) {
             pieces {
                 CreatedAt
+                MinerAddr
                 PieceCid
                 IndexStatus {
                     Status
                     Error
                 }
                 DealCount
+                Reason
+                Details
+                RemediationActions
             }
             totalCount
             more
@@ -554,8 +588,8 @@ const FlaggedPiecesQuery = gql`
 `;
 
 const FlaggedPiecesCountQuery = gql`
-    query AppFlaggedPiecesCountQuery($hasUnsealedCopy: Boolean) {
-        piecesFlaggedCount(hasUnsealedCopy: $hasUnsealedCopy)
+    query AppFlaggedPiecesCountQuery($hasUnsealedCopy: Boolean, $reason: String) {
+        piecesFlaggedCount(hasUnsealedCopy: $hasUnsealedCopy, reason: $reason)
     }
 `;
 
@@ -565,6 +599,12 @@ const PieceBuildIndexMutation = gql`
     }
 `;
 
+const PieceRemediateMutation = gql`
+    mutation AppPieceRemediateMutation($pieceCid: String!, $minerAddr: String!, $action: String!) {
+        pieceRemediate(pieceCid: $pieceCid, minerAddr: $minerAddr, action: $action)
+    }
+`;
+
 const PieceStatusQuery = gql`
     query AppPieceStatusQuery($pieceCid: String!) {
         pieceStatus(pieceCid: $pieceCid) {
@@ -902,6 +942,7 @@ export {
     ProposalLogsCountQuery,
     RetrievalLogQuery,
     RetrievalLogsListQuery,
+    HttpRetrievalStatsQuery,
     RetrievalLogsCountQuery,
     IpniProviderInfoQuery,
     IpniAdQuery,
@@ -911,6 +952,7 @@ export {
     IpniDistanceFromLatestAdQuery,
     PiecesWithPayloadCidQuery,
     PieceBuildIndexMutation,
+    PieceRemediateMutation,
     PieceStatusQuery,
     FlaggedPiecesQuery,
     FlaggedPiecesCountQuery,
@@ -144,6 +144,36 @@ func (d *RetrievalLogDB) ListLastUpdatedAndOpen(ctx context.Context, lastUpdated
 	return d.list(ctx, 0, 0, where, lastUpdated)
 }
 
+// PiecesRetrievedSince returns the distinct piece cids of retrievals that
+// were updated at or after the given time
+func (d *RetrievalLogDB) PiecesRetrievedSince(ctx context.Context, since time.Time) ([]cid.Cid, error) {
+	qry := "SELECT DISTINCT PieceCID FROM RetrievalDealStates " +
+		"WHERE UpdatedAt >= ? AND PieceCID != '' AND Status != 'DealStatusRejected'"
+	rows, err := d.db.QueryContext(ctx, qry, since)
+	if err != nil {
+		return nil, fmt.Errorf("getting pieces retrieved since %s: %w", since, err)
+	}
+	defer rows.Close()
+
+	var pieceCids []cid.Cid
+	for rows.Next() {
+		var pieceCid string
+		if err := rows.Scan(&pieceCid); err != nil {
+			return nil, err
+		}
+		c, err := cid.Parse(pieceCid)
+		if err != nil {
+			return nil, fmt.Errorf("parsing piece cid '%s': %w", pieceCid, err)
+		}
+		pieceCids = append(pieceCids, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return pieceCids, nil
+}
+
 func (d *RetrievalLogDB) list(ctx context.Context, offset int, limit int, where string, whereArgs ...interface{}) ([]RetrievalDealState, error) {
 	qry := "SELECT " +
 		"RowID, " +
@@ -0,0 +1,66 @@
+package commppool
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/filecoin-project/go-jsonrpc"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/google/uuid"
+)
+
+// The namespace of the RPC API exposed by commp workers
+const WorkerNamespace = "CommpWorker"
+
+// JobDataPath is the path on the boost API server at which commp workers can
+// fetch the data for a job, eg /remote/commp/<job id>
+const JobDataPath = "/remote/commp/"
+
+// Job describes the data that a worker should compute commp over
+type Job struct {
+	ID uuid.UUID
+	// The path to the staged file on the boost node. If the worker has access
+	// to the same path (eg over a shared filesystem) it reads the file directly,
+	// otherwise it streams the file from boost over HTTP.
+	FilePath string
+	// The size of the staged file
+	Size int64
+}
+
+// WorkerAPI is the RPC API exposed by a commp worker process
+type WorkerAPI interface {
+	// Session returns an ID that changes each time the worker process restarts
+	Session(ctx context.Context) (uuid.UUID, error)
+	// ComputeCommP computes the piece commitment over the data for the job
+	ComputeCommP(ctx context.Context, job Job) (abi.PieceInfo, error)
+}
+
+// DialFunc connects to the RPC API of the worker at the given url
+type DialFunc func(ctx context.Context, url string) (WorkerAPI, jsonrpc.ClientCloser, error)
+
+type workerClient struct {
+	client struct {
+		Session      func(ctx context.Context) (uuid.UUID, error)
+		ComputeCommP func(ctx context.Context, job Job) (abi.PieceInfo, error)
+	}
+}
+
+var _ WorkerAPI = (*workerClient)(nil)
+
+// DialWorker connects to a commp worker over JSON RPC
+func DialWorker(ctx context.Context, url string) (WorkerAPI, jsonrpc.ClientCloser, error) {
+	var c workerClient
+	closer, err := jsonrpc.NewMergeClient(ctx, url, WorkerNamespace, []interface{}{&c.client}, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("dialing commp worker %s: %w", url, err)
+	}
+	return &c, closer, nil
+}
+
+func (c *workerClient) Session(ctx context.Context) (uuid.UUID, error) {
+	return c.client.Session(ctx)
+}
+
+func (c *workerClient) ComputeCommP(ctx context.Context, job Job) (abi.PieceInfo, error) {
+	return c.client.ComputeCommP(ctx, job)
+}
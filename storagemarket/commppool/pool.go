@@ -0,0 +1,368 @@
+package commppool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-jsonrpc"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/google/uuid"
+	logging "github.com/ipfs/go-log/v2"
+)
+
+var log = logging.Logger("commppool")
+
+var ErrNotEnabled = errors.New("commp worker pool is not enabled")
+
+type Config struct {
+	// Whether to send commp jobs to the worker pool
+	Enabled bool
+	// The maximum number of workers a job is attempted on before it fails
+	MaxAttempts int
+	// How often to check that registered workers are still reachable
+	HealthCheckInterval time.Duration
+	// How long to wait for a worker to respond to a health check
+	HealthCheckTimeout time.Duration
+}
+
+// Pool schedules commp jobs on a set of commp worker processes that register
+// with boost. Each job is sent to the worker with the lowest load. If a worker
+// dies while computing commp, the job is retried on another worker.
+type Pool struct {
+	cfg  Config
+	dial DialFunc
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	lk      sync.Mutex
+	workers map[string]*worker
+	jobs    map[uuid.UUID]Job
+	// changed is closed (and replaced) when there may be new worker capacity
+	// available, eg a worker registers or a job completes
+	changed chan struct{}
+}
+
+type worker struct {
+	url     string
+	api     WorkerAPI
+	closer  jsonrpc.ClientCloser
+	session uuid.UUID
+	maxJobs int
+	active  int
+}
+
+// load is the fraction of the worker's capacity that is in use
+func (w *worker) load() float64 {
+	return float64(w.active) / float64(w.maxJobs)
+}
+
+// WorkerInfo describes a registered worker
+type WorkerInfo struct {
+	URL        string
+	MaxJobs    int
+	ActiveJobs int
+}
+
+func NewPool(cfg Config, dial DialFunc) *Pool {
+	if cfg.MaxAttempts < 1 {
+		cfg.MaxAttempts = 1
+	}
+	if cfg.HealthCheckInterval <= 0 {
+		cfg.HealthCheckInterval = 30 * time.Second
+	}
+	if cfg.HealthCheckTimeout <= 0 {
+		cfg.HealthCheckTimeout = 10 * time.Second
+	}
+	if dial == nil {
+		dial = DialWorker
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Pool{
+		cfg:     cfg,
+		dial:    dial,
+		ctx:     ctx,
+		cancel:  cancel,
+		workers: make(map[string]*worker),
+		jobs:    make(map[uuid.UUID]Job),
+		changed: make(chan struct{}),
+	}
+}
+
+func (p *Pool) Enabled() bool {
+	return p != nil && p.cfg.Enabled
+}
+
+// Start runs a background process that removes workers which are no longer
+// reachable
+func (p *Pool) Start() {
+	go p.healthCheckLoop()
+}
+
+func (p *Pool) Stop() {
+	p.cancel()
+
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	for url, w := range p.workers {
+		w.closer()
+		delete(p.workers, url)
+	}
+}
+
+// Register adds the worker at the given url to the pool. Workers call
+// Register periodically, so if the worker is already registered this is a
+// no-op (unless the worker has restarted since it last registered).
+func (p *Pool) Register(ctx context.Context, url string, maxJobs int) error {
+	if !p.Enabled() {
+		return ErrNotEnabled
+	}
+	if maxJobs < 1 {
+		maxJobs = 1
+	}
+
+	p.lk.Lock()
+	existing, ok := p.workers[url]
+	p.lk.Unlock()
+
+	if ok {
+		sess, err := existing.api.Session(ctx)
+		if err == nil && sess == existing.session {
+			p.lk.Lock()
+			existing.maxJobs = maxJobs
+			p.notifyLocked()
+			p.lk.Unlock()
+			return nil
+		}
+		p.removeWorker(existing, "worker has restarted")
+	}
+
+	// Use the pool's context for the connection, so that it outlives the
+	// register request
+	api, closer, err := p.dial(p.ctx, url)
+	if err != nil {
+		return err
+	}
+	sess, err := api.Session(ctx)
+	if err != nil {
+		closer()
+		return fmt.Errorf("getting session of commp worker %s: %w", url, err)
+	}
+
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	if _, ok := p.workers[url]; ok {
+		// Another register call for the same worker completed first
+		closer()
+		return nil
+	}
+	p.workers[url] = &worker{
+		url:     url,
+		api:     api,
+		closer:  closer,
+		session: sess,
+		maxJobs: maxJobs,
+	}
+	p.notifyLocked()
+	log.Infow("registered commp worker", "url", url, "max-jobs", maxJobs, "workers", len(p.workers))
+
+	return nil
+}
+
+// Workers lists the registered workers
+func (p *Pool) Workers() []WorkerInfo {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	infos := make([]WorkerInfo, 0, len(p.workers))
+	for _, w := range p.workers {
+		infos = append(infos, WorkerInfo{URL: w.url, MaxJobs: w.maxJobs, ActiveJobs: w.active})
+	}
+	return infos
+}
+
+// ComputeCommP computes commp over the file at filepath on one of the
+// workers in the pool. It waits until a worker has capacity for the job.
+func (p *Pool) ComputeCommP(ctx context.Context, filepath string) (*abi.PieceInfo, error) {
+	st, err := os.Stat(filepath)
+	if err != nil {
+		return nil, fmt.Errorf("getting size of %s: %w", filepath, err)
+	}
+	if st.Size() == 0 {
+		return nil, fmt.Errorf("empty file")
+	}
+
+	job := Job{ID: uuid.New(), FilePath: filepath, Size: st.Size()}
+	p.lk.Lock()
+	p.jobs[job.ID] = job
+	p.lk.Unlock()
+
+	defer func() {
+		p.lk.Lock()
+		delete(p.jobs, job.ID)
+		p.lk.Unlock()
+	}()
+
+	var lastErr error
+	for attempt := 1; attempt <= p.cfg.MaxAttempts; attempt++ {
+		w, err := p.reserve(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		log.Debugw("sending commp job to worker", "job", job.ID, "file", filepath, "worker", w.url, "attempt", attempt)
+		pi, err := w.api.ComputeCommP(ctx, job)
+		p.release(w)
+		if err == nil {
+			return &pi, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		// Check whether the job failed because of a problem with the data or
+		// because the worker went away
+		if p.isAlive(w) {
+			return nil, fmt.Errorf("computing commp on worker %s: %w", w.url, err)
+		}
+
+		log.Warnw("commp worker failed while computing commp, retrying on another worker",
+			"job", job.ID, "worker", w.url, "attempt", attempt, "err", err)
+		p.removeWorker(w, err.Error())
+		lastErr = err
+	}
+
+	return nil, fmt.Errorf("commp job failed after %d attempts: %w", p.cfg.MaxAttempts, lastErr)
+}
+
+// reserve waits until there is a worker with spare capacity, and reserves a
+// slot on the worker with the lowest load
+func (p *Pool) reserve(ctx context.Context) (*worker, error) {
+	for {
+		p.lk.Lock()
+		var best *worker
+		for _, w := range p.workers {
+			if w.active >= w.maxJobs {
+				continue
+			}
+			if best == nil || w.load() < best.load() {
+				best = w
+			}
+		}
+		if best != nil {
+			best.active++
+			p.lk.Unlock()
+			return best, nil
+		}
+		if len(p.workers) == 0 {
+			log.Warn("waiting for a commp worker to register")
+		}
+		changed := p.changed
+		p.lk.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-p.ctx.Done():
+			return nil, p.ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+func (p *Pool) release(w *worker) {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	w.active--
+	p.notifyLocked()
+}
+
+func (p *Pool) notifyLocked() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// isAlive checks that the worker is reachable and has not restarted
+func (p *Pool) isAlive(w *worker) bool {
+	ctx, cancel := context.WithTimeout(p.ctx, p.cfg.HealthCheckTimeout)
+	defer cancel()
+
+	sess, err := w.api.Session(ctx)
+	return err == nil && sess == w.session
+}
+
+func (p *Pool) removeWorker(w *worker, reason string) {
+	p.lk.Lock()
+	if p.workers[w.url] != w {
+		// The worker has already been removed
+		p.lk.Unlock()
+		return
+	}
+	delete(p.workers, w.url)
+	count := len(p.workers)
+	p.lk.Unlock()
+
+	w.closer()
+	log.Warnw("removed commp worker", "url", w.url, "reason", reason, "workers", count)
+}
+
+func (p *Pool) healthCheckLoop() {
+	ticker := time.NewTicker(p.cfg.HealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		p.lk.Lock()
+		workers := make([]*worker, 0, len(p.workers))
+		for _, w := range p.workers {
+			workers = append(workers, w)
+		}
+		p.lk.Unlock()
+
+		for _, w := range workers {
+			if !p.isAlive(w) {
+				p.removeWorker(w, "failed health check")
+			}
+		}
+	}
+}
+
+// ServeJobData serves the staged file for a job to a worker that doesn't
+// have direct access to the file. The worker may use range requests to
+// resume an interrupted download.
+func (p *Pool) ServeJobData(w http.ResponseWriter, r *http.Request) {
+	jobID, err := uuid.Parse(strings.TrimPrefix(r.URL.Path, JobDataPath))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("parsing job id: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	p.lk.Lock()
+	job, ok := p.jobs[jobID]
+	p.lk.Unlock()
+	if !ok {
+		http.Error(w, fmt.Sprintf("commp job %s not found", jobID), http.StatusNotFound)
+		return
+	}
+
+	f, err := os.Open(job.FilePath)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("opening file for commp job %s: %s", jobID, err), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	http.ServeContent(w, r, "", time.Time{}, f)
+}
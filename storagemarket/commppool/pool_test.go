@@ -0,0 +1,203 @@
+package commppool
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/boost/testutil"
+	"github.com/filecoin-project/go-jsonrpc"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+type mockWorker struct {
+	lk      sync.Mutex
+	session uuid.UUID
+	dead    bool
+	jobs    int
+	block   chan struct{}
+	started chan struct{}
+}
+
+func newMockWorker() *mockWorker {
+	return &mockWorker{session: uuid.New(), started: make(chan struct{}, 16)}
+}
+
+func (m *mockWorker) Session(context.Context) (uuid.UUID, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	if m.dead {
+		return uuid.Nil, errors.New("connection refused")
+	}
+	return m.session, nil
+}
+
+func (m *mockWorker) ComputeCommP(ctx context.Context, job Job) (abi.PieceInfo, error) {
+	m.lk.Lock()
+	m.jobs++
+	block := m.block
+	m.lk.Unlock()
+
+	m.started <- struct{}{}
+	if block != nil {
+		select {
+		case <-block:
+		case <-ctx.Done():
+			return abi.PieceInfo{}, ctx.Err()
+		}
+	}
+
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	if m.dead {
+		return abi.PieceInfo{}, errors.New("connection reset")
+	}
+	return abi.PieceInfo{Size: abi.PaddedPieceSize(job.Size)}, nil
+}
+
+func (m *mockWorker) kill() {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	m.dead = true
+}
+
+func mockDialer(workers map[string]*mockWorker) DialFunc {
+	return func(ctx context.Context, url string) (WorkerAPI, jsonrpc.ClientCloser, error) {
+		w, ok := workers[url]
+		if !ok {
+			return nil, nil, errors.New("no such worker")
+		}
+		return w, func() {}, nil
+	}
+}
+
+func createTestFile(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "data")
+	require.NoError(t, os.WriteFile(path, testutil.RandomBytes(1024), 0644))
+	return path
+}
+
+func TestPoolBalancesLoad(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	block := make(chan struct{})
+	w1 := newMockWorker()
+	w1.block = block
+	w2 := newMockWorker()
+	w2.block = block
+	workers := map[string]*mockWorker{"w1": w1, "w2": w2}
+
+	p := NewPool(Config{Enabled: true, MaxAttempts: 2}, mockDialer(workers))
+	defer p.Stop()
+	require.NoError(t, p.Register(ctx, "w1", 4))
+	require.NoError(t, p.Register(ctx, "w2", 4))
+
+	path := createTestFile(t)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := p.ComputeCommP(ctx, path)
+			require.NoError(t, err)
+		}()
+	}
+
+	// Wait for all four jobs to start: the jobs should be split evenly
+	// between the workers
+	for i := 0; i < 4; i++ {
+		select {
+		case <-w1.started:
+		case <-w2.started:
+		case <-ctx.Done():
+			require.Fail(t, "timed out waiting for jobs to start")
+		}
+	}
+	require.Equal(t, 2, w1.jobs)
+	require.Equal(t, 2, w2.jobs)
+
+	close(block)
+	wg.Wait()
+}
+
+func TestPoolRetriesJobWhenWorkerDies(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	w1 := newMockWorker()
+	w1.block = make(chan struct{})
+	w2 := newMockWorker()
+	workers := map[string]*mockWorker{"w1": w1, "w2": w2}
+
+	p := NewPool(Config{Enabled: true, MaxAttempts: 2}, mockDialer(workers))
+	defer p.Stop()
+	require.NoError(t, p.Register(ctx, "w1", 1))
+
+	path := createTestFile(t)
+	res := make(chan error, 1)
+	go func() {
+		_, err := p.ComputeCommP(ctx, path)
+		res <- err
+	}()
+
+	// Wait for the job to start on the first worker, then register another
+	// worker and kill the first one
+	<-w1.started
+	require.NoError(t, p.Register(ctx, "w2", 1))
+	w1.kill()
+	close(w1.block)
+
+	require.NoError(t, <-res)
+	require.Equal(t, 1, w2.jobs)
+	require.Len(t, p.Workers(), 1)
+	require.Equal(t, "w2", p.Workers()[0].URL)
+}
+
+func TestPoolRegisterWhenDisabled(t *testing.T) {
+	p := NewPool(Config{Enabled: false}, mockDialer(nil))
+	err := p.Register(context.Background(), "w1", 1)
+	require.ErrorIs(t, err, ErrNotEnabled)
+}
+
+func TestWorkerFetchesJobDataOverHttp(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Create a CAR file and calculate the expected commp
+	dir := t.TempDir()
+	randomFilePath, err := testutil.CreateRandomFile(dir, 1, 2*1024*1024)
+	require.NoError(t, err)
+	_, carFilePath, err := testutil.CreateDenseCARv2(dir, randomFilePath)
+	require.NoError(t, err)
+
+	localWorker := NewWorker("", nil, 1)
+	st, err := os.Stat(carFilePath)
+	require.NoError(t, err)
+	expected, err := localWorker.ComputeCommP(ctx, Job{ID: uuid.New(), FilePath: carFilePath, Size: st.Size()})
+	require.NoError(t, err)
+
+	// Register the job with the pool so that its data is served over HTTP
+	p := NewPool(Config{Enabled: true}, mockDialer(nil))
+	defer p.Stop()
+	job := Job{ID: uuid.New(), FilePath: carFilePath, Size: st.Size()}
+	p.jobs[job.ID] = job
+	srv := httptest.NewServer(http.HandlerFunc(p.ServeJobData))
+	defer srv.Close()
+
+	// Use a file path that the worker can't access so that it streams the data
+	remoteWorker := NewWorker(srv.URL+JobDataPath, nil, 1)
+	remoteJob := job
+	remoteJob.FilePath = filepath.Join(dir, "not-shared")
+	pi, err := remoteWorker.ComputeCommP(ctx, remoteJob)
+	require.NoError(t, err)
+	require.Equal(t, expected, pi)
+}
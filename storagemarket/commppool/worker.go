@@ -0,0 +1,198 @@
+package commppool
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/filecoin-project/go-commp-utils/writer"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/google/uuid"
+	carv2 "github.com/ipld/go-car/v2"
+)
+
+// The number of times a worker will try to resume fetching job data over
+// HTTP before giving up
+const maxFetchAttempts = 5
+
+// Worker computes commp for jobs sent by boost. It implements WorkerAPI.
+type Worker struct {
+	session uuid.UUID
+	// The base URL at which to fetch job data from boost, eg
+	// http://localhost:1288/remote/commp/
+	fetchURL    string
+	fetchHeader http.Header
+	throttle    chan struct{}
+}
+
+var _ WorkerAPI = (*Worker)(nil)
+
+func NewWorker(fetchURL string, fetchHeader http.Header, maxJobs int) *Worker {
+	if maxJobs < 1 {
+		maxJobs = 1
+	}
+	return &Worker{
+		session:     uuid.New(),
+		fetchURL:    fetchURL,
+		fetchHeader: fetchHeader,
+		throttle:    make(chan struct{}, maxJobs),
+	}
+}
+
+func (w *Worker) Session(context.Context) (uuid.UUID, error) {
+	return w.session, nil
+}
+
+func (w *Worker) ComputeCommP(ctx context.Context, job Job) (abi.PieceInfo, error) {
+	select {
+	case w.throttle <- struct{}{}:
+	case <-ctx.Done():
+		return abi.PieceInfo{}, ctx.Err()
+	}
+	defer func() { <-w.throttle }()
+
+	start := time.Now()
+	rd, err := w.openJobData(ctx, job)
+	if err != nil {
+		return abi.PieceInfo{}, err
+	}
+	defer rd.Close()
+
+	// (willscott - oct 2023 - remove once raw byte supported): confirm file is a car file.
+	// Keep a copy of the header bytes so they can be fed to the commp writer.
+	var hdr bytes.Buffer
+	if _, err := carv2.ReadVersion(io.TeeReader(rd, &hdr)); err != nil {
+		return abi.PieceInfo{}, fmt.Errorf("failed to read car header: %w", err)
+	}
+
+	cw := &writer.Writer{}
+	written, err := io.Copy(cw, io.MultiReader(&hdr, rd))
+	if err != nil {
+		return abi.PieceInfo{}, fmt.Errorf("writing to commp writer: %w", err)
+	}
+	if written != job.Size {
+		return abi.PieceInfo{}, fmt.Errorf("number of bytes written to CommP writer %d not equal to the file size %d", written, job.Size)
+	}
+
+	pi, err := cw.Sum()
+	if err != nil {
+		return abi.PieceInfo{}, fmt.Errorf("failed to calculate CommP: %w", err)
+	}
+
+	log.Infow("computed commp", "job", job.ID, "size", job.Size, "piece-cid", pi.PieceCID, "took", time.Since(start).String())
+	return abi.PieceInfo{Size: pi.PieceSize, PieceCID: pi.PieceCID}, nil
+}
+
+// openJobData reads the job data directly from disk if the staged file is
+// accessible on this machine, otherwise it streams the data from boost
+func (w *Worker) openJobData(ctx context.Context, job Job) (io.ReadCloser, error) {
+	st, err := os.Stat(job.FilePath)
+	if err == nil && st.Size() == job.Size {
+		log.Debugw("reading job data from shared path", "job", job.ID, "path", job.FilePath)
+		return os.Open(job.FilePath)
+	}
+
+	if w.fetchURL == "" {
+		return nil, fmt.Errorf("file %s for job %s is not accessible and no fetch url is configured", job.FilePath, job.ID)
+	}
+
+	log.Debugw("streaming job data from boost", "job", job.ID, "url", w.fetchURL+job.ID.String())
+	return &httpJobReader{
+		ctx:    ctx,
+		url:    w.fetchURL + job.ID.String(),
+		header: w.fetchHeader,
+		size:   job.Size,
+	}, nil
+}
+
+// httpJobReader streams job data from boost. If the stream is interrupted it
+// resumes with a range request from the last byte received.
+type httpJobReader struct {
+	ctx      context.Context
+	url      string
+	header   http.Header
+	size     int64
+	offset   int64
+	attempts int
+	body     io.ReadCloser
+}
+
+func (r *httpJobReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+
+	if r.body == nil {
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	if err != nil && r.offset < r.size {
+		// The stream ended before all the data was received: re-open it from
+		// the current offset on the next read
+		_ = r.body.Close()
+		r.body = nil
+		if r.attempts >= maxFetchAttempts {
+			return n, fmt.Errorf("fetching job data at offset %d after %d attempts: %w", r.offset, r.attempts, err)
+		}
+		log.Debugw("job data stream interrupted, resuming", "url", r.url, "offset", r.offset, "err", err)
+		return n, nil
+	}
+	if r.offset >= r.size {
+		return n, nil
+	}
+	return n, err
+}
+
+func (r *httpJobReader) open() error {
+	if r.attempts > 0 {
+		select {
+		case <-time.After(time.Duration(r.attempts) * time.Second):
+		case <-r.ctx.Done():
+			return r.ctx.Err()
+		}
+	}
+	r.attempts++
+
+	req, err := http.NewRequestWithContext(r.ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		return fmt.Errorf("creating request for job data: %w", err)
+	}
+	for k, v := range r.header {
+		req.Header[k] = v
+	}
+	if r.offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", r.offset))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("fetching job data from %s: %w", r.url, err)
+	}
+
+	expected := http.StatusOK
+	if r.offset > 0 {
+		expected = http.StatusPartialContent
+	}
+	if resp.StatusCode != expected {
+		_ = resp.Body.Close()
+		return fmt.Errorf("fetching job data from %s: unexpected status %d", r.url, resp.StatusCode)
+	}
+
+	r.body = resp.Body
+	return nil
+}
+
+func (r *httpJobReader) Close() error {
+	if r.body != nil {
+		return r.body.Close()
+	}
+	return nil
+}
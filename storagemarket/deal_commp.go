@@ -20,8 +20,27 @@ import (
 var ErrCommpMismatch = fmt.Errorf("commp mismatch")
 
 // Verify that the commp provided in the deal proposal matches commp calculated
-// over the downloaded file
-func (p *Provider) verifyCommP(deal *types.ProviderDealState) *dealMakingError {
+// over the downloaded file.
+// If commp was already computed while the file was downloaded (streamedPi is
+// not nil) and it matches, the file is not read again.
+func (p *Provider) verifyCommP(deal *types.ProviderDealState, streamedPi *abi.PieceInfo) *dealMakingError {
+	clientPieceCid := deal.ClientDealProposal.Proposal.PieceCID
+	if streamedPi != nil {
+		pieceCid, err := padPieceCommitment(*streamedPi, deal.ClientDealProposal.Proposal.PieceSize)
+		if err == nil && pieceCid == clientPieceCid {
+			p.dealLogger.Infow(deal.DealUuid, "commP computed during transfer matches deal proposal")
+			// The file isn't read again to compute commp, so check that it
+			// is a CAR file here
+			if err := checkCarHeader(deal.InboundFilePath); err != nil {
+				return err
+			}
+			p.recordSubPieces(deal)
+			return nil
+		}
+		p.dealLogger.Infow(deal.DealUuid, "commP computed during transfer does not match deal proposal: recalculating commP",
+			"expected", clientPieceCid, "actual", pieceCid, "err", err)
+	}
+
 	p.dealLogger.Infow(deal.DealUuid, "checking commP")
 	pieceCid, err := p.generatePieceCommitment(deal.InboundFilePath, deal.ClientDealProposal.Proposal.PieceSize)
 	if err != nil {
@@ -29,7 +48,6 @@ func (p *Provider) verifyCommP(deal *types.ProviderDealState) *dealMakingError {
 		return err
 	}
 
-	clientPieceCid := deal.ClientDealProposal.Proposal.PieceCID
 	if pieceCid != clientPieceCid {
 		if deal.IsOffline {
 			// Allow manual retry in case user accidentally supplied the wrong
@@ -45,13 +63,40 @@ func (p *Provider) verifyCommP(deal *types.ProviderDealState) *dealMakingError {
 		}
 	}
 
+	p.recordSubPieces(deal)
+	return nil
+}
+
+// checkCarHeader confirms that the file is a CAR file
+func checkCarHeader(filepath string) *dealMakingError {
+	rd, err := os.Open(filepath)
+	if err != nil {
+		return &dealMakingError{
+			retry: types.DealRetryFatal,
+			error: fmt.Errorf("failed to get reader: %w", err),
+		}
+	}
+
+	defer func() {
+		if err := rd.Close(); err != nil {
+			log.Warnf("failed to close reader for %s: %w", filepath, err)
+		}
+	}()
+
+	// Raw bytes are not supported yet, so the file must be a CAR file
+	if _, err := carv2.ReadVersion(rd); err != nil {
+		return &dealMakingError{
+			retry: types.DealRetryFatal,
+			error: fmt.Errorf("failed to read car header: %w", err),
+		}
+	}
 	return nil
 }
 
 // generatePieceCommitment generates commp either locally or remotely,
 // depending on config, and pads it as necessary to match the piece size.
 func (p *Provider) generatePieceCommitment(filepath string, pieceSize abi.PaddedPieceSize) (cid.Cid, *dealMakingError) {
-	pi, err := generatePieceCommitment(p.ctx, p.commpCalc, p.commpThrottle, filepath, pieceSize, p.config.RemoteCommp)
+	pi, err := generatePieceCommitment(p.ctx, p.commpCalc, p.commpThrottle, p.commpWorkerPool, filepath, pieceSize, p.config.RemoteCommp)
 	if err != nil {
 		return cid.Undef, err
 	}
@@ -79,12 +124,21 @@ func (t CommpThrottle) release() {
 	<-t
 }
 
-// generatePieceCommitment generates commp either locally or remotely,
-// depending on config, and pads it as necessary to match the piece size.
-func generatePieceCommitment(ctx context.Context, commpCalc smtypes.CommpCalculator, throttle CommpThrottle, filepath string, pieceSize abi.PaddedPieceSize, doRemoteCommP bool) (*abi.PieceInfo, *dealMakingError) {
-	// Check whether to send commp to a remote process or do it locally
+// generatePieceCommitment generates commp on the worker pool (if there is one),
+// remotely or locally, depending on config, and pads it as necessary to match
+// the piece size.
+func generatePieceCommitment(ctx context.Context, commpCalc smtypes.CommpCalculator, throttle CommpThrottle, workerPool smtypes.CommpWorkerPool, filepath string, pieceSize abi.PaddedPieceSize, doRemoteCommP bool) (*abi.PieceInfo, *dealMakingError) {
+	// Check whether to send commp to the worker pool, to a remote process or
+	// do it locally
 	var pi *abi.PieceInfo
-	if doRemoteCommP {
+	if workerPool != nil {
+		var err *dealMakingError
+		pi, err = workerPoolCommP(ctx, workerPool, filepath)
+		if err != nil {
+			err.error = fmt.Errorf("performing commp on worker pool: %w", err.error)
+			return nil, err
+		}
+	} else if doRemoteCommP {
 		var err *dealMakingError
 		pi, err = remoteCommP(ctx, commpCalc, filepath)
 		if err != nil {
@@ -107,22 +161,52 @@ func generatePieceCommitment(ctx context.Context, commpCalc smtypes.CommpCalcula
 		}
 	}
 
-	// if the data does not fill the whole piece
-	if pi.Size < pieceSize {
-		// pad the data so that it fills the piece
-		rawPaddedCommp, err := commp.PadCommP(
-			// we know how long a pieceCid "hash" is, just blindly extract the trailing 32 bytes
-			pi.PieceCID.Hash()[len(pi.PieceCID.Hash())-32:],
-			uint64(pi.Size),
-			uint64(pieceSize),
-		)
-		if err != nil {
+	pieceCid, err := padPieceCommitment(*pi, pieceSize)
+	if err != nil {
+		return nil, &dealMakingError{
+			retry: types.DealRetryFatal,
+			error: err,
+		}
+	}
+	pi.PieceCID = pieceCid
+
+	return pi, nil
+}
+
+// padPieceCommitment pads commp as necessary to match the piece size
+func padPieceCommitment(pi abi.PieceInfo, pieceSize abi.PaddedPieceSize) (cid.Cid, error) {
+	// if the data fills the whole piece there's nothing to do
+	if pi.Size >= pieceSize {
+		return pi.PieceCID, nil
+	}
+
+	// pad the data so that it fills the piece
+	rawPaddedCommp, err := commp.PadCommP(
+		// we know how long a pieceCid "hash" is, just blindly extract the trailing 32 bytes
+		pi.PieceCID.Hash()[len(pi.PieceCID.Hash())-32:],
+		uint64(pi.Size),
+		uint64(pieceSize),
+	)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("failed to pad commp: %w", err)
+	}
+	return commcid.DataCommitmentV1ToCID(rawPaddedCommp)
+}
+
+// workerPoolCommP sends the commp job to a pool of commp worker processes
+func workerPoolCommP(ctx context.Context, workerPool smtypes.CommpWorkerPool, filepath string) (*abi.PieceInfo, *dealMakingError) {
+	pi, err := workerPool.ComputeCommP(ctx, filepath)
+	if err != nil {
+		if ctx.Err() != nil {
 			return nil, &dealMakingError{
-				retry: types.DealRetryFatal,
-				error: fmt.Errorf("failed to pad commp: %w", err),
+				retry: types.DealRetryAuto,
+				error: fmt.Errorf("boost shutdown while computing commp on worker pool: %w", err),
 			}
 		}
-		pi.PieceCID, _ = commcid.DataCommitmentV1ToCID(rawPaddedCommp)
+		return nil, &dealMakingError{
+			retry: types.DealRetryManual,
+			error: fmt.Errorf("computing commp on worker pool: %w", err),
+		}
 	}
 
 	return pi, nil
@@ -0,0 +1,147 @@
+package storagemarket
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/go-data-segment/datasegment"
+	commcid "github.com/filecoin-project/go-fil-commcid"
+	"github.com/filecoin-project/go-state-types/abi"
+)
+
+// recordSubPieces parses the FRC-0058 data segment index of the deal data,
+// and if the deal piece is an aggregate, records the valid sub-pieces in the
+// database.
+// A deal piece that is not an aggregate, or that has an invalid data segment
+// index, is still a valid deal, so errors are logged rather than failing the
+// deal.
+func (p *Provider) recordSubPieces(deal *types.ProviderDealState) {
+	subPieces, invalid, err := parseDealSubPieces(deal)
+	if err != nil {
+		p.dealLogger.Infow(deal.DealUuid, "could not parse data segment index of deal data", "err", err)
+		return
+	}
+	if len(subPieces) == 0 {
+		// The piece is not an aggregate
+		return
+	}
+
+	if invalid > 0 {
+		p.dealLogger.Infow(deal.DealUuid, "skipped invalid entries in data segment index", "invalid", invalid, "valid", len(subPieces))
+	}
+
+	if err := p.subPiecesDB.Insert(p.ctx, deal.DealUuid, subPieces); err != nil {
+		p.dealLogger.LogError(deal.DealUuid, "failed to record deal sub-pieces", err)
+		return
+	}
+	p.dealLogger.Infow(deal.DealUuid, "recorded aggregate deal sub-pieces", "count", len(subPieces))
+}
+
+// removeSubPieces removes the sub-pieces recorded for a deal that failed,
+// so that retrievals by sub-piece CID don't look for data in a piece that
+// will not be stored
+func (p *Provider) removeSubPieces(ctx context.Context, deal *types.ProviderDealState) {
+	if deal.Err == "" {
+		return
+	}
+	if err := p.subPiecesDB.Delete(ctx, deal.DealUuid); err != nil {
+		p.dealLogger.LogError(deal.DealUuid, "failed to remove deal sub-pieces", err)
+	}
+}
+
+// parseDealSubPieces reads the data segment index at the end of the deal
+// data and returns the sub-pieces described by its valid entries, along with
+// the number of invalid entries.
+func parseDealSubPieces(deal *types.ProviderDealState) ([]types.DealSubPiece, int, error) {
+	pieceCid := deal.ClientDealProposal.Proposal.PieceCID
+	pieceSize := deal.ClientDealProposal.Proposal.PieceSize
+	if pieceSize.Validate() != nil {
+		return nil, 0, nil
+	}
+
+	f, err := os.Open(deal.InboundFilePath)
+	if err != nil {
+		return nil, 0, fmt.Errorf("opening deal data file %s: %w", deal.InboundFilePath, err)
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return nil, 0, fmt.Errorf("getting stats of deal data file %s: %w", deal.InboundFilePath, err)
+	}
+
+	// The data segment index is stored at the end of the piece. If the deal
+	// data doesn't extend that far, the piece is not an aggregate.
+	dsis := datasegment.DataSegmentIndexStartOffset(pieceSize)
+	dataSize := uint64(st.Size())
+	unpaddedSize := uint64(pieceSize.Unpadded())
+	if dataSize <= dsis || dataSize > unpaddedSize {
+		return nil, 0, nil
+	}
+
+	// The deal data is padded with zeros up to the piece size
+	rd := io.MultiReader(
+		io.NewSectionReader(f, int64(dsis), int64(dataSize-dsis)),
+		io.LimitReader(zeroReader{}, int64(unpaddedSize-dataSize)))
+	indexData, err := parseIndex(bufio.NewReader(rd))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var invalid int
+	subPieces := make([]types.DealSubPiece, 0, len(indexData.Entries))
+	for _, e := range indexData.Entries {
+		if err := e.Validate(); err != nil {
+			if errors.Is(err, datasegment.ErrValidation) {
+				invalid++
+				continue
+			}
+			return nil, 0, fmt.Errorf("validating data segment index entry: %w", err)
+		}
+
+		// The segment must not overlap with the data segment index itself
+		if e.UnpaddedOffest()+e.UnpaddedLength() > dsis {
+			invalid++
+			continue
+		}
+
+		subPieceCid, err := commcid.DataCommitmentV1ToCID(e.CommDs[:])
+		if err != nil {
+			invalid++
+			continue
+		}
+
+		subPieces = append(subPieces, types.DealSubPiece{
+			DealUuid:    deal.DealUuid,
+			PieceCID:    pieceCid,
+			SubPieceCID: subPieceCid,
+			Offset:      abi.PaddedPieceSize(e.Offset),
+			Size:        abi.PaddedPieceSize(e.Size),
+		})
+	}
+
+	return subPieces, invalid, nil
+}
+
+// parseIndex parses a data segment index, converting a panic in the parser
+// into an error
+func parseIndex(r io.Reader) (idx datasegment.IndexData, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic while parsing data segment index: %v", r)
+		}
+	}()
+	return datasegment.ParseDataSegmentIndex(r)
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
@@ -0,0 +1,92 @@
+package storagemarket
+
+import (
+	"bytes"
+	"io"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/filecoin-project/boost/lib/streamcommp"
+	"github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/boost/testutil"
+	"github.com/filecoin-project/go-data-segment/datasegment"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/builtin/v9/market"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDealSubPieces(t *testing.T) {
+	dir := t.TempDir()
+
+	// Create an aggregate of two sub-pieces
+	var subPieceData [][]byte
+	var subPieceInfos []abi.PieceInfo
+	var readers []io.Reader
+	for _, size := range []int{2000, 5000} {
+		data := testutil.RandomBytes(size)
+		c := streamcommp.New()
+		_, err := c.Write(data)
+		require.NoError(t, err)
+		pi, err := c.Sum()
+		require.NoError(t, err)
+
+		subPieceData = append(subPieceData, data)
+		subPieceInfos = append(subPieceInfos, pi)
+		readers = append(readers, bytes.NewReader(data))
+	}
+
+	_, size, err := datasegment.ComputeDealPlacement(subPieceInfos)
+	require.NoError(t, err)
+	aggregateSize := abi.PaddedPieceSize(1 << (64 - bits.LeadingZeros64(size+256)))
+	a, err := datasegment.NewAggregate(aggregateSize, subPieceInfos)
+	require.NoError(t, err)
+	out, err := a.AggregateObjectReader(readers)
+	require.NoError(t, err)
+
+	aggregateFile := filepath.Join(dir, "aggregate")
+	fo, err := os.Create(aggregateFile)
+	require.NoError(t, err)
+	_, err = io.Copy(fo, out)
+	require.NoError(t, err)
+	require.NoError(t, fo.Close())
+
+	aggregateCid, err := a.PieceCID()
+	require.NoError(t, err)
+	deal := &types.ProviderDealState{
+		DealUuid:        uuid.New(),
+		InboundFilePath: aggregateFile,
+		ClientDealProposal: market.ClientDealProposal{
+			Proposal: market.DealProposal{PieceCID: aggregateCid, PieceSize: aggregateSize},
+		},
+	}
+
+	subPieces, invalid, err := parseDealSubPieces(deal)
+	require.NoError(t, err)
+	require.Zero(t, invalid)
+	require.Len(t, subPieces, len(subPieceInfos))
+	for i, sp := range subPieces {
+		require.Equal(t, deal.DealUuid, sp.DealUuid)
+		require.Equal(t, aggregateCid, sp.PieceCID)
+		require.Equal(t, subPieceInfos[i].PieceCID, sp.SubPieceCID)
+
+		// The data at the sub-piece offset should be the sub-piece data
+		f, err := os.Open(aggregateFile)
+		require.NoError(t, err)
+		segment := make([]byte, len(subPieceData[i]))
+		_, err = f.ReadAt(segment, int64(sp.Offset.Unpadded()))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+		require.Equal(t, subPieceData[i], segment)
+	}
+
+	// A piece that is not an aggregate should have no sub-pieces
+	plainFile := filepath.Join(dir, "plain")
+	require.NoError(t, os.WriteFile(plainFile, testutil.RandomBytes(int(aggregateSize.Unpadded())), 0644))
+	deal.InboundFilePath = plainFile
+	subPieces, _, err = parseDealSubPieces(deal)
+	require.NoError(t, err)
+	require.Empty(t, subPieces)
+}
@@ -176,7 +176,7 @@ func (p *Provider) execDealUptoAddPiece(ctx context.Context, deal *types.Provide
 		p.dealLogger.Infow(deal.DealUuid, "deal data-transfer can no longer be cancelled")
 	} else if deal.Checkpoint < dealcheckpoints.Transferred {
 		// verify CommP matches for an offline deal
-		if err := p.verifyCommP(deal); err != nil {
+		if err := p.verifyCommP(deal, nil); err != nil {
 			err.error = fmt.Errorf("error when matching commP for imported data for offline deal: %w", err)
 			return err
 		}
@@ -368,8 +368,15 @@ func (p *Provider) transferAndVerify(dh *dealHandler, pub event.Emitter, deal *s
 	p.dealLogger.Infow(deal.DealUuid, "deal data-transfer completed successfully", "bytes received", deal.NBytesReceived, "time taken",
 		time.Since(st).String())
 
+	// If commp was computed while the data was transferred, use it to verify
+	// the data without reading the file again
+	var streamedPi *abi.PieceInfo
+	if ch, ok := handler.(transport.CommpHandler); ok {
+		streamedPi = ch.PieceCommitment()
+	}
+
 	// Verify CommP matches
-	if err := p.verifyCommP(deal); err != nil {
+	if err := p.verifyCommP(deal, streamedPi); err != nil {
 		err.error = fmt.Errorf("failed to verify CommP: %w", err.error)
 		return err
 	}
@@ -532,6 +539,13 @@ func (p *Provider) addPiece(ctx context.Context, pub event.Emitter, deal *types.
 
 	p.dealLogger.Infow(deal.DealUuid, "add piece called")
 
+	// Wait for the piece to be packed with other pieces into a sector
+	donePacking, derr := p.packPiece(ctx, deal)
+	if derr != nil {
+		return derr
+	}
+	defer donePacking()
+
 	proposal := deal.ClientDealProposal.Proposal
 	paddedReader, err := openReader(deal.InboundFilePath, proposal.PieceSize.Unpadded())
 	if err != nil {
@@ -840,6 +854,9 @@ func (p *Provider) cleanupDeal(deal *types.ProviderDealState) {
 		p.cleanupDealHandler(deal.DealUuid)
 	}
 
+	// remove the sub-pieces of the deal if it failed
+	p.removeSubPieces(context.Background(), deal)
+
 	done := make(chan struct{}, 1)
 	// submit req to event loop to untag tagged funds and storage space
 	select {
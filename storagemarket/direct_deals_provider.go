@@ -51,14 +51,15 @@ type DirectDealsProvider struct {
 	ctx    context.Context // context to be stopped when stopping boostd
 
 	// Address of the provider on chain.
-	Address       address.Address
-	fullnodeApi   v1api.FullNode
-	pieceAdder    types.PieceAdder
-	commpCalc     smtypes.CommpCalculator
-	commpThrottle CommpThrottle
-	sps           sealingpipeline.API
-	directDealsDB *db.DirectDealsDB
-	dealLogger    *logs.DealLogger
+	Address         address.Address
+	fullnodeApi     v1api.FullNode
+	pieceAdder      types.PieceAdder
+	commpCalc       smtypes.CommpCalculator
+	commpThrottle   CommpThrottle
+	commpWorkerPool smtypes.CommpWorkerPool
+	sps             sealingpipeline.API
+	directDealsDB   *db.DirectDealsDB
+	dealLogger      *logs.DealLogger
 
 	runningLk sync.RWMutex
 	running   map[uuid.UUID]struct{}
@@ -67,16 +68,17 @@ type DirectDealsProvider struct {
 	ip *indexprovider.Wrapper
 }
 
-func NewDirectDealsProvider(cfg DDPConfig, minerAddr address.Address, fullnodeApi v1api.FullNode, pieceAdder types.PieceAdder, commpCalc smtypes.CommpCalculator, commpt CommpThrottle, sps sealingpipeline.API, directDealsDB *db.DirectDealsDB, dealLogger *logs.DealLogger, piecedirectory *piecedirectory.PieceDirectory, ip *indexprovider.Wrapper) *DirectDealsProvider {
+func NewDirectDealsProvider(cfg DDPConfig, minerAddr address.Address, fullnodeApi v1api.FullNode, pieceAdder types.PieceAdder, commpCalc smtypes.CommpCalculator, commpt CommpThrottle, commpWorkerPool smtypes.CommpWorkerPool, sps sealingpipeline.API, directDealsDB *db.DirectDealsDB, dealLogger *logs.DealLogger, piecedirectory *piecedirectory.PieceDirectory, ip *indexprovider.Wrapper) *DirectDealsProvider {
 	return &DirectDealsProvider{
-		config:        cfg,
-		Address:       minerAddr,
-		fullnodeApi:   fullnodeApi,
-		pieceAdder:    pieceAdder,
-		commpCalc:     commpCalc,
-		commpThrottle: commpt,
-		sps:           sps,
-		directDealsDB: directDealsDB,
+		config:          cfg,
+		Address:         minerAddr,
+		fullnodeApi:     fullnodeApi,
+		pieceAdder:      pieceAdder,
+		commpCalc:       commpCalc,
+		commpThrottle:   commpt,
+		commpWorkerPool: commpWorkerPool,
+		sps:             sps,
+		directDealsDB:   directDealsDB,
 		//logsSqlDB: logsSqlDB,
 		//logsDB: logsDB,
 
@@ -344,7 +346,7 @@ func (ddp *DirectDealsProvider) execDeal(ctx context.Context, entry *smtypes.Dir
 		// TODO: should we be passing pieceSize here ??!?
 		pieceSize := abi.UnpaddedPieceSize(fstat.Size())
 
-		generatedPieceInfo, dmErr := generatePieceCommitment(ctx, ddp.commpCalc, ddp.commpThrottle, entry.InboundFilePath, pieceSize.Padded(), ddp.config.RemoteCommp)
+		generatedPieceInfo, dmErr := generatePieceCommitment(ctx, ddp.commpCalc, ddp.commpThrottle, ddp.commpWorkerPool, entry.InboundFilePath, pieceSize.Padded(), ddp.config.RemoteCommp)
 		if dmErr != nil {
 			return &dealMakingError{
 				retry: types.DealRetryManual,
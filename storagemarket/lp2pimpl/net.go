@@ -288,7 +288,7 @@ func (p *DealProvider) handleNewDealStream(s network.Stream) {
 	defer s.SetWriteDeadline(time.Time{}) // nolint
 
 	// Write the response to the client
-	err = cborutil.WriteCborRPC(s, &types.DealResponse{Accepted: res.Accepted, Message: res.Reason})
+	err = cborutil.WriteCborRPC(s, &types.DealResponse{Accepted: res.Accepted, Message: res.Reason, RetryAfter: res.RetryAfter})
 	if err != nil {
 		reqLog.Warnw("writing deal response", "err", err)
 	}
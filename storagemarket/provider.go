@@ -22,6 +22,7 @@ import (
 	"github.com/filecoin-project/boost/storagemanager"
 	"github.com/filecoin-project/boost/storagemarket/logs"
 	"github.com/filecoin-project/boost/storagemarket/sealingpipeline"
+	"github.com/filecoin-project/boost/storagemarket/sectorpacker"
 	"github.com/filecoin-project/boost/storagemarket/types"
 	smtypes "github.com/filecoin-project/boost/storagemarket/types"
 	"github.com/filecoin-project/boost/storagemarket/types/dealcheckpoints"
@@ -60,6 +61,13 @@ type SealingPipelineCache struct {
 	CacheError error
 }
 
+// WaitingDealsCache caches the number of deals waiting to be added to a sector
+type WaitingDealsCache struct {
+	Count      int
+	CacheTime  time.Time
+	CacheError error
+}
+
 // PackingResult returns information about how a deal was put into a sector
 type PackingResult struct {
 	SectorNumber abi.SectorNumber
@@ -85,6 +93,11 @@ type Config struct {
 	SealingPipelineCacheTimeout time.Duration
 	StorageFilter               string
 	Curio                       bool
+	// Thresholds at which to stop accepting deals because the sealing
+	// pipeline is backed up
+	Backpressure BackpressureConfig
+	// How to group deal pieces into sectors
+	SectorPacking SectorPackingConfig
 }
 
 var log = logging.Logger("boost-provider")
@@ -110,17 +123,20 @@ type Provider struct {
 	processedDealChan    chan processedDealReq
 
 	// Sealing Pipeline API
-	sps      sealingpipeline.API
-	spsCache SealingPipelineCache
+	sps               sealingpipeline.API
+	spsCacheLk        sync.Mutex
+	spsCache          SealingPipelineCache
+	waitingDealsCache WaitingDealsCache
 
 	// Boost deal filter
 	df dtypes.StorageDealFilter
 
 	// Database API
-	db        *sql.DB
-	dealsDB   *db.DealsDB
-	logsSqlDB *sql.DB
-	logsDB    *db.LogsDB
+	db          *sql.DB
+	dealsDB     *db.DealsDB
+	subPiecesDB *db.SubPiecesDB
+	logsSqlDB   *sql.DB
+	logsDB      *db.LogsDB
 
 	Transport      transport.Transport
 	xferLimiter    *transferLimiter
@@ -131,7 +147,9 @@ type Provider struct {
 
 	pieceAdder                  types.PieceAdder
 	commpThrottle               CommpThrottle
+	commpWorkerPool             smtypes.CommpWorkerPool
 	commpCalc                   smtypes.CommpCalculator
+	packer                      *sectorpacker.Packer
 	maxDealCollateralMultiplier uint64
 	chainDealManager            types.ChainDealManager
 
@@ -150,7 +168,7 @@ type Provider struct {
 
 func NewProvider(cfg Config, sqldb *sql.DB, dealsDB *db.DealsDB, fundMgr *fundmanager.FundManager, storageMgr *storagemanager.StorageManager,
 	fullnodeApi v1api.FullNode, dp types.DealPublisher, addr address.Address, pa types.PieceAdder, commpCalc smtypes.CommpCalculator, commpThrottle CommpThrottle,
-	sps sealingpipeline.API, cm types.ChainDealManager, df dtypes.StorageDealFilter, logsSqlDB *sql.DB, logsDB *db.LogsDB,
+	commpWorkerPool smtypes.CommpWorkerPool, sps sealingpipeline.API, cm types.ChainDealManager, df dtypes.StorageDealFilter, logsSqlDB *sql.DB, logsDB *db.LogsDB,
 	piecedirectory *piecedirectory.PieceDirectory, ip types.IndexProvider, askGetter types.AskGetter,
 	sigVerifier types.SignatureVerifier, dl *logs.DealLogger, tspt transport.Transport) (*Provider, error) {
 
@@ -159,6 +177,13 @@ func NewProvider(cfg Config, sqldb *sql.DB, dealsDB *db.DealsDB, fundMgr *fundma
 		return nil, err
 	}
 
+	switch cfg.Backpressure.Mode {
+	case "", BackpressureModePause, BackpressureModeOfflineOnly:
+	default:
+		return nil, fmt.Errorf("invalid backpressure mode '%s': must be one of '%s', '%s' or ''",
+			cfg.Backpressure.Mode, BackpressureModePause, BackpressureModeOfflineOnly)
+	}
+
 	v, err := sps.Version(context.Background())
 	if err != nil {
 		return nil, err
@@ -179,17 +204,18 @@ func NewProvider(cfg Config, sqldb *sql.DB, dealsDB *db.DealsDB, fundMgr *fundma
 	}
 
 	return &Provider{
-		ctx:       ctx,
-		cancel:    cancel,
-		config:    cfg,
-		Address:   addr,
-		newDealPS: newDealPS,
-		db:        sqldb,
-		dealsDB:   dealsDB,
-		logsSqlDB: logsSqlDB,
-		sps:       sps,
-		spsCache:  SealingPipelineCache{},
-		df:        df,
+		ctx:         ctx,
+		cancel:      cancel,
+		config:      cfg,
+		Address:     addr,
+		newDealPS:   newDealPS,
+		db:          sqldb,
+		dealsDB:     dealsDB,
+		subPiecesDB: db.NewSubPiecesDB(sqldb),
+		logsSqlDB:   logsSqlDB,
+		sps:         sps,
+		spsCache:    SealingPipelineCache{},
+		df:          df,
 
 		acceptDealChan:       make(chan acceptDealReq),
 		finishedDealChan:     make(chan finishedDealReq),
@@ -207,6 +233,7 @@ func NewProvider(cfg Config, sqldb *sql.DB, dealsDB *db.DealsDB, fundMgr *fundma
 		fullnodeApi:                 fullnodeApi,
 		pieceAdder:                  pa,
 		commpThrottle:               commpThrottle,
+		commpWorkerPool:             commpWorkerPool,
 		commpCalc:                   commpCalc,
 		chainDealManager:            cm,
 		maxDealCollateralMultiplier: 2,
@@ -243,6 +270,17 @@ func (p *Provider) DealBySignedProposalCid(ctx context.Context, propCid cid.Cid)
 	return deal, nil
 }
 
+// DealSubPieces returns the sub-pieces of the deal, if the deal piece is an
+// aggregate
+func (p *Provider) DealSubPieces(ctx context.Context, dealUuid uuid.UUID) ([]types.DealSubPiece, error) {
+	return p.subPiecesDB.ByDeal(ctx, dealUuid)
+}
+
+// SubPieceDeals returns the aggregate deal pieces that contain the sub-piece
+func (p *Provider) SubPieceDeals(ctx context.Context, subPieceCid cid.Cid) ([]types.DealSubPiece, error) {
+	return p.subPiecesDB.BySubPieceCID(ctx, subPieceCid)
+}
+
 func (p *Provider) GetAsk() *legacytypes.SignedStorageAsk {
 	return p.askGetter.GetAsk(p.Address)
 }
@@ -434,6 +472,12 @@ func (p *Provider) Start() error {
 		log.Infof("finished cleaning up %d completed deals", len(finished))
 	}
 
+	// start the sector packer before restarting deals that are waiting to be
+	// added to a sector
+	if err := p.startSectorPacker(); err != nil {
+		return err
+	}
+
 	// restart all active deals
 	activeDeals, err := p.dealsDB.ListActive(p.ctx)
 	if err != nil {
@@ -525,6 +569,9 @@ func (p *Provider) cleanupDealOnRestart(deal *types.ProviderDealState) {
 		_ = os.Remove(deal.InboundFilePath)
 	}
 
+	// remove the sub-pieces of the deal if it failed
+	p.removeSubPieces(p.ctx, deal)
+
 	// untag storage space
 	errs := p.storageManager.Untag(p.ctx, deal.DealUuid)
 	if errs == nil {
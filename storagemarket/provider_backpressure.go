@@ -0,0 +1,144 @@
+package storagemarket
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/boost/storagemarket/types/dealcheckpoints"
+	lapi "github.com/filecoin-project/lotus/api"
+)
+
+const (
+	// BackpressureModePause rejects all new deals while the sealing pipeline
+	// is backed up
+	BackpressureModePause = "pause"
+	// BackpressureModeOfflineOnly rejects new online deals while the sealing
+	// pipeline is backed up, but still accepts offline deals
+	BackpressureModeOfflineOnly = "offline-only"
+)
+
+// BackpressureConfig sets the thresholds at which boost stops accepting new
+// deals because the sealing pipeline is backed up.
+// A threshold of zero is disabled.
+type BackpressureConfig struct {
+	// Mode is the action to take when a threshold is exceeded:
+	// BackpressureModePause or BackpressureModeOfflineOnly
+	Mode string
+	// The maximum number of sectors in each sealing state
+	MaxSectorsInAddPiece   int
+	MaxSectorsInPreCommit1 int
+	MaxSectorsInPreCommit2 int
+	MaxSectorsInWaitDeals  int
+	// The maximum number of deals that are waiting to be added to a sector
+	MaxDealsWaitingForAddPiece int
+	// RetryAfter is the amount of time clients are told to wait before
+	// retrying a deal that was rejected because of backpressure
+	RetryAfter time.Duration
+}
+
+type sectorThreshold struct {
+	state lapi.SectorState
+	limit int
+}
+
+func (c BackpressureConfig) sectorThresholds() []sectorThreshold {
+	return []sectorThreshold{
+		{state: "AddPiece", limit: c.MaxSectorsInAddPiece},
+		{state: "PreCommit1", limit: c.MaxSectorsInPreCommit1},
+		{state: "PreCommit2", limit: c.MaxSectorsInPreCommit2},
+		{state: "WaitDeals", limit: c.MaxSectorsInWaitDeals},
+	}
+}
+
+func (c BackpressureConfig) hasSectorThreshold() bool {
+	for _, t := range c.sectorThresholds() {
+		if t.limit > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// checkBackpressure rejects the deal if the sealing pipeline is backed up
+func (p *Provider) checkBackpressure(deal *types.ProviderDealState) *acceptError {
+	cfg := p.config.Backpressure
+	if cfg.Mode == BackpressureModeOfflineOnly && deal.IsOffline {
+		return nil
+	}
+
+	reasons := p.backpressureReasons()
+	if len(reasons) == 0 {
+		return nil
+	}
+
+	reason := "sealing pipeline is busy (" + strings.Join(reasons, ", ") + ")"
+	if cfg.Mode == BackpressureModeOfflineOnly {
+		reason += ": only accepting offline deals"
+	}
+	if cfg.RetryAfter > 0 {
+		reason += fmt.Sprintf(": retry after %s", cfg.RetryAfter)
+	}
+
+	return &acceptError{
+		error:         fmt.Errorf("sealing pipeline backpressure: %s", strings.Join(reasons, ", ")),
+		reason:        reason,
+		isSevereError: false,
+		retryAfter:    cfg.RetryAfter,
+	}
+}
+
+// backpressureReasons returns a description of each backpressure threshold
+// that has been exceeded
+func (p *Provider) backpressureReasons() []string {
+	cfg := p.config.Backpressure
+	if cfg.Mode != BackpressureModePause && cfg.Mode != BackpressureModeOfflineOnly {
+		return nil
+	}
+
+	var reasons []string
+	if cfg.hasSectorThreshold() {
+		status, err := p.sealingPipelineStatus()
+		if err != nil {
+			// Don't reject deals just because the sealing pipeline status is
+			// temporarily unavailable
+			log.Warnw("backpressure: failed to get sealing pipeline status", "err", err)
+		} else {
+			for _, t := range cfg.sectorThresholds() {
+				if count := status.SectorStates[t.state]; t.limit > 0 && count >= t.limit {
+					reasons = append(reasons, fmt.Sprintf("%d sectors in %s", count, t.state))
+				}
+			}
+		}
+	}
+
+	if cfg.MaxDealsWaitingForAddPiece > 0 {
+		waiting, err := p.dealsWaitingForAddPiece()
+		if err != nil {
+			log.Warnw("backpressure: failed to count deals waiting to be added to a sector", "err", err)
+		} else if waiting >= cfg.MaxDealsWaitingForAddPiece {
+			reasons = append(reasons, fmt.Sprintf("%d deals waiting to be added to a sector", waiting))
+		}
+	}
+
+	return reasons
+}
+
+// dealsWaitingForAddPiece returns the number of deals that have been
+// published but not yet added to a sector. The result is cached for the same
+// duration as the sealing pipeline status.
+func (p *Provider) dealsWaitingForAddPiece() (int, error) {
+	p.spsCacheLk.Lock()
+	defer p.spsCacheLk.Unlock()
+
+	if time.Now().Before(p.waitingDealsCache.CacheTime.Add(p.config.SealingPipelineCacheTimeout)) && p.waitingDealsCache.CacheError == nil {
+		return p.waitingDealsCache.Count, nil
+	}
+
+	count, err := p.dealsDB.CountAtCheckpoint(p.ctx, dealcheckpoints.PublishConfirmed)
+	p.waitingDealsCache.Count = count
+	p.waitingDealsCache.CacheError = err
+	p.waitingDealsCache.CacheTime = time.Now()
+	return count, err
+}
@@ -0,0 +1,63 @@
+package storagemarket
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	mock_sealingpipeline "github.com/filecoin-project/boost/storagemarket/sealingpipeline/mock"
+	"github.com/filecoin-project/boost/storagemarket/types"
+	lapi "github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckBackpressure(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	sps := mock_sealingpipeline.NewMockAPI(ctrl)
+	sps.EXPECT().WorkerJobs(gomock.Any()).Return(map[uuid.UUID][]storiface.WorkerJob{}, nil).AnyTimes()
+	sps.EXPECT().SectorsSummary(gomock.Any()).Return(map[lapi.SectorState]int{"PreCommit1": 4, "WaitDeals": 1}, nil).AnyTimes()
+
+	newProvider := func(cfg BackpressureConfig) *Provider {
+		return &Provider{
+			ctx:    context.Background(),
+			config: Config{Backpressure: cfg, SealingPipelineCacheTimeout: time.Minute},
+			sps:    sps,
+		}
+	}
+	online := &types.ProviderDealState{DealUuid: uuid.New()}
+	offline := &types.ProviderDealState{DealUuid: uuid.New(), IsOffline: true}
+
+	t.Run("disabled", func(t *testing.T) {
+		p := newProvider(BackpressureConfig{MaxSectorsInPreCommit1: 1})
+		require.Nil(t, p.checkBackpressure(online))
+	})
+
+	t.Run("below threshold", func(t *testing.T) {
+		p := newProvider(BackpressureConfig{Mode: BackpressureModePause, MaxSectorsInPreCommit1: 5, MaxSectorsInWaitDeals: 2})
+		require.Nil(t, p.checkBackpressure(online))
+	})
+
+	t.Run("pause", func(t *testing.T) {
+		p := newProvider(BackpressureConfig{Mode: BackpressureModePause, MaxSectorsInPreCommit1: 4, RetryAfter: 5 * time.Minute})
+		aerr := p.checkBackpressure(online)
+		require.NotNil(t, aerr)
+		require.False(t, aerr.isSevereError)
+		require.Equal(t, 5*time.Minute, aerr.retryAfter)
+		require.Contains(t, aerr.reason, "4 sectors in PreCommit1")
+		require.Contains(t, aerr.reason, "retry after 5m0s")
+
+		require.NotNil(t, p.checkBackpressure(offline))
+	})
+
+	t.Run("offline only", func(t *testing.T) {
+		p := newProvider(BackpressureConfig{Mode: BackpressureModeOfflineOnly, MaxSectorsInWaitDeals: 1})
+		aerr := p.checkBackpressure(online)
+		require.NotNil(t, aerr)
+		require.Contains(t, aerr.reason, "only accepting offline deals")
+
+		require.Nil(t, p.checkBackpressure(offline))
+	})
+}
@@ -80,6 +80,8 @@ func (p *Provider) getDealFilterParams(deal *types.ProviderDealState) (*dealfilt
 // sealingPipelineStatus updates the SealingPipelineCache to reduce constant sealingpipeline.GetStatus calls
 // to the lotus-miner. This is to speed up the deal filter processing
 func (p *Provider) sealingPipelineStatus() (sealingpipeline.Status, error) {
+	p.spsCacheLk.Lock()
+	defer p.spsCacheLk.Unlock()
 
 	if time.Now().After(p.spsCache.CacheTime.Add(p.config.SealingPipelineCacheTimeout)) || p.spsCache.CacheError != nil {
 		sealingStatus, err := sealingpipeline.GetStatus(p.ctx, p.sps)
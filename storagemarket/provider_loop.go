@@ -73,6 +73,9 @@ type acceptError struct {
 	isSevereError bool
 	// The reason sent to the client for why their deal was rejected
 	reason string
+	// How long the client should wait before retrying the deal, if the
+	// provider is temporarily unable to accept deals
+	retryAfter time.Duration
 }
 
 // we still need to call the BasicDealFilter() even when external deal filter is not set.
@@ -124,6 +127,11 @@ func (p *Provider) processOnlineDealProposal(deal *types.ProviderDealState) *acc
 		return aerr
 	}
 
+	// Check that the sealing pipeline is not backed up
+	if aerr := p.checkBackpressure(deal); aerr != nil {
+		return aerr
+	}
+
 	// we still need to call runDealFilters() even when external deal filter is not set
 	if aerr := p.runDealFilters(deal); aerr != nil {
 		return aerr
@@ -235,6 +243,11 @@ func (p *Provider) processOfflineDealProposal(ds *smtypes.ProviderDealState, dh
 		return aerr
 	}
 
+	// Check that the sealing pipeline is not backed up
+	if aerr := p.checkBackpressure(ds); aerr != nil {
+		return aerr
+	}
+
 	// we still need to call runDealFilters() even when external deal filter is not set
 	if aerr := p.runDealFilters(ds); aerr != nil {
 		return aerr
@@ -600,7 +613,8 @@ func (p *Provider) sendErrorResp(aerr *acceptError, resp chan acceptDealResp, de
 	// The error is not a severe error, so don't log an error, just
 	// send a message to the client with a rejection reason
 	p.dealLogger.Infow(dealId, "deal acceptance request rejected", "reason", aerr.reason, "error", aerr.error)
-	resp <- acceptDealResp{ri: &api.ProviderDealRejectionInfo{Accepted: false, Reason: aerr.reason}, err: nil}
+	ri := &api.ProviderDealRejectionInfo{Accepted: false, Reason: aerr.reason, RetryAfter: uint64(aerr.retryAfter.Seconds())}
+	resp <- acceptDealResp{ri: ri, err: nil}
 }
 
 func (p *Provider) setupHandlerAndStartDeal(deal *types.ProviderDealState, rsp chan acceptDealResp) {
@@ -0,0 +1,102 @@
+package storagemarket
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/filecoin-project/boost/storagemarket/sectorpacker"
+	"github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/go-state-types/abi"
+	lbuild "github.com/filecoin-project/lotus/build"
+	ctypes "github.com/filecoin-project/lotus/chain/types"
+)
+
+// SectorPackingConfig configures how deal pieces are grouped into sectors
+// before they are added to the sealing pipeline
+type SectorPackingConfig struct {
+	// Whether to hold pieces and pack them into sector-sized bins
+	Enabled bool
+	// The maximum amount of time to hold a piece while waiting for other
+	// pieces to fill a sector
+	MaxWait time.Duration
+	// Only pack pieces into the same sector if their deal end epochs are
+	// within this many epochs of each other
+	EndEpochTolerance abi.ChainEpoch
+	// The amount of time it is expected to take to seal a sector. A piece is
+	// always released in time to be sealed before its deal start epoch.
+	ExpectedSealDuration time.Duration
+}
+
+// startSectorPacker creates and starts the sector packer, if sector packing
+// is enabled
+func (p *Provider) startSectorPacker() error {
+	cfg := p.config.SectorPacking
+	if !cfg.Enabled {
+		return nil
+	}
+
+	mi, err := p.fullnodeApi.StateMinerInfo(p.ctx, p.Address, ctypes.EmptyTSK)
+	if err != nil {
+		return fmt.Errorf("getting miner info to determine sector size: %w", err)
+	}
+
+	p.packer = sectorpacker.New(sectorpacker.Config{
+		SectorSize:        abi.PaddedPieceSize(mi.SectorSize),
+		MaxWait:           cfg.MaxWait,
+		EndEpochTolerance: cfg.EndEpochTolerance,
+	})
+	p.packer.Start(p.ctx)
+
+	log.Infow("sector packing enabled", "sector size", mi.SectorSize, "max wait", cfg.MaxWait,
+		"end epoch tolerance", cfg.EndEpochTolerance)
+	return nil
+}
+
+// packPiece waits for the sector packer to release the deal's piece. The
+// returned function must be called once the piece has been added to a sector.
+func (p *Provider) packPiece(ctx context.Context, deal *types.ProviderDealState) (func(), *dealMakingError) {
+	if p.packer == nil {
+		return func() {}, nil
+	}
+
+	proposal := deal.ClientDealProposal.Proposal
+	piece := sectorpacker.Piece{
+		DealUuid: deal.DealUuid,
+		Size:     proposal.PieceSize,
+		EndEpoch: proposal.EndEpoch,
+		Deadline: p.packingDeadline(proposal.StartEpoch),
+	}
+
+	p.dealLogger.Infow(deal.DealUuid, "waiting for sector packing", "deadline", piece.Deadline)
+	decision, done, err := p.packer.Pack(ctx, piece)
+	if err != nil {
+		return nil, &dealMakingError{
+			retry: types.DealRetryAuto,
+			error: fmt.Errorf("waiting for sector packing: %w", err),
+		}
+	}
+
+	p.dealLogger.Infow(deal.DealUuid, "sector packing decision", "bin", decision.Bin, "position", decision.Position,
+		"bin pieces", decision.BinPieces, "bin size", decision.BinSize, "fill ratio", decision.FillRatio,
+		"waited", decision.Waited.String(), "reason", decision.Reason)
+	return done, nil
+}
+
+// packingDeadline returns the latest time at which a piece can be added to a
+// sector and still be sealed before the deal start epoch
+func (p *Provider) packingDeadline(startEpoch abi.ChainEpoch) time.Time {
+	now := time.Now()
+	chainHead, err := p.fullnodeApi.ChainHead(p.ctx)
+	if err != nil {
+		log.Warnw("sector packing: failed to get chain head, releasing piece immediately", "err", err)
+		return now
+	}
+
+	untilStart := time.Duration(startEpoch-chainHead.Height()) * time.Duration(lbuild.BlockDelaySecs) * time.Second
+	budget := untilStart - p.config.SectorPacking.ExpectedSealDuration
+	if budget > p.config.SectorPacking.MaxWait {
+		budget = p.config.SectorPacking.MaxWait
+	}
+	return now.Add(budget)
+}
@@ -0,0 +1,355 @@
+package sectorpacker
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/boost/metrics"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/google/uuid"
+	logging "github.com/ipfs/go-log/v2"
+	"go.opencensus.io/stats"
+)
+
+var log = logging.Logger("sectorpacker")
+
+// How often to check whether pieces that are being held should be released
+const checkInterval = time.Second
+
+const (
+	// ReasonFull means the bin was released because it fills a sector
+	ReasonFull = "sector full"
+	// ReasonMaxWait means the bin was released because one of its pieces
+	// has been held for the maximum wait time
+	ReasonMaxWait = "max wait elapsed"
+	// ReasonDeadline means the bin was released because one of its pieces
+	// must be added to a sector now to be sealed before the deal start epoch
+	ReasonDeadline = "start epoch deadline"
+)
+
+type Config struct {
+	// The padded size of a sector
+	SectorSize abi.PaddedPieceSize
+	// The maximum amount of time to hold a piece while waiting for other
+	// pieces to fill a sector
+	MaxWait time.Duration
+	// Pieces are only packed together if their deal end epochs are within
+	// the same window of this many epochs. Zero means pieces are packed
+	// together regardless of end epoch.
+	EndEpochTolerance abi.ChainEpoch
+}
+
+// Piece is a deal piece waiting to be added to a sector
+type Piece struct {
+	DealUuid uuid.UUID
+	Size     abi.PaddedPieceSize
+	EndEpoch abi.ChainEpoch
+	// Deadline is the latest time at which the piece must be released to be
+	// sealed before the deal start epoch
+	Deadline time.Time
+}
+
+// Decision describes how a piece was packed
+type Decision struct {
+	// A sequence number identifying the bin the piece was packed into
+	Bin uint64
+	// The position of the piece within the bin
+	Position int
+	// The number of pieces in the bin
+	BinPieces int
+	// The total padded size of the pieces in the bin
+	BinSize abi.PaddedPieceSize
+	// The ratio of BinSize to the sector size
+	FillRatio float64
+	// How long the piece was held before it was released
+	Waited time.Duration
+	// Why the bin was released
+	Reason string
+}
+
+type pending struct {
+	Piece
+	added    time.Time
+	decision Decision
+	released chan Decision
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+func (pc *pending) setDone() {
+	pc.doneOnce.Do(func() { close(pc.done) })
+}
+
+// Packer holds deal pieces for a short time and groups them into
+// sector-sized bins before they are handed to the sealer.
+// Pieces in a bin are released one at a time, largest first, so that the
+// sealer adds them to the same sector with the minimum amount of padding.
+type Packer struct {
+	cfg Config
+
+	lk      sync.Mutex
+	groups  map[abi.ChainEpoch][]*pending
+	queue   [][]*pending
+	nextBin uint64
+
+	kick    chan struct{}
+	queued  chan struct{}
+	started sync.Once
+}
+
+func New(cfg Config) *Packer {
+	return &Packer{
+		cfg:    cfg,
+		groups: make(map[abi.ChainEpoch][]*pending),
+		kick:   make(chan struct{}, 1),
+		queued: make(chan struct{}, 1),
+	}
+}
+
+// Start runs the packer until the context is cancelled
+func (p *Packer) Start(ctx context.Context) {
+	p.started.Do(func() {
+		go p.run(ctx)
+		go p.handoff(ctx)
+	})
+}
+
+// Pack holds the piece until it is released as part of a bin. The caller
+// must call the returned function once it has finished adding the piece to
+// a sector, so that the next piece in the bin can be released.
+func (p *Packer) Pack(ctx context.Context, piece Piece) (Decision, func(), error) {
+	pc := &pending{
+		Piece:    piece,
+		added:    time.Now(),
+		released: make(chan Decision, 1),
+		done:     make(chan struct{}),
+	}
+
+	key := p.groupKey(piece.EndEpoch)
+	p.lk.Lock()
+	p.groups[key] = append(p.groups[key], pc)
+	p.lk.Unlock()
+	notify(p.kick)
+
+	select {
+	case d := <-pc.released:
+		return d, pc.setDone, nil
+	case <-ctx.Done():
+		p.lk.Lock()
+		p.groups[key] = removePending(p.groups[key], pc)
+		p.lk.Unlock()
+		// If the piece has already been put in a bin, make sure it doesn't
+		// hold up the rest of the bin
+		pc.setDone()
+		return Decision{}, func() {}, ctx.Err()
+	}
+}
+
+func (p *Packer) groupKey(endEpoch abi.ChainEpoch) abi.ChainEpoch {
+	if p.cfg.EndEpochTolerance <= 0 {
+		return 0
+	}
+	return endEpoch / p.cfg.EndEpochTolerance
+}
+
+func (p *Packer) run(ctx context.Context) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-p.kick:
+		}
+
+		p.evaluate(time.Now())
+	}
+}
+
+// evaluate packs the pieces in each group into bins, and queues the bins that
+// are ready to be released
+func (p *Packer) evaluate(now time.Time) {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	released := false
+	for key, pieces := range p.groups {
+		var remaining []*pending
+		for _, bin := range packBins(pieces, p.cfg.SectorSize) {
+			reason := p.releaseReason(bin, now)
+			if reason == "" {
+				remaining = append(remaining, bin...)
+				continue
+			}
+			p.queueBin(bin, reason, now)
+			released = true
+		}
+
+		if len(remaining) == 0 {
+			delete(p.groups, key)
+		} else {
+			p.groups[key] = remaining
+		}
+	}
+
+	if released {
+		notify(p.queued)
+	}
+}
+
+// releaseReason returns the reason to release the bin, or the empty string
+// if the bin should be held for longer
+func (p *Packer) releaseReason(bin []*pending, now time.Time) string {
+	if binSize(bin) >= p.cfg.SectorSize {
+		return ReasonFull
+	}
+	for _, pc := range bin {
+		if !now.Before(pc.Deadline) {
+			return ReasonDeadline
+		}
+	}
+	for _, pc := range bin {
+		if now.Sub(pc.added) >= p.cfg.MaxWait {
+			return ReasonMaxWait
+		}
+	}
+	return ""
+}
+
+// queueBin queues the bin to be handed off to the sealer. It must be called
+// with the lock held.
+func (p *Packer) queueBin(bin []*pending, reason string, now time.Time) {
+	p.nextBin++
+	size := binSize(bin)
+	fill := float64(size) / float64(p.cfg.SectorSize)
+	if fill > 1 {
+		fill = 1
+	}
+
+	for i, pc := range bin {
+		pc.decision = Decision{
+			Bin:       p.nextBin,
+			Position:  i,
+			BinPieces: len(bin),
+			BinSize:   size,
+			FillRatio: fill,
+			Waited:    now.Sub(pc.added),
+			Reason:    reason,
+		}
+	}
+	p.queue = append(p.queue, bin)
+
+	log.Infow("released bin", "bin", p.nextBin, "pieces", len(bin), "size", size, "fill", fill, "reason", reason)
+	stats.Record(context.Background(), metrics.SectorPackingFillRatio.M(fill), metrics.SectorPackingBinPieces.M(int64(len(bin))))
+}
+
+// handoff releases each queued bin. Bins are released concurrently, so that
+// a slow AddPiece for one bin doesn't hold up the pieces in other bins.
+func (p *Packer) handoff(ctx context.Context) {
+	for {
+		p.lk.Lock()
+		if len(p.queue) == 0 {
+			p.lk.Unlock()
+			select {
+			case <-ctx.Done():
+				return
+			case <-p.queued:
+			}
+			continue
+		}
+		bin := p.queue[0]
+		p.queue = p.queue[1:]
+		p.lk.Unlock()
+
+		go p.releaseBin(ctx, bin)
+	}
+}
+
+// releaseBin lets the pieces in the bin proceed one at a time, so that they
+// are added to the sealer consecutively. The wait for a piece to be added is
+// bounded by the deadline of the pieces still held in the bin, so that they
+// can still be sealed before their deal start epoch.
+func (p *Packer) releaseBin(ctx context.Context, bin []*pending) {
+	for i, pc := range bin {
+		pc.released <- pc.decision
+		if i == len(bin)-1 {
+			return
+		}
+
+		deadline := bin[i+1].Deadline
+		for _, next := range bin[i+2:] {
+			if next.Deadline.Before(deadline) {
+				deadline = next.Deadline
+			}
+		}
+		timer := time.NewTimer(time.Until(deadline))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-pc.done:
+		case <-timer.C:
+			log.Warnw("releasing next piece in bin before previous piece was added to a sector: deadline reached",
+				"bin", pc.decision.Bin, "position", i, "deal", pc.DealUuid)
+		}
+		timer.Stop()
+	}
+}
+
+// packBins groups pieces into sector-sized bins using first fit decreasing.
+// Because piece sizes are powers of two, first fit decreasing fills each
+// bin completely before starting the next, and adding the pieces of a bin to
+// a sector in decreasing order of size needs no padding between pieces.
+func packBins(pieces []*pending, sectorSize abi.PaddedPieceSize) [][]*pending {
+	sorted := append([]*pending{}, pieces...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Size > sorted[j].Size
+	})
+
+	var bins [][]*pending
+	var sizes []abi.PaddedPieceSize
+	for _, pc := range sorted {
+		placed := false
+		for i := range bins {
+			if sizes[i]+pc.Size <= sectorSize {
+				bins[i] = append(bins[i], pc)
+				sizes[i] += pc.Size
+				placed = true
+				break
+			}
+		}
+		if !placed {
+			bins = append(bins, []*pending{pc})
+			sizes = append(sizes, pc.Size)
+		}
+	}
+	return bins
+}
+
+func binSize(bin []*pending) abi.PaddedPieceSize {
+	var size abi.PaddedPieceSize
+	for _, pc := range bin {
+		size += pc.Size
+	}
+	return size
+}
+
+func removePending(pieces []*pending, pc *pending) []*pending {
+	for i, other := range pieces {
+		if other == pc {
+			return append(pieces[:i], pieces[i+1:]...)
+		}
+	}
+	return pieces
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
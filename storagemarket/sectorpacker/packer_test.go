@@ -0,0 +1,247 @@
+package sectorpacker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+type packResult struct {
+	piece    Piece
+	decision Decision
+	done     func()
+	err      error
+}
+
+func packAsync(ctx context.Context, p *Packer, piece Piece) chan packResult {
+	res := make(chan packResult, 1)
+	go func() {
+		d, done, err := p.Pack(ctx, piece)
+		res <- packResult{piece: piece, decision: d, done: done, err: err}
+	}()
+	return res
+}
+
+func newPiece(size abi.PaddedPieceSize, endEpoch abi.ChainEpoch) Piece {
+	return Piece{
+		DealUuid: uuid.New(),
+		Size:     size,
+		EndEpoch: endEpoch,
+		Deadline: time.Now().Add(time.Hour),
+	}
+}
+
+func TestPackerFullSector(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p := New(Config{SectorSize: 1024, MaxWait: time.Hour})
+	p.Start(ctx)
+
+	// Add pieces that together exactly fill a sector
+	var results []chan packResult
+	for _, size := range []abi.PaddedPieceSize{256, 512, 128, 128} {
+		results = append(results, packAsync(ctx, p, newPiece(size, 1000)))
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Expect the pieces to be released one at a time, largest first
+	var prevSize abi.PaddedPieceSize
+	for i := 0; i < len(results); i++ {
+		var res packResult
+		select {
+		case res = <-results[0]:
+		case res = <-results[1]:
+		case res = <-results[2]:
+		case res = <-results[3]:
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "timed out waiting for piece to be released")
+		}
+		require.NoError(t, res.err)
+		require.Equal(t, i, res.decision.Position)
+		require.Equal(t, 4, res.decision.BinPieces)
+		require.Equal(t, abi.PaddedPieceSize(1024), res.decision.BinSize)
+		require.Equal(t, 1.0, res.decision.FillRatio)
+		require.Equal(t, ReasonFull, res.decision.Reason)
+		if i > 0 {
+			require.LessOrEqual(t, res.piece.Size, prevSize)
+		}
+		prevSize = res.piece.Size
+
+		// The next piece should not be released until this one is done
+		select {
+		case <-results[0]:
+			require.Fail(t, "piece released before previous piece was done")
+		case <-results[1]:
+			require.Fail(t, "piece released before previous piece was done")
+		case <-results[2]:
+			require.Fail(t, "piece released before previous piece was done")
+		case <-results[3]:
+			require.Fail(t, "piece released before previous piece was done")
+		case <-time.After(50 * time.Millisecond):
+		}
+		res.done()
+	}
+}
+
+func TestPackerMaxWait(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p := New(Config{SectorSize: 1024, MaxWait: 100 * time.Millisecond})
+	p.Start(ctx)
+
+	res := <-packAsync(ctx, p, newPiece(256, 1000))
+	require.NoError(t, res.err)
+	require.Equal(t, ReasonMaxWait, res.decision.Reason)
+	require.Equal(t, 1, res.decision.BinPieces)
+	require.Equal(t, 0.25, res.decision.FillRatio)
+	require.GreaterOrEqual(t, res.decision.Waited, 100*time.Millisecond)
+	res.done()
+}
+
+func TestPackerDeadline(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p := New(Config{SectorSize: 1024, MaxWait: time.Hour})
+	p.Start(ctx)
+
+	piece := newPiece(256, 1000)
+	piece.Deadline = time.Now()
+	res := <-packAsync(ctx, p, piece)
+	require.NoError(t, res.err)
+	require.Equal(t, ReasonDeadline, res.decision.Reason)
+	res.done()
+}
+
+func TestPackerConcurrentBins(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p := New(Config{SectorSize: 1024, MaxWait: time.Hour, EndEpochTolerance: 100})
+	p.Start(ctx)
+
+	// Fill two bins, in different end epoch windows
+	bin1 := []chan packResult{packAsync(ctx, p, newPiece(512, 150)), packAsync(ctx, p, newPiece(512, 150))}
+	bin2 := []chan packResult{packAsync(ctx, p, newPiece(512, 250)), packAsync(ctx, p, newPiece(512, 250))}
+
+	// The first piece of each bin should be released without waiting for
+	// the first piece of the other bin to be added to a sector
+	var first []packResult
+	for _, bin := range [][]chan packResult{bin1, bin2} {
+		select {
+		case r := <-bin[0]:
+			first = append(first, r)
+		case r := <-bin[1]:
+			first = append(first, r)
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "timed out waiting for piece to be released")
+		}
+	}
+	require.NotEqual(t, first[0].decision.Bin, first[1].decision.Bin)
+	for _, r := range first {
+		require.NoError(t, r.err)
+		require.Equal(t, 0, r.decision.Position)
+		r.done()
+	}
+}
+
+func TestPackerHandoffDeadline(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p := New(Config{SectorSize: 1024, MaxWait: time.Hour})
+	p.Start(ctx)
+
+	// The pieces fill a sector, so they are released straight away. They
+	// must be added to a sector soon, so the second piece should not wait
+	// for the first piece to be added to a sector.
+	piece1 := newPiece(512, 1000)
+	piece1.Deadline = time.Now().Add(200 * time.Millisecond)
+	piece2 := newPiece(512, 1000)
+	piece2.Deadline = piece1.Deadline
+	res1 := packAsync(ctx, p, piece1)
+	res2 := packAsync(ctx, p, piece2)
+
+	var rs []packResult
+	for i := 0; i < 2; i++ {
+		select {
+		case r := <-res1:
+			rs = append(rs, r)
+		case r := <-res2:
+			rs = append(rs, r)
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "timed out waiting for piece to be released")
+		}
+	}
+	for i, r := range rs {
+		require.NoError(t, r.err)
+		require.Equal(t, i, r.decision.Position)
+	}
+}
+
+func TestPackerEndEpochGroups(t *testing.T) {
+	p := New(Config{SectorSize: 1024, MaxWait: time.Hour, EndEpochTolerance: 100})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Two pieces that would fill a sector, but with end epochs in different
+	// windows, should not be packed together
+	res1 := packAsync(ctx, p, newPiece(512, 150))
+	res2 := packAsync(ctx, p, newPiece(512, 250))
+	require.Eventually(t, func() bool {
+		p.lk.Lock()
+		defer p.lk.Unlock()
+		return len(p.groups) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	p.evaluate(time.Now())
+	p.lk.Lock()
+	require.Empty(t, p.queue)
+	p.lk.Unlock()
+
+	// A piece with an end epoch in the same window as the first piece should
+	// be packed with it
+	res3 := packAsync(ctx, p, newPiece(512, 199))
+	require.Eventually(t, func() bool {
+		p.lk.Lock()
+		defer p.lk.Unlock()
+		return len(p.groups[1]) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	p.Start(ctx)
+	for _, res := range []chan packResult{res1, res3} {
+		r := <-res
+		require.NoError(t, r.err)
+		require.Equal(t, ReasonFull, r.decision.Reason)
+		r.done()
+	}
+
+	// Cancelling the context should cause the remaining piece to stop waiting
+	cancel()
+	r := <-res2
+	require.ErrorIs(t, r.err, context.Canceled)
+}
+
+func TestPackBins(t *testing.T) {
+	var pieces []*pending
+	for _, size := range []abi.PaddedPieceSize{128, 512, 256, 512, 128, 256} {
+		pieces = append(pieces, &pending{Piece: Piece{Size: size}})
+	}
+
+	bins := packBins(pieces, 1024)
+	require.Len(t, bins, 2)
+	require.Equal(t, abi.PaddedPieceSize(1024), binSize(bins[0]))
+	require.Equal(t, abi.PaddedPieceSize(768), binSize(bins[1]))
+	for _, bin := range bins {
+		for i := 1; i < len(bin); i++ {
+			require.LessOrEqual(t, bin[i].Size, bin[i-1].Size)
+		}
+	}
+}
@@ -94,3 +94,18 @@ const (
 	// DealRetryFatal means that the deal will fail immediately and permanently
 	DealRetryFatal DealRetryType = "fatal"
 )
+
+// DealSubPiece is a sub-piece of an aggregate deal piece, as described by
+// the piece's FRC-0058 data segment index
+type DealSubPiece struct {
+	// DealUuid is the uuid of the deal for the aggregate piece
+	DealUuid uuid.UUID
+	// PieceCID is the piece CID of the aggregate piece
+	PieceCID cid.Cid
+	// SubPieceCID is the piece CID of the sub-piece
+	SubPieceCID cid.Cid
+	// Offset is the padded offset of the sub-piece within the aggregate piece
+	Offset abi.PaddedPieceSize
+	// Size is the padded size of the sub-piece
+	Size abi.PaddedPieceSize
+}
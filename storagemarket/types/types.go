@@ -153,6 +153,9 @@ type DealResponse struct {
 	// Message is the reason the deal proposal was rejected. It is empty if
 	// the deal was accepted.
 	Message string
+	// RetryAfter is the number of seconds the client should wait before
+	// retrying, if the provider is temporarily unable to accept deals
+	RetryAfter uint64
 }
 
 type PieceAdder interface {
@@ -163,6 +166,11 @@ type CommpCalculator interface {
 	ComputeDataCid(ctx context.Context, pieceSize abi.UnpaddedPieceSize, pieceData storiface.Data) (abi.PieceInfo, error)
 }
 
+// CommpWorkerPool computes commp on a pool of commp worker processes
+type CommpWorkerPool interface {
+	ComputeCommP(ctx context.Context, filepath string) (*abi.PieceInfo, error)
+}
+
 type DealPublisher interface {
 	Publish(ctx context.Context, deal market.ClientDealProposal) (cid.Cid, error)
 }
@@ -1373,7 +1373,7 @@ func (t *DealResponse) MarshalCBOR(w io.Writer) error {
 
 	cw := cbg.NewCborWriter(w)
 
-	if _, err := cw.Write([]byte{162}); err != nil {
+	if _, err := cw.Write([]byte{163}); err != nil {
 		return err
 	}
 
@@ -1415,6 +1415,23 @@ func (t *DealResponse) MarshalCBOR(w io.Writer) error {
 	if err := cbg.WriteBool(w, t.Accepted); err != nil {
 		return err
 	}
+
+	// t.RetryAfter (uint64) (uint64)
+	if len("RetryAfter") > 8192 {
+		return xerrors.Errorf("Value in field \"RetryAfter\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("RetryAfter"))); err != nil {
+		return err
+	}
+	if _, err := cw.WriteString(string("RetryAfter")); err != nil {
+		return err
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajUnsignedInt, uint64(t.RetryAfter)); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -1485,6 +1502,21 @@ func (t *DealResponse) UnmarshalCBOR(r io.Reader) (err error) {
 			default:
 				return fmt.Errorf("booleans are either major type 7, value 20 or 21 (got %d)", extra)
 			}
+			// t.RetryAfter (uint64) (uint64)
+		case "RetryAfter":
+
+			{
+
+				maj, extra, err = cr.ReadHeader()
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.RetryAfter = uint64(extra)
+
+			}
 
 		default:
 			// Field doesn't exist on this type, so ignore it
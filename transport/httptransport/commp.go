@@ -0,0 +1,287 @@
+package httptransport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/filecoin-project/boost/lib/streamcommp"
+	"github.com/filecoin-project/boost/storagemarket/logs"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/google/uuid"
+)
+
+// Save the intermediate commp state to disk every 256 MiB
+const commpStateSaveInterval = 256 * 1024 * 1024
+
+type chunkRange struct {
+	file       string
+	rangeStart int64
+	rangeEnd   int64
+}
+
+// commpFollower computes commp over the transfer data while it is being
+// downloaded.
+// Chunks are downloaded in parallel, so the data is fed to the commp
+// calculator as soon as there is a contiguous prefix of downloaded data. The
+// intermediate state of the calculation is saved to a state file so that it
+// can be resumed after a restart.
+type commpFollower struct {
+	dealUuid   uuid.UUID
+	dl         *logs.DealLogger
+	outputFile string
+	stateFile  string
+	dealSize   int64
+	chunks     []chunkRange
+
+	notifyCh chan struct{}
+	stop     context.CancelFunc
+	stopOnce sync.Once
+	done     chan struct{}
+
+	lk        sync.Mutex
+	calc      *streamcommp.Calc
+	lastSaved uint64
+}
+
+func commpStateFile(outputFile string) string {
+	return outputFile + "-commp"
+}
+
+func newCommpFollower(dealUuid uuid.UUID, dl *logs.DealLogger, outputFile string, dealSize int64, chunks []chunkRange) *commpFollower {
+	f := &commpFollower{
+		dealUuid:   dealUuid,
+		dl:         dl,
+		outputFile: outputFile,
+		stateFile:  commpStateFile(outputFile),
+		dealSize:   dealSize,
+		chunks:     chunks,
+		notifyCh:   make(chan struct{}, 1),
+	}
+
+	f.calc = streamcommp.New()
+	st, err := readCommpStateFile(f.stateFile)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		f.dl.Infow(dealUuid, "ignoring unreadable commp state file", "file", f.stateFile, "err", err)
+	case st.Size > uint64(dealSize):
+		f.dl.Infow(dealUuid, "ignoring commp state file: size is larger than deal size", "state size", st.Size, "deal size", dealSize)
+	default:
+		calc, err := streamcommp.NewFromState(*st)
+		if err != nil {
+			f.dl.Infow(dealUuid, "ignoring invalid commp state file", "file", f.stateFile, "err", err)
+			break
+		}
+		f.calc = calc
+		f.lastSaved = st.Size
+		f.dl.Infow(dealUuid, "resuming streaming commp", "offset", st.Size)
+	}
+
+	return f
+}
+
+// start runs the commp calculation in the background until stopped
+func (f *commpFollower) start(ctx context.Context) {
+	ctx, f.stop = context.WithCancel(ctx)
+	f.done = make(chan struct{})
+	go func() {
+		defer close(f.done)
+		for {
+			select {
+			case <-ctx.Done():
+				f.lk.Lock()
+				f.saveState()
+				f.lk.Unlock()
+				return
+			case <-f.notifyCh:
+			}
+
+			if err := f.advance(ctx); err != nil && ctx.Err() == nil {
+				f.dl.Infow(f.dealUuid, "streaming commp: failed to process downloaded data", "err", err)
+			}
+		}
+	}()
+}
+
+// close stops the background calculation and saves its state
+func (f *commpFollower) close() {
+	f.stopOnce.Do(func() {
+		if f.stop != nil {
+			f.stop()
+			<-f.done
+		}
+	})
+}
+
+// notify tells the follower that more data has been downloaded
+func (f *commpFollower) notify() {
+	select {
+	case f.notifyCh <- struct{}{}:
+	default:
+	}
+}
+
+// finish stops the background calculation, processes any remaining data
+// and returns the piece commitment. It must be called once all chunks have
+// been downloaded.
+func (f *commpFollower) finish(ctx context.Context) (*abi.PieceInfo, error) {
+	f.close()
+
+	if err := f.advance(ctx); err != nil {
+		return nil, err
+	}
+
+	f.lk.Lock()
+	defer f.lk.Unlock()
+
+	if size := f.calc.Size(); size != uint64(f.dealSize) {
+		return nil, fmt.Errorf("streaming commp processed %d bytes but deal size is %d", size, f.dealSize)
+	}
+	pi, err := f.calc.Sum()
+	if err != nil {
+		return nil, err
+	}
+
+	// The calculation is complete so the state file is no longer needed
+	if err := os.Remove(f.stateFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+		f.dl.Infow(f.dealUuid, "error deleting commp state file", "file", f.stateFile, "err", err)
+	}
+	return &pi, nil
+}
+
+// advance feeds the contiguous prefix of downloaded data that has not yet
+// been processed to the commp calculator
+func (f *commpFollower) advance(ctx context.Context) error {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+
+	for {
+		offset := int64(f.calc.Size())
+		if offset >= f.dealSize {
+			return nil
+		}
+
+		file, fileOffset, available, err := f.source(offset)
+		if err != nil {
+			return err
+		}
+		if available <= 0 {
+			return nil
+		}
+
+		if err := f.process(ctx, file, fileOffset, available); err != nil {
+			return err
+		}
+
+		if f.calc.Size()-f.lastSaved >= commpStateSaveInterval {
+			f.saveState()
+		}
+	}
+}
+
+// source returns the file that contains the data at the given offset, the
+// offset within the file, and the number of contiguous bytes that are
+// available from that point
+func (f *commpFollower) source(offset int64) (string, int64, int64, error) {
+	for _, c := range f.chunks {
+		if offset < c.rangeStart || offset >= c.rangeEnd {
+			continue
+		}
+
+		// If the chunk has already been appended to the output file, read
+		// from the output file
+		outputStats, err := os.Stat(f.outputFile)
+		if err != nil {
+			return "", 0, 0, fmt.Errorf("failed to get stats of the output file %s: %w", f.outputFile, err)
+		}
+		if offset < outputStats.Size() {
+			return f.outputFile, offset, min(outputStats.Size(), c.rangeEnd) - offset, nil
+		}
+		if c.file == f.outputFile {
+			return "", 0, 0, nil
+		}
+
+		chunkStats, err := os.Stat(c.file)
+		if errors.Is(err, os.ErrNotExist) {
+			return "", 0, 0, nil
+		}
+		if err != nil {
+			return "", 0, 0, fmt.Errorf("failed to get stats of the chunk file %s: %w", c.file, err)
+		}
+		chunkOffset := offset - c.rangeStart
+		return c.file, chunkOffset, chunkStats.Size() - chunkOffset, nil
+	}
+
+	return "", 0, 0, fmt.Errorf("offset %d is outside the deal data", offset)
+}
+
+func (f *commpFollower) process(ctx context.Context, file string, offset int64, size int64) error {
+	fi, err := os.Open(file)
+	if err != nil {
+		return fmt.Errorf("failed to open %s for streaming commp: %w", file, err)
+	}
+	defer fi.Close()
+
+	buf := make([]byte, readBufferSize)
+	rd := io.NewSectionReader(fi, offset, size)
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		nr, readErr := rd.Read(buf)
+		if nr > 0 {
+			_, _ = f.calc.Write(buf[:nr])
+		}
+		if readErr == io.EOF {
+			return nil
+		}
+		if readErr != nil {
+			return fmt.Errorf("failed to read %s for streaming commp: %w", file, readErr)
+		}
+	}
+}
+
+// saveState writes the intermediate commp state to the state file. It must
+// be called with the lock held.
+func (f *commpFollower) saveState() {
+	size := f.calc.Size()
+	if size == f.lastSaved {
+		return
+	}
+
+	data, err := json.Marshal(f.calc.State())
+	if err != nil {
+		f.dl.Infow(f.dealUuid, "failed to marshal commp state", "err", err)
+		return
+	}
+
+	// Write to a temp file and rename so that the state file is never
+	// partially written
+	tmp := f.stateFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		f.dl.Infow(f.dealUuid, "failed to write commp state file", "file", tmp, "err", err)
+		return
+	}
+	if err := os.Rename(tmp, f.stateFile); err != nil {
+		f.dl.Infow(f.dealUuid, "failed to rename commp state file", "file", tmp, "err", err)
+		return
+	}
+	f.lastSaved = size
+}
+
+func readCommpStateFile(file string) (*streamcommp.State, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var st streamcommp.State
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, fmt.Errorf("error unmarshalling commp state file %s: %w", file, err)
+	}
+	return &st, nil
+}
@@ -18,6 +18,7 @@ import (
 	"github.com/filecoin-project/boost/transport"
 	"github.com/filecoin-project/boost/transport/httptransport/util"
 	"github.com/filecoin-project/boost/transport/types"
+	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/google/uuid"
 	"github.com/jpillora/backoff"
 	p2phttp "github.com/libp2p/go-libp2p-http"
@@ -44,6 +45,7 @@ type httpError struct {
 }
 
 var _ transport.Transport = (*httpTransport)(nil)
+var _ transport.CommpHandler = (*transfer)(nil)
 
 type Option func(*httpTransport)
 
@@ -62,6 +64,14 @@ func NChunksOpt(nChunks int) Option {
 	}
 }
 
+// StreamingCommpOpt enables computing commp over the data while it is being
+// downloaded
+func StreamingCommpOpt(b bool) Option {
+	return func(h *httpTransport) {
+		h.streamingCommp = b
+	}
+}
+
 func AllowPrivateIPsOpt(b bool) Option {
 	return func(h *httpTransport) {
 		h.allowPrivateIPs = b
@@ -79,6 +89,7 @@ type httpTransport struct {
 
 	nChunks         int
 	allowPrivateIPs bool
+	streamingCommp  bool
 
 	dl *logs.DealLogger
 }
@@ -175,6 +186,7 @@ func (h *httpTransport) Execute(ctx context.Context, transportInfo []byte, dealI
 		maxReconnectAttempts: h.maxReconnectAttempts,
 		dl:                   h.dl,
 		nChunks:              nChunks,
+		streamingCommp:       h.streamingCommp,
 	}
 
 	cleanupFns := []func(){
@@ -265,6 +277,11 @@ type transfer struct {
 
 	nChunks int
 	lock    sync.RWMutex
+
+	// streamingCommp is true if commp should be computed during the download
+	streamingCommp bool
+	commp          *commpFollower
+	pieceInfo      *abi.PieceInfo
 }
 
 func (t *transfer) addBytesReceived(n int64) {
@@ -272,6 +289,17 @@ func (t *transfer) addBytesReceived(n int64) {
 	defer t.lock.Unlock()
 	t.nBytesReceived += n
 	t.emitEvent(types.TransportEvent{NBytesReceived: t.nBytesReceived})
+	if t.commp != nil {
+		t.commp.notify()
+	}
+}
+
+// PieceCommitment returns the commp computed over the data while it was being
+// downloaded, or nil if streaming commp is disabled or it failed
+func (t *transfer) PieceCommitment() *abi.PieceInfo {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return t.pieceInfo
 }
 
 func (t *transfer) getBytesReceived() int64 {
@@ -368,6 +396,28 @@ func (t *transfer) execute(ctx context.Context) error {
 	chunkSize := dealSize / int64(nchunks)
 	lastAppendedChunk := int(outputStats.Size() / chunkSize)
 
+	if t.streamingCommp {
+		// Compute commp over the chunks as they are downloaded
+		chunks := make([]chunkRange, 0, nchunks)
+		for i := 0; i < nchunks; i++ {
+			c := chunkRange{
+				file:       t.dealInfo.OutputFile,
+				rangeStart: int64(i) * chunkSize,
+				rangeEnd:   int64(i+1) * chunkSize,
+			}
+			if i > 0 {
+				c.file = t.dealInfo.OutputFile + "-" + fmt.Sprint(i)
+			}
+			if i == nchunks-1 {
+				c.rangeEnd = dealSize
+			}
+			chunks = append(chunks, c)
+		}
+		t.commp = newCommpFollower(duuid, t.dl, t.dealInfo.OutputFile, dealSize, chunks)
+		t.commp.start(ctx)
+		defer t.commp.close()
+	}
+
 	downloaders := make([]*downloader, 0, nchunks-lastAppendedChunk)
 
 	for i := lastAppendedChunk; i < nchunks; i++ {
@@ -428,6 +478,20 @@ func (t *transfer) execute(ctx context.Context) error {
 		}
 
 		if reqErr == nil {
+			// all the chunks have been downloaded, so finish computing commp
+			// before the chunks are joined
+			if t.commp != nil {
+				pi, err := t.commp.finish(ctx)
+				if err != nil {
+					t.dl.Infow(duuid, "streaming commp failed, commp will be computed after the transfer", "err", err)
+				} else {
+					t.dl.Infow(duuid, "streaming commp completed", "piece cid", pi.PieceCID, "piece size", pi.Size)
+					t.lock.Lock()
+					t.pieceInfo = pi
+					t.lock.Unlock()
+				}
+			}
+
 			// append chunks one by one to the output file
 			// * minimize space overhead by removing the chunk file once it has been appended ot the output successfully
 			// * keep in mind restarts, resume writing from the correct chunk / offset
@@ -5,6 +5,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"math/rand"
 	"net"
@@ -22,6 +23,7 @@ import (
 
 	"github.com/filecoin-project/boost/transport"
 	"github.com/filecoin-project/boost/transport/types"
+	"github.com/filecoin-project/go-commp-utils/writer"
 	"github.com/google/uuid"
 	"github.com/ipfs/boxo/blockservice"
 	bstore "github.com/ipfs/boxo/blockstore"
@@ -168,6 +170,48 @@ func TestHttpTransferShouldBeDoneInMultipleChunks(t *testing.T) {
 	httpParallelTransferTest(t, getTempFilePath(t), (100*readBufferSize)+30, 5, 5)
 }
 
+func TestHttpTransferWithStreamingCommp(t *testing.T) {
+	ctx := context.Background()
+	rawSize := (100 * readBufferSize) + 30
+	st := newServerTest(t, rawSize)
+	carSize := len(st.carBytes)
+
+	// Calculate the expected commp over the whole file
+	w := &writer.Writer{}
+	_, err := w.Write(st.carBytes)
+	require.NoError(t, err)
+	expected, err := w.Sum()
+	require.NoError(t, err)
+
+	for _, nChunks := range []int{1, 5} {
+		t.Run(fmt.Sprintf("%d chunks", nChunks), func(t *testing.T) {
+			svcs := serversWithRangeHandler(st)
+			reqFn, closer, _ := svcs["http"](t)
+			defer closer()
+
+			of := getTempFilePath(t)
+			ht := New(nil, newDealLogger(t, ctx), NChunksOpt(nChunks), StreamingCommpOpt(true))
+			th := executeTransfer(t, ctx, ht, carSize, reqFn(), of)
+			evts := waitForTransferComplete(th)
+			require.NotEmpty(t, evts)
+			require.EqualValues(t, carSize, evts[len(evts)-1].NBytesReceived)
+			assertFileContents(t, of, st.carBytes)
+
+			// The commp should have been computed during the transfer
+			ch, ok := th.(transport.CommpHandler)
+			require.True(t, ok)
+			pi := ch.PieceCommitment()
+			require.NotNil(t, pi)
+			require.Equal(t, expected.PieceCID, pi.PieceCID)
+			require.Equal(t, expected.PieceSize, pi.Size)
+
+			// The commp state file should have been cleaned up
+			_, err := os.Stat(commpStateFile(of))
+			require.True(t, errors.Is(err, os.ErrNotExist))
+		})
+	}
+}
+
 func TestChangeNumberOfChunksForUnfinishedDownloads(t *testing.T) {
 	// This test ensures that downloads complete with the same chunking setting that they have been started with.
 	// For example if a download has been started with NChunks=3, then
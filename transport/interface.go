@@ -7,6 +7,7 @@ import (
 
 	smtypes "github.com/filecoin-project/boost/storagemarket/types"
 	"github.com/filecoin-project/boost/transport/types"
+	"github.com/filecoin-project/go-state-types/abi"
 )
 
 //go:generate go run github.com/golang/mock/mockgen -destination=mocks/mock_transport.go -package=mocks . Transport,Handler
@@ -20,6 +21,15 @@ type Handler interface {
 	Close()
 }
 
+// CommpHandler is implemented by handlers that compute commp over the data
+// while it is being transferred
+type CommpHandler interface {
+	Handler
+	// PieceCommitment returns the commp of the transferred data, or nil if
+	// it was not computed during the transfer
+	PieceCommitment() *abi.PieceInfo
+}
+
 func TransferParamsAsJson(transfer smtypes.Transfer) (string, error) {
 	if transfer.Type != "http" && transfer.Type != "libp2p" {
 		return "", fmt.Errorf("cannot parse params for unrecognized transfer type '%s'", transfer.Type)
@@ -7,6 +7,10 @@ import (
 
 const DataTransferProtocol = "/fil/storage/transfer/1.0.0"
 
+// Libp2pHttpRetrievalProtocol is the protocol over which booster-http serves
+// retrievals to clients that connect over libp2p
+const Libp2pHttpRetrievalProtocol = "/http/1.1"
+
 // HttpRequest has parameters for an HTTP transfer
 type HttpRequest struct {
 	// URL can be